@@ -138,6 +138,12 @@ func main() {
 	flag.BoolVar(&verbose, "v", false, "Enable verbose logging.")
 	flag.BoolVar(&quiet, "q", false, "Quiet logging. Totally silent.")
 	flag.BoolVar(&veryVerbose, "vv", false, "Enable very verbose logging.")
+	flag.StringVar(&core.TLSCertFile, "tls-cert-file", "", "The server certificate, which enables TLS.")
+	flag.StringVar(&core.TLSKeyFile, "tls-key-file", "", "The private key of the server certificate.")
+	flag.StringVar(&core.TLSCACertFile, "tls-ca-cert-file", "", "The CA certificates for verifying clients and leaders.")
+	flag.StringVar(&core.TLSAuthClients, "tls-auth-clients", "", "Verify client certificates: 'yes', 'no', or 'optional'.")
+	flag.StringVar(&core.TLSReplication, "tls-replication", "", "Use TLS to connect to the leader: 'yes' or 'no'.")
+	flag.StringVar(&core.MetricsAddr, "metrics-addr", "", "The address of the Prometheus metrics listener, such as ':4321'.")
 	flag.Parse()
 
 	var logw io.Writer = os.Stderr
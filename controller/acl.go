@@ -0,0 +1,576 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/controller/glob"
+	"github.com/tidwall/tile38/controller/server"
+)
+
+// ACL users
+//
+// Users are stored in the config file. A connection that authenticates with
+// AUTH username password, or with HTTP basic auth, runs commands as that
+// user. Other connections run as the default user, whose password is the
+// requirepass. The default user may run all commands on all keys, unless it
+// is defined with ACL SETUSER default, which also replaces the requirepass
+// for AUTH default password.
+
+const defaultUser = "default"
+
+var errWrongPass = errors.New("WRONGPASS invalid username-password pair")
+
+// aclCategories are the command categories that can be granted to users.
+var aclCategories = []string{"read", "write", "admin", "hooks", "scripting"}
+
+// aclUser is a user with its permissions. The passwords are sha256 hashes.
+type aclUser struct {
+	On         bool     `json:"on,omitempty"`
+	NoPass     bool     `json:"nopass,omitempty"`
+	Passwords  []string `json:"passwords,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+	Keys       []string `json:"keys,omitempty"`
+}
+
+func aclHash(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func (user *aclUser) checkPassword(password string) bool {
+	if user.NoPass {
+		return true
+	}
+	hash := aclHash(password)
+	for _, h := range user.Passwords {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
+
+func (user *aclUser) hasCategory(category string) bool {
+	for _, c := range user.Categories {
+		if c == category || c == "all" {
+			return true
+		}
+	}
+	return false
+}
+
+func (user *aclUser) allKeys() bool {
+	for _, pattern := range user.Keys {
+		if pattern == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (user *aclUser) matchKey(key string) bool {
+	for _, pattern := range user.Keys {
+		if match, _ := glob.Match(pattern, key); match {
+			return true
+		}
+	}
+	return false
+}
+
+// rules returns the user in the ACL SETUSER syntax.
+func (user *aclUser) rules() []string {
+	var rules []string
+	if user.On {
+		rules = append(rules, "on")
+	} else {
+		rules = append(rules, "off")
+	}
+	if user.NoPass {
+		rules = append(rules, "nopass")
+	}
+	for _, hash := range user.Passwords {
+		rules = append(rules, "#"+hash)
+	}
+	for _, pattern := range user.Keys {
+		rules = append(rules, "~"+pattern)
+	}
+	for _, category := range user.Categories {
+		rules = append(rules, "+@"+category)
+	}
+	return rules
+}
+
+// setRule applies an ACL SETUSER rule to a user.
+func (user *aclUser) setRule(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		user.On = true
+		return nil
+	case "off":
+		user.On = false
+		return nil
+	case "nopass":
+		user.NoPass = true
+		user.Passwords = nil
+		return nil
+	case "resetpass":
+		user.NoPass = false
+		user.Passwords = nil
+		return nil
+	case "allcommands":
+		user.Categories = []string{"all"}
+		return nil
+	case "nocommands":
+		user.Categories = nil
+		return nil
+	case "allkeys":
+		user.Keys = []string{"*"}
+		return nil
+	case "resetkeys":
+		user.Keys = nil
+		return nil
+	case "reset":
+		*user = aclUser{}
+		return nil
+	}
+	switch {
+	case strings.HasPrefix(rule, ">"):
+		hash := aclHash(rule[1:])
+		user.Passwords = append(removeString(user.Passwords, hash), hash)
+		user.NoPass = false
+	case strings.HasPrefix(rule, "<"):
+		user.Passwords = removeString(user.Passwords, aclHash(rule[1:]))
+	case strings.HasPrefix(rule, "~") && len(rule) > 1:
+		user.Keys = append(removeString(user.Keys, rule[1:]), rule[1:])
+	case strings.HasPrefix(rule, "+@"), strings.HasPrefix(rule, "-@"):
+		category := strings.ToLower(rule[2:])
+		if category != "all" && !stringInSlice(category, aclCategories) {
+			return fmt.Errorf("Unknown command category '%s'", category)
+		}
+		if rule[0] == '-' {
+			if category == "all" {
+				user.Categories = nil
+			} else if user.hasCategory("all") {
+				// expand all, except for the removed category
+				user.Categories = removeString(append([]string(nil), aclCategories...), category)
+			} else {
+				user.Categories = removeString(user.Categories, category)
+			}
+		} else if category == "all" {
+			user.Categories = []string{"all"}
+		} else if !user.hasCategory(category) {
+			user.Categories = append(user.Categories, category)
+		}
+	default:
+		return fmt.Errorf("Syntax error in ACL SETUSER modifier '%s'", rule)
+	}
+	return nil
+}
+
+func removeString(strs []string, str string) []string {
+	var res []string
+	for _, s := range strs {
+		if s != str {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+func stringInSlice(str string, strs []string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// aclCategory returns the category of a command. Commands without a
+// category, such as PING and AUTH, are allowed for all users.
+func aclCategory(msg *server.Message) string {
+	switch msg.Command {
+	case "get", "keys", "scan", "nearby", "nearbydistinct", "within", "intersects", "search",
+		"bounds", "ttl", "type", "jget", "stats", "indexes", "history", "join":
+		return "read"
+	case "set", "fset", "del", "pdel", "drop", "flushdb", "expire", "persist",
+		"jset", "jdel", "index", "delindex", "sethistory", "delhistory", "histadd":
+		return "write"
+	case "sethook", "delhook", "pdelhook", "hooks", "setchan", "delchan", "pdelchan", "chans",
+		"subscribe", "psubscribe":
+		return "hooks"
+	case "eval", "evalsha", "script":
+		return "scripting"
+	case "acl":
+		if len(msg.Values) > 1 && strings.ToLower(msg.Values[1].String()) == "whoami" {
+			return ""
+		}
+		return "admin"
+	case "shutdown", "massinsert", "follow", "readonly", "server", "info", "aof", "aofmd5",
+		"gc", "aofshrink", "save", "bgsave", "lastsave", "psync", "role", "replicas",
+		"config", "client", "slowlog", "monitor":
+		return "admin"
+	}
+	return ""
+}
+
+// aclKeys returns the keys that a command accesses. This includes the keys
+// of the GET and ROAM areas of a search, and the keys of the search of a hook
+// or channel.
+func aclKeys(msg *server.Message) []string {
+	switch msg.Command {
+	case "get", "scan", "search", "bounds", "ttl", "type", "jget", "indexes", "set", "fset",
+		"del", "pdel", "drop", "expire", "persist", "jset", "jdel", "index", "delindex",
+		"history", "sethistory", "delhistory", "histadd":
+		if len(msg.Values) > 1 {
+			return []string{msg.Values[1].String()}
+		}
+	case "nearby", "nearbydistinct", "within", "intersects":
+		if len(msg.Values) > 1 {
+			return append([]string{msg.Values[1].String()}, aclAreaKeys(msg)...)
+		}
+	case "sethook", "setchan":
+		return aclHookKeys(msg)
+	case "join":
+		if len(msg.Values) > 2 {
+			return []string{msg.Values[1].String(), msg.Values[2].String()}
+		}
+	case "stats":
+		var keys []string
+		for _, v := range msg.Values[1:] {
+			keys = append(keys, v.String())
+		}
+		return keys
+	}
+	return nil
+}
+
+// aclAllKeys returns true for the commands that access all keys, which are
+// only allowed for the users with the allkeys pattern.
+func aclAllKeys(msg *server.Message) bool {
+	switch msg.Command {
+	case "flushdb", "keys", "hooks", "chans":
+		return true
+	}
+	return false
+}
+
+// aclAreaKeys returns the key of the GET or ROAM area of a search, if any.
+func aclAreaKeys(msg *server.Message) []string {
+	cmd := msg.Command
+	if cmd == "nearbydistinct" {
+		cmd = "nearby"
+	}
+	vs, _, err := parseSearchScanBaseTokens(cmd, msg.Values[1:])
+	if err != nil {
+		return nil
+	}
+	vs, typ, ok := tokenval(vs)
+	if !ok {
+		return nil
+	}
+	switch strings.ToLower(typ) {
+	case "get", "roam":
+		if _, key, ok := tokenval(vs); ok {
+			return []string{key}
+		}
+	}
+	return nil
+}
+
+// aclHookKeys returns the keys of the search of a SETHOOK or SETCHAN. The
+// options of the hook are skipped by their number of arguments.
+func aclHookKeys(msg *server.Message) []string {
+	vs := msg.Values[1:]
+	skip := 1 // name
+	if msg.Command == "sethook" {
+		skip++ // endpoints
+	}
+	for len(vs) >= skip {
+		vs = vs[skip:]
+		if len(vs) == 0 {
+			break
+		}
+		if skip = hookOptionLen(vs); skip == 0 {
+			switch cmd := strings.ToLower(vs[0].String()); cmd {
+			case "nearby", "within", "intersects":
+				return aclKeys(&server.Message{Command: cmd, Values: vs})
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// aclAllowed returns an error when a user may not run a command. The caller
+// must hold the lock.
+func (c *Controller) aclAllowed(name string, msg *server.Message) error {
+	user, ok := c.config.Users[name]
+	if !ok {
+		if name == defaultUser {
+			return nil
+		}
+		return fmt.Errorf("NOPERM user '%s' no longer exists", name)
+	}
+	category := aclCategory(msg)
+	if category == "" {
+		return nil
+	}
+	if !user.On {
+		return fmt.Errorf("NOPERM user '%s' is disabled", name)
+	}
+	if !user.hasCategory(category) {
+		return fmt.Errorf("NOPERM this user has no permissions to run the '%s' command", msg.Command)
+	}
+	if aclAllKeys(msg) && !user.allKeys() {
+		return errors.New("NOPERM this user has no permissions to access all keys")
+	}
+	for _, key := range aclKeys(msg) {
+		if !user.matchKey(key) {
+			return fmt.Errorf("NOPERM this user has no permissions to access the '%s' key", key)
+		}
+	}
+	return nil
+}
+
+// connUser returns the user of a connection. The caller must hold the lock.
+func (c *Controller) connUser(conn *server.Conn) string {
+	if cc, ok := c.conns[conn]; ok && cc.user != "" {
+		return cc.user
+	}
+	return defaultUser
+}
+
+// aclCheck returns an error when the user of a connection may not run a
+// command.
+func (c *Controller) aclCheck(conn *server.Conn, msg *server.Message) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.config.Users) == 0 {
+		return nil
+	}
+	return c.aclAllowed(c.connUser(conn), msg)
+}
+
+// authCredentials returns the username and password of an AUTH command, or
+// of the Authorization header of an HTTP request. The username is empty when
+// only a password is provided.
+func authCredentials(msg *server.Message) (username, password string) {
+	if msg.Auth != "" {
+		auth := strings.TrimSpace(msg.Auth)
+		if len(auth) > 6 && strings.ToLower(auth[:6]) == "basic " {
+			data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(auth[6:]))
+			if err == nil {
+				if i := strings.IndexByte(string(data), ':'); i >= 0 {
+					return string(data[:i]), string(data[i+1:])
+				}
+			}
+		}
+		return "", auth
+	}
+	if msg.Command == "auth" {
+		switch len(msg.Values) {
+		case 2:
+			return "", msg.Values[1].String()
+		case 3:
+			return msg.Values[1].String(), msg.Values[2].String()
+		}
+	}
+	return "", ""
+}
+
+// aclLogin authenticates a connection as a user.
+func (c *Controller) aclLogin(conn *server.Conn, name, password string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if user, ok := c.config.Users[name]; ok {
+		if !user.On || !user.checkPassword(password) {
+			return errWrongPass
+		}
+	} else if name != defaultUser {
+		return errWrongPass
+	} else if c.config.RequirePass != "" && c.config.RequirePass != strings.TrimSpace(password) {
+		return errWrongPass
+	}
+	if name == defaultUser {
+		name = ""
+	}
+	if cc, ok := c.conns[conn]; ok {
+		cc.user = name
+	}
+	return nil
+}
+
+// cmdACL handles ACL SETUSER, GETUSER, DELUSER, LIST, and WHOAMI.
+func (c *Controller) cmdACL(msg *server.Message, conn *server.Conn) (res string, err error) {
+	start := time.Now()
+	vs := msg.Values[1:]
+	var ok bool
+	var subcmd string
+	if vs, subcmd, ok = tokenval(vs); !ok || subcmd == "" {
+		return "", errInvalidNumberOfArguments
+	}
+	switch strings.ToLower(subcmd) {
+	default:
+		return "", errInvalidArgument(subcmd)
+	case "whoami":
+		if len(vs) != 0 {
+			return "", errInvalidNumberOfArguments
+		}
+		name := c.connUser(conn)
+		switch msg.OutputType {
+		case server.JSON:
+			return `{"ok":true,"user":` + jsonString(name) + `,"elapsed":"` + time.Now().Sub(start).String() + "\"}", nil
+		case server.RESP:
+			return "+" + name + "\r\n", nil
+		}
+	case "setuser":
+		var name string
+		if vs, name, ok = tokenval(vs); !ok || name == "" {
+			return "", errInvalidNumberOfArguments
+		}
+		user := &aclUser{}
+		if prev, ok := c.config.Users[name]; ok {
+			*user = *prev
+		}
+		for _, v := range vs {
+			if err := user.setRule(v.String()); err != nil {
+				return "", err
+			}
+		}
+		bak := c.config.Users
+		c.config.Users = make(map[string]*aclUser)
+		for name, user := range bak {
+			c.config.Users[name] = user
+		}
+		c.config.Users[name] = user
+		if err := c.writeConfig(false); err != nil {
+			c.config.Users = bak
+			return "", err
+		}
+		return server.OKMessage(msg, start), nil
+	case "getuser":
+		var name string
+		if vs, name, ok = tokenval(vs); !ok || name == "" || len(vs) != 0 {
+			return "", errInvalidNumberOfArguments
+		}
+		user, ok := c.config.Users[name]
+		if !ok {
+			switch msg.OutputType {
+			case server.JSON:
+				return "", errors.New("user not found")
+			case server.RESP:
+				return "$-1\r\n", nil
+			}
+			return "", nil
+		}
+		switch msg.OutputType {
+		case server.JSON:
+			return `{"ok":true,"user":` + jsonString(name) + `,"rules":` + jsonStrings(user.rules()) +
+				`,"elapsed":"` + time.Now().Sub(start).String() + "\"}", nil
+		case server.RESP:
+			var flags []resp.Value
+			if user.On {
+				flags = append(flags, resp.StringValue("on"))
+			} else {
+				flags = append(flags, resp.StringValue("off"))
+			}
+			if user.NoPass {
+				flags = append(flags, resp.StringValue("nopass"))
+			}
+			vals := []resp.Value{
+				resp.StringValue("flags"), resp.ArrayValue(flags),
+				resp.StringValue("passwords"), respStrings(user.Passwords),
+				resp.StringValue("categories"), respStrings(user.Categories),
+				resp.StringValue("keys"), respStrings(user.Keys),
+			}
+			data, err := resp.ArrayValue(vals).MarshalRESP()
+			if err != nil {
+				return "", err
+			}
+			return string(data), nil
+		}
+	case "deluser":
+		if len(vs) == 0 {
+			return "", errInvalidNumberOfArguments
+		}
+		users := make(map[string]*aclUser)
+		for name, user := range c.config.Users {
+			users[name] = user
+		}
+		var count int
+		for _, v := range vs {
+			if _, ok := users[v.String()]; ok {
+				delete(users, v.String())
+				count++
+			}
+		}
+		if count > 0 {
+			bak := c.config.Users
+			c.config.Users = users
+			if err := c.writeConfig(false); err != nil {
+				c.config.Users = bak
+				return "", err
+			}
+		}
+		switch msg.OutputType {
+		case server.JSON:
+			return `{"ok":true,"deleted":` + strconv.Itoa(count) + `,"elapsed":"` + time.Now().Sub(start).String() + "\"}", nil
+		case server.RESP:
+			return ":" + strconv.Itoa(count) + "\r\n", nil
+		}
+	case "list":
+		if len(vs) != 0 {
+			return "", errInvalidNumberOfArguments
+		}
+		var names []string
+		for name := range c.config.Users {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var lines []string
+		for _, name := range names {
+			lines = append(lines, "user "+name+" "+strings.Join(c.config.Users[name].rules(), " "))
+		}
+		switch msg.OutputType {
+		case server.JSON:
+			return `{"ok":true,"users":` + jsonStrings(lines) + `,"elapsed":"` + time.Now().Sub(start).String() + "\"}", nil
+		case server.RESP:
+			data, err := respStrings(lines).MarshalRESP()
+			if err != nil {
+				return "", err
+			}
+			return string(data), nil
+		}
+	}
+	return "", nil
+}
+
+func jsonStrings(strs []string) string {
+	var buf []byte
+	buf = append(buf, '[')
+	for i, s := range strs {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, jsonString(s)...)
+	}
+	return string(append(buf, ']'))
+}
+
+func respStrings(strs []string) resp.Value {
+	vals := []resp.Value{}
+	for _, s := range strs {
+		vals = append(vals, resp.StringValue(s))
+	}
+	return resp.ArrayValue(vals)
+}
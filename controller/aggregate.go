@@ -0,0 +1,459 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/controller/bing"
+	"github.com/tidwall/tile38/controller/collection"
+	"github.com/tidwall/tile38/geojson"
+	"github.com/tidwall/tile38/geojson/geohash"
+)
+
+// aggFuncT is an aggregate of a field, like SUM speed.
+type aggFuncT struct {
+	op    string // sum, avg, min, or max
+	field string
+}
+
+// aggValueT is the running value of an aggregate. Only the numeric values of
+// a field are aggregated.
+type aggValueT struct {
+	n             uint64
+	sum, min, max float64
+}
+
+type aggGroupT struct {
+	count  uint64
+	values []aggValueT
+}
+
+// aggregateT is the AGG and GRID output of the searches. The objects are
+// aggregated while they are iterated, and only the aggregates are kept.
+type aggregateT struct {
+	funcs      []aggFuncT
+	groupBy    string       // "field", "hash", "tile", "quad", "grid", or empty for a single group
+	field      string       // the field of GROUPBY field
+	precision  uint64       // the geohash precision, or the zoom of the tiles
+	cols, rows int          // the size of the grid
+	bounds     geojson.BBox // the bounds of the grid, which are the search area
+	cells      bool         // the groups are cells of a GRID output
+	groups     map[string]*aggGroupT
+}
+
+func newAggregate() *aggregateT {
+	return &aggregateT{groups: make(map[string]*aggGroupT)}
+}
+
+// parseFuncs parses aggregates, like SUM speed, and GROUPBY when groupBy is
+// true. It stops at the first token that isn't one of them, which is the
+// area of a search.
+func (agg *aggregateT) parseFuncs(vs []resp.Value, groupBy bool) ([]resp.Value, error) {
+	for {
+		nvs, tok, ok := tokenval(vs)
+		if !ok || tok == "" {
+			return vs, nil
+		}
+		switch ltok := strings.ToLower(tok); ltok {
+		default:
+			return vs, nil
+		case "sum", "avg", "min", "max":
+			var field string
+			if nvs, field, ok = tokenval(nvs); !ok || field == "" {
+				return nil, errInvalidNumberOfArguments
+			}
+			agg.funcs = append(agg.funcs, aggFuncT{op: ltok, field: field})
+		case "groupby":
+			if !groupBy {
+				return vs, nil
+			}
+			if agg.groupBy != "" {
+				return nil, errDuplicateArgument(strings.ToUpper(tok))
+			}
+			var by string
+			if nvs, by, ok = tokenval(nvs); !ok || by == "" {
+				return nil, errInvalidNumberOfArguments
+			}
+			switch strings.ToLower(by) {
+			default:
+				agg.groupBy, agg.field = "field", by
+			case "hash", "tile":
+				var sprecision string
+				if nvs, sprecision, ok = tokenval(nvs); !ok || sprecision == "" {
+					return nil, errInvalidNumberOfArguments
+				}
+				if err := agg.parsePrecision(strings.ToLower(by), sprecision); err != nil {
+					return nil, err
+				}
+			}
+		}
+		vs = nvs
+	}
+}
+
+// parsePrecision sets the cells of the geohashes or the tiles.
+func (agg *aggregateT) parsePrecision(by, sprecision string) error {
+	max := uint64(12)
+	if by != "hash" {
+		max = 23
+	}
+	n, err := strconv.ParseUint(sprecision, 10, 64)
+	if err != nil || n > max || (n == 0 && by == "hash") {
+		return errInvalidArgument(sprecision)
+	}
+	agg.groupBy, agg.precision = by, n
+	return nil
+}
+
+// parseAggregate parses the arguments of the AGG output.
+// AGG [GROUPBY (HASH precision | TILE z | field)] (SUM | AVG | MIN | MAX field)...
+func parseAggregate(vs []resp.Value) (vsout []resp.Value, agg *aggregateT, err error) {
+	agg = newAggregate()
+	if vs, err = agg.parseFuncs(vs, true); err != nil {
+		return nil, nil, err
+	}
+	if len(agg.funcs) == 0 && agg.groupBy == "" {
+		return nil, nil, errors.New("AGG requires an aggregate or GROUPBY")
+	}
+	return vs, agg, nil
+}
+
+// parseGrid parses the arguments of the GRID output.
+// GRID (HASH precision | QUAD level | SIZE cols rows) [SUM | AVG | MIN | MAX field]...
+func parseGrid(vs []resp.Value) (vsout []resp.Value, agg *aggregateT, err error) {
+	agg = newAggregate()
+	agg.cells = true
+	var ok bool
+	var by string
+	if vs, by, ok = tokenval(vs); !ok || by == "" {
+		return nil, nil, errInvalidNumberOfArguments
+	}
+	switch strings.ToLower(by) {
+	default:
+		return nil, nil, errInvalidArgument(by)
+	case "hash", "quad":
+		var sprecision string
+		if vs, sprecision, ok = tokenval(vs); !ok || sprecision == "" {
+			return nil, nil, errInvalidNumberOfArguments
+		}
+		if err := agg.parsePrecision(strings.ToLower(by), sprecision); err != nil {
+			return nil, nil, err
+		}
+	case "size":
+		var scols, srows string
+		if vs, scols, ok = tokenval(vs); !ok || scols == "" {
+			return nil, nil, errInvalidNumberOfArguments
+		}
+		if vs, srows, ok = tokenval(vs); !ok || srows == "" {
+			return nil, nil, errInvalidNumberOfArguments
+		}
+		// the number of cells is limited, since every cell may be returned
+		cols, err := strconv.ParseUint(scols, 10, 16)
+		if err != nil || cols == 0 || cols > 1000 {
+			return nil, nil, errInvalidArgument(scols)
+		}
+		rows, err := strconv.ParseUint(srows, 10, 16)
+		if err != nil || rows == 0 || rows > 1000 {
+			return nil, nil, errInvalidArgument(srows)
+		}
+		agg.groupBy, agg.cols, agg.rows = "grid", int(cols), int(rows)
+	}
+	if vs, err = agg.parseFuncs(vs, false); err != nil {
+		return nil, nil, err
+	}
+	return vs, agg, nil
+}
+
+// group returns the group of an object.
+func (agg *aggregateT) group(o geojson.Object, fmap map[string]int, fields []collection.FieldValue) string {
+	if agg.groupBy == "field" {
+		return aggFieldValue(agg.field, o, fmap, fields).String()
+	}
+	point := o.CalculatedPoint()
+	return agg.cell(point.Y, point.X)
+}
+
+// cell returns the cell of a position, for the grouping by position.
+func (agg *aggregateT) cell(lat, lon float64) string {
+	switch agg.groupBy {
+	case "hash":
+		hash, err := geohash.Encode(lat, lon, int(agg.precision))
+		if err != nil {
+			return ""
+		}
+		return hash
+	case "tile", "quad":
+		x, y := bing.PixelXYToTileXY(bing.LatLongToPixelXY(lat, lon, agg.precision))
+		if agg.groupBy == "quad" {
+			return bing.TileXYToQuadKey(x, y, agg.precision)
+		}
+		return strconv.FormatUint(agg.precision, 10) + "/" +
+			strconv.FormatInt(x, 10) + "/" + strconv.FormatInt(y, 10)
+	case "grid":
+		col := gridIndex(lon, agg.bounds.Min.X, agg.bounds.Max.X, agg.cols)
+		row := gridIndex(lat, agg.bounds.Min.Y, agg.bounds.Max.Y, agg.rows)
+		return strconv.Itoa(col) + "," + strconv.Itoa(row)
+	}
+	return ""
+}
+
+// gridIndex returns the column or the row of a grid. Positions outside of the
+// grid go to the nearest cell.
+func gridIndex(v, min, max float64, n int) int {
+	if max <= min {
+		return 0
+	}
+	i := int(math.Floor((v - min) / (max - min) * float64(n)))
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+// cellBounds returns the bounds of a cell of a GRID output.
+func (agg *aggregateT) cellBounds(key string) geojson.BBox {
+	var minLat, minLon, maxLat, maxLon float64
+	switch agg.groupBy {
+	case "hash":
+		minLat, minLon, maxLat, maxLon, _ = geohash.Bounds(key)
+	case "quad":
+		minLat, minLon, maxLat, maxLon, _ = bing.QuadKeyToBounds(key)
+	case "grid":
+		parts := strings.SplitN(key, ",", 2)
+		col, _ := strconv.Atoi(parts[0])
+		row, _ := strconv.Atoi(parts[1])
+		w := (agg.bounds.Max.X - agg.bounds.Min.X) / float64(agg.cols)
+		h := (agg.bounds.Max.Y - agg.bounds.Min.Y) / float64(agg.rows)
+		minLon, minLat = agg.bounds.Min.X+w*float64(col), agg.bounds.Min.Y+h*float64(row)
+		maxLon, maxLat = minLon+w, minLat+h
+	}
+	return geojson.BBox{
+		Min: geojson.Position{X: minLon, Y: minLat},
+		Max: geojson.Position{X: maxLon, Y: maxLat},
+	}
+}
+
+// countable returns true when the objects in a box can be counted without
+// visiting them, because the box is in one cell. Only the counts of the
+// cells are known for those objects, so there must be no field aggregates.
+func (agg *aggregateT) countable(bbox geojson.BBox) bool {
+	if len(agg.funcs) > 0 || agg.groupBy == "field" {
+		return false
+	}
+	return agg.cell(bbox.Min.Y, bbox.Min.X) == agg.cell(bbox.Max.Y, bbox.Max.X)
+}
+
+// gridCountable returns true when a GRID output of a search only needs the
+// counts of the cells, and every object in the area of the search is counted.
+func (c *Controller) gridCountable(s liveFenceSwitches, sw *scanWriter) bool {
+	if sw.agg == nil || !sw.agg.cells || len(sw.agg.funcs) > 0 {
+		return false
+	}
+	if s.o != nil || s.sparse != 0 || s.cursor != 0 || s.after != nil || !sw.globEverything {
+		return false
+	}
+	if len(s.wheres) > 0 || len(s.whereins) > 0 || len(s.whereexprs) > 0 {
+		return false
+	}
+	return len(c.expires[s.key]) == 0
+}
+
+// addCount adds the objects of a box that is in one cell.
+func (agg *aggregateT) addCount(bbox geojson.BBox, n int) {
+	key := agg.cell(bbox.Min.Y, bbox.Min.X)
+	g, ok := agg.groups[key]
+	if !ok {
+		g = &aggGroupT{values: make([]aggValueT, len(agg.funcs))}
+		agg.groups[key] = g
+	}
+	g.count += uint64(n)
+}
+
+func aggFieldValue(field string, o geojson.Object, fmap map[string]int, fields []collection.FieldValue) collection.FieldValue {
+	if field == "z" {
+		return collection.NumberValue(o.CalculatedPoint().Z)
+	}
+	if idx, ok := fmap[field]; ok && idx < len(fields) {
+		return fields[idx]
+	}
+	return collection.FieldValue{}
+}
+
+// add aggregates an object.
+func (agg *aggregateT) add(o geojson.Object, fmap map[string]int, fields []collection.FieldValue) {
+	key := agg.group(o, fmap, fields)
+	g, ok := agg.groups[key]
+	if !ok {
+		g = &aggGroupT{values: make([]aggValueT, len(agg.funcs))}
+		agg.groups[key] = g
+	}
+	g.count++
+	for i, fn := range agg.funcs {
+		value := aggFieldValue(fn.field, o, fmap, fields)
+		if value.Kind == collection.String {
+			continue
+		}
+		v := &g.values[i]
+		if v.n == 0 || value.Num < v.min {
+			v.min = value.Num
+		}
+		if v.n == 0 || value.Num > v.max {
+			v.max = value.Num
+		}
+		v.sum += value.Num
+		v.n++
+	}
+}
+
+// result returns the names and the values of the aggregates of a group. A
+// nil value is an aggregate without values.
+func (agg *aggregateT) result(g *aggGroupT) (names []string, values []*float64) {
+	count := float64(g.count)
+	names = append(names, "count")
+	values = append(values, &count)
+	for i, fn := range agg.funcs {
+		v := g.values[i]
+		var n float64
+		switch fn.op {
+		case "sum":
+			n = v.sum
+		case "avg":
+			n = v.sum / float64(v.n)
+		case "min":
+			n = v.min
+		case "max":
+			n = v.max
+		}
+		names = append(names, fn.op+"("+fn.field+")")
+		if v.n == 0 && fn.op != "sum" {
+			values = append(values, nil)
+		} else {
+			values = append(values, &n)
+		}
+	}
+	return names, values
+}
+
+// sortedGroups returns the keys of the groups in order.
+func (agg *aggregateT) sortedGroups() []string {
+	keys := make([]string, 0, len(agg.groups))
+	for key := range agg.groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func aggNumber(n float64) string {
+	if math.IsInf(n, 0) || math.IsNaN(n) {
+		return "null"
+	}
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+// writeJSON writes the aggregates as the "aggregates" object, or as the
+// "groups" array with GROUPBY.
+func (agg *aggregateT) writeJSON(wr *bytes.Buffer) {
+	writeGroup := func(g *aggGroupT) {
+		names, values := agg.result(g)
+		for i, name := range names {
+			if i > 0 {
+				wr.WriteByte(',')
+			}
+			wr.WriteString(jsonString(name) + ":")
+			if values[i] == nil {
+				wr.WriteString("null")
+			} else {
+				wr.WriteString(aggNumber(*values[i]))
+			}
+		}
+	}
+	if agg.groupBy == "" {
+		wr.WriteString(`,"aggregates":{`)
+		g, ok := agg.groups[""]
+		if !ok {
+			g = &aggGroupT{values: make([]aggValueT, len(agg.funcs))}
+		}
+		writeGroup(g)
+		wr.WriteByte('}')
+		return
+	}
+	if agg.cells {
+		wr.WriteString(`,"cells":[`)
+	} else {
+		wr.WriteString(`,"groups":[`)
+	}
+	for i, key := range agg.sortedGroups() {
+		if i > 0 {
+			wr.WriteByte(',')
+		}
+		if agg.cells {
+			wr.WriteString(`{"cell":` + jsonString(key) +
+				`,"bounds":` + agg.cellBounds(key).ExternalJSON() + `,`)
+		} else {
+			wr.WriteString(`{"group":` + jsonString(key) + `,`)
+		}
+		writeGroup(agg.groups[key])
+		wr.WriteByte('}')
+	}
+	wr.WriteByte(']')
+}
+
+// respValue returns the aggregates as an array of names and values, or
+// with GROUPBY as an array of the groups and their aggregates.
+func (agg *aggregateT) respValue() resp.Value {
+	groupValue := func(g *aggGroupT) resp.Value {
+		names, values := agg.result(g)
+		vals := make([]resp.Value, 0, len(names)*2)
+		for i, name := range names {
+			vals = append(vals, resp.StringValue(name))
+			if values[i] == nil {
+				vals = append(vals, resp.NullValue())
+			} else {
+				vals = append(vals, resp.StringValue(aggNumber(*values[i])))
+			}
+		}
+		return resp.ArrayValue(vals)
+	}
+	if agg.groupBy == "" {
+		g, ok := agg.groups[""]
+		if !ok {
+			g = &aggGroupT{values: make([]aggValueT, len(agg.funcs))}
+		}
+		return groupValue(g)
+	}
+	var vals []resp.Value
+	for _, key := range agg.sortedGroups() {
+		if agg.cells {
+			bbox := agg.cellBounds(key)
+			vals = append(vals, resp.ArrayValue([]resp.Value{
+				resp.StringValue(key),
+				resp.ArrayValue([]resp.Value{
+					resp.ArrayValue([]resp.Value{
+						resp.FloatValue(bbox.Min.Y),
+						resp.FloatValue(bbox.Min.X),
+					}),
+					resp.ArrayValue([]resp.Value{
+						resp.FloatValue(bbox.Max.Y),
+						resp.FloatValue(bbox.Max.X),
+					}),
+				}),
+				groupValue(agg.groups[key]),
+			}))
+			continue
+		}
+		vals = append(vals, resp.ArrayValue([]resp.Value{
+			resp.StringValue(key),
+			groupValue(agg.groups[key]),
+		}))
+	}
+	return resp.ArrayValue(vals)
+}
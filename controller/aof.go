@@ -54,12 +54,26 @@ func (c *Controller) loadAOF() error {
 			count, float64(d)/float64(time.Second), ps, byteSpeed)
 	}()
 	var msg server.Message
+	var multi [][]resp.Value // commands of a transaction
+	var multion bool         // reading a transaction
+	var multisz int          // size of the transaction
 	rd := bufio.NewReader(c.f)
 	for {
 		var nn int
 		ch, err := rd.ReadByte()
 		if err != nil {
 			if err == io.EOF {
+				if multion {
+					// the transaction was not completely written, which
+					// means it was never applied.
+					log.Warnf("truncating incomplete transaction at the end of the aof")
+					if err := c.f.Truncate(int64(c.aofsz)); err != nil {
+						return err
+					}
+					if _, err := c.f.Seek(int64(c.aofsz), 0); err != nil {
+						return err
+					}
+				}
 				return nil
 			}
 			return err
@@ -124,6 +138,30 @@ func (c *Controller) loadAOF() error {
 			}
 			nn += 1 + len(ns) + int(n) + 2
 		}
+		switch {
+		case msg.Command == "multi":
+			multi, multion, multisz = nil, true, nn
+			continue
+		case multion && msg.Command == "exec":
+			for _, values := range multi {
+				msg.Values = values
+				msg.Command = qlower(values[0].Bytes())
+				if _, _, err := c.command(&msg, nil, nil); err != nil {
+					if commandErrIsFatal(err) {
+						return err
+					}
+				}
+				count++
+			}
+			msg.Values = nil
+			c.aofsz += multisz + nn
+			multi, multion = nil, false
+			continue
+		case multion:
+			multi = append(multi, append([]resp.Value(nil), msg.Values...))
+			multisz += nn
+			continue
+		}
 		if _, _, err := c.command(&msg, nil, nil); err != nil {
 			if commandErrIsFatal(err) {
 				return err
@@ -162,59 +200,79 @@ func commandErrIsFatal(err error) bool {
 }
 
 func (c *Controller) writeAOF(value resp.Value, d *commandDetailsT) error {
-	if d != nil {
-		if !d.updated {
-			return nil // just ignore writes if the command did not update
+	if d == nil {
+		return c.writeAOFUnit([]resp.Value{value}, nil)
+	}
+	if !d.updated {
+		return nil // just ignore writes if the command did not update
+	}
+	return c.writeAOFUnit([]resp.Value{value}, []*commandDetailsT{d})
+}
+
+// writeAOFUnit writes commands to the aof with a single write, such that a
+// transaction is never partially written. The hooks and live connections are
+// notified of each of the details.
+func (c *Controller) writeAOFUnit(values []resp.Value, ds []*commandDetailsT) error {
+	// parents forward their children only
+	var leaves []*commandDetailsT
+	for _, d := range ds {
+		if d.parent {
+			leaves = append(leaves, d.children...)
+		} else {
+			leaves = append(leaves, d)
 		}
-		if c.config.FollowHost == "" {
-			// process hooks, for leader only
-			if d.parent {
-				// process children only
-				for _, d := range d.children {
-					if err := c.queueHooks(d); err != nil {
-						return err
-					}
-				}
+	}
+	if c.config.FollowHost == "" {
+		// process hooks, for leader only
+		for _, d := range leaves {
+			if err := c.queueHooks(d); err != nil {
+				return err
+			}
+		}
+		// the positions of the collections with a history, for leader only
+		if hvalues := c.recordHistory(leaves); len(hvalues) > 0 {
+			if len(values) > 1 {
+				// a transaction, the positions go before the exec
+				exec := values[len(values)-1]
+				values = append(append(values[:len(values)-1:len(values)-1], hvalues...), exec)
 			} else {
-				// process parent
-				if err := c.queueHooks(d); err != nil {
-					return err
-				}
+				values = append(values, hvalues...)
 			}
 		}
 	}
-	if c.shrinking {
-		var values []string
-		for _, value := range value.Array() {
-			values = append(values, value.String())
+	var data []byte
+	for _, value := range values {
+		if c.shrinking {
+			var svalues []string
+			for _, value := range value.Array() {
+				svalues = append(svalues, value.String())
+			}
+			c.shrinklog = append(c.shrinklog, svalues)
 		}
-		c.shrinklog = append(c.shrinklog, values)
-	}
-	data, err := value.MarshalRESP()
-	if err != nil {
-		return err
+		vdata, err := value.MarshalRESP()
+		if err != nil {
+			return err
+		}
+		data = append(data, vdata...)
 	}
 	n, err := c.f.Write(data)
 	if err != nil {
 		return err
 	}
 	c.aofsz += n
+	c.replAppend(data)
 
 	// notify aof live connections that we have new data
 	c.fcond.L.Lock()
 	c.fcond.Broadcast()
 	c.fcond.L.Unlock()
 
-	if d != nil {
+	if len(leaves) > 0 {
+		c.touchWatches(leaves)
+
 		// write to live connection streams
 		c.lcond.L.Lock()
-		if d.parent {
-			for _, d := range d.children {
-				c.lstack = append(c.lstack, d)
-			}
-		} else {
-			c.lstack = append(c.lstack, d)
-		}
+		c.lstack = append(c.lstack, leaves...)
 		c.lcond.Broadcast()
 		c.lcond.L.Unlock()
 	}
@@ -224,15 +282,22 @@ func (c *Controller) writeAOF(value resp.Value, d *commandDetailsT) error {
 func (c *Controller) queueHooks(d *commandDetailsT) error {
 	// big list of all of the messages
 	var hmsgs [][]byte
+	var hmsgHooks []*Hook
 	var hooks []*Hook
 	// find the hook by the key
 	if hm, ok := c.hookcols[d.key]; ok {
 		for _, hook := range hm {
 			// match the fence
 			msgs := FenceMatch(hook.Name, hook.ScanWriter, hook.Fence, hook.Metas, d)
-			if len(msgs) > 0 {
+			if len(msgs) > 0 && hook.channel {
+				// channels are not queued
+				c.pubsub.publish(hook.Name, msgs)
+			} else if len(msgs) > 0 {
 				// append each msg to the big list
 				hmsgs = append(hmsgs, msgs...)
+				for range msgs {
+					hmsgHooks = append(hmsgHooks, hook)
+				}
 				hooks = append(hooks, hook)
 			}
 		}
@@ -243,13 +308,14 @@ func (c *Controller) queueHooks(d *commandDetailsT) error {
 
 	// queue the message in the buntdb database
 	err := c.qdb.Update(func(tx *buntdb.Tx) error {
-		for _, msg := range hmsgs {
+		for i, msg := range hmsgs {
 			c.qidx++ // increment the log id
 			key := hookLogPrefix + uint64ToString(c.qidx)
-			_, _, err := tx.Set(key, string(msg), hookLogSetDefaults())
+			_, _, err := tx.Set(key, string(msg), hmsgHooks[i].logSetOptions())
 			if err != nil {
 				return err
 			}
+			hmsgHooks[i].stats.queue(1)
 			log.Debugf("queued hook: %d", c.qidx)
 		}
 		_, _, err := tx.Set("hook:idx", uint64ToString(c.qidx), nil)
@@ -282,7 +348,9 @@ func uint64ToString(u uint64) string {
 }
 
 type liveAOFSwitches struct {
-	pos int64
+	pos     int64
+	reply   string // defaults to +OK
+	backlog []byte // sent before the aof
 }
 
 func (s liveAOFSwitches) Error() string {
@@ -359,7 +427,7 @@ func (c *Controller) cmdAOF(msg *server.Message) (res string, err error) {
 	return "", s
 }
 
-func (c *Controller) liveAOF(pos int64, conn net.Conn, rd *server.AnyReaderWriter, msg *server.Message) error {
+func (c *Controller) liveAOF(s liveAOFSwitches, conn net.Conn, rd *server.AnyReaderWriter, msg *server.Message) error {
 	c.mu.Lock()
 	c.aofconnM[conn] = true
 	c.mu.Unlock()
@@ -370,7 +438,11 @@ func (c *Controller) liveAOF(pos int64, conn net.Conn, rd *server.AnyReaderWrite
 		conn.Close()
 	}()
 
-	if _, err := conn.Write([]byte("+OK\r\n")); err != nil {
+	reply := s.reply
+	if reply == "" {
+		reply = "+OK\r\n"
+	}
+	if _, err := conn.Write(append([]byte(reply), s.backlog...)); err != nil {
 		return err
 	}
 
@@ -381,7 +453,7 @@ func (c *Controller) liveAOF(pos int64, conn net.Conn, rd *server.AnyReaderWrite
 		return err
 	}
 	defer f.Close()
-	if _, err := f.Seek(pos, 0); err != nil {
+	if _, err := f.Seek(s.pos, 0); err != nil {
 		return err
 	}
 	cond := sync.NewCond(&sync.Mutex{})
@@ -6,7 +6,6 @@ import (
 	"path"
 	"sort"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/tidwall/tile38/controller/collection"
@@ -21,7 +20,7 @@ const maxchunk = 4 * 1024 * 1024
 func (c *Controller) aofshrink() {
 	start := time.Now()
 	c.mu.Lock()
-	if c.shrinking {
+	if c.shrinking || c.snap != nil {
 		c.mu.Unlock()
 		return
 	}
@@ -29,6 +28,15 @@ func (c *Controller) aofshrink() {
 	c.shrinklog = nil
 	c.mu.Unlock()
 
+	var resave bool
+	defer func() {
+		if resave {
+			// runs after the shrink is marked as done
+			if err := c.saveSnapshot(); err != nil {
+				log.Errorf("snapshot after aof shrink failed: %v", err)
+			}
+		}
+	}()
 	defer func() {
 		c.mu.Lock()
 		c.shrinking = false
@@ -94,7 +102,7 @@ func (c *Controller) aofshrink() {
 					var now = time.Now()         // used for expiration
 					var count = 0                // the object count
 					col.ScanGreaterOrEqual(nextid, false,
-						func(id string, obj geojson.Object, fields []float64) bool {
+						func(id string, obj geojson.Object, fields []collection.FieldValue) bool {
 							if count == maxids {
 								// we reached the max number of ids for one batch
 								nextid = id
@@ -108,10 +116,10 @@ func (c *Controller) aofshrink() {
 							values = append(values, keys[0])
 							values = append(values, id)
 							for i, fvalue := range fields {
-								if fvalue != 0 {
+								if !fvalue.IsZero() {
 									values = append(values, "field")
 									values = append(values, fnames[i])
-									values = append(values, strconv.FormatFloat(fvalue, 'f', -1, 64))
+									values = append(values, fvalue.Arg())
 								}
 							}
 							if exm != nil {
@@ -201,13 +209,7 @@ func (c *Controller) aofshrink() {
 				hook.mu.Lock()
 				defer hook.mu.Unlock()
 
-				var values []string
-				values = append(values, "sethook")
-				values = append(values, name)
-				values = append(values, strings.Join(hook.Endpoints, ","))
-				for _, value := range hook.Message.Values {
-					values = append(values, value.String())
-				}
+				values := hook.commandValues()
 
 				// append the values to the aof buffer
 				aofbuf = append(aofbuf, '*')
@@ -222,6 +224,52 @@ func (c *Controller) aofshrink() {
 				}
 			}()
 		}
+
+		// load field indexes
+		func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			var keys []string
+			for key := range c.fieldIndexes {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				for _, field := range c.indexedFields(key) {
+					values := []string{"index", key, "field", field}
+					aofbuf = append(aofbuf, '*')
+					aofbuf = append(aofbuf, strconv.FormatInt(int64(len(values)), 10)...)
+					aofbuf = append(aofbuf, '\r', '\n')
+					for _, value := range values {
+						aofbuf = append(aofbuf, '$')
+						aofbuf = append(aofbuf, strconv.FormatInt(int64(len(value)), 10)...)
+						aofbuf = append(aofbuf, '\r', '\n')
+						aofbuf = append(aofbuf, value...)
+						aofbuf = append(aofbuf, '\r', '\n')
+					}
+				}
+			}
+		}()
+
+		// load histories
+		func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			for _, key := range c.historyKeys() {
+				for _, values := range c.historyCommands(key) {
+					aofbuf = append(aofbuf, '*')
+					aofbuf = append(aofbuf, strconv.FormatInt(int64(len(values)), 10)...)
+					aofbuf = append(aofbuf, '\r', '\n')
+					for _, value := range values {
+						aofbuf = append(aofbuf, '$')
+						aofbuf = append(aofbuf, strconv.FormatInt(int64(len(value)), 10)...)
+						aofbuf = append(aofbuf, '\r', '\n')
+						aofbuf = append(aofbuf, value...)
+						aofbuf = append(aofbuf, '\r', '\n')
+					}
+				}
+			}
+		}()
 		if len(aofbuf) > 0 {
 			if _, err := f.Write(aofbuf); err != nil {
 				return err
@@ -282,9 +330,16 @@ func (c *Controller) aofshrink() {
 				log.Fatalf("shink seek end fatal operation: %v", err)
 			}
 			c.aofsz = int(n)
+			c.replSave()
 
 			os.Remove(path.Join(c.dir, "appendonly.bak")) // ignore error
 
+			// the snapshot position no longer points into the new aof, so
+			// the snapshot is written again at the end of the new aof
+			if os.Remove(path.Join(c.dir, "snapshot")) == nil {
+				resave = true
+			}
+
 			// kill all followers connections
 			for conn := range c.aofconnM {
 				conn.Close()
@@ -151,7 +151,7 @@ func (c *Controller) followCheckSome(addr string, followc uint64) (pos int64, er
 		return 0, nil
 	}
 
-	conn, err := DialTimeout(addr, time.Second*2)
+	conn, err := DialTLSTimeout(addr, time.Second*2, c.replTLS)
 	if err != nil {
 		return 0, err
 	}
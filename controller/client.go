@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
@@ -33,6 +34,24 @@ func DialTimeout(address string, timeout time.Duration) (*Conn, error) {
 	return conn, nil
 }
 
+// DialTLSTimeout dials a resp server over tls. A nil config dials without
+// tls.
+func DialTLSTimeout(address string, timeout time.Duration, config *tls.Config) (*Conn, error) {
+	if config == nil {
+		return DialTimeout(address, timeout)
+	}
+	tlsconn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", address, config)
+	if err != nil {
+		return nil, err
+	}
+	conn := &Conn{
+		conn: tlsconn,
+		rd:   resp.NewReader(tlsconn),
+		wr:   resp.NewWriter(tlsconn),
+	}
+	return conn, nil
+}
+
 // Close closes the connection.
 func (conn *Conn) Close() error {
 	conn.wr.WriteMultiBulk("quit")
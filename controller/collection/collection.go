@@ -49,15 +49,16 @@ func (i *itemT) Point() (x, y, z float64) {
 
 // Collection represents a collection of geojson objects.
 type Collection struct {
-	items       *btree.BTree // items sorted by keys
-	values      *btree.BTree // items sorted by value+key
-	index       *index.Index // items geospatially indexed
-	fieldMap    map[string]int
-	fieldValues map[string][]float64
-	weight      int
-	points      int
-	objects     int // geometry count
-	nobjects    int // non-geometry count
+	items        *btree.BTree            // items sorted by keys
+	values       *btree.BTree            // items sorted by value+key
+	index        *index.Index            // items geospatially indexed
+	fieldIndexes map[string]*btree.BTree // items sorted by field value+key
+	fieldMap     map[string]int
+	fieldValues  map[string][]FieldValue
+	weight       int
+	points       int
+	objects      int // geometry count
+	nobjects     int // non-geometry count
 }
 
 var counter uint64
@@ -73,14 +74,14 @@ func New() *Collection {
 	return col
 }
 
-func (c *Collection) setFieldValues(id string, values []float64) {
+func (c *Collection) setFieldValues(id string, values []FieldValue) {
 	if c.fieldValues == nil {
-		c.fieldValues = make(map[string][]float64)
+		c.fieldValues = make(map[string][]FieldValue)
 	}
 	c.fieldValues[id] = values
 }
 
-func (c *Collection) getFieldValues(id string) (values []float64) {
+func (c *Collection) getFieldValues(id string) (values []FieldValue) {
 	if c.fieldValues == nil {
 		return nil
 	}
@@ -121,7 +122,7 @@ func (c *Collection) Bounds() (minX, minY, maxX, maxY float64) {
 // If an item with the same id is already in the collection then the new item will adopt the old item's fields.
 // The fields argument is optional.
 // The return values are the old object, the old fields, and the new fields
-func (c *Collection) ReplaceOrInsert(id string, obj geojson.Object, fields []string, values []float64) (oldObject geojson.Object, oldFields []float64, newFields []float64) {
+func (c *Collection) ReplaceOrInsert(id string, obj geojson.Object, fields []string, values []FieldValue) (oldObject geojson.Object, oldFields []FieldValue, newFields []FieldValue) {
 	var oldItem *itemT
 	var newItem *itemT = &itemT{id: id, object: obj}
 	// add the new item to main btree and remove the old one if needed
@@ -130,6 +131,7 @@ func (c *Collection) ReplaceOrInsert(id string, obj geojson.Object, fields []str
 		// the old item was removed, now let's remove from the rtree
 		// or strings tree.
 		oldItem = oldItemPtr.(*itemT)
+		c.indexRemove(oldItem)
 		if obj.IsGeometry() {
 			// geometry
 			c.index.Remove(oldItem)
@@ -143,7 +145,7 @@ func (c *Collection) ReplaceOrInsert(id string, obj geojson.Object, fields []str
 		c.points -= oldItem.object.PositionCount()
 
 		// decrement the weights
-		c.weight -= len(c.getFieldValues(id)) * 8
+		c.weight -= fieldsWeight(c.getFieldValues(id))
 		c.weight -= oldItem.object.Weight() + len(oldItem.id)
 
 		// references
@@ -163,15 +165,15 @@ func (c *Collection) ReplaceOrInsert(id string, obj geojson.Object, fields []str
 	c.points += obj.PositionCount()
 
 	// add the new weights
-	c.weight += len(newFields) * 8
+	c.weight += fieldsWeight(newFields)
 	c.weight += obj.Weight() + len(id)
 	if fields == nil {
 		if len(values) > 0 {
 			// directly set the field values, update weight
-			c.weight -= len(newFields) * 8
+			c.weight -= fieldsWeight(newFields)
 			newFields = values
 			c.setFieldValues(id, newFields)
-			c.weight += len(newFields) * 8
+			c.weight += fieldsWeight(newFields)
 		}
 	} else {
 		//if len(fields) == 0 {
@@ -183,17 +185,19 @@ func (c *Collection) ReplaceOrInsert(id string, obj geojson.Object, fields []str
 		}
 		newFields = c.getFieldValues(id)
 	}
+	c.indexInsert(newItem)
 	return oldObject, oldFields, newFields
 }
 
 // Remove removes an object and returns it.
 // If the object does not exist then the 'ok' return value will be false.
-func (c *Collection) Remove(id string) (obj geojson.Object, fields []float64, ok bool) {
+func (c *Collection) Remove(id string) (obj geojson.Object, fields []FieldValue, ok bool) {
 	i := c.items.Delete(&itemT{id: id})
 	if i == nil {
 		return nil, nil, false
 	}
 	item := i.(*itemT)
+	c.indexRemove(item)
 	if item.object.IsGeometry() {
 		c.index.Remove(item)
 		c.objects--
@@ -203,7 +207,7 @@ func (c *Collection) Remove(id string) (obj geojson.Object, fields []float64, ok
 	}
 	fields = c.getFieldValues(id)
 	c.deleteFieldValues(id)
-	c.weight -= len(fields) * 8
+	c.weight -= fieldsWeight(fields)
 	c.weight -= item.object.Weight() + len(item.id)
 	c.points -= item.object.PositionCount()
 	return item.object, fields, true
@@ -211,7 +215,7 @@ func (c *Collection) Remove(id string) (obj geojson.Object, fields []float64, ok
 
 // Get returns an object.
 // If the object does not exist then the 'ok' return value will be false.
-func (c *Collection) Get(id string) (obj geojson.Object, fields []float64, ok bool) {
+func (c *Collection) Get(id string) (obj geojson.Object, fields []FieldValue, ok bool) {
 	i := c.items.Get(&itemT{id: id})
 	if i == nil {
 		return nil, nil, false
@@ -222,31 +226,33 @@ func (c *Collection) Get(id string) (obj geojson.Object, fields []float64, ok bo
 
 // SetField set a field value for an object and returns that object.
 // If the object does not exist then the 'ok' return value will be false.
-func (c *Collection) SetField(id, field string, value float64) (obj geojson.Object, fields []float64, updated bool, ok bool) {
+func (c *Collection) SetField(id, field string, value FieldValue) (obj geojson.Object, fields []FieldValue, updated bool, ok bool) {
 	i := c.items.Get(&itemT{id: id})
 	if i == nil {
 		ok = false
 		return
 	}
 	item := i.(*itemT)
+	c.indexRemove(item)
 	updated = c.setField(item, field, value)
+	c.indexInsert(item)
 	return item.object, c.getFieldValues(id), updated, true
 }
 
-func (c *Collection) setField(item *itemT, field string, value float64) (updated bool) {
+func (c *Collection) setField(item *itemT, field string, value FieldValue) (updated bool) {
 	idx, ok := c.fieldMap[field]
 	if !ok {
 		idx = len(c.fieldMap)
 		c.fieldMap[field] = idx
 	}
 	fields := c.getFieldValues(item.id)
-	c.weight -= len(fields) * 8
+	c.weight -= fieldsWeight(fields)
 	for idx >= len(fields) {
-		fields = append(fields, 0)
+		fields = append(fields, FieldValue{})
 	}
-	c.weight += len(fields) * 8
 	ovalue := fields[idx]
 	fields[idx] = value
+	c.weight += fieldsWeight(fields)
 	c.setFieldValues(item.id, fields)
 	return ovalue != value
 }
@@ -267,7 +273,7 @@ func (c *Collection) FieldArr() []string {
 
 // Scan iterates though the collection ids.
 func (c *Collection) Scan(desc bool,
-	iterator func(id string, obj geojson.Object, fields []float64) bool,
+	iterator func(id string, obj geojson.Object, fields []FieldValue) bool,
 ) bool {
 	var keepon = true
 	iter := func(item btree.Item) bool {
@@ -285,7 +291,7 @@ func (c *Collection) Scan(desc bool,
 
 // ScanGreaterOrEqual iterates though the collection starting with specified id.
 func (c *Collection) ScanRange(start, end string, desc bool,
-	iterator func(id string, obj geojson.Object, fields []float64) bool,
+	iterator func(id string, obj geojson.Object, fields []FieldValue) bool,
 ) bool {
 	var keepon = true
 	iter := func(item btree.Item) bool {
@@ -304,7 +310,7 @@ func (c *Collection) ScanRange(start, end string, desc bool,
 
 // SearchValues iterates though the collection values.
 func (c *Collection) SearchValues(desc bool,
-	iterator func(id string, obj geojson.Object, fields []float64) bool,
+	iterator func(id string, obj geojson.Object, fields []FieldValue) bool,
 ) bool {
 	var keepon = true
 	iter := func(item btree.Item) bool {
@@ -322,7 +328,7 @@ func (c *Collection) SearchValues(desc bool,
 
 // SearchValuesRange iterates though the collection values.
 func (c *Collection) SearchValuesRange(start, end string, desc bool,
-	iterator func(id string, obj geojson.Object, fields []float64) bool,
+	iterator func(id string, obj geojson.Object, fields []FieldValue) bool,
 ) bool {
 	var keepon = true
 	iter := func(item btree.Item) bool {
@@ -338,9 +344,29 @@ func (c *Collection) SearchValuesRange(start, end string, desc bool,
 	return keepon
 }
 
+// SearchValuesGreaterOrEqual iterates though the collection values starting
+// with the specified value and id.
+func (c *Collection) SearchValuesGreaterOrEqual(value, id string, desc bool,
+	iterator func(id string, obj geojson.Object, fields []FieldValue) bool,
+) bool {
+	var keepon = true
+	iter := func(item btree.Item) bool {
+		iitm := item.(*itemT)
+		keepon = iterator(iitm.id, iitm.object, c.getFieldValues(iitm.id))
+		return keepon
+	}
+	pivot := &itemT{id: id, object: geojson.String(value)}
+	if desc {
+		c.values.DescendLessOrEqual(pivot, iter)
+	} else {
+		c.values.AscendGreaterOrEqual(pivot, iter)
+	}
+	return keepon
+}
+
 // ScanGreaterOrEqual iterates though the collection starting with specified id.
 func (c *Collection) ScanGreaterOrEqual(id string, desc bool,
-	iterator func(id string, obj geojson.Object, fields []float64) bool,
+	iterator func(id string, obj geojson.Object, fields []FieldValue) bool,
 ) bool {
 	var keepon = true
 	iter := func(item btree.Item) bool {
@@ -356,7 +382,7 @@ func (c *Collection) ScanGreaterOrEqual(id string, desc bool,
 	return keepon
 }
 
-func (c *Collection) geoSearch(bbox geojson.BBox, iterator func(id string, obj geojson.Object, fields []float64) bool) bool {
+func (c *Collection) geoSearch(bbox geojson.BBox, iterator func(id string, obj geojson.Object, fields []FieldValue) bool) bool {
 	return c.index.Search(bbox.Min.Y, bbox.Min.X, bbox.Max.Y, bbox.Max.X, bbox.Min.Z, bbox.Max.Z, func(item interface{}) bool {
 		iitm := item.(*itemT)
 		if !iterator(iitm.id, iitm.object, c.getFieldValues(iitm.id)) {
@@ -367,7 +393,7 @@ func (c *Collection) geoSearch(bbox geojson.BBox, iterator func(id string, obj g
 }
 
 // Nearby returns all object that are nearby a point.
-func (c *Collection) Nearby(sparse uint8, lat, lon, meters, minZ, maxZ float64, iterator func(id string, obj geojson.Object, fields []float64) bool) bool {
+func (c *Collection) Nearby(sparse uint8, lat, lon, meters, minZ, maxZ float64, iterator func(id string, obj geojson.Object, fields []FieldValue) bool) bool {
 	var keepon = true
 	center := geojson.Position{X: lon, Y: lat, Z: 0}
 	bbox := geojson.BBoxesFromCenter(lat, lon, meters)
@@ -375,7 +401,7 @@ func (c *Collection) Nearby(sparse uint8, lat, lon, meters, minZ, maxZ float64,
 	if sparse > 0 {
 		for _, bbox := range bboxes {
 			bbox.Min.Z, bbox.Max.Z = minZ, maxZ
-			keepon = c.geoSearch(bbox, func(id string, obj geojson.Object, fields []float64) bool {
+			keepon = c.geoSearch(bbox, func(id string, obj geojson.Object, fields []FieldValue) bool {
 				if obj.Nearby(center, meters) {
 					if iterator(id, obj, fields) {
 						return false
@@ -390,7 +416,7 @@ func (c *Collection) Nearby(sparse uint8, lat, lon, meters, minZ, maxZ float64,
 		return keepon
 	}
 	bbox.Min.Z, bbox.Max.Z = minZ, maxZ
-	return c.geoSearch(bbox, func(id string, obj geojson.Object, fields []float64) bool {
+	return c.geoSearch(bbox, func(id string, obj geojson.Object, fields []FieldValue) bool {
 		if obj.Nearby(center, meters) {
 			return iterator(id, obj, fields)
 		}
@@ -398,26 +424,30 @@ func (c *Collection) Nearby(sparse uint8, lat, lon, meters, minZ, maxZ float64,
 	})
 }
 
-// Within returns all object that are fully contained within an object or bounding box. Set obj to nil in order to use the bounding box.
-func (c *Collection) Within(sparse uint8, obj geojson.Object, minLat, minLon, maxLat, maxLon, minZ, maxZ float64, iterator func(id string, obj geojson.Object, fields []float64) bool) bool {
-	var keepon = true
-	var bbox geojson.BBox
+// searchBBox returns the bounding box of a Within or Intersects search.
+func searchBBox(obj geojson.Object, minLat, minLon, maxLat, maxLon, minZ, maxZ float64) geojson.BBox {
 	if obj != nil {
-		bbox = obj.CalculatedBBox()
+		bbox := obj.CalculatedBBox()
 		if minZ == math.Inf(-1) && maxZ == math.Inf(+1) {
 			if bbox.Min.Z == 0 && bbox.Max.Z == 0 {
 				bbox.Min.Z = minZ
 				bbox.Max.Z = maxZ
 			}
 		}
-	} else {
-		bbox = geojson.BBox{Min: geojson.Position{X: minLon, Y: minLat, Z: minZ}, Max: geojson.Position{X: maxLon, Y: maxLat, Z: maxZ}}
+		return bbox
 	}
+	return geojson.BBox{Min: geojson.Position{X: minLon, Y: minLat, Z: minZ}, Max: geojson.Position{X: maxLon, Y: maxLat, Z: maxZ}}
+}
+
+// Within returns all object that are fully contained within an object or bounding box. Set obj to nil in order to use the bounding box.
+func (c *Collection) Within(sparse uint8, obj geojson.Object, minLat, minLon, maxLat, maxLon, minZ, maxZ float64, iterator func(id string, obj geojson.Object, fields []FieldValue) bool) bool {
+	var keepon = true
+	bbox := searchBBox(obj, minLat, minLon, maxLat, maxLon, minZ, maxZ)
 	bboxes := bbox.Sparse(sparse)
 	if sparse > 0 {
 		for _, bbox := range bboxes {
 			if obj != nil {
-				keepon = c.geoSearch(bbox, func(id string, o geojson.Object, fields []float64) bool {
+				keepon = c.geoSearch(bbox, func(id string, o geojson.Object, fields []FieldValue) bool {
 					if o.Within(obj) {
 						if iterator(id, o, fields) {
 							return false
@@ -427,7 +457,7 @@ func (c *Collection) Within(sparse uint8, obj geojson.Object, minLat, minLon, ma
 				})
 			}
 			if keepon {
-				keepon = c.geoSearch(bbox, func(id string, o geojson.Object, fields []float64) bool {
+				keepon = c.geoSearch(bbox, func(id string, o geojson.Object, fields []FieldValue) bool {
 					if o.WithinBBox(bbox) {
 						if iterator(id, o, fields) {
 							return false
@@ -443,14 +473,14 @@ func (c *Collection) Within(sparse uint8, obj geojson.Object, minLat, minLon, ma
 		return keepon
 	}
 	if obj != nil {
-		return c.geoSearch(bbox, func(id string, o geojson.Object, fields []float64) bool {
+		return c.geoSearch(bbox, func(id string, o geojson.Object, fields []FieldValue) bool {
 			if o.Within(obj) {
 				return iterator(id, o, fields)
 			}
 			return true
 		})
 	}
-	return c.geoSearch(bbox, func(id string, o geojson.Object, fields []float64) bool {
+	return c.geoSearch(bbox, func(id string, o geojson.Object, fields []FieldValue) bool {
 		if o.WithinBBox(bbox) {
 			return iterator(id, o, fields)
 		}
@@ -459,20 +489,9 @@ func (c *Collection) Within(sparse uint8, obj geojson.Object, minLat, minLon, ma
 }
 
 // Intersects returns all object that are intersect an object or bounding box. Set obj to nil in order to use the bounding box.
-func (c *Collection) Intersects(sparse uint8, obj geojson.Object, minLat, minLon, maxLat, maxLon, minZ, maxZ float64, iterator func(id string, obj geojson.Object, fields []float64) bool) bool {
+func (c *Collection) Intersects(sparse uint8, obj geojson.Object, minLat, minLon, maxLat, maxLon, minZ, maxZ float64, iterator func(id string, obj geojson.Object, fields []FieldValue) bool) bool {
 	var keepon = true
-	var bbox geojson.BBox
-	if obj != nil {
-		bbox = obj.CalculatedBBox()
-		if minZ == math.Inf(-1) && maxZ == math.Inf(+1) {
-			if bbox.Min.Z == 0 && bbox.Max.Z == 0 {
-				bbox.Min.Z = minZ
-				bbox.Max.Z = maxZ
-			}
-		}
-	} else {
-		bbox = geojson.BBox{Min: geojson.Position{X: minLon, Y: minLat, Z: minZ}, Max: geojson.Position{X: maxLon, Y: maxLat, Z: maxZ}}
-	}
+	bbox := searchBBox(obj, minLat, minLon, maxLat, maxLon, minZ, maxZ)
 	var bboxes []geojson.BBox
 	if sparse > 0 {
 		split := 1 << sparse
@@ -488,7 +507,7 @@ func (c *Collection) Intersects(sparse uint8, obj geojson.Object, minLat, minLon
 		}
 		for _, bbox := range bboxes {
 			if obj != nil {
-				keepon = c.geoSearch(bbox, func(id string, o geojson.Object, fields []float64) bool {
+				keepon = c.geoSearch(bbox, func(id string, o geojson.Object, fields []FieldValue) bool {
 					if o.Intersects(obj) {
 						if iterator(id, o, fields) {
 							return false
@@ -498,7 +517,7 @@ func (c *Collection) Intersects(sparse uint8, obj geojson.Object, minLat, minLon
 				})
 			}
 			if keepon {
-				keepon = c.geoSearch(bbox, func(id string, o geojson.Object, fields []float64) bool {
+				keepon = c.geoSearch(bbox, func(id string, o geojson.Object, fields []FieldValue) bool {
 					if o.IntersectsBBox(bbox) {
 						if iterator(id, o, fields) {
 							return false
@@ -514,14 +533,14 @@ func (c *Collection) Intersects(sparse uint8, obj geojson.Object, minLat, minLon
 		return keepon
 	}
 	if obj != nil {
-		return c.geoSearch(bbox, func(id string, o geojson.Object, fields []float64) bool {
+		return c.geoSearch(bbox, func(id string, o geojson.Object, fields []FieldValue) bool {
 			if o.Intersects(obj) {
 				return iterator(id, o, fields)
 			}
 			return true
 		})
 	}
-	return c.geoSearch(bbox, func(id string, o geojson.Object, fields []float64) bool {
+	return c.geoSearch(bbox, func(id string, o geojson.Object, fields []FieldValue) bool {
 		if o.IntersectsBBox(bbox) {
 			return iterator(id, o, fields)
 		}
@@ -529,7 +548,7 @@ func (c *Collection) Intersects(sparse uint8, obj geojson.Object, minLat, minLon
 	})
 }
 
-func (c *Collection) NearestNeighbors(lat, lon float64, iterator func(id string, obj geojson.Object, fields []float64) bool) bool {
+func (c *Collection) NearestNeighbors(lat, lon float64, iterator func(id string, obj geojson.Object, fields []FieldValue) bool) bool {
 	return c.index.NearestNeighbors(lat, lon, func(item interface{}) bool {
 		var iitm *itemT
 		iitm, ok := item.(*itemT)
@@ -542,3 +561,66 @@ func (c *Collection) NearestNeighbors(lat, lon float64, iterator func(id string,
 		return true
 	})
 }
+
+// Join iterates over the pairs of objects of two collections whose bounding
+// boxes are within meters of each other. The objects still need to be
+// checked for a match.
+func (c *Collection) Join(other *Collection, meters float64,
+	iterator func(idA string, objA geojson.Object, fieldsA []FieldValue,
+		idB string, objB geojson.Object, fieldsB []FieldValue) bool,
+) bool {
+	var padX, padY float64
+	if meters > 0 {
+		// the boxes of c are grown by the distance at its farthest latitude
+		_, minY, _, maxY := c.Bounds()
+		lat := math.Max(math.Abs(minY), math.Abs(maxY))
+		_, _, latMax, lonMax := geojson.BBoxBounds(lat, 0, meters)
+		padX, padY = lonMax, latMax-lat
+		if lonMax >= 180 {
+			padX = 360
+		}
+	}
+	return c.index.Join(other.index, padX, padY, func(a, b interface{}) bool {
+		iitmA, ok := a.(*itemT)
+		if !ok {
+			return true // just ignore
+		}
+		iitmB, ok := b.(*itemT)
+		if !ok {
+			return true // just ignore
+		}
+		return iterator(iitmA.id, iitmA.object, c.getFieldValues(iitmA.id),
+			iitmB.id, iitmB.object, other.getFieldValues(iitmB.id))
+	})
+}
+
+// SearchCount iterates over the objects that intersect a bounding box,
+// except for the objects in the parts of the index that the whole func
+// accepts. Those are passed to count as their number, without visiting them.
+func (c *Collection) SearchCount(bbox geojson.BBox,
+	whole func(bbox geojson.BBox) bool,
+	iterator func(id string, obj geojson.Object, fields []FieldValue) bool,
+	count func(bbox geojson.BBox, n int) bool,
+) bool {
+	nodeBBox := func(minX, minY, maxX, maxY float64) geojson.BBox {
+		return geojson.BBox{
+			Min: geojson.Position{X: minX, Y: minY},
+			Max: geojson.Position{X: maxX, Y: maxY},
+		}
+	}
+	return c.index.SearchCount(bbox.Min.Y, bbox.Min.X, bbox.Max.Y, bbox.Max.X, bbox.Min.Z, bbox.Max.Z,
+		func(minX, minY, maxX, maxY float64) bool {
+			return whole(nodeBBox(minX, minY, maxX, maxY))
+		},
+		func(item interface{}) bool {
+			iitm, ok := item.(*itemT)
+			if !ok {
+				return true // just ignore
+			}
+			return iterator(iitm.id, iitm.object, c.getFieldValues(iitm.id))
+		},
+		func(minX, minY, maxX, maxY float64, n int) bool {
+			return count(nodeBBox(minX, minY, maxX, maxY), n)
+		},
+	)
+}
@@ -2,6 +2,7 @@ package collection
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
 	"strconv"
 	"testing"
@@ -32,7 +33,7 @@ func TestCollection(t *testing.T) {
 	}
 	count := 0
 	bbox := geojson.BBox{Min: geojson.Position{X: -180, Y: -90, Z: 0}, Max: geojson.Position{X: 180, Y: 90, Z: 0}}
-	c.geoSearch(bbox, func(id string, obj geojson.Object, field []float64) bool {
+	c.geoSearch(bbox, func(id string, obj geojson.Object, field []FieldValue) bool {
 		count++
 		return true
 	})
@@ -84,7 +85,7 @@ func TestManyCollections(t *testing.T) {
 	col := colsM["13"]
 	//println(col.Count())
 	bbox := geojson.BBox{Min: geojson.Position{X: -180, Y: 30, Z: 0}, Max: geojson.Position{X: 34, Y: 100, Z: 0}}
-	col.geoSearch(bbox, func(id string, obj geojson.Object, fields []float64) bool {
+	col.geoSearch(bbox, func(id string, obj geojson.Object, fields []FieldValue) bool {
 		//println(id)
 		return true
 	})
@@ -188,3 +189,140 @@ func BenchmarkRemove(t *testing.B) {
 		}
 	}
 }
+
+func TestFieldValue(t *testing.T) {
+	tests := []struct {
+		input string
+		value FieldValue
+	}{
+		{"10.5", NumberValue(10.5)},
+		{"-1", NumberValue(-1)},
+		{"true", BoolValue(true)},
+		{"FALSE", BoolValue(false)},
+		{"hello", StringValue("hello")},
+		{`"007"`, StringValue("007")},
+		{`"true"`, StringValue("true")},
+		{`""hi""`, StringValue(`"hi"`)},
+		{"nan", StringValue("nan")},
+		{"-Inf", StringValue("-Inf")},
+	}
+	for _, test := range tests {
+		value := ParseFieldValue(test.input)
+		if value != test.value {
+			t.Fatalf("%s: expected %v, got %v", test.input, test.value, value)
+		}
+		if ParseFieldValue(value.Arg()) != value {
+			t.Fatalf("%s: round trip failed", test.input)
+		}
+	}
+	if !NumberValue(0).IsZero() || !StringValue("").IsZero() || BoolValue(false).IsZero() {
+		t.Fatal("invalid zero values")
+	}
+	c := New()
+	c.ReplaceOrInsert("1", geojson.SimplePoint{X: 1, Y: 1}, []string{"name"}, []FieldValue{StringValue("bob")})
+	_, fields, _ := c.Get("1")
+	if len(fields) != 1 || fields[0] != StringValue("bob") {
+		t.Fatalf("invalid fields %v", fields)
+	}
+}
+
+func TestFieldIndex(t *testing.T) {
+	c := New()
+	point := geojson.SimplePoint{X: 1, Y: 1}
+	for i := 0; i < 100; i++ {
+		id := strconv.FormatInt(int64(i), 10)
+		c.ReplaceOrInsert(id, point, []string{"speed"}, []FieldValue{NumberValue(float64(i % 10))})
+	}
+	if !c.AddIndex("speed") || c.AddIndex("speed") {
+		t.Fatal("expected one new index")
+	}
+	count := func(min, max float64) int {
+		var n int
+		c.ScanIndexRange("speed", min, max, false, func(id string, obj geojson.Object, fields []FieldValue) bool {
+			n++
+			return true
+		})
+		return n
+	}
+	if n := count(3, 4); n != 20 {
+		t.Fatalf("expected 20, got %d", n)
+	}
+	c.SetField("3", "speed", NumberValue(100))
+	c.Remove("13")
+	c.ReplaceOrInsert("23", point, []string{"speed"}, []FieldValue{StringValue("fast")})
+	c.ReplaceOrInsert("101", point, nil, nil)
+	if n := count(3, 4); n != 17 {
+		t.Fatalf("expected 17, got %d", n)
+	}
+	if n := count(math.Inf(-1), 0); n != 11 {
+		t.Fatalf("expected 11, got %d", n)
+	}
+	var n int
+	c.ScanIndex("speed", true, func(id string, obj geojson.Object, fields []FieldValue) bool {
+		if n == 0 && id != "23" {
+			t.Fatalf("expected 23, got %s", id)
+		}
+		if n == 1 && id != "3" {
+			t.Fatalf("expected 3, got %s", id)
+		}
+		n++
+		return true
+	})
+	if n != c.Count() {
+		t.Fatalf("expected %d, got %d", c.Count(), n)
+	}
+	c.SetField("4", "speed", NumberValue(math.NaN()))
+	c.SetField("14", "speed", NumberValue(math.NaN()))
+	if n := count(math.Inf(-1), math.Inf(1)); n != c.Count()-3 {
+		t.Fatalf("expected %d, got %d", c.Count()-3, n)
+	}
+	c.Remove("4")
+	var nans int
+	c.ScanIndex("speed", false, func(id string, obj geojson.Object, fields []FieldValue) bool {
+		if len(fields) > 0 && math.IsNaN(fields[0].Num) {
+			nans++
+		}
+		return true
+	})
+	if nans != 1 {
+		t.Fatalf("expected 1 NaN, got %d", nans)
+	}
+	if !c.RemoveIndex("speed") || c.HasIndex("speed") {
+		t.Fatal("expected the index to be removed")
+	}
+}
+
+func TestJoin(t *testing.T) {
+	randPoints := func(n int) *Collection {
+		c := New()
+		for i := 0; i < n; i++ {
+			p := geojson.Position{X: rand.Float64()*20 - 120, Y: rand.Float64()*60 + 10, Z: 0}
+			c.ReplaceOrInsert(strconv.Itoa(i), geojson.Point{Coordinates: p}, nil, nil)
+		}
+		return c
+	}
+	a, b := randPoints(2000), randPoints(1000)
+	const meters = 50000
+	pairs := make(map[[2]string]bool)
+	a.Join(b, meters, func(idA string, objA geojson.Object, fieldsA []FieldValue,
+		idB string, objB geojson.Object, fieldsB []FieldValue) bool {
+		pairs[[2]string{idA, idB}] = true
+		return true
+	})
+	var n int
+	a.Scan(false, func(idA string, objA geojson.Object, fields []FieldValue) bool {
+		b.Scan(false, func(idB string, objB geojson.Object, fields []FieldValue) bool {
+			if objA.CalculatedPoint().DistanceTo(objB.CalculatedPoint()) <= meters {
+				if !pairs[[2]string{idA, idB}] {
+					t.Fatalf("missing pair %s %s", idA, idB)
+				}
+				n++
+			}
+			return true
+		})
+		return true
+	})
+	if n == 0 || len(pairs) < n {
+		t.Fatalf("expected at least %d pairs, got %d", n, len(pairs))
+	}
+}
@@ -0,0 +1,124 @@
+package collection
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// FieldKind is the type of value stored in a field.
+type FieldKind byte
+
+const (
+	// Number is a float64 field value.
+	Number FieldKind = iota
+	// String is a string field value.
+	String
+	// Bool is a boolean field value.
+	Bool
+)
+
+// FieldValue is a typed field value. The zero value is the number zero.
+type FieldValue struct {
+	Kind FieldKind
+	Num  float64 // the number, or 1 and 0 for true and false booleans
+	Str  string  // the string, only used when Kind is String
+}
+
+// NumberValue returns a numeric field value.
+func NumberValue(n float64) FieldValue {
+	return FieldValue{Kind: Number, Num: n}
+}
+
+// StringValue returns a string field value.
+func StringValue(s string) FieldValue {
+	return FieldValue{Kind: String, Str: s}
+}
+
+// BoolValue returns a boolean field value.
+func BoolValue(t bool) FieldValue {
+	if t {
+		return FieldValue{Kind: Bool, Num: 1}
+	}
+	return FieldValue{Kind: Bool}
+}
+
+// ParseFieldValue converts user input into a typed field value.
+// Anything that parses as a finite float is a number, "true" and "false" are
+// booleans, and everything else is a string. A value in double quotes, like
+// "007", is always the string between the quotes.
+func ParseFieldValue(s string) FieldValue {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return StringValue(s[1 : len(s)-1])
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil && !math.IsNaN(n) && !math.IsInf(n, 0) {
+		return NumberValue(n)
+	}
+	switch strings.ToLower(s) {
+	case "true":
+		return BoolValue(true)
+	case "false":
+		return BoolValue(false)
+	}
+	return StringValue(s)
+}
+
+// IsZero returns true for values that are equal to an unset field.
+func (v FieldValue) IsZero() bool {
+	switch v.Kind {
+	case Number:
+		return v.Num == 0
+	case String:
+		return v.Str == ""
+	}
+	return false
+}
+
+// Equals compares two values. Booleans and numbers are compared by their
+// numeric value.
+func (v FieldValue) Equals(o FieldValue) bool {
+	if (v.Kind == String) != (o.Kind == String) {
+		return false
+	}
+	if v.Kind == String {
+		return v.Str == o.Str
+	}
+	return v.Num == o.Num
+}
+
+// String returns the textual representation of the value.
+func (v FieldValue) String() string {
+	switch v.Kind {
+	case String:
+		return v.Str
+	case Bool:
+		if v.Num != 0 {
+			return "true"
+		}
+		return "false"
+	}
+	return strconv.FormatFloat(v.Num, 'f', -1, 64)
+}
+
+// Arg returns the value as a command argument. The result can be passed to
+// ParseFieldValue to get the same value back, so strings that look like
+// numbers or booleans are quoted.
+func (v FieldValue) Arg() string {
+	if v.Kind == String && ParseFieldValue(v.Str) != v {
+		return `"` + v.Str + `"`
+	}
+	return v.String()
+}
+
+// Weight returns the in-memory cost of the value in bytes.
+func (v FieldValue) Weight() int {
+	return 8 + len(v.Str)
+}
+
+func fieldsWeight(fields []FieldValue) int {
+	var weight int
+	for _, v := range fields {
+		weight += v.Weight()
+	}
+	return weight
+}
@@ -0,0 +1,262 @@
+package collection
+
+import (
+	"math"
+	"sort"
+
+	"github.com/tidwall/btree"
+	"github.com/tidwall/tile38/geojson"
+)
+
+// fieldItemT is an entry in a field index. Every item in the collection has
+// an entry in each field index, using the zero value for unset fields.
+type fieldItemT struct {
+	value FieldValue
+	item  *itemT
+	last  bool // pivot that sorts after all items with the same value
+}
+
+func (i *fieldItemT) Less(item btree.Item, ctx interface{}) bool {
+	j := item.(*fieldItemT)
+	if cmp := CompareFieldValues(i.value, j.value); cmp != 0 {
+		return cmp < 0
+	}
+	if i.last || j.last {
+		return j.last && !i.last
+	}
+	return i.item.id < j.item.id
+}
+
+// CompareFieldValues returns -1, 0, or +1 depending on the ordering of two
+// values. Numbers and booleans are ordered by their numeric value and sort
+// before all strings. NaN sorts before all other numbers.
+func CompareFieldValues(a, b FieldValue) int {
+	if (a.Kind == String) != (b.Kind == String) {
+		if a.Kind == String {
+			return 1
+		}
+		return -1
+	}
+	if a.Kind == String {
+		if a.Str < b.Str {
+			return -1
+		}
+		if a.Str > b.Str {
+			return 1
+		}
+		return 0
+	}
+	if an, bn := math.IsNaN(a.Num), math.IsNaN(b.Num); an || bn {
+		// NaN sorts before all other numbers, so that the order is strict
+		switch {
+		case an && bn:
+			return 0
+		case an:
+			return -1
+		}
+		return 1
+	}
+	if a.Num < b.Num {
+		return -1
+	}
+	if a.Num > b.Num {
+		return 1
+	}
+	return 0
+}
+
+func (c *Collection) fieldValue(id string, field string) FieldValue {
+	idx, ok := c.fieldMap[field]
+	if !ok {
+		return FieldValue{}
+	}
+	fields := c.getFieldValues(id)
+	if idx < len(fields) {
+		return fields[idx]
+	}
+	return FieldValue{}
+}
+
+// indexInsert adds an item to all field indexes.
+func (c *Collection) indexInsert(item *itemT) {
+	for field, tr := range c.fieldIndexes {
+		tr.ReplaceOrInsert(&fieldItemT{value: c.fieldValue(item.id, field), item: item})
+	}
+}
+
+// indexRemove removes an item from all field indexes. It must be called
+// before the field values of the item change.
+func (c *Collection) indexRemove(item *itemT) {
+	for field, tr := range c.fieldIndexes {
+		tr.Delete(&fieldItemT{value: c.fieldValue(item.id, field), item: item})
+	}
+}
+
+// AddIndex creates an ordered index on a field. Returns false if the index
+// already exists.
+func (c *Collection) AddIndex(field string) bool {
+	if _, ok := c.fieldIndexes[field]; ok {
+		return false
+	}
+	tr := btree.New(128, nil)
+	c.items.Ascend(func(item btree.Item) bool {
+		iitm := item.(*itemT)
+		tr.ReplaceOrInsert(&fieldItemT{value: c.fieldValue(iitm.id, field), item: iitm})
+		return true
+	})
+	if c.fieldIndexes == nil {
+		c.fieldIndexes = make(map[string]*btree.BTree)
+	}
+	c.fieldIndexes[field] = tr
+	return true
+}
+
+// RemoveIndex removes the index on a field. Returns false if the index does
+// not exist.
+func (c *Collection) RemoveIndex(field string) bool {
+	if _, ok := c.fieldIndexes[field]; !ok {
+		return false
+	}
+	delete(c.fieldIndexes, field)
+	return true
+}
+
+// HasIndex returns true when the field is indexed.
+func (c *Collection) HasIndex(field string) bool {
+	_, ok := c.fieldIndexes[field]
+	return ok
+}
+
+// Indexes returns the names of the indexed fields in sorted order.
+func (c *Collection) Indexes() []string {
+	fields := make([]string, 0, len(c.fieldIndexes))
+	for field := range c.fieldIndexes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// ScanIndex iterates though the collection ordered by the value of an
+// indexed field. Items with equal values are ordered by id.
+func (c *Collection) ScanIndex(field string, desc bool,
+	iterator func(id string, obj geojson.Object, fields []FieldValue) bool,
+) bool {
+	tr, ok := c.fieldIndexes[field]
+	if !ok {
+		return true
+	}
+	var keepon = true
+	iter := func(item btree.Item) bool {
+		iitm := item.(*fieldItemT).item
+		keepon = iterator(iitm.id, iitm.object, c.getFieldValues(iitm.id))
+		return keepon
+	}
+	if desc {
+		tr.Descend(iter)
+	} else {
+		tr.Ascend(iter)
+	}
+	return keepon
+}
+
+// ScanIndexRange iterates though the items of an indexed field that have a
+// numeric value in the inclusive range [min, max].
+func (c *Collection) ScanIndexRange(field string, min, max float64, desc bool,
+	iterator func(id string, obj geojson.Object, fields []FieldValue) bool,
+) bool {
+	tr, ok := c.fieldIndexes[field]
+	if !ok {
+		return true
+	}
+	var keepon = true
+	lo := &fieldItemT{value: NumberValue(min), item: &itemT{}}
+	hi := &fieldItemT{value: NumberValue(max), last: true}
+	iter := func(item btree.Item) bool {
+		fitm := item.(*fieldItemT)
+		if fitm.value.Kind == String {
+			return false
+		}
+		if desc {
+			if !(fitm.value.Num >= min) {
+				// below the range, or NaN
+				return false
+			}
+		} else if fitm.value.Num > max {
+			return false
+		}
+		keepon = iterator(fitm.item.id, fitm.item.object, c.getFieldValues(fitm.item.id))
+		return keepon
+	}
+	if desc {
+		tr.DescendLessOrEqual(hi, iter)
+	} else {
+		tr.AscendGreaterOrEqual(lo, iter)
+	}
+	return keepon
+}
+
+// IndexRangeCount returns the number of items of an indexed field that have
+// a numeric value in the inclusive range [min, max]. Counting stops at limit.
+func (c *Collection) IndexRangeCount(field string, min, max float64, limit int) int {
+	var count int
+	c.ScanIndexRange(field, min, max, false,
+		func(id string, obj geojson.Object, fields []FieldValue) bool {
+			count++
+			return count < limit
+		},
+	)
+	return count
+}
+
+func bboxIntersects(a, b geojson.BBox) bool {
+	return a.Min.X <= b.Max.X && a.Max.X >= b.Min.X &&
+		a.Min.Y <= b.Max.Y && a.Max.Y >= b.Min.Y &&
+		a.Min.Z <= b.Max.Z && a.Max.Z >= b.Min.Z
+}
+
+// NearbyMatch returns true when Nearby, without sparse, would return the
+// object. It's used to filter the results of a field index walk.
+func NearbyMatch(o geojson.Object, lat, lon, meters, minZ, maxZ float64) bool {
+	if !o.IsGeometry() {
+		return false
+	}
+	bbox := geojson.BBoxesFromCenter(lat, lon, meters)
+	bbox.Min.Z, bbox.Max.Z = minZ, maxZ
+	if !bboxIntersects(o.CalculatedBBox(), bbox) {
+		return false
+	}
+	return o.Nearby(geojson.Position{X: lon, Y: lat, Z: 0}, meters)
+}
+
+// WithinMatch returns true when Within, without sparse, would return the
+// object. It's used to filter the results of a field index walk.
+func WithinMatch(o, obj geojson.Object, minLat, minLon, maxLat, maxLon, minZ, maxZ float64) bool {
+	if !o.IsGeometry() {
+		return false
+	}
+	bbox := searchBBox(obj, minLat, minLon, maxLat, maxLon, minZ, maxZ)
+	if !bboxIntersects(o.CalculatedBBox(), bbox) {
+		return false
+	}
+	if obj != nil {
+		return o.Within(obj)
+	}
+	return o.WithinBBox(bbox)
+}
+
+// IntersectsMatch returns true when Intersects, without sparse, would return
+// the object. It's used to filter the results of a field index walk.
+func IntersectsMatch(o, obj geojson.Object, minLat, minLon, maxLat, maxLon, minZ, maxZ float64) bool {
+	if !o.IsGeometry() {
+		return false
+	}
+	bbox := searchBBox(obj, minLat, minLon, maxLat, maxLon, minZ, maxZ)
+	if !bboxIntersects(o.CalculatedBBox(), bbox) {
+		return false
+	}
+	if obj != nil {
+		return o.Intersects(obj)
+	}
+	return o.IntersectsBBox(bbox)
+}
@@ -12,11 +12,18 @@ import (
 	"github.com/tidwall/resp"
 	"github.com/tidwall/tile38/controller/glob"
 	"github.com/tidwall/tile38/controller/server"
+	"github.com/tidwall/tile38/core"
 )
 
 const (
-	defaultKeepAlive     = 300 // seconds
-	defaultProtectedMode = "yes"
+	defaultKeepAlive       = 300 // seconds
+	defaultProtectedMode   = "yes"
+	defaultFailoverTimeout = 5 // seconds
+	defaultTLSAuthClients  = "no"
+	defaultTLSReplication  = "no"
+
+	defaultSlowlogLogSlowerThan = 10000 // microseconds
+	defaultSlowlogMaxLen        = 128
 )
 
 const (
@@ -26,9 +33,23 @@ const (
 	MaxMemory     = "maxmemory"
 	AutoGC        = "autogc"
 	KeepAlive     = "keepalive"
+
+	Peers           = "peers"
+	FailoverTimeout = "failover-timeout"
+	ReplBacklogSize = "repl-backlog-size"
+
+	TLSCertFile    = "tls-cert-file"
+	TLSKeyFile     = "tls-key-file"
+	TLSCACertFile  = "tls-ca-cert-file"
+	TLSAuthClients = "tls-auth-clients"
+	TLSReplication = "tls-replication"
+
+	SlowlogLogSlowerThan = "slowlog-log-slower-than"
+	SlowlogMaxLen        = "slowlog-max-len"
 )
 
-var validProperties = []string{RequirePass, LeaderAuth, ProtectedMode, MaxMemory, AutoGC, KeepAlive}
+var validProperties = []string{RequirePass, LeaderAuth, ProtectedMode, MaxMemory, AutoGC, KeepAlive, Peers, FailoverTimeout, ReplBacklogSize,
+	TLSCertFile, TLSKeyFile, TLSCACertFile, TLSAuthClients, TLSReplication, SlowlogLogSlowerThan, SlowlogMaxLen}
 
 // Config is a tile38 config
 type Config struct {
@@ -39,6 +60,11 @@ type Config struct {
 	ServerID   string `json:"server_id,omitempty"`
 	ReadOnly   bool   `json:"read_only,omitempty"`
 
+	FailoverEpoch int `json:"failover_epoch,omitempty"`
+
+	ReplID    string `json:"repl_id,omitempty"`
+	ReplShift int    `json:"repl_shift,omitempty"` // repl offset minus aof size
+
 	// Properties
 	RequirePassP   string `json:"requirepass,omitempty"`
 	RequirePass    string `json:"-"`
@@ -52,6 +78,31 @@ type Config struct {
 	AutoGC         uint64 `json:"-"`
 	KeepAliveP     string `json:"keepalive,omitempty"`
 	KeepAlive      int    `json:"-"`
+
+	PeersP           string   `json:"peers,omitempty"`
+	Peers            []string `json:"-"`
+	FailoverTimeoutP string   `json:"failover-timeout,omitempty"`
+	FailoverTimeout  int      `json:"-"`
+	ReplBacklogSizeP string   `json:"repl-backlog-size,omitempty"`
+	ReplBacklogSize  int      `json:"-"`
+
+	TLSCertFileP    string `json:"tls-cert-file,omitempty"`
+	TLSCertFile     string `json:"-"`
+	TLSKeyFileP     string `json:"tls-key-file,omitempty"`
+	TLSKeyFile      string `json:"-"`
+	TLSCACertFileP  string `json:"tls-ca-cert-file,omitempty"`
+	TLSCACertFile   string `json:"-"`
+	TLSAuthClientsP string `json:"tls-auth-clients,omitempty"`
+	TLSAuthClients  string `json:"-"`
+	TLSReplicationP string `json:"tls-replication,omitempty"`
+	TLSReplication  string `json:"-"`
+
+	SlowlogLogSlowerThanP string `json:"slowlog-log-slower-than,omitempty"`
+	SlowlogLogSlowerThan  int    `json:"-"`
+	SlowlogMaxLenP        string `json:"slowlog-max-len,omitempty"`
+	SlowlogMaxLen         int    `json:"-"`
+
+	Users map[string]*aclUser `json:"users,omitempty"` // acl users by name
 }
 
 func (c *Controller) loadConfig() error {
@@ -85,6 +136,57 @@ func (c *Controller) loadConfig() error {
 	if err := c.setConfigProperty(KeepAlive, c.config.KeepAliveP, true); err != nil {
 		return err
 	}
+	if err := c.setConfigProperty(Peers, c.config.PeersP, true); err != nil {
+		return err
+	}
+	if err := c.setConfigProperty(FailoverTimeout, c.config.FailoverTimeoutP, true); err != nil {
+		return err
+	}
+	if err := c.setConfigProperty(ReplBacklogSize, c.config.ReplBacklogSizeP, true); err != nil {
+		return err
+	}
+	if err := c.setConfigProperty(TLSCertFile, c.config.TLSCertFileP, true); err != nil {
+		return err
+	}
+	if err := c.setConfigProperty(TLSKeyFile, c.config.TLSKeyFileP, true); err != nil {
+		return err
+	}
+	if err := c.setConfigProperty(TLSCACertFile, c.config.TLSCACertFileP, true); err != nil {
+		return err
+	}
+	if err := c.setConfigProperty(TLSAuthClients, c.config.TLSAuthClientsP, true); err != nil {
+		return err
+	}
+	if err := c.setConfigProperty(TLSReplication, c.config.TLSReplicationP, true); err != nil {
+		return err
+	}
+	if err := c.setConfigProperty(SlowlogLogSlowerThan, c.config.SlowlogLogSlowerThanP, true); err != nil {
+		return err
+	}
+	if err := c.setConfigProperty(SlowlogMaxLen, c.config.SlowlogMaxLenP, true); err != nil {
+		return err
+	}
+	return nil
+}
+
+// loadTLSFlags sets the tls properties that are provided on the command
+// line, which take precedence over the config file.
+func (c *Controller) loadTLSFlags() error {
+	flags := []struct{ name, value string }{
+		{TLSCertFile, core.TLSCertFile},
+		{TLSKeyFile, core.TLSKeyFile},
+		{TLSCACertFile, core.TLSCACertFile},
+		{TLSAuthClients, core.TLSAuthClients},
+		{TLSReplication, core.TLSReplication},
+	}
+	for _, flag := range flags {
+		if flag.value == "" {
+			continue
+		}
+		if err := c.setConfigProperty(flag.name, flag.value, false); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -183,6 +285,82 @@ func (c *Controller) setConfigProperty(name, value string, fromLoad bool) error
 				c.config.KeepAlive = int(keepalive)
 			}
 		}
+	case Peers:
+		peers, ok := parsePeers(value)
+		if !ok {
+			invalid = true
+		} else {
+			c.config.Peers = peers
+		}
+	case FailoverTimeout:
+		if value == "" {
+			c.config.FailoverTimeout = defaultFailoverTimeout
+		} else {
+			timeout, err := strconv.ParseUint(value, 10, 64)
+			if err != nil || timeout == 0 {
+				invalid = true
+			} else {
+				c.config.FailoverTimeout = int(timeout)
+			}
+		}
+	case ReplBacklogSize:
+		if value == "" {
+			c.config.ReplBacklogSize = defaultReplBacklogSize
+		} else {
+			sz, ok := parseMemSize(value)
+			if !ok || sz == 0 {
+				invalid = true
+			} else {
+				c.config.ReplBacklogSize = sz
+			}
+		}
+	case SlowlogLogSlowerThan:
+		if value == "" {
+			c.config.SlowlogLogSlowerThan = defaultSlowlogLogSlowerThan
+		} else {
+			micros, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				invalid = true
+			} else {
+				c.config.SlowlogLogSlowerThan = int(micros)
+			}
+		}
+	case SlowlogMaxLen:
+		if value == "" {
+			c.config.SlowlogMaxLen = defaultSlowlogMaxLen
+		} else {
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				invalid = true
+			} else {
+				c.config.SlowlogMaxLen = int(n)
+			}
+		}
+		c.slowlog.setMaxLen(c.config.SlowlogMaxLen)
+	case TLSCertFile:
+		c.config.TLSCertFile = value
+	case TLSKeyFile:
+		c.config.TLSKeyFile = value
+	case TLSCACertFile:
+		c.config.TLSCACertFile = value
+	case TLSAuthClients:
+		switch strings.ToLower(value) {
+		case "":
+			c.config.TLSAuthClients = defaultTLSAuthClients
+		case "yes", "no", "optional":
+			c.config.TLSAuthClients = strings.ToLower(value)
+		default:
+			invalid = true
+		}
+	case TLSReplication:
+		switch strings.ToLower(value) {
+		case "":
+			c.config.TLSReplication = defaultTLSReplication
+		case "yes", "no":
+			c.config.TLSReplication = strings.ToLower(value)
+		default:
+			invalid = true
+		}
 	}
 
 	if invalid {
@@ -217,11 +395,42 @@ func (c *Controller) getConfigProperty(name string) string {
 		return formatMemSize(c.config.MaxMemory)
 	case KeepAlive:
 		return strconv.FormatUint(uint64(c.config.KeepAlive), 10)
+	case Peers:
+		return strings.Join(c.config.Peers, ",")
+	case FailoverTimeout:
+		return strconv.FormatUint(uint64(c.config.FailoverTimeout), 10)
+	case ReplBacklogSize:
+		return formatMemSize(c.config.ReplBacklogSize)
+	case SlowlogLogSlowerThan:
+		return strconv.FormatInt(int64(c.config.SlowlogLogSlowerThan), 10)
+	case SlowlogMaxLen:
+		return strconv.FormatUint(uint64(c.config.SlowlogMaxLen), 10)
+	case TLSCertFile:
+		return c.config.TLSCertFile
+	case TLSKeyFile:
+		return c.config.TLSKeyFile
+	case TLSCACertFile:
+		return c.config.TLSCACertFile
+	case TLSAuthClients:
+		if c.config.TLSAuthClients == "" {
+			return defaultTLSAuthClients
+		}
+		return c.config.TLSAuthClients
+	case TLSReplication:
+		if c.config.TLSReplication == "" {
+			return defaultTLSReplication
+		}
+		return c.config.TLSReplication
 	}
 }
 
 func (c *Controller) initConfig() error {
-	c.config = Config{ServerID: randomKey(16)}
+	c.config = Config{
+		ServerID:             randomKey(16),
+		SlowlogLogSlowerThan: defaultSlowlogLogSlowerThan,
+		SlowlogMaxLen:        defaultSlowlogMaxLen,
+	}
+	c.slowlog.setMaxLen(defaultSlowlogMaxLen)
 	return c.writeConfig(true)
 }
 
@@ -254,6 +463,40 @@ func (c *Controller) writeConfig(writeProperties bool) error {
 		} else {
 			c.config.KeepAliveP = strconv.FormatUint(uint64(c.config.KeepAlive), 10)
 		}
+		c.config.PeersP = strings.Join(c.config.Peers, ",")
+		if c.config.FailoverTimeout == 0 || c.config.FailoverTimeout == defaultFailoverTimeout {
+			c.config.FailoverTimeoutP = ""
+		} else {
+			c.config.FailoverTimeoutP = strconv.FormatUint(uint64(c.config.FailoverTimeout), 10)
+		}
+		if c.config.ReplBacklogSize == 0 || c.config.ReplBacklogSize == defaultReplBacklogSize {
+			c.config.ReplBacklogSizeP = ""
+		} else {
+			c.config.ReplBacklogSizeP = formatMemSize(c.config.ReplBacklogSize)
+		}
+		c.config.TLSCertFileP = c.config.TLSCertFile
+		c.config.TLSKeyFileP = c.config.TLSKeyFile
+		c.config.TLSCACertFileP = c.config.TLSCACertFile
+		if c.config.TLSAuthClients == defaultTLSAuthClients {
+			c.config.TLSAuthClientsP = ""
+		} else {
+			c.config.TLSAuthClientsP = c.config.TLSAuthClients
+		}
+		if c.config.TLSReplication == defaultTLSReplication {
+			c.config.TLSReplicationP = ""
+		} else {
+			c.config.TLSReplicationP = c.config.TLSReplication
+		}
+		if c.config.SlowlogLogSlowerThan == defaultSlowlogLogSlowerThan {
+			c.config.SlowlogLogSlowerThanP = ""
+		} else {
+			c.config.SlowlogLogSlowerThanP = strconv.FormatInt(int64(c.config.SlowlogLogSlowerThan), 10)
+		}
+		if c.config.SlowlogMaxLen == defaultSlowlogMaxLen {
+			c.config.SlowlogMaxLenP = ""
+		} else {
+			c.config.SlowlogMaxLenP = strconv.FormatUint(uint64(c.config.SlowlogMaxLen), 10)
+		}
 	}
 	var data []byte
 	data, err = json.MarshalIndent(c.config, "", "\t")
@@ -314,9 +557,18 @@ func (c *Controller) cmdConfigSet(msg *server.Message) (res string, err error) {
 	if len(vs) != 0 {
 		return "", errInvalidNumberOfArguments
 	}
+	bak := c.config
 	if err := c.setConfigProperty(name, value, false); err != nil {
 		return "", err
 	}
+	if strings.HasPrefix(strings.ToLower(name), "tls-") {
+		// the listener keeps its tls config until restart, but the
+		// replication connections use the new config right away.
+		if _, c.replTLS, err = c.tlsConfigs(); err != nil {
+			c.config = bak
+			return "", err
+		}
+	}
 	return server.OKMessage(msg, start), nil
 }
 func (c *Controller) cmdConfigRewrite(msg *server.Message) (res string, err error) {
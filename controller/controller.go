@@ -3,6 +3,7 @@ package controller
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -24,11 +25,13 @@ import (
 	"github.com/tidwall/tile38/controller/server"
 	"github.com/tidwall/tile38/core"
 	"github.com/tidwall/tile38/geojson"
+	lua "github.com/yuin/gopher-lua"
 )
 
 var errOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'")
 
 const hookLogPrefix = "hook:log:"
+const hookDLQPrefix = "hook:dlq:"
 
 type collectionT struct {
 	Key        string
@@ -39,12 +42,12 @@ type commandDetailsT struct {
 	command   string
 	key, id   string
 	field     string
-	value     float64
+	value     collection.FieldValue
 	obj       geojson.Object
-	fields    []float64
+	fields    []collection.FieldValue
 	fmap      map[string]int
 	oldObj    geojson.Object
-	oldFields []float64
+	oldFields []collection.FieldValue
 	updated   bool
 	timestamp time.Time
 
@@ -63,6 +66,8 @@ type clientConn struct {
 	opened time.Time
 	last   time.Time
 	conn   *server.Conn
+	multi  multiT // transaction state
+	user   string // acl user, empty for the default user
 }
 
 // Controller is a tile38 controller
@@ -83,12 +88,21 @@ type Controller struct {
 	lstack    []*commandDetailsT
 	lives     map[*liveBuffer]bool
 	lcond     *sync.Cond
-	fcup      bool                        // follow caught up
-	fcuponce  bool                        // follow caught up once
-	shrinking bool                        // aof shrinking flag
-	shrinklog [][]string                  // aof shrinking log
-	hooks     map[string]*Hook            // hook name
-	hookcols  map[string]map[string]*Hook // col key
+	fcup      bool                          // follow caught up
+	fcuponce  bool                          // follow caught up once
+	shrinking bool                          // aof shrinking flag
+	shrinklog [][]string                    // aof shrinking log
+	hooks     map[string]*Hook              // hook name
+	hookcols  map[string]map[string]*Hook   // col key
+	hookdwls  map[string]*Hook              // hooks that detect dwell or loiter
+	ndwls     int32                         // number of hookdwls, atomic
+	pubsub    *pubsub                       // channel subscribers
+	monitors  *monitors                     // connections in monitor mode
+	watching  map[*clientConn]bool          // connections with watched objects
+	fmulti    [][]resp.Value                // follower transaction being received
+	fleader   int                           // leader offset at the last sync
+	scripts   map[string]*lua.FunctionProto // compiled scripts by sha1
+	replTLS   *tls.Config                   // tls config for the leader and peers
 	aofconnM  map[net.Conn]bool
 	expires   map[string]map[string]time.Time
 	exlist    []exitem
@@ -98,6 +112,17 @@ type Controller struct {
 
 	epc *endpoint.EndpointManager
 
+	fieldIndexes map[string]map[string]bool // indexed fields by col key
+	histories    map[string]*historyT       // position histories by col key
+
+	snap     *snapshotView // the snapshot that is being written
+	lastSave time.Time     // time of the last successful snapshot
+
+	failover failoverT // failover peer monitor
+	repl     replT     // replication stream and backlog
+	metrics  metricsT  // command counts and latencies
+	slowlog  slowlogT  // most recent slow commands
+
 	statsTotalConns    int
 	statsTotalCommands int
 	statsExpired       int
@@ -106,8 +131,10 @@ type Controller struct {
 	currentShrinkStart time.Time
 
 	stopBackgroundExpiring bool
+	stopBackgroundDwelling bool
 	stopWatchingMemory     bool
 	stopWatchingAutoGC     bool
+	stopWatchingPeers      bool
 	outOfMemory            bool
 }
 
@@ -128,12 +155,19 @@ func ListenAndServeEx(host string, port int, dir string, ln *net.Listener, http
 		lcond:    sync.NewCond(&sync.Mutex{}),
 		hooks:    make(map[string]*Hook),
 		hookcols: make(map[string]map[string]*Hook),
+		pubsub:   newPubsub(),
+		monitors: newMonitors(),
+		watching: make(map[*clientConn]bool),
+		scripts:  make(map[string]*lua.FunctionProto),
 		aofconnM: make(map[net.Conn]bool),
 		expires:  make(map[string]map[string]time.Time),
 		started:  time.Now(),
 		conns:    make(map[*server.Conn]*clientConn),
 		epc:      endpoint.NewEndpointManager(),
 		http:     http,
+
+		fieldIndexes: make(map[string]map[string]bool),
+		histories:    make(map[string]*historyT),
 	}
 	if err := os.MkdirAll(dir, 0700); err != nil {
 		return err
@@ -141,6 +175,14 @@ func ListenAndServeEx(host string, port int, dir string, ln *net.Listener, http
 	if err := c.loadConfig(); err != nil {
 		return err
 	}
+	if err := c.loadTLSFlags(); err != nil {
+		return err
+	}
+	tlsConfig, replTLS, err := c.tlsConfigs()
+	if err != nil {
+		return err
+	}
+	c.replTLS = replTLS
 	// load the queue before the aof
 	qdb, err := buntdb.Open(path.Join(dir, "queue.db"))
 	if err != nil {
@@ -164,6 +206,10 @@ func ListenAndServeEx(host string, port int, dir string, ln *net.Listener, http
 	if err != nil {
 		return err
 	}
+	err = qdb.CreateIndex("dlq", hookDLQPrefix+"*", buntdb.IndexJSONCaseSensitive("hook"))
+	if err != nil {
+		return err
+	}
 	c.qdb = qdb
 	c.qidx = qidx
 	if err := c.migrateAOF(); err != nil {
@@ -174,11 +220,15 @@ func ListenAndServeEx(host string, port int, dir string, ln *net.Listener, http
 		return err
 	}
 	c.f = f
+	if err := c.loadSnapshot(); err != nil {
+		return err
+	}
 	if err := c.loadAOF(); err != nil {
 		return err
 	}
 	c.mu.Lock()
 	c.fillExpiresList()
+	c.replInit()
 	if c.config.FollowHost != "" {
 		go c.follow(c.config.FollowHost, c.config.FollowPort, c.followc)
 	}
@@ -188,25 +238,47 @@ func ListenAndServeEx(host string, port int, dir string, ln *net.Listener, http
 		c.followc++ // this will force any follow communication to die
 		c.mu.Unlock()
 	}()
+	if core.MetricsAddr != "" {
+		if err := c.serveMetrics(core.MetricsAddr); err != nil {
+			return err
+		}
+	}
 	go c.processLives()
 	go c.watchMemory()
 	go c.watchGC()
 	go c.backgroundExpiring()
+	go c.backgroundDwelling()
+	go c.watchPeers()
 	defer func() {
 		c.mu.Lock()
 		c.stopBackgroundExpiring = true
+		c.stopBackgroundDwelling = true
 		c.stopWatchingMemory = true
 		c.stopWatchingAutoGC = true
+		c.stopWatchingPeers = true
 		c.mu.Unlock()
 	}()
 	handler := func(conn *server.Conn, msg *server.Message, rd *server.AnyReaderWriter, w io.Writer, websocket bool) error {
+		addr := conn.RemoteAddr().String()
+		var name string
 		c.mu.Lock()
 		if cc, ok := c.conns[conn]; ok {
 			cc.last = time.Now()
+			name = cc.name
 		}
 		c.statsTotalCommands++
+		slowerThan := time.Duration(c.config.SlowlogLogSlowerThan) * time.Microsecond
 		c.mu.Unlock()
+		c.monitors.feed(addr, msg)
+		start := time.Now()
 		err := c.handleInputCommand(conn, msg, w)
+		dur := time.Now().Sub(start)
+		// the controller lock is released by now, so the metrics and the
+		// slowlog have their own locks
+		c.metrics.observe(msg.Command, dur)
+		if slowerThan >= 0 && dur >= slowerThan && msg.Command != "monitor" {
+			c.slowlog.add(msg, start, dur, addr, name)
+		}
 		if err != nil {
 			if err.Error() == "going live" {
 				return c.goLive(err, conn, rd, msg, websocket)
@@ -252,10 +324,13 @@ func ListenAndServeEx(host string, port int, dir string, ln *net.Listener, http
 	}
 	closed := func(conn *server.Conn) {
 		c.mu.Lock()
+		if cc, ok := c.conns[conn]; ok {
+			c.unwatch(cc)
+		}
 		delete(c.conns, conn)
 		c.mu.Unlock()
 	}
-	return server.ListenAndServe(host, port, protected, handler, opened, closed, ln, http)
+	return server.ListenAndServe(host, port, protected, handler, opened, closed, ln, http, tlsConfig)
 }
 
 func (c *Controller) watchGC() {
@@ -333,6 +408,7 @@ func (c *Controller) watchMemory() {
 }
 
 func (c *Controller) setCol(key string, col *collection.Collection) {
+	c.applyIndexes(key, col)
 	c.cols.ReplaceOrInsert(&collectionT{Key: key, Collection: col})
 }
 
@@ -442,7 +518,17 @@ func (c *Controller) handleInputCommand(conn *server.Conn, msg *server.Message,
 
 	var write bool
 
-	if !conn.Authenticated || msg.Command == "auth" {
+	if username, password := authCredentials(msg); username != "" &&
+		(!conn.Authenticated || msg.Command == "auth") {
+		// named user
+		if err := c.aclLogin(conn, username, password); err != nil {
+			return writeErr(err)
+		}
+		conn.Authenticated = true
+		if msg.ConnType != server.HTTP || msg.Command == "auth" {
+			return writeOutput(server.OKMessage(msg, start))
+		}
+	} else if !conn.Authenticated || msg.Command == "auth" {
 		c.mu.RLock()
 		requirePass := c.config.RequirePass
 		c.mu.RUnlock()
@@ -463,6 +549,9 @@ func (c *Controller) handleInputCommand(conn *server.Conn, msg *server.Message,
 			if requirePass != strings.TrimSpace(password) {
 				return writeErr(errors.New("invalid password"))
 			}
+			if err := c.aclLogin(conn, defaultUser, password); err != nil {
+				return writeErr(err)
+			}
 			conn.Authenticated = true
 			if msg.ConnType != server.HTTP {
 				return writeOutput(server.OKMessage(msg, start))
@@ -471,13 +560,35 @@ func (c *Controller) handleInputCommand(conn *server.Conn, msg *server.Message,
 			return writeErr(errors.New("invalid password"))
 		}
 	}
+	if err := c.aclCheck(conn, msg); err != nil {
+		return writeErr(err)
+	}
+	// transactions
+	switch msg.Command {
+	case "multi", "exec", "discard", "watch", "unwatch":
+		res, err := c.cmdMulti(conn, msg)
+		if err != nil {
+			return writeErr(err)
+		}
+		return writeOutput(res)
+	}
+	if m := c.clientMulti(conn); m != nil && m.on {
+		res, err := c.queueMulti(m, msg)
+		if err != nil {
+			return writeErr(err)
+		}
+		return writeOutput(res)
+	}
+
 	// choose the locking strategy
 	switch msg.Command {
 	default:
 		c.mu.RLock()
 		defer c.mu.RUnlock()
 	case "set", "del", "drop", "fset", "flushdb", "sethook", "pdelhook", "delhook",
-		"expire", "persist", "jset", "pdel":
+		"setchan", "pdelchan", "delchan",
+		"expire", "persist", "jset", "pdel", "index", "delindex",
+		"sethistory", "delhistory", "histadd":
 		// write operations
 		write = true
 		c.mu.Lock()
@@ -488,21 +599,28 @@ func (c *Controller) handleInputCommand(conn *server.Conn, msg *server.Message,
 		if c.config.ReadOnly {
 			return writeErr(errors.New("read only"))
 		}
-	case "get", "keys", "scan", "nearby", "within", "intersects", "hooks", "search",
-		"ttl", "bounds", "server", "info", "type", "jget":
+	case "get", "keys", "scan", "nearby", "within", "intersects", "hooks", "chans", "search",
+		"ttl", "bounds", "server", "info", "type", "jget", "indexes", "lastsave", "history",
+		"join":
 		// read operations
 		c.mu.RLock()
 		defer c.mu.RUnlock()
 		if c.config.FollowHost != "" && !c.fcuponce {
 			return writeErr(errors.New("catching up to leader"))
 		}
-	case "follow", "readonly", "config":
+	case "follow", "readonly", "config", "script", "acl":
 		// system operations
 		// does not write to aof, but requires a write lock.
 		c.mu.Lock()
 		defer c.mu.Unlock()
 	case "output":
 		// this is local connection operation. Locks not needed.
+	case "subscribe", "psubscribe":
+		// the subscription mode manages its own locks
+	case "eval", "evalsha":
+		// scripts manage their own locks
+	case "slowlog", "monitor":
+		// the slowlog and monitors have their own locks
 	case "echo":
 	case "massinsert":
 		// dev operation
@@ -512,7 +630,13 @@ func (c *Controller) handleInputCommand(conn *server.Conn, msg *server.Message,
 		// dev operation
 		c.mu.Lock()
 		defer c.mu.Unlock()
-	case "aofshrink":
+	case "aofshrink", "bgsave":
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+	case "save":
+		// locks are managed by the snapshot writer
+	case "role", "replicas":
+		// replication status, which is available while catching up
 		c.mu.RLock()
 		defer c.mu.RUnlock()
 	case "client":
@@ -582,11 +706,25 @@ func (c *Controller) command(
 	case "flushdb":
 		res, d, err = c.cmdFlushDB(msg)
 	case "sethook":
-		res, d, err = c.cmdSetHook(msg)
+		res, d, err = c.cmdSetHook(msg, false)
 	case "delhook":
-		res, d, err = c.cmdDelHook(msg)
+		res, d, err = c.cmdDelHook(msg, false)
 	case "pdelhook":
-		res, d, err = c.cmdPDelHook(msg)
+		res, d, err = c.cmdPDelHook(msg, false)
+	case "setchan":
+		res, d, err = c.cmdSetHook(msg, true)
+	case "delchan":
+		res, d, err = c.cmdDelHook(msg, true)
+	case "pdelchan":
+		res, d, err = c.cmdPDelHook(msg, true)
+	case "chans":
+		res, err = c.cmdHooks(msg, true)
+	case "subscribe", "psubscribe":
+		res, err = c.cmdSubscribe(msg)
+	case "eval", "evalsha":
+		res, err = c.cmdEval(msg, conn)
+	case "script":
+		res, err = c.cmdScript(msg)
 	case "expire":
 		res, d, err = c.cmdExpire(msg)
 	case "persist":
@@ -594,7 +732,23 @@ func (c *Controller) command(
 	case "ttl":
 		res, err = c.cmdTTL(msg)
 	case "hooks":
-		res, err = c.cmdHooks(msg)
+		res, err = c.cmdHooks(msg, false)
+	case "index":
+		res, d, err = c.cmdIndex(msg)
+	case "delindex":
+		res, d, err = c.cmdDelIndex(msg)
+	case "indexes":
+		res, err = c.cmdIndexes(msg)
+	case "sethistory":
+		res, d, err = c.cmdSetHistory(msg)
+	case "delhistory":
+		res, d, err = c.cmdDelHistory(msg)
+	case "histadd":
+		res, d, err = c.cmdHistAdd(msg)
+	case "history":
+		res, err = c.cmdHistory(msg)
+	case "join":
+		res, err = c.cmdJoin(msg)
 	case "shutdown":
 		if !core.DevMode {
 			err = fmt.Errorf("unknown command '%s'", msg.Values[0])
@@ -657,6 +811,18 @@ func (c *Controller) command(
 	case "aofshrink":
 		go c.aofshrink()
 		res = server.OKMessage(msg, time.Now())
+	case "save":
+		res, err = c.cmdSave(msg)
+	case "bgsave":
+		res, err = c.cmdBgSave(msg)
+	case "lastsave":
+		res, err = c.cmdLastSave(msg)
+	case "psync":
+		res, err = c.cmdPSync(msg)
+	case "role":
+		res, err = c.cmdRole(msg)
+	case "replicas":
+		res, err = c.cmdReplicas(msg)
 	case "config get":
 		res, err = c.cmdConfigGet(msg)
 	case "config set":
@@ -674,6 +840,12 @@ func (c *Controller) command(
 		}
 	case "client":
 		res, err = c.cmdClient(msg, conn)
+	case "slowlog":
+		res, err = c.cmdSlowlog(msg)
+	case "monitor":
+		res, err = c.cmdMonitor(msg)
+	case "acl":
+		res, err = c.cmdACL(msg, conn)
 	}
 	return
 }
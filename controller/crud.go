@@ -18,7 +18,7 @@ import (
 
 type fvt struct {
 	field string
-	value float64
+	value collection.FieldValue
 }
 
 type byField []fvt
@@ -33,14 +33,14 @@ func (a byField) Swap(i, j int) {
 	a[i], a[j] = a[j], a[i]
 }
 
-func orderFields(fmap map[string]int, fields []float64) []fvt {
+func orderFields(fmap map[string]int, fields []collection.FieldValue) []fvt {
 	var fv fvt
 	fvs := make([]fvt, 0, len(fmap))
 	for field, idx := range fmap {
 		if idx < len(fields) {
 			fv.field = field
 			fv.value = fields[idx]
-			if fv.value != 0 {
+			if !fv.value.IsZero() {
 				fvs = append(fvs, fv)
 			}
 		}
@@ -154,16 +154,40 @@ func (c *Controller) cmdGet(msg *server.Message) (string, error) {
 		withfields = true
 		vs = vs[1:]
 	}
+	var at time.Time
+	if _, peek, ok := tokenval(vs); ok && strings.ToLower(peek) == "at" {
+		var sat string
+		if vs, sat, ok = tokenval(vs[1:]); !ok || sat == "" {
+			return "", errInvalidNumberOfArguments
+		}
+		var err error
+		if at, err = parseHistoryTime(sat); err != nil {
+			return "", err
+		}
+	}
 
-	col := c.getCol(key)
-	if col == nil {
-		if msg.OutputType == server.RESP {
-			return "$-1\r\n", nil
+	var col *collection.Collection
+	var o geojson.Object
+	var fields []collection.FieldValue
+	if !at.IsZero() {
+		// the object as it was at a time, which has no fields
+		h, found := c.histories[key]
+		if !found {
+			return "", errHistoryNotEnabled
 		}
-		return "", errKeyNotFound
+		o = h.at(id, at, time.Now())
+		ok = o != nil
+	} else {
+		col = c.getCol(key)
+		if col == nil {
+			if msg.OutputType == server.RESP {
+				return "$-1\r\n", nil
+			}
+			return "", errKeyNotFound
+		}
+		o, fields, ok = col.Get(id)
+		ok = ok && !c.hasExpired(key, id)
 	}
-	o, fields, ok := col.Get(id)
-	ok = ok && !c.hasExpired(key, id)
 	if !ok {
 		if msg.OutputType == server.RESP {
 			return "$-1\r\n", nil
@@ -252,7 +276,7 @@ func (c *Controller) cmdGet(msg *server.Message) (string, error) {
 	if len(vs) != 0 {
 		return "", errInvalidNumberOfArguments
 	}
-	if withfields {
+	if withfields && col != nil {
 		fvs := orderFields(col.FieldMap(), fields)
 		if len(fvs) > 0 {
 			fvals := make([]resp.Value, 0, len(fvs)*2)
@@ -264,9 +288,9 @@ func (c *Controller) cmdGet(msg *server.Message) (string, error) {
 					if i > 0 {
 						buf.WriteString(`,`)
 					}
-					buf.WriteString(jsonString(fv.field) + ":" + strconv.FormatFloat(fv.value, 'f', -1, 64))
+					buf.WriteString(jsonString(fv.field) + ":" + fieldValueJSON(fv.value))
 				} else {
-					fvals = append(fvals, resp.StringValue(fv.field), resp.StringValue(strconv.FormatFloat(fv.value, 'f', -1, 64)))
+					fvals = append(fvals, resp.StringValue(fv.field), resp.StringValue(fv.value.String()))
 				}
 				i++
 			}
@@ -316,12 +340,18 @@ func (c *Controller) cmdDel(msg *server.Message) (res string, d commandDetailsT,
 	found := false
 	col := c.getCol(d.key)
 	if col != nil {
+		c.snapshotTouch(d.key, d.id)
 		d.obj, d.fields, ok = col.Remove(d.id)
 		if ok {
 			if col.Count() == 0 {
 				c.deleteCol(d.key)
 			}
 			found = true
+			// the fields of the deleted object, for the fence notifications
+			d.fmap = make(map[string]int)
+			for key, idx := range col.FieldMap() {
+				d.fmap[key] = idx
+			}
 		}
 	}
 	c.clearIDExpires(d.key, d.id)
@@ -358,7 +388,7 @@ func (c *Controller) cmdPdel(msg *server.Message) (res string, d commandDetailsT
 		return
 	}
 	now := time.Now()
-	iter := func(id string, o geojson.Object, fields []float64) bool {
+	iter := func(id string, o geojson.Object, fields []collection.FieldValue) bool {
 		if match, _ := glob.Match(d.pattern, id); match {
 			d.children = append(d.children, &commandDetailsT{
 				command:   "del",
@@ -380,8 +410,15 @@ func (c *Controller) cmdPdel(msg *server.Message) (res string, d commandDetailsT
 		} else {
 			col.ScanRange(g.Limits[0], g.Limits[1], false, iter)
 		}
+		// the fields of the deleted objects, for the fence notifications
+		fmap := make(map[string]int)
+		for key, idx := range col.FieldMap() {
+			fmap[key] = idx
+		}
 		var atLeastOneNotDeleted bool
 		for i, dc := range d.children {
+			dc.fmap = fmap
+			c.snapshotTouch(d.key, dc.id)
 			dc.obj, dc.fields, ok = col.Remove(dc.id)
 			if !ok {
 				d.children[i].command = "?"
@@ -444,6 +481,7 @@ func (c *Controller) cmdDrop(msg *server.Message) (res string, d commandDetailsT
 	d.command = "drop"
 	d.timestamp = time.Now()
 	c.clearKeyExpires(d.key)
+	c.clearHistory(d.key)
 	switch msg.OutputType {
 	case server.JSON:
 		res = `{"ok":true,"elapsed":"` + time.Now().Sub(start).String() + "\"}"
@@ -468,6 +506,9 @@ func (c *Controller) cmdFlushDB(msg *server.Message) (res string, d commandDetai
 	c.clearAllExpires()
 	c.hooks = make(map[string]*Hook)
 	c.hookcols = make(map[string]map[string]*Hook)
+	c.resetDwellHooks()
+	c.fieldIndexes = make(map[string]map[string]bool)
+	c.histories = make(map[string]*historyT)
 	d.command = "flushdb"
 	d.updated = true
 	d.timestamp = time.Now()
@@ -481,7 +522,7 @@ func (c *Controller) cmdFlushDB(msg *server.Message) (res string, d commandDetai
 }
 
 func (c *Controller) parseSetArgs(vs []resp.Value) (
-	d commandDetailsT, fields []string, values []float64,
+	d commandDetailsT, fields []string, values []collection.FieldValue,
 	xx, nx bool,
 	expires *float64, etype []byte, evs []resp.Value, err error,
 ) {
@@ -506,7 +547,6 @@ func (c *Controller) parseSetArgs(vs []resp.Value) (
 			vs = nvs
 			var name string
 			var svalue string
-			var value float64
 			if vs, name, ok = tokenval(vs); !ok || name == "" {
 				err = errInvalidNumberOfArguments
 				return
@@ -519,13 +559,8 @@ func (c *Controller) parseSetArgs(vs []resp.Value) (
 				err = errInvalidNumberOfArguments
 				return
 			}
-			value, err = strconv.ParseFloat(svalue, 64)
-			if err != nil {
-				err = errInvalidArgument(svalue)
-				return
-			}
 			fields = append(fields, name)
-			values = append(values, value)
+			values = append(values, collection.ParseFieldValue(svalue))
 			continue
 		}
 		if lcb(arg, "ex") {
@@ -724,7 +759,7 @@ func (c *Controller) cmdSet(msg *server.Message) (res string, d commandDetailsT,
 	vs := msg.Values[1:]
 	var fmap map[string]int
 	var fields []string
-	var values []float64
+	var values []collection.FieldValue
 	var xx, nx bool
 	var ex *float64
 	d, fields, values, xx, nx, ex, _, _, err = c.parseSetArgs(vs)
@@ -746,6 +781,7 @@ func (c *Controller) cmdSet(msg *server.Message) (res string, d commandDetailsT,
 			goto notok
 		}
 	}
+	c.snapshotTouch(d.key, d.id)
 	c.clearIDExpires(d.key, d.id)
 	d.oldObj, d.oldFields, d.fields = col.ReplaceOrInsert(d.id, d.obj, fields, values)
 	d.command = "set"
@@ -813,11 +849,7 @@ func (c *Controller) parseFSetArgs(vs []resp.Value) (d commandDetailsT, err erro
 		err = errInvalidNumberOfArguments
 		return
 	}
-	d.value, err = strconv.ParseFloat(svalue, 64)
-	if err != nil {
-		err = errInvalidArgument(svalue)
-		return
-	}
+	d.value = collection.ParseFieldValue(svalue)
 	return
 }
 
@@ -831,6 +863,7 @@ func (c *Controller) cmdFset(msg *server.Message) (res string, d commandDetailsT
 		return
 	}
 	var ok bool
+	c.snapshotTouch(d.key, d.id)
 	d.obj, d.fields, d.updated, ok = col.SetField(d.id, d.field, d.value)
 	if !ok {
 		err = errIDNotFound
@@ -891,6 +924,7 @@ func (c *Controller) cmdExpire(msg *server.Message) (res string, d commandDetail
 		ok = ok && !c.hasExpired(key, id)
 	}
 	if ok {
+		c.snapshotTouch(key, id)
 		c.expireAt(key, id, time.Now().Add(time.Duration(float64(time.Second)*value)))
 		d.updated = true
 	}
@@ -935,6 +969,7 @@ func (c *Controller) cmdPersist(msg *server.Message) (res string, d commandDetai
 		_, _, ok = col.Get(id)
 		ok = ok && !c.hasExpired(key, id)
 		if ok {
+			c.snapshotTouch(key, id)
 			cleared = c.clearIDExpires(key, id)
 		}
 	}
@@ -0,0 +1,184 @@
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/tidwall/tile38/controller/collection"
+)
+
+// Cursors are opaque tokens that hold the sort key of the last item of a
+// page. The next page starts after that item, so paging doesn't skip or
+// repeat items while objects are inserted and deleted. Numeric cursors are
+// offsets into the iteration. The searches return offsets, in the order of
+// the iteration, unless CURSOR * or a token requests the opaque cursors.
+
+const (
+	cursorID       byte = 'i' // SCAN by id
+	cursorField    byte = 'f' // SCAN by the value of an indexed field, then id
+	cursorValue    byte = 'v' // SEARCH by value, then id
+	cursorDistance byte = 'd' // NEARBY by distance, then id
+	cursorPoint    byte = 'p' // WITHIN and INTERSECTS by position, then id
+)
+
+// cursorExpiry is how long the cursors of the spatial searches are valid.
+// Objects move, so an old position says little about the next page.
+const cursorExpiry = time.Minute * 5
+
+var errCursorExpired = errors.New("cursor expired")
+
+type cursorT struct {
+	kind   byte
+	issued int64                 // unix time, only for the spatial cursors
+	nums   [2]float64            // distance, or the x and y of the position
+	value  collection.FieldValue // field value, or the value of SEARCH
+	id     string
+}
+
+func (cur *cursorT) spatial() bool {
+	return cur.kind == cursorDistance || cur.kind == cursorPoint
+}
+
+// compare returns -1, 0, or +1 depending on the order of two cursors of
+// the same kind.
+func (cur *cursorT) compare(other *cursorT) int {
+	switch cur.kind {
+	case cursorField, cursorValue:
+		if cmp := collection.CompareFieldValues(cur.value, other.value); cmp != 0 {
+			return cmp
+		}
+	case cursorDistance, cursorPoint:
+		for i := range cur.nums {
+			if cur.nums[i] < other.nums[i] {
+				return -1
+			}
+			if cur.nums[i] > other.nums[i] {
+				return 1
+			}
+		}
+	}
+	if cur.id < other.id {
+		return -1
+	}
+	if cur.id > other.id {
+		return 1
+	}
+	return 0
+}
+
+func appendCursorUvarint(b []byte, n uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], n)]...)
+}
+
+func appendCursorString(b []byte, s string) []byte {
+	b = appendCursorUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendCursorFloat(b []byte, f float64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], math.Float64bits(f))
+	return append(b, buf[:]...)
+}
+
+// encode returns the token of the cursor. The spatial cursors are stamped
+// with the current time.
+func (cur *cursorT) encode() string {
+	b := []byte{cur.kind}
+	switch cur.kind {
+	case cursorField:
+		b = append(b, byte(cur.value.Kind))
+		b = appendCursorFloat(b, cur.value.Num)
+		b = appendCursorString(b, cur.value.Str)
+	case cursorValue:
+		b = appendCursorString(b, cur.value.Str)
+	case cursorDistance, cursorPoint:
+		b = appendCursorUvarint(b, uint64(time.Now().Unix()))
+		b = appendCursorFloat(b, cur.nums[0])
+		if cur.kind == cursorPoint {
+			b = appendCursorFloat(b, cur.nums[1])
+		}
+	}
+	b = appendCursorString(b, cur.id)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// cursorReader reads the parts of a token. A read past the end of the
+// token sets the error.
+type cursorReader struct {
+	b   []byte
+	err error
+}
+
+func (rd *cursorReader) uvarint() uint64 {
+	n, sz := binary.Uvarint(rd.b)
+	if sz <= 0 {
+		rd.err = errors.New("invalid cursor")
+		rd.b = nil
+		return 0
+	}
+	rd.b = rd.b[sz:]
+	return n
+}
+
+func (rd *cursorReader) bytes(n uint64) []byte {
+	if uint64(len(rd.b)) < n {
+		rd.err = errors.New("invalid cursor")
+		rd.b = nil
+		return nil
+	}
+	b := rd.b[:n]
+	rd.b = rd.b[n:]
+	return b
+}
+
+func (rd *cursorReader) float() float64 {
+	b := rd.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(b))
+}
+
+func (rd *cursorReader) string() string {
+	return string(rd.bytes(rd.uvarint()))
+}
+
+// parseCursor decodes the token of a cursor.
+func parseCursor(token string) (*cursorT, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid cursor")
+	}
+	cur := &cursorT{kind: b[0]}
+	rd := &cursorReader{b: b[1:]}
+	switch cur.kind {
+	default:
+		return nil, errors.New("invalid cursor")
+	case cursorID:
+	case cursorField:
+		kind := rd.bytes(1)
+		if kind != nil {
+			cur.value.Kind = collection.FieldKind(kind[0])
+		}
+		cur.value.Num = rd.float()
+		cur.value.Str = rd.string()
+	case cursorValue:
+		cur.value = collection.StringValue(rd.string())
+	case cursorDistance, cursorPoint:
+		cur.issued = int64(rd.uvarint())
+		cur.nums[0] = rd.float()
+		if cur.kind == cursorPoint {
+			cur.nums[1] = rd.float()
+		}
+	}
+	cur.id = rd.string()
+	if rd.err != nil || len(rd.b) != 0 {
+		return nil, errors.New("invalid cursor")
+	}
+	return cur, nil
+}
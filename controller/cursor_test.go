@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/tidwall/tile38/controller/collection"
+)
+
+func TestCursor(t *testing.T) {
+	cursors := []cursorT{
+		{kind: cursorID, id: "truck1"},
+		{kind: cursorField, value: collection.NumberValue(-12.5), id: "truck1"},
+		{kind: cursorField, value: collection.StringValue("north"), id: ""},
+		{kind: cursorValue, value: collection.StringValue("hello world"), id: "str1"},
+		{kind: cursorDistance, nums: [2]float64{1523.25}, id: "truck2"},
+		{kind: cursorPoint, nums: [2]float64{-115, 33}, id: "truck3"},
+	}
+	for _, cur := range cursors {
+		token := cur.encode()
+		res, err := parseCursor(token)
+		if err != nil {
+			t.Fatalf("%s: %v", token, err)
+		}
+		if res.compare(&cur) != 0 || res.kind != cur.kind {
+			t.Fatalf("expected %+v, got %+v", cur, *res)
+		}
+		if cur.spatial() && time.Since(time.Unix(res.issued, 0)) > time.Minute {
+			t.Fatalf("expected a recent issue time, got %d", res.issued)
+		}
+	}
+	for _, token := range []string{"", "!!", "eA", "aQ", "aQNh", "aQFhYQ"} {
+		if _, err := parseCursor(token); err == nil {
+			t.Fatalf("expected an error for '%s'", token)
+		}
+	}
+}
+
+func TestCursorExpiry(t *testing.T) {
+	old := []byte{cursorDistance}
+	old = appendCursorUvarint(old, uint64(time.Now().Add(-cursorExpiry-time.Minute).Unix()))
+	old = appendCursorFloat(old, 100)
+	old = appendCursorString(old, "truck1")
+	after, err := parseCursor(base64.RawURLEncoding.EncodeToString(old))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sw := &scanWriter{}
+	if err := sw.useCursor(after, cursorDistance, false, true, nil); err != errCursorExpired {
+		t.Fatalf("expected '%v', got '%v'", errCursorExpired, err)
+	}
+	if err := sw.useCursor(after, cursorPoint, false, true, nil); err == nil {
+		t.Fatal("expected an error for the wrong kind of cursor")
+	}
+	after, _ = parseCursor((&cursorT{kind: cursorDistance, id: "truck1"}).encode())
+	if err := sw.useCursor(after, cursorDistance, false, true, nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,196 @@
+package controller
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/tile38/controller/log"
+	"github.com/tidwall/tile38/geojson"
+)
+
+// defaultLoiterMeters is the radius that an object must stay within to be
+// loitering, when the fence has no LOITER.
+const defaultLoiterMeters = 50
+
+// dwellT tracks an object that is inside of a fence.
+type dwellT struct {
+	details     *commandDetailsT // last update of the object
+	since       time.Time        // when the object entered the fence
+	anchor      geojson.Position // center of the loiter radius
+	anchorSince time.Time        // when the object arrived at the anchor
+	dwelled     bool             // the dwell notification has been sent
+	loitered    bool             // the loiter notification has been sent
+}
+
+// dwellOn returns true when the fence detects dwell or loiter.
+func (fence *liveFenceSwitches) dwellOn() bool {
+	return fence.dwell > 0 && (fence.detect == nil ||
+		fence.detect["dwell"] || fence.detect["loiter"])
+}
+
+// fenceUpdateDwell updates the dwell state of an object after it's been
+// matched against the fence.
+func fenceUpdateDwell(fence *liveFenceSwitches, details *commandDetailsT, inside bool) {
+	if !fence.dwellOn() {
+		return
+	}
+	groupkey := details.key + ":" + details.id
+	if !inside || details.command == "del" {
+		delete(fence.dwells, groupkey)
+		return
+	}
+	p := details.obj.CalculatedPoint()
+	dw, ok := fence.dwells[groupkey]
+	if !ok {
+		if fence.dwells == nil {
+			fence.dwells = make(map[string]*dwellT)
+		}
+		dw = &dwellT{since: details.timestamp, anchor: p, anchorSince: details.timestamp}
+		fence.dwells[groupkey] = dw
+	} else if dw.anchor.DistanceTo(p) > fence.loiter {
+		dw.anchor = p
+		dw.anchorSince = details.timestamp
+		dw.loitered = false
+	}
+	dw.details = details
+}
+
+// fenceMatchDwell returns the dwell and loiter notifications that are due.
+// It's called by the dwell timer with a "tick" command.
+func fenceMatchDwell(hookName string, sw *scanWriter, fence *liveFenceSwitches, metas []FenceMeta, tick *commandDetailsT) [][]byte {
+	if !fence.dwellOn() || len(fence.dwells) == 0 {
+		return nil
+	}
+	var groupkeys []string
+	for groupkey := range fence.dwells {
+		groupkeys = append(groupkeys, groupkey)
+	}
+	sort.Strings(groupkeys)
+	var msgs [][]byte
+	for _, groupkey := range groupkeys {
+		dw := fence.dwells[groupkey]
+		var detects []string
+		if !dw.dwelled && tick.timestamp.Sub(dw.since) >= fence.dwell {
+			dw.dwelled = true
+			detects = append(detects, "dwell")
+		}
+		if !dw.loitered && tick.timestamp.Sub(dw.anchorSince) >= fence.dwell {
+			dw.loitered = true
+			detects = append(detects, "loiter")
+		}
+		for _, detect := range detects {
+			if fence.detect != nil && !fence.detect[detect] {
+				continue
+			}
+			res := fenceWriteObject(sw, fence, dw.details)
+			if res == nil {
+				break
+			}
+			group, ok := fence.groups[groupkey]
+			if !ok {
+				group = bsonID()
+			}
+			msgs = append(msgs, makemsg(dw.details.command, group, detect, hookName, metas, dw.details.key, tick.timestamp, nil, res[1:]))
+		}
+	}
+	return msgs
+}
+
+// dwellDue returns true when a dwell or loiter notification of the fence is
+// due at now.
+func (fence *liveFenceSwitches) dwellDue(now time.Time) bool {
+	for _, dw := range fence.dwells {
+		if (!dw.dwelled && now.Sub(dw.since) >= fence.dwell) ||
+			(!dw.loitered && now.Sub(dw.anchorSince) >= fence.dwell) {
+			return true
+		}
+	}
+	return false
+}
+
+// addDwellHook adds a hook to the hooks that are ticked by
+// backgroundDwelling.
+func (c *Controller) addDwellHook(hook *Hook) {
+	if c.hookdwls == nil {
+		c.hookdwls = make(map[string]*Hook)
+	}
+	c.hookdwls[hook.Name] = hook
+	atomic.StoreInt32(&c.ndwls, int32(len(c.hookdwls)))
+}
+
+// delDwellHook removes a hook from the hooks that are ticked by
+// backgroundDwelling.
+func (c *Controller) delDwellHook(name string) {
+	delete(c.hookdwls, name)
+	atomic.StoreInt32(&c.ndwls, int32(len(c.hookdwls)))
+}
+
+// resetDwellHooks removes all of the hooks that are ticked by
+// backgroundDwelling.
+func (c *Controller) resetDwellHooks() {
+	c.hookdwls = nil
+	atomic.StoreInt32(&c.ndwls, 0)
+}
+
+// tickDwellHooks sends a tick to the keys of the hooks that have dwell or
+// loiter notifications due. The write lock is only taken when there are
+// notifications to send. It returns false when the dwelling is stopped.
+func (c *Controller) tickDwellHooks(now time.Time) bool {
+	c.mu.RLock()
+	if c.stopBackgroundDwelling {
+		c.mu.RUnlock()
+		return false
+	}
+	var keys []string
+	for _, hook := range c.hookdwls {
+		if hook.Fence.dwellDue(now) {
+			keys = append(keys, hook.Key)
+		}
+	}
+	c.mu.RUnlock()
+	if len(keys) == 0 {
+		return true
+	}
+	sort.Strings(keys)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, key := range keys {
+		if i > 0 && keys[i-1] == key {
+			continue
+		}
+		if err := c.queueHooks(&commandDetailsT{command: "tick", key: key, timestamp: now}); err != nil {
+			log.Error(err)
+		}
+	}
+	return true
+}
+
+// backgroundDwelling sends a tick to the fences that detect dwell or loiter.
+// It's executes 10 times a second. The hooks are skipped without locking when
+// none of them detect dwell or loiter.
+func (c *Controller) backgroundDwelling() {
+	for {
+		now := time.Now()
+		if atomic.LoadInt32(&c.ndwls) > 0 && !c.tickDwellHooks(now) {
+			return
+		}
+
+		// live fences match on their own goroutine
+		var ticks []*commandDetailsT
+		c.lcond.L.Lock()
+		keys := make(map[string]bool)
+		for lb := range c.lives {
+			if lb.fence != nil && lb.fence.dwellOn() && !keys[lb.key] {
+				keys[lb.key] = true
+				ticks = append(ticks, &commandDetailsT{command: "tick", key: lb.key, timestamp: now})
+			}
+		}
+		if len(ticks) > 0 {
+			c.lstack = append(c.lstack, ticks...)
+			c.lcond.Broadcast()
+		}
+		c.lcond.L.Unlock()
+		time.Sleep(time.Second / 10)
+	}
+}
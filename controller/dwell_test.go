@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDwellHooks(t *testing.T) {
+	c := testController()
+	testDo(t, c, "SETCHAN", "ch1", "NEARBY", "fleet", "FENCE", "DETECT", "dwell", "DWELL", 10, "POINT", 33, -115, 5000)
+	testDo(t, c, "SETCHAN", "ch2", "NEARBY", "fleet", "FENCE", "POINT", 33, -115, 5000)
+	testDo(t, c, "SETCHAN", "ch3", "NEARBY", "fleet", "FENCE", "DWELL", 10, "POINT", 33, -115, 5000)
+	if len(c.hookdwls) != 2 || c.hookdwls["ch1"] == nil || c.hookdwls["ch3"] == nil || c.ndwls != 2 {
+		t.Fatalf("expected the ch1 and ch3 dwell hooks, got %v", c.hookdwls)
+	}
+
+	now := time.Now()
+	fence := c.hooks["ch1"].Fence
+	fence.dwells = map[string]*dwellT{"fleet:truck1": {since: now, anchorSince: now}}
+	if fence.dwellDue(now.Add(time.Second)) {
+		t.Fatal("expected no dwell due")
+	}
+	if !fence.dwellDue(now.Add(10 * time.Second)) {
+		t.Fatal("expected a dwell due")
+	}
+	fence.dwells["fleet:truck1"].dwelled = true
+	fence.dwells["fleet:truck1"].loitered = true
+	if fence.dwellDue(now.Add(10 * time.Second)) {
+		t.Fatal("expected no dwell due after the notifications")
+	}
+
+	testDo(t, c, "SETCHAN", "ch1", "NEARBY", "fleet", "FENCE", "POINT", 33, -115, 5000)
+	testDo(t, c, "DELCHAN", "ch2")
+	if len(c.hookdwls) != 1 || c.hookdwls["ch3"] == nil || c.ndwls != 1 {
+		t.Fatalf("expected the ch3 dwell hook, got %v", c.hookdwls)
+	}
+	testDo(t, c, "PDELCHAN", "ch*")
+	if len(c.hookdwls) != 0 || c.ndwls != 0 {
+		t.Fatalf("expected no dwell hooks, got %v", c.hookdwls)
+	}
+}
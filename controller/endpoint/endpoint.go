@@ -22,6 +22,7 @@ const (
 	Kafka  = EndpointProtocol("kafka")  // Kafka
 	MQTT   = EndpointProtocol("mqtt")   // MQTT
 	AMQP   = EndpointProtocol("amqp")   // AMQP
+	NATS   = EndpointProtocol("nats")   // NATS
 )
 
 // Endpoint represents an endpoint.
@@ -43,7 +44,10 @@ type Endpoint struct {
 	Redis struct {
 		Host    string
 		Port    int
-		Channel string
+		Channel string // channel, or stream when XAdd is set
+		XAdd    bool   // add to a stream instead of publishing
+		MaxLen  int    // stream length to trim to, 0 is unlimited
+		Field   string // stream entry field of the message
 	}
 	Kafka struct {
 		Host      string
@@ -63,6 +67,15 @@ type Endpoint struct {
 		Qos       byte
 		Retained  bool
 	}
+	NATS struct {
+		Host      string
+		Port      int
+		Subject   string
+		User      string
+		Pass      string
+		Token     string
+		JetStream bool // wait for the JetStream publish ack
+	}
 }
 
 type EndpointConn interface {
@@ -73,11 +86,19 @@ type EndpointConn interface {
 type EndpointManager struct {
 	mu    sync.RWMutex // this is intentionally exposed
 	conns map[string]EndpointConn
+	stats map[EndpointProtocol]Stats
+}
+
+// Stats are the delivery counts of a protocol.
+type Stats struct {
+	Sent   uint64 // messages sent
+	Failed uint64 // failed send attempts
 }
 
 func NewEndpointManager() *EndpointManager {
 	epc := &EndpointManager{
 		conns: make(map[string]EndpointConn),
+		stats: make(map[EndpointProtocol]Stats),
 	}
 	go epc.Run()
 	return epc
@@ -108,6 +129,12 @@ func (epc *EndpointManager) Validate(url string) error {
 }
 
 func (epc *EndpointManager) Send(endpoint, val string) error {
+	return epc.SendOptions(endpoint, val, nil)
+}
+
+// SendOptions sends a message with the HTTP options of a hook, which are
+// ignored by the other protocols. The options may be nil.
+func (epc *EndpointManager) SendOptions(endpoint, val string, opts *HTTPOptions) error {
 	for {
 		epc.mu.Lock()
 		conn, ok := epc.conns[endpoint]
@@ -134,11 +161,18 @@ func (epc *EndpointManager) Send(endpoint, val string) error {
 				conn = newMQTTEndpointConn(ep)
 			case AMQP:
 				conn = newAMQPEndpointConn(ep)
+			case NATS:
+				conn = newNATSEndpointConn(ep)
 			}
 			epc.conns[endpoint] = conn
 		}
 		epc.mu.Unlock()
-		err := conn.Send(val)
+		var err error
+		if hconn, ok := conn.(*HTTPEndpointConn); ok && opts != nil {
+			err = hconn.SendOptions(val, opts)
+		} else {
+			err = conn.Send(val)
+		}
 		if err != nil {
 			if err == errExpired {
 				// it's possible that the connection has expired in-between
@@ -146,12 +180,47 @@ func (epc *EndpointManager) Send(endpoint, val string) error {
 				// just try the send again.
 				continue
 			}
+			epc.record(endpoint, false)
 			return err
 		}
+		epc.record(endpoint, true)
 		return nil
 	}
 }
 
+// record counts a send attempt for the protocol of an endpoint.
+func (epc *EndpointManager) record(endpoint string, sent bool) {
+	var protocol EndpointProtocol
+	switch {
+	case strings.HasPrefix(endpoint, "https:"):
+		protocol = HTTP
+	case strings.HasPrefix(endpoint, "amqps:"):
+		protocol = AMQP
+	default:
+		protocol = EndpointProtocol(endpoint[:strings.IndexByte(endpoint, ':')])
+	}
+	epc.mu.Lock()
+	stats := epc.stats[protocol]
+	if sent {
+		stats.Sent++
+	} else {
+		stats.Failed++
+	}
+	epc.stats[protocol] = stats
+	epc.mu.Unlock()
+}
+
+// Stats returns the delivery counts for each protocol.
+func (epc *EndpointManager) Stats() map[EndpointProtocol]Stats {
+	epc.mu.RLock()
+	defer epc.mu.RUnlock()
+	stats := make(map[EndpointProtocol]Stats, len(epc.stats))
+	for protocol, s := range epc.stats {
+		stats[protocol] = s
+	}
+	return stats
+}
+
 func parseEndpoint(s string) (Endpoint, error) {
 	var endpoint Endpoint
 	endpoint.Original = s
@@ -176,6 +245,8 @@ func parseEndpoint(s string) (Endpoint, error) {
 		endpoint.Protocol = AMQP
 	case strings.HasPrefix(s, "mqtt:"):
 		endpoint.Protocol = MQTT
+	case strings.HasPrefix(s, "nats:"):
+		endpoint.Protocol = NATS
 	}
 
 	s = s[strings.Index(s, ":")+1:]
@@ -232,6 +303,40 @@ func parseEndpoint(s string) (Endpoint, error) {
 				return endpoint, errors.New("invalid redis channel name")
 			}
 		}
+		endpoint.Redis.Field = "message"
+		if len(sqp) > 1 {
+			m, err := url.ParseQuery(sqp[1])
+			if err != nil {
+				return endpoint, errors.New("invalid redis url")
+			}
+			for key, val := range m {
+				if len(val) == 0 {
+					continue
+				}
+				switch key {
+				case "xadd":
+					n, err := strconv.ParseUint(val[0], 10, 8)
+					if err != nil || n > 1 {
+						return endpoint, errors.New("invalid redis xadd, should be [0, 1]")
+					}
+					endpoint.Redis.XAdd = n == 1
+				case "maxlen":
+					n, err := strconv.ParseUint(val[0], 10, 63)
+					if err != nil {
+						return endpoint, errors.New("invalid redis maxlen value")
+					}
+					endpoint.Redis.MaxLen = int(n)
+				case "field":
+					if val[0] == "" {
+						return endpoint, errors.New("invalid redis field name")
+					}
+					endpoint.Redis.Field = val[0]
+				}
+			}
+		}
+		if endpoint.Redis.XAdd && endpoint.Redis.Channel == "" {
+			return endpoint, errors.New("missing redis stream name")
+		}
 	}
 
 	if endpoint.Protocol == Disque {
@@ -438,5 +543,81 @@ func parseEndpoint(s string) (Endpoint, error) {
 		}
 	}
 
+	// NATS connection strings
+	// nats://[user:pass@|token@]host:port/<subject>?params=value
+	//
+	// - "user", "pass" - [string] user credentials
+	// - "token" - [string] authentication token
+	// - "js" - [0, 1] wait for the JetStream publish ack
+	//
+	if endpoint.Protocol == NATS {
+		if i := strings.LastIndexByte(s, '@'); i >= 0 {
+			userinfo, err := url.PathUnescape(s[:i])
+			if err != nil {
+				return endpoint, errors.New("invalid NATS credentials")
+			}
+			if j := strings.IndexByte(userinfo, ':'); j >= 0 {
+				endpoint.NATS.User = userinfo[:j]
+				endpoint.NATS.Pass = userinfo[j+1:]
+			} else {
+				endpoint.NATS.Token = userinfo
+			}
+			s = s[i+1:]
+		}
+		dp := strings.Split(s, ":")
+		switch len(dp) {
+		default:
+			return endpoint, errors.New("invalid NATS url")
+		case 1:
+			endpoint.NATS.Host = dp[0]
+			endpoint.NATS.Port = 4222
+		case 2:
+			endpoint.NATS.Host = dp[0]
+			n, err := strconv.ParseUint(dp[1], 10, 16)
+			if err != nil {
+				return endpoint, errors.New("invalid NATS url port")
+			}
+			endpoint.NATS.Port = int(n)
+		}
+		if len(sp) > 1 {
+			var err error
+			endpoint.NATS.Subject, err = url.QueryUnescape(sp[1])
+			if err != nil {
+				return endpoint, errors.New("invalid NATS subject")
+			}
+		}
+		if len(sqp) > 1 {
+			m, err := url.ParseQuery(sqp[1])
+			if err != nil {
+				return endpoint, errors.New("invalid NATS url")
+			}
+			for key, val := range m {
+				if len(val) == 0 {
+					continue
+				}
+				switch key {
+				case "user":
+					endpoint.NATS.User = val[0]
+				case "pass":
+					endpoint.NATS.Pass = val[0]
+				case "token":
+					endpoint.NATS.Token = val[0]
+				case "js":
+					n, err := strconv.ParseUint(val[0], 10, 8)
+					if err != nil || n > 1 {
+						return endpoint, errors.New("invalid NATS js, should be [0, 1]")
+					}
+					endpoint.NATS.JetStream = n == 1
+				}
+			}
+		}
+		if endpoint.NATS.Subject == "" {
+			return endpoint, errors.New("missing NATS subject")
+		}
+		if strings.ContainsAny(endpoint.NATS.Subject, " \t\r\n") {
+			return endpoint, errors.New("invalid NATS subject")
+		}
+	}
+
 	return endpoint, nil
 }
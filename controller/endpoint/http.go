@@ -2,10 +2,14 @@ package endpoint
 
 import (
 	"bytes"
-	"fmt"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -15,6 +19,26 @@ const (
 	httpMaxIdleConnections = 20
 )
 
+// HTTPOptions are the request options of the HTTP endpoints of a hook.
+type HTTPOptions struct {
+	Headers   [][2]string   // name and value of custom headers
+	Bearer    string        // bearer token
+	BasicUser string        // basic auth user
+	BasicPass string        // basic auth password
+	Secret    string        // signs the requests when set
+	Timeout   time.Duration // request timeout, 0 is the default
+}
+
+// StatusError is returned for non-2xx responses.
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (err *StatusError) Error() string {
+	return "invalid status: " + err.Status
+}
+
 type HTTPEndpointConn struct {
 	ep     Endpoint
 	client *http.Client
@@ -28,7 +52,6 @@ func newHTTPEndpointConn(ep Endpoint) *HTTPEndpointConn {
 				MaxIdleConnsPerHost: httpMaxIdleConnections,
 				IdleConnTimeout:     httpExpiresAfter,
 			},
-			Timeout: httpRequestTimeout,
 		},
 	}
 }
@@ -38,12 +61,51 @@ func (conn *HTTPEndpointConn) Expired() bool {
 }
 
 func (conn *HTTPEndpointConn) Send(msg string) error {
+	return conn.SendOptions(msg, nil)
+}
+
+// httpSignature returns the X-Tile38-Signature header of a request, which
+// is "t=<unix time>,v1=<hex hmac>". The hmac is the HMAC-SHA256 of the
+// unix time, a dot, and the body, with the secret of the hook. Receivers
+// verify the hmac and reject requests with old timestamps.
+func httpSignature(secret, body string, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(mac, ts)
+	io.WriteString(mac, ".")
+	io.WriteString(mac, body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SendOptions posts a message with the request options of a hook. The
+// options may be nil.
+func (conn *HTTPEndpointConn) SendOptions(msg string, opts *HTTPOptions) error {
+	timeout := httpRequestTimeout
+	if opts != nil && opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 	req, err := http.NewRequest("POST", conn.ep.Original, bytes.NewBufferString(msg))
 	if err != nil {
 		return err
 	}
+	req = req.WithContext(ctx)
 
 	req.Header.Set("Content-Type", "application/json")
+	if opts != nil {
+		for _, header := range opts.Headers {
+			req.Header.Add(header[0], header[1])
+		}
+		if opts.Bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+opts.Bearer)
+		} else if opts.BasicUser != "" {
+			req.SetBasicAuth(opts.BasicUser, opts.BasicPass)
+		}
+		if opts.Secret != "" {
+			req.Header.Set("X-Tile38-Signature", httpSignature(opts.Secret, msg, time.Now()))
+		}
+	}
 	resp, err := conn.client.Do(req)
 	if err != nil {
 		return err
@@ -54,9 +116,9 @@ func (conn *HTTPEndpointConn) Send(msg string) error {
 	if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
 		return err
 	}
-	// we only care about the 200 response
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("invalid status: %s", resp.Status)
+	// we only care about the 2xx responses
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{Code: resp.StatusCode, Status: resp.Status}
 	}
 	return nil
 }
@@ -0,0 +1,83 @@
+package endpoint
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPOptions(t *testing.T) {
+	reqs := make(chan *http.Request, 10)
+	bodies := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		reqs <- r
+		bodies <- string(body)
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(time.Second / 2)
+		case "/created":
+			w.WriteHeader(http.StatusCreated)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	epm := NewEndpointManager()
+
+	opts := &HTTPOptions{
+		Headers: [][2]string{{"X-Fleet", "north"}},
+		Bearer:  "t0ken",
+		Secret:  "s3cret",
+	}
+	if err := epm.SendOptions(srv.URL+"/hook", `{"id":"truck1"}`, opts); err != nil {
+		t.Fatal(err)
+	}
+	r, body := <-reqs, <-bodies
+	if r.Header.Get("X-Fleet") != "north" || r.Header.Get("Authorization") != "Bearer t0ken" {
+		t.Fatalf("unexpected headers %v", r.Header)
+	}
+	var ts, sig string
+	for _, part := range strings.Split(r.Header.Get("X-Tile38-Signature"), ",") {
+		if strings.HasPrefix(part, "t=") {
+			ts = part[2:]
+		} else if strings.HasPrefix(part, "v1=") {
+			sig = part[3:]
+		}
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(ts + "." + body))
+	if ts == "" || sig != hex.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("invalid signature '%s'", r.Header.Get("X-Tile38-Signature"))
+	}
+
+	opts = &HTTPOptions{BasicUser: "alice", BasicPass: "p@ss"}
+	if err := epm.SendOptions(srv.URL+"/created", "{}", opts); err != nil {
+		t.Fatal(err)
+	}
+	r, _ = <-reqs, <-bodies
+	if user, pass, ok := r.BasicAuth(); !ok || user != "alice" || pass != "p@ss" {
+		t.Fatalf("unexpected basic auth '%s'", r.Header.Get("Authorization"))
+	}
+	if r.Header.Get("X-Tile38-Signature") != "" {
+		t.Fatal("expected no signature")
+	}
+
+	err := epm.Send(srv.URL+"/missing", "{}")
+	if serr, ok := err.(*StatusError); !ok || serr.Code != http.StatusNotFound {
+		t.Fatalf("expected a status error, got '%v'", err)
+	}
+	<-reqs
+	<-bodies
+
+	err = epm.SendOptions(srv.URL+"/slow", "{}", &HTTPOptions{Timeout: time.Second / 10})
+	if err == nil {
+		t.Fatal("expected a timeout error")
+	}
+}
@@ -0,0 +1,258 @@
+package endpoint
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	natsExpiresAfter = time.Second * 30
+	natsTimeout      = time.Second * 5
+)
+
+// NATSEndpointConn publishes to a NATS subject. With JetStream, each message
+// is acknowledged by the stream that stores the subject.
+type NATSEndpointConn struct {
+	mu    sync.Mutex
+	ep    Endpoint
+	ex    bool
+	t     time.Time
+	conn  net.Conn
+	rd    *bufio.Reader
+	inbox string // reply subject prefix for JetStream acks
+	seq   int    // JetStream ack counter
+}
+
+func newNATSEndpointConn(ep Endpoint) *NATSEndpointConn {
+	return &NATSEndpointConn{
+		ep: ep,
+		t:  time.Now(),
+	}
+}
+
+func (conn *NATSEndpointConn) Expired() bool {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if !conn.ex {
+		if time.Now().Sub(conn.t) > natsExpiresAfter {
+			if conn.conn != nil {
+				conn.close()
+			}
+			conn.ex = true
+		}
+	}
+	return conn.ex
+}
+
+func (conn *NATSEndpointConn) close() {
+	if conn.conn != nil {
+		conn.conn.Close()
+		conn.conn = nil
+	}
+	conn.rd = nil
+}
+
+// connect dials the server, and sends CONNECT with the credentials. For
+// JetStream it also subscribes to the inbox of the acks.
+func (conn *NATSEndpointConn) connect() error {
+	addr := net.JoinHostPort(conn.ep.NATS.Host, strconv.Itoa(conn.ep.NATS.Port))
+	c, err := net.DialTimeout("tcp", addr, natsTimeout)
+	if err != nil {
+		return err
+	}
+	conn.conn = c
+	conn.rd = bufio.NewReader(c)
+	conn.conn.SetDeadline(time.Now().Add(natsTimeout))
+	line, err := conn.rd.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return errors.New("invalid nats info")
+	}
+	opts := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "tile38",
+		"lang":     "go",
+		"protocol": 1,
+		"headers":  true,
+		// JetStream replies with a 503 when no stream has the subject
+		"no_responders": true,
+	}
+	if conn.ep.NATS.Token != "" {
+		opts["auth_token"] = conn.ep.NATS.Token
+	}
+	if conn.ep.NATS.User != "" {
+		opts["user"] = conn.ep.NATS.User
+		opts["pass"] = conn.ep.NATS.Pass
+	}
+	data, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+	cmd := "CONNECT " + string(data) + "\r\n"
+	if conn.ep.NATS.JetStream {
+		var b [8]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return err
+		}
+		conn.inbox = "_INBOX." + hex.EncodeToString(b[:])
+		cmd += "SUB " + conn.inbox + ".* 1\r\n"
+	}
+	if _, err := io.WriteString(conn.conn, cmd+"PING\r\n"); err != nil {
+		return err
+	}
+	return conn.waitPong()
+}
+
+// waitPong reads until the PONG reply of a PING.
+func (conn *NATSEndpointConn) waitPong() error {
+	for {
+		line, err := conn.rd.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(line, "PONG"):
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return natsError(line)
+		case strings.HasPrefix(line, "PING"):
+			if _, err := io.WriteString(conn.conn, "PONG\r\n"); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// waitAck reads until the JetStream ack of a message.
+func (conn *NATSEndpointConn) waitAck(reply string) error {
+	for {
+		line, err := conn.rd.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(line, "-ERR"):
+			return natsError(line)
+		case strings.HasPrefix(line, "PING"):
+			if _, err := io.WriteString(conn.conn, "PONG\r\n"); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "MSG "), strings.HasPrefix(line, "HMSG "):
+			// MSG <subject> <sid> <size>
+			// HMSG <subject> <sid> <header size> <total size>
+			args := strings.Fields(line)
+			if len(args) < 4 {
+				return errors.New("invalid nats message")
+			}
+			size, err := strconv.Atoi(args[len(args)-1])
+			if err != nil {
+				return errors.New("invalid nats message")
+			}
+			hsize := 0
+			if args[0] == "HMSG" {
+				if hsize, err = strconv.Atoi(args[len(args)-2]); err != nil || hsize > size {
+					return errors.New("invalid nats message")
+				}
+			}
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(conn.rd, data); err != nil {
+				return err
+			}
+			if args[1] != reply {
+				// not the ack of this message
+				continue
+			}
+			if hsize > 0 {
+				// NATS/1.0 503\r\n
+				status := strings.Fields(strings.SplitN(string(data[:hsize]), "\r\n", 2)[0])
+				if len(status) > 1 && status[1] == "503" {
+					return errors.New("no jetstream stream for the subject")
+				}
+			}
+			var ack struct {
+				Stream string `json:"stream"`
+				Error  *struct {
+					Description string `json:"description"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(data[hsize:size], &ack); err != nil {
+				return errors.New("invalid jetstream ack")
+			}
+			if ack.Error != nil {
+				return errors.New(ack.Error.Description)
+			}
+			if ack.Stream == "" {
+				return errors.New("invalid jetstream ack")
+			}
+			return nil
+		}
+	}
+}
+
+func natsError(line string) error {
+	return errors.New(strings.Trim(strings.TrimSpace(line[len("-ERR"):]), "'"))
+}
+
+func (conn *NATSEndpointConn) Send(msg string) error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.ex {
+		return errExpired
+	}
+	conn.t = time.Now()
+	if conn.conn == nil {
+		if err := conn.connect(); err != nil {
+			conn.close()
+			return err
+		}
+	}
+	conn.conn.SetDeadline(time.Now().Add(natsTimeout))
+	var reply string
+	if conn.ep.NATS.JetStream {
+		conn.seq++
+		reply = conn.inbox + "." + strconv.Itoa(conn.seq)
+	}
+	var cmd []byte
+	cmd = append(cmd, "PUB "...)
+	cmd = append(cmd, conn.ep.NATS.Subject...)
+	if reply != "" {
+		cmd = append(cmd, ' ')
+		cmd = append(cmd, reply...)
+	}
+	cmd = append(cmd, ' ')
+	cmd = strconv.AppendInt(cmd, int64(len(msg)), 10)
+	cmd = append(cmd, '\r', '\n')
+	cmd = append(cmd, msg...)
+	cmd = append(cmd, '\r', '\n')
+	if reply == "" {
+		// the PONG confirms that the server has processed the message
+		cmd = append(cmd, "PING\r\n"...)
+	}
+	if _, err := conn.conn.Write(cmd); err != nil {
+		conn.close()
+		return err
+	}
+	var err error
+	if reply == "" {
+		err = conn.waitPong()
+	} else {
+		err = conn.waitAck(reply)
+	}
+	if err != nil {
+		conn.close()
+		return err
+	}
+	return nil
+}
@@ -4,7 +4,10 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -71,7 +74,15 @@ func (conn *RedisEndpointConn) Send(msg string) error {
 	}
 
 	var args []string
-	args = append(args, "PUBLISH", conn.ep.Redis.Channel, msg)
+	if conn.ep.Redis.XAdd {
+		args = append(args, "XADD", conn.ep.Redis.Channel)
+		if conn.ep.Redis.MaxLen > 0 {
+			args = append(args, "MAXLEN", strconv.Itoa(conn.ep.Redis.MaxLen))
+		}
+		args = append(args, "*", conn.ep.Redis.Field, msg)
+	} else {
+		args = append(args, "PUBLISH", conn.ep.Redis.Channel, msg)
+	}
 	cmd := buildRedisCommand(args)
 
 	if _, err := conn.conn.Write(cmd); err != nil {
@@ -85,6 +96,39 @@ func (conn *RedisEndpointConn) Send(msg string) error {
 		return err
 	}
 
+	if c == '-' {
+		// error reply, such as a WRONGTYPE stream key
+		ln, err := conn.rd.ReadBytes('\n')
+		conn.close()
+		if err != nil {
+			return err
+		}
+		return errors.New(strings.TrimSpace(string(ln)))
+	}
+
+	if conn.ep.Redis.XAdd {
+		// the reply is the id of the entry
+		if c != '$' {
+			conn.close()
+			return errors.New("invalid redis reply")
+		}
+		ln, err := conn.rd.ReadBytes('\n')
+		if err != nil {
+			conn.close()
+			return err
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(string(ln)), 10, 64)
+		if err != nil || n < 0 {
+			conn.close()
+			return errors.New("invalid redis reply")
+		}
+		if _, err := io.ReadFull(conn.rd, make([]byte, n+2)); err != nil {
+			conn.close()
+			return err
+		}
+		return nil
+	}
+
 	if c != ':' {
 		conn.close()
 		return errors.New("invalid redis reply")
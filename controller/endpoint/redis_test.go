@@ -0,0 +1,134 @@
+package endpoint
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testRedisServer is a minimal stand-in for a Redis server. It replies to
+// XADD with an entry id and to PUBLISH with one receiver, and sends the
+// received commands to cmds.
+func testRedisServer(t *testing.T, cmds chan []string) (port int, close func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				rd := bufio.NewReader(conn)
+				for {
+					args, err := testReadCommand(rd)
+					if err != nil {
+						return
+					}
+					cmds <- args
+					switch strings.ToUpper(args[0]) {
+					case "XADD":
+						if args[1] == "wrongtype" {
+							io.WriteString(conn, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+						} else {
+							io.WriteString(conn, "$15\r\n1526919030474-0\r\n")
+						}
+					case "PUBLISH":
+						io.WriteString(conn, ":1\r\n")
+					default:
+						io.WriteString(conn, "-ERR unknown command\r\n")
+					}
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, func() { ln.Close() }
+}
+
+func testReadCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	var args []string
+	for i := 0; i < n; i++ {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sz, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		arg := make([]byte, sz+2)
+		if _, err := io.ReadFull(rd, arg); err != nil {
+			return nil, err
+		}
+		args = append(args, string(arg[:sz]))
+	}
+	return args, nil
+}
+
+func TestRedisXAdd(t *testing.T) {
+	cmds := make(chan []string, 10)
+	port, close := testRedisServer(t, cmds)
+	defer close()
+	addr := "redis://127.0.0.1:" + strconv.Itoa(port)
+	epm := NewEndpointManager()
+
+	tests := []struct {
+		url    string
+		expect string
+	}{
+		{addr + "/fences?xadd=1", "XADD fences * message {}"},
+		{addr + "/fences?xadd=1&maxlen=1000&field=event", "XADD fences MAXLEN 1000 * event {}"},
+		{addr + "/fences", "PUBLISH fences {}"},
+	}
+	for _, test := range tests {
+		if err := epm.Send(test.url, "{}"); err != nil {
+			t.Fatalf("%s: %v", test.url, err)
+		}
+		if cmd := strings.Join(<-cmds, " "); cmd != test.expect {
+			t.Fatalf("expected '%s', got '%s'", test.expect, cmd)
+		}
+	}
+	err := epm.Send(addr+"/wrongtype?xadd=1", "{}")
+	if err == nil || !strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		t.Fatalf("expected a WRONGTYPE error, got '%v'", err)
+	}
+	<-cmds
+	stats := epm.Stats()[Redis]
+	if stats.Sent != 3 || stats.Failed != 1 {
+		t.Fatalf("expected 3 sent and 1 failed, got %d and %d", stats.Sent, stats.Failed)
+	}
+}
+
+func TestRedisXAddURL(t *testing.T) {
+	for _, url := range []string{
+		"redis://localhost?xadd=1",
+		"redis://localhost/fences?xadd=2",
+		"redis://localhost/fences?xadd=1&maxlen=-1",
+	} {
+		if _, err := parseEndpoint(url); err == nil {
+			t.Fatalf("expected an error for '%s'", url)
+		}
+	}
+	ep, err := parseEndpoint("redis://localhost:6380/fences?xadd=1&maxlen=10&field=f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ep.Redis.XAdd || ep.Redis.MaxLen != 10 || ep.Redis.Field != "f" ||
+		ep.Redis.Channel != "fences" || ep.Redis.Port != 6380 {
+		t.Fatalf("unexpected endpoint %+v", ep.Redis)
+	}
+}
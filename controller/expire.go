@@ -107,6 +107,7 @@ func (c *Controller) fillExpiresList() {
 // from the database. It's executes 10 times a seconds.
 func (c *Controller) backgroundExpiring() {
 	rand.Seed(time.Now().UnixNano())
+	var swept time.Time
 	for {
 		c.mu.Lock()
 		if c.stopBackgroundExpiring {
@@ -114,35 +115,51 @@ func (c *Controller) backgroundExpiring() {
 			return
 		}
 		now := time.Now()
-		var purged int
-		for i := 0; i < 20 && len(c.exlist) > 0; i++ {
-			ix := rand.Int() % len(c.exlist)
-			if now.After(c.exlist[ix].at) {
-				if c.hasExpired(c.exlist[ix].key, c.exlist[ix].id) {
-					msg := &server.Message{}
-					msg.Values = resp.MultiBulkValue("del", c.exlist[ix].key, c.exlist[ix].id).Array()
-					msg.Command = "del"
-					_, d, err := c.cmdDel(msg)
-					if err != nil {
-						c.mu.Unlock()
-						log.Fatal(err)
-						continue
-					}
-					if err := c.writeAOF(resp.ArrayValue(msg.Values), &d); err != nil {
-						c.mu.Unlock()
-						log.Fatal(err)
-						continue
-					}
-					purged++
-				}
-				c.exlist[ix] = c.exlist[len(c.exlist)-1]
-				c.exlist = c.exlist[:len(c.exlist)-1]
-			}
+		if now.Sub(swept) >= time.Second {
+			// the positions of the histories that are too old
+			c.sweepHistories(now)
+			swept = now
 		}
+		purged, err := c.purgeExpired(now)
 		c.mu.Unlock()
+		if err != nil {
+			log.Fatal(err)
+		}
 		if purged > 5 {
 			continue
 		}
 		time.Sleep(time.Second / 10)
 	}
 }
+
+// purgeExpired deletes up to 20 random items that have expired. Followers
+// keep their expired items until the delete of the leader arrives. A local
+// delete would be added to the replication offset of the follower, which
+// then no longer points into the stream of the leader.
+func (c *Controller) purgeExpired(now time.Time) (purged int, err error) {
+	for i := 0; i < 20 && len(c.exlist) > 0; i++ {
+		ix := rand.Int() % len(c.exlist)
+		if now.After(c.exlist[ix].at) {
+			if c.hasExpired(c.exlist[ix].key, c.exlist[ix].id) {
+				if c.config.FollowHost != "" {
+					continue
+				}
+				msg := &server.Message{}
+				msg.Values = resp.MultiBulkValue("del", c.exlist[ix].key, c.exlist[ix].id).Array()
+				msg.Command = "del"
+				_, d, err := c.cmdDel(msg)
+				if err != nil {
+					return purged, err
+				}
+				if err := c.writeAOF(resp.ArrayValue(msg.Values), &d); err != nil {
+					return purged, err
+				}
+				purged++
+				c.statsExpired++
+			}
+			c.exlist[ix] = c.exlist[len(c.exlist)-1]
+			c.exlist = c.exlist[:len(c.exlist)-1]
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,573 @@
+// Package expr implements the small boolean and arithmetic expression
+// language used by WHEREEXPR to filter objects by their field values.
+//
+//	speed > 30 AND (fuel < 10 OR status == 3)
+//
+// Expressions are compiled once and can be evaluated many times.
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/tile38/controller/collection"
+)
+
+// Lookup returns the value of a field.
+type Lookup func(name string) collection.FieldValue
+
+// Expr is a compiled expression.
+type Expr struct {
+	src    string
+	root   node
+	fields []string
+}
+
+// Compile parses an expression.
+func Compile(src string) (*Expr, error) {
+	p := &parser{src: src}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected '%s'", p.tok.text)
+	}
+	e := &Expr{src: src, root: root}
+	seen := make(map[string]bool)
+	walk(root, func(n node) {
+		if n, ok := n.(*identNode); ok && !seen[n.name] {
+			seen[n.name] = true
+			e.fields = append(e.fields, n.name)
+		}
+	})
+	return e, nil
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Fields returns the names of the fields referenced by the expression.
+func (e *Expr) Fields() []string {
+	return e.fields
+}
+
+// Eval evaluates the expression.
+func (e *Expr) Eval(lookup Lookup) collection.FieldValue {
+	return e.root.eval(lookup)
+}
+
+// Match evaluates the expression and returns true when the result is truthy.
+func (e *Expr) Match(lookup Lookup) bool {
+	return truthy(e.root.eval(lookup))
+}
+
+func truthy(v collection.FieldValue) bool {
+	if v.Kind == collection.String {
+		return v.Str != ""
+	}
+	return v.Num != 0 && !math.IsNaN(v.Num)
+}
+
+type node interface {
+	eval(lookup Lookup) collection.FieldValue
+}
+
+type valueNode struct {
+	value collection.FieldValue
+}
+
+func (n *valueNode) eval(lookup Lookup) collection.FieldValue {
+	return n.value
+}
+
+type identNode struct {
+	name string
+}
+
+func (n *identNode) eval(lookup Lookup) collection.FieldValue {
+	return lookup(n.name)
+}
+
+type notNode struct {
+	x node
+}
+
+func (n *notNode) eval(lookup Lookup) collection.FieldValue {
+	return collection.BoolValue(!truthy(n.x.eval(lookup)))
+}
+
+type negNode struct {
+	x node
+}
+
+func (n *negNode) eval(lookup Lookup) collection.FieldValue {
+	v := n.x.eval(lookup)
+	if v.Kind == collection.String {
+		return collection.NumberValue(math.NaN())
+	}
+	return collection.NumberValue(-v.Num)
+}
+
+type binaryNode struct {
+	op   string
+	x, y node
+}
+
+func (n *binaryNode) eval(lookup Lookup) collection.FieldValue {
+	switch n.op {
+	case "and":
+		return collection.BoolValue(truthy(n.x.eval(lookup)) && truthy(n.y.eval(lookup)))
+	case "or":
+		return collection.BoolValue(truthy(n.x.eval(lookup)) || truthy(n.y.eval(lookup)))
+	}
+	x, y := n.x.eval(lookup), n.y.eval(lookup)
+	switch n.op {
+	case "==":
+		return collection.BoolValue(x.Equals(y))
+	case "!=":
+		return collection.BoolValue(!x.Equals(y))
+	case "<", "<=", ">", ">=":
+		return collection.BoolValue(compare(n.op, x, y))
+	case "+":
+		if x.Kind == collection.String && y.Kind == collection.String {
+			return collection.StringValue(x.Str + y.Str)
+		}
+	}
+	// arithmetic on strings is undefined
+	if x.Kind == collection.String || y.Kind == collection.String {
+		return collection.NumberValue(math.NaN())
+	}
+	switch n.op {
+	case "+":
+		return collection.NumberValue(x.Num + y.Num)
+	case "-":
+		return collection.NumberValue(x.Num - y.Num)
+	case "*":
+		return collection.NumberValue(x.Num * y.Num)
+	case "/":
+		return collection.NumberValue(x.Num / y.Num)
+	case "%":
+		return collection.NumberValue(math.Mod(x.Num, y.Num))
+	}
+	return collection.NumberValue(math.NaN())
+}
+
+// compare orders two values. Strings are only ordered against strings, and
+// numbers and booleans against numbers and booleans.
+func compare(op string, x, y collection.FieldValue) bool {
+	if (x.Kind == collection.String) != (y.Kind == collection.String) {
+		return false
+	}
+	if x.Kind == collection.String {
+		switch op {
+		case "<":
+			return x.Str < y.Str
+		case "<=":
+			return x.Str <= y.Str
+		case ">":
+			return x.Str > y.Str
+		}
+		return x.Str >= y.Str
+	}
+	switch op {
+	case "<":
+		return x.Num < y.Num
+	case "<=":
+		return x.Num <= y.Num
+	case ">":
+		return x.Num > y.Num
+	}
+	return x.Num >= y.Num
+}
+
+func walk(n node, fn func(n node)) {
+	fn(n)
+	switch n := n.(type) {
+	case *notNode:
+		walk(n.x, fn)
+	case *negNode:
+		walk(n.x, fn)
+	case *binaryNode:
+		walk(n.x, fn)
+		walk(n.y, fn)
+	}
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string // normalized text, keywords and operators are lowercase
+	pos  int
+}
+
+// maxDepth is the maximum nesting of the parentheses and the operators of an
+// expression, which keeps the recursion of the parser and of the evaluation
+// bounded. Each operator of a chain like a+b+c nests the ones before it.
+const maxDepth = 128
+
+type parser struct {
+	src   string
+	pos   int
+	tok   token
+	depth int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid expression: "+format, args...)
+}
+
+// enter increases the nesting of the expression, and fails when it's too
+// deep. Every enter is paired with a leave, or with a restore of the depth
+// for the operators of a chain.
+func (p *parser) enter() error {
+	p.depth++
+	if p.depth > maxDepth {
+		return p.errorf("nested too deeply")
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+// next reads the next token.
+func (p *parser) next() error {
+	for p.pos < len(p.src) && isSpace(p.src[p.pos]) {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos == len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return nil
+	}
+	ch := p.src[p.pos]
+	switch {
+	case isDigit(ch) || (ch == '.' && p.pos+1 < len(p.src) && isDigit(p.src[p.pos+1])):
+		for p.pos < len(p.src) && (isDigit(p.src[p.pos]) || p.src[p.pos] == '.' ||
+			p.src[p.pos] == 'e' || p.src[p.pos] == 'E' ||
+			((p.src[p.pos] == '-' || p.src[p.pos] == '+') &&
+				(p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E'))) {
+			p.pos++
+		}
+		p.tok = token{kind: tokNumber, text: p.src[start:p.pos], pos: start}
+		if _, err := strconv.ParseFloat(p.tok.text, 64); err != nil {
+			return p.errorf("invalid number '%s'", p.tok.text)
+		}
+	case ch == '"' || ch == '\'':
+		var buf []byte
+		p.pos++
+		for {
+			if p.pos == len(p.src) {
+				return p.errorf("unterminated string")
+			}
+			c := p.src[p.pos]
+			if c == ch {
+				p.pos++
+				break
+			}
+			if c == '\\' && p.pos+1 < len(p.src) {
+				p.pos++
+				c = p.src[p.pos]
+			}
+			buf = append(buf, c)
+			p.pos++
+		}
+		p.tok = token{kind: tokString, text: string(buf), pos: start}
+	case ch == '`':
+		end := strings.IndexByte(p.src[p.pos+1:], '`')
+		if end == -1 {
+			return p.errorf("unterminated field name")
+		}
+		p.pos += end + 2
+		p.tok = token{kind: tokIdent, text: p.src[start+1 : p.pos-1], pos: start}
+	case isIdentStart(ch):
+		for p.pos < len(p.src) && isIdent(p.src[p.pos]) {
+			p.pos++
+		}
+		text := p.src[start:p.pos]
+		switch lc := strings.ToLower(text); lc {
+		case "and", "or", "not":
+			p.tok = token{kind: tokOp, text: lc, pos: start}
+		case "true", "false":
+			// parsed as a boolean by ParseFieldValue
+			p.tok = token{kind: tokNumber, text: lc, pos: start}
+		default:
+			p.tok = token{kind: tokIdent, text: text, pos: start}
+		}
+	default:
+		if p.pos+1 < len(p.src) {
+			switch op := p.src[p.pos : p.pos+2]; op {
+			case "==", "!=", "<=", ">=", "&&", "||", "<>":
+				p.pos += 2
+				switch op {
+				case "&&":
+					op = "and"
+				case "||":
+					op = "or"
+				case "<>":
+					op = "!="
+				}
+				p.tok = token{kind: tokOp, text: op, pos: start}
+				return nil
+			}
+		}
+		switch ch {
+		case '<', '>', '+', '-', '*', '/', '%', '(', ')', '!', '=':
+			p.pos++
+			op := string(ch)
+			switch op {
+			case "!":
+				op = "not"
+			case "=":
+				op = "=="
+			}
+			p.tok = token{kind: tokOp, text: op, pos: start}
+			return nil
+		}
+		return p.errorf("unexpected '%s'", string(ch))
+	}
+	return nil
+}
+
+func (p *parser) isOp(ops ...string) bool {
+	if p.tok.kind != tokOp {
+		return false
+	}
+	for _, op := range ops {
+		if p.tok.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) parseOr() (node, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	depth := p.depth
+	defer func() { p.depth = depth }()
+	for p.isOp("or") {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryNode{op: "or", x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	x, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	depth := p.depth
+	defer func() { p.depth = depth }()
+	for p.isOp("and") {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		y, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryNode{op: "and", x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.isOp("not") {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{x: x}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *parser) parseCompare() (node, error) {
+	x, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.isOp("==", "!=", "<", "<=", ">", ">=") {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		op := p.tok.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		y, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryNode{op: op, x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseSum() (node, error) {
+	x, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	depth := p.depth
+	defer func() { p.depth = depth }()
+	for p.isOp("+", "-") {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		op := p.tok.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		y, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryNode{op: op, x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseProduct() (node, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	depth := p.depth
+	defer func() { p.depth = depth }()
+	for p.isOp("*", "/", "%") {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		op := p.tok.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryNode{op: op, x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isOp("-") {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &negNode{x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokEOF:
+		return nil, p.errorf("unexpected end of expression")
+	case tokNumber:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		return &valueNode{value: collection.ParseFieldValue(tok.text)}, nil
+	case tokString:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		return &valueNode{value: collection.StringValue(tok.text)}, nil
+	case tokIdent:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		return &identNode{name: tok.text}, nil
+	}
+	if p.isOp("(") {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOp(")") {
+			return nil, p.errorf("missing ')'")
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		return x, nil
+	}
+	return nil, p.errorf("unexpected '%s'", tok.text)
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isIdentStart(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isIdent(ch byte) bool {
+	return isIdentStart(ch) || isDigit(ch) || ch == '.'
+}
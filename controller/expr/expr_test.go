@@ -0,0 +1,98 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tidwall/tile38/controller/collection"
+)
+
+func TestMatch(t *testing.T) {
+	fields := map[string]collection.FieldValue{
+		"speed":  collection.NumberValue(45),
+		"fuel":   collection.NumberValue(8),
+		"status": collection.NumberValue(2),
+		"name":   collection.StringValue("truck 1"),
+		"active": collection.BoolValue(true),
+	}
+	lookup := func(name string) collection.FieldValue {
+		return fields[name]
+	}
+	tests := []struct {
+		src   string
+		match bool
+	}{
+		{"speed > 30 AND (fuel < 10 OR status == 3)", true},
+		{"speed > 30 && fuel >= 10 || status == 3", false},
+		{"NOT (speed > 30)", false},
+		{"!active", false},
+		{"active", true},
+		{"active == true", true},
+		{"active == 1", true},
+		{"name == 'truck 1'", true},
+		{`name != "truck 1"`, false},
+		{"name > 'a' and name < 'u'", true},
+		{"name > 10", false},
+		{"speed * 2 - fuel / 2 == 86", true},
+		{"-speed < 0", true},
+		{"speed % 2 = 1", true},
+		{"missing == 0", true},
+		{"name + 1", false},
+		{"`speed` >= 45.0", true},
+	}
+	for _, test := range tests {
+		e, err := Compile(test.src)
+		if err != nil {
+			t.Fatalf("%s: %v", test.src, err)
+		}
+		if match := e.Match(lookup); match != test.match {
+			t.Fatalf("%s: expected %v, got %v", test.src, test.match, match)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		"", "speed >", "(speed > 1", "speed > 1)", "'abc", "speed $ 1", "1.2.3",
+	} {
+		if _, err := Compile(src); err == nil {
+			t.Fatalf("%s: expected an error", src)
+		}
+	}
+}
+
+func TestNesting(t *testing.T) {
+	nested := func(open, close string, n int) string {
+		return strings.Repeat(open, n) + "speed > 1" + strings.Repeat(close, n)
+	}
+	for _, src := range []string{
+		nested("(", ")", maxDepth-1), nested("not ", "", maxDepth-1), nested("-", "", maxDepth-2),
+		"a" + strings.Repeat("+a", maxDepth), "a" + strings.Repeat(" and a", maxDepth),
+		"(a" + strings.Repeat("+a", maxDepth/2) + ")*(b" + strings.Repeat("+b", maxDepth/2-2) + ")",
+	} {
+		if _, err := Compile(src); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	for _, src := range []string{
+		nested("(", ")", 10000), nested("not ", "", 10000), nested("not (", ")", maxDepth),
+		"speed > " + strings.Repeat("-", 10000) + "1",
+		"a" + strings.Repeat("+a", 10000), "a" + strings.Repeat(" or a", 10000),
+		"a" + strings.Repeat("+a", maxDepth+1),
+	} {
+		if _, err := Compile(src); err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+			t.Fatalf("expected a nesting error, got %v", err)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	e, err := Compile("a > 1 and (b < 2 or a == c)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := e.Fields()
+	if len(fields) != 3 || fields[0] != "a" || fields[1] != "b" || fields[2] != "c" {
+		t.Fatalf("invalid fields %v", fields)
+	}
+}
@@ -0,0 +1,419 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/controller/log"
+	"github.com/tidwall/tile38/controller/server"
+)
+
+// Automatic failover
+//
+// Every server that has the "peers" property set monitors the other servers
+// in the group once a second using the ROLE command. A follower that cannot
+// reach its leader for "failover-timeout" seconds considers the leader down.
+// When a quorum (a majority of the group) agrees that the leader is down,
+// the follower with the most data promotes itself to leader and bumps the
+// failover epoch. The other servers, including the old leader when it comes
+// back, follow the leader with the highest epoch.
+
+// peerState is the last known state of a server in the failover group.
+type peerState struct {
+	addr       string
+	id         string
+	role       string // "leader" or "follower"
+	following  string
+	leaderID   string
+	leaderDown bool
+	epoch      int
+	offset     int // replication offset
+	err        error
+	seen       time.Time
+}
+
+// failoverT holds the state of the peer monitor.
+type failoverT struct {
+	peers      map[string]*peerState // by addr
+	leaderAddr string                // leader being watched
+	leaderID   string                // id of the leader being watched
+	leaderSeen time.Time             // last time the leader responded
+}
+
+// parsePeers parses a comma separated list of "host:port" addresses.
+func parsePeers(value string) ([]string, bool) {
+	var peers []string
+	for _, addr := range strings.Split(value, ",") {
+		addr = strings.ToLower(strings.TrimSpace(addr))
+		if addr == "" {
+			continue
+		}
+		_, sport, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, false
+		}
+		if _, err := strconv.ParseUint(sport, 10, 16); err != nil {
+			return nil, false
+		}
+		peers = append(peers, addr)
+	}
+	return peers, true
+}
+
+// failoverTimeout returns the time that a leader may be unreachable before
+// it's considered down.
+func (c *Controller) failoverTimeout() time.Duration {
+	timeout := c.config.FailoverTimeout
+	if timeout <= 0 {
+		timeout = defaultFailoverTimeout
+	}
+	return time.Duration(timeout) * time.Second
+}
+
+// leaderDown returns true when this server is a follower that has not heard
+// from its leader within the failover timeout.
+func (c *Controller) leaderDown() bool {
+	if c.config.FollowHost == "" || c.failover.leaderSeen.IsZero() {
+		return false
+	}
+	return time.Now().Sub(c.failover.leaderSeen) > c.failoverTimeout()
+}
+
+func (c *Controller) roleMap() map[string]interface{} {
+	m := make(map[string]interface{})
+	m["id"] = c.config.ServerID
+	m["epoch"] = c.config.FailoverEpoch
+	m["aof_size"] = c.aofsz
+	m["repl_offset"] = c.repl.offset
+	if c.config.FollowHost == "" {
+		m["role"] = "leader"
+	} else {
+		m["role"] = "follower"
+		m["following"] = fmt.Sprintf("%s:%d", c.config.FollowHost, c.config.FollowPort)
+		m["leader_id"] = c.failover.leaderID
+		m["leader_down"] = c.leaderDown()
+		m["caught_up"] = c.fcup
+	}
+	return m
+}
+
+func (c *Controller) cmdRole(msg *server.Message) (res string, err error) {
+	start := time.Now()
+	if len(msg.Values) != 1 {
+		return "", errInvalidNumberOfArguments
+	}
+	m := c.roleMap()
+	switch msg.OutputType {
+	case server.JSON:
+		data, err := json.Marshal(m)
+		if err != nil {
+			return "", err
+		}
+		res = `{"ok":true,"role":` + string(data) + `,"elapsed":"` + time.Now().Sub(start).String() + "\"}"
+	case server.RESP:
+		data, err := resp.ArrayValue(respValuesSimpleMap(m)).MarshalRESP()
+		if err != nil {
+			return "", err
+		}
+		res = string(data)
+	}
+	return res, nil
+}
+
+func (c *Controller) cmdReplicas(msg *server.Message) (res string, err error) {
+	start := time.Now()
+	if len(msg.Values) != 1 {
+		return "", errInvalidNumberOfArguments
+	}
+	var ms []map[string]interface{}
+	for _, addr := range c.config.Peers {
+		m := map[string]interface{}{"addr": addr}
+		if peer := c.failover.peers[addr]; peer != nil {
+			if peer.err != nil {
+				m["err"] = peer.err.Error()
+			} else {
+				m["id"] = peer.id
+				m["role"] = peer.role
+				m["epoch"] = peer.epoch
+				m["repl_offset"] = peer.offset
+				if peer.role == "follower" {
+					m["following"] = peer.following
+					m["leader_down"] = peer.leaderDown
+				}
+			}
+			if !peer.seen.IsZero() {
+				m["last_seen"] = peer.seen.Unix()
+			}
+		}
+		ms = append(ms, m)
+	}
+	switch msg.OutputType {
+	case server.JSON:
+		buf := &bytes.Buffer{}
+		buf.WriteString(`{"ok":true,"replicas":[`)
+		for i, m := range ms {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			data, err := json.Marshal(m)
+			if err != nil {
+				return "", err
+			}
+			buf.Write(data)
+		}
+		buf.WriteString(`],"elapsed":"` + time.Now().Sub(start).String() + "\"}")
+		return buf.String(), nil
+	case server.RESP:
+		var vals []resp.Value
+		for _, m := range ms {
+			vals = append(vals, resp.ArrayValue(respValuesSimpleMap(m)))
+		}
+		data, err := resp.ArrayValue(vals).MarshalRESP()
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	return "", nil
+}
+
+// doRole requests the role of a server in the failover group.
+func (c *Controller) doRole(addr, auth string, tlsConfig *tls.Config) *peerState {
+	peer := &peerState{addr: addr}
+	conn, err := DialTLSTimeout(addr, time.Second, tlsConfig)
+	if err != nil {
+		peer.err = err
+		return peer
+	}
+	defer conn.Close()
+	conn.conn.SetDeadline(time.Now().Add(time.Second))
+	if auth != "" {
+		if err := c.followDoLeaderAuth(conn, auth); err != nil {
+			peer.err = err
+			return peer
+		}
+	}
+	v, err := conn.Do("role")
+	if err == nil {
+		err = v.Error()
+	}
+	if err != nil {
+		peer.err = err
+		return peer
+	}
+	arr := v.Array()
+	m := make(map[string]string)
+	for i := 0; i < len(arr)/2; i++ {
+		m[arr[i*2+0].String()] = arr[i*2+1].String()
+	}
+	peer.id = m["id"]
+	peer.role = m["role"]
+	peer.following = m["following"]
+	peer.leaderID = m["leader_id"]
+	peer.leaderDown = m["leader_down"] == "true"
+	peer.epoch, _ = strconv.Atoi(m["epoch"])
+	peer.offset, _ = strconv.Atoi(m["repl_offset"])
+	peer.seen = time.Now()
+	if peer.id == "" || (peer.role != "leader" && peer.role != "follower") {
+		peer.err = fmt.Errorf("invalid role response")
+	}
+	return peer
+}
+
+// watchPeers monitors the leader and the other servers in the failover
+// group. It executes once a second.
+func (c *Controller) watchPeers() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for range t.C {
+		c.mu.RLock()
+		stop := c.stopWatchingPeers
+		peers := c.config.Peers
+		auth := c.config.LeaderAuth
+		tlsConfig := c.replTLS
+		var leaderAddr string
+		if c.config.FollowHost != "" {
+			leaderAddr = fmt.Sprintf("%s:%d", c.config.FollowHost, c.config.FollowPort)
+		}
+		newLeader := leaderAddr != c.failover.leaderAddr
+		c.mu.RUnlock()
+		if stop {
+			return
+		}
+		if len(peers) == 0 {
+			continue
+		}
+		if newLeader {
+			c.mu.Lock()
+			if leaderAddr != c.failover.leaderAddr {
+				// give a new leader a full timeout to respond
+				c.failover.leaderAddr = leaderAddr
+				c.failover.leaderID = ""
+				c.failover.leaderSeen = time.Now()
+			}
+			c.mu.Unlock()
+		}
+
+		// poll the leader and all peers at the same time
+		var wg sync.WaitGroup
+		var leader *peerState
+		states := make([]*peerState, len(peers))
+		for i, addr := range peers {
+			wg.Add(1)
+			go func(i int, addr string) {
+				defer wg.Done()
+				states[i] = c.doRole(addr, auth, tlsConfig)
+			}(i, addr)
+		}
+		if leaderAddr != "" {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				leader = c.doRole(leaderAddr, auth, tlsConfig)
+			}()
+		}
+		wg.Wait()
+
+		c.mu.Lock()
+		if c.failover.leaderAddr == leaderAddr && leader != nil &&
+			leader.err == nil && leader.role == "leader" {
+			c.failover.leaderID = leader.id
+			c.failover.leaderSeen = leader.seen
+		}
+		c.failover.peers = make(map[string]*peerState)
+		for _, peer := range states {
+			c.failover.peers[peer.addr] = peer
+		}
+		c.failoverStep(states)
+		c.mu.Unlock()
+	}
+}
+
+// failoverStep decides if this server should follow another leader or
+// promote itself to leader.
+func (c *Controller) failoverStep(states []*peerState) {
+	self := &peerState{
+		id:         c.config.ServerID,
+		role:       "leader",
+		leaderID:   c.failover.leaderID,
+		leaderDown: c.leaderDown(),
+		epoch:      c.config.FailoverEpoch,
+		offset:     c.repl.offset,
+	}
+	if c.config.FollowHost != "" {
+		self.role = "follower"
+	}
+
+	// converge on the newest leader
+	if best := newestLeader(states, self.id); best != nil {
+		if best.epoch > self.epoch ||
+			(best.epoch == self.epoch && self.role == "leader" && best.id < self.id) {
+			if self.role == "leader" || c.failover.leaderID != best.id {
+				log.Infof("failover: following leader %s at epoch %d", best.addr, best.epoch)
+				c.failoverFollow(best.addr, best.epoch)
+			} else if best.epoch != self.epoch {
+				c.config.FailoverEpoch = best.epoch
+				c.writeConfig(false)
+			}
+			return
+		}
+	}
+	if self.role != "follower" || !self.leaderDown {
+		return
+	}
+	candidate := failoverCandidate(self, states)
+	if candidate == nil || candidate.id != self.id {
+		return
+	}
+	epoch := self.epoch
+	for _, peer := range states {
+		if peer.err == nil && peer.epoch > epoch {
+			epoch = peer.epoch
+		}
+	}
+	epoch++
+	log.Infof("failover: leader is down, promoting self to leader at epoch %d", epoch)
+	c.failoverFollow("", epoch)
+}
+
+// failoverFollow follows a new leader, or no one when addr is empty.
+func (c *Controller) failoverFollow(addr string, epoch int) {
+	pconfig := c.config
+	if addr == "" {
+		c.config.FollowHost = ""
+		c.config.FollowPort = 0
+	} else {
+		host, sport, _ := net.SplitHostPort(addr)
+		port, _ := strconv.Atoi(sport)
+		c.config.FollowHost = host
+		c.config.FollowPort = port
+	}
+	c.config.FailoverEpoch = epoch
+	if err := c.writeConfig(false); err != nil {
+		log.Errorf("failover: %v", err)
+		c.config = pconfig // revert
+		return
+	}
+	c.followc++
+	if c.config.FollowHost != "" {
+		go c.follow(c.config.FollowHost, c.config.FollowPort, c.followc)
+	} else {
+		c.replPromote()
+	}
+}
+
+// newestLeader returns the reachable leader with the highest epoch, not
+// including self. Ties go to the lowest id.
+func newestLeader(states []*peerState, selfID string) *peerState {
+	var best *peerState
+	for _, peer := range states {
+		if peer.err != nil || peer.role != "leader" || peer.id == selfID {
+			continue
+		}
+		if best == nil || peer.epoch > best.epoch ||
+			(peer.epoch == best.epoch && peer.id < best.id) {
+			best = peer
+		}
+	}
+	return best
+}
+
+// failoverCandidate returns the follower that should be promoted when the
+// leader is down, or nil when there's no quorum. The candidate is the
+// follower with the largest replication offset, and ties go to the lowest id.
+func failoverCandidate(self *peerState, states []*peerState) *peerState {
+	members := len(states) + 1
+	voters := []*peerState{self}
+	for _, peer := range states {
+		if peer.err != nil {
+			continue
+		}
+		if peer.id == self.id {
+			// self is listed in the peers
+			members--
+			continue
+		}
+		if peer.role == "follower" && peer.leaderDown &&
+			(peer.leaderID == "" || self.leaderID == "" || peer.leaderID == self.leaderID) {
+			voters = append(voters, peer)
+		}
+	}
+	if len(voters) < members/2+1 {
+		return nil
+	}
+	best := voters[0]
+	for _, peer := range voters[1:] {
+		if peer.offset > best.offset || (peer.offset == best.offset && peer.id < best.id) {
+			best = peer
+		}
+	}
+	return best
+}
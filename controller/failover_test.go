@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFailoverCandidate(t *testing.T) {
+	down := errors.New("connection refused")
+	self := &peerState{id: "b", role: "follower", leaderID: "a", leaderDown: true, offset: 100}
+	states := []*peerState{
+		{addr: ":9851", err: down},
+		{addr: ":9852", id: "b", role: "follower", leaderID: "a", leaderDown: true, offset: 100},
+		{addr: ":9853", id: "c", role: "follower", leaderID: "a", leaderDown: true, offset: 200},
+	}
+	if candidate := failoverCandidate(self, states); candidate == nil || candidate.id != "c" {
+		t.Fatalf("expected 'c', got %v", candidate)
+	}
+	states[2].offset = 100
+	if candidate := failoverCandidate(self, states); candidate == nil || candidate.id != "b" {
+		t.Fatalf("expected 'b', got %v", candidate)
+	}
+	// no quorum when the other follower still sees the leader
+	states[2].leaderDown = false
+	if candidate := failoverCandidate(self, states); candidate != nil {
+		t.Fatalf("expected nil, got %v", candidate)
+	}
+	states[2].leaderDown = true
+	states[2].leaderID = "x"
+	if candidate := failoverCandidate(self, states); candidate != nil {
+		t.Fatalf("expected nil, got %v", candidate)
+	}
+}
+
+func TestNewestLeader(t *testing.T) {
+	states := []*peerState{
+		{id: "a", role: "leader", epoch: 1},
+		{id: "b", role: "leader", epoch: 2},
+		{id: "c", role: "leader", epoch: 2},
+		{id: "d", role: "follower", epoch: 3},
+		{id: "e", role: "leader", epoch: 4, err: errors.New("timeout")},
+	}
+	if best := newestLeader(states, "x"); best == nil || best.id != "b" {
+		t.Fatalf("expected 'b', got %v", best)
+	}
+	if best := newestLeader(states, "b"); best == nil || best.id != "c" {
+		t.Fatalf("expected 'c', got %v", best)
+	}
+	if best := newestLeader(states[3:], "x"); best != nil {
+		t.Fatalf("expected nil, got %v", best)
+	}
+}
+
+func TestParsePeers(t *testing.T) {
+	peers, ok := parsePeers(" LocalHost:9851, 127.0.0.1:9852,")
+	if !ok || len(peers) != 2 || peers[0] != "localhost:9851" || peers[1] != "127.0.0.1:9852" {
+		t.Fatalf("invalid peers %v", peers)
+	}
+	for _, value := range []string{"localhost", "localhost:abc", "localhost:99999"} {
+		if _, ok := parsePeers(value); ok {
+			t.Fatalf("%s: expected invalid", value)
+		}
+	}
+}
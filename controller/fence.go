@@ -2,10 +2,12 @@ package controller
 
 import (
 	"math"
+	"sort"
 	"strconv"
 	"time"
 
 	"github.com/tidwall/gjson"
+	"github.com/tidwall/tile38/controller/collection"
 	"github.com/tidwall/tile38/controller/glob"
 	"github.com/tidwall/tile38/controller/server"
 	"github.com/tidwall/tile38/geojson"
@@ -59,7 +61,13 @@ func hookJSONString(hookName string, metas []FenceMeta) string {
 	return string(appendHookDetails(nil, hookName, metas))
 }
 func fenceMatch(hookName string, sw *scanWriter, fence *liveFenceSwitches, metas []FenceMeta, details *commandDetailsT) [][]byte {
+	if details.command == "tick" {
+		return fenceMatchDwell(hookName, sw, fence, metas, details)
+	}
 	if details.command == "drop" {
+		fence.dwells = nil
+		fence.motions = nil
+		fence.roamGroups = nil
 		return [][]byte{[]byte(`{"command":"drop"` + hookJSONString(hookName, metas) + `,"time":` + jsonTimeFormat(details.timestamp) + `}`)}
 	}
 	if len(fence.glob) > 0 && !(len(fence.glob) == 1 && fence.glob[0] == '*') {
@@ -80,13 +88,28 @@ func fenceMatch(hookName string, sw *scanWriter, fence *liveFenceSwitches, metas
 		}
 	}
 	if details.command == "del" {
-		return [][]byte{[]byte(`{"command":"del"` + hookJSONString(hookName, metas) + `,"id":` + jsonString(details.id) + `,"time":` + jsonTimeFormat(details.timestamp) + `}`)}
+		fenceUpdateDwell(fence, details, false)
+		fenceUpdateMotion(fence, details)
+		delete(fence.groups, details.key+":"+details.id)
+		var msgs [][]byte
+		if fence.roam.on && fence.cmd != "nearby" {
+			// the object leaves all of its areas
+			msgs = fenceMatchRoamArea(hookName, sw, fence, metas, details, nil)
+		}
+		return append(msgs, []byte(`{"command":"del"`+hookJSONString(hookName, metas)+`,"id":`+jsonString(details.id)+`,"time":`+jsonTimeFormat(details.timestamp)+`}`))
+	}
+	mo := fenceUpdateMotion(fence, details)
+	if !fenceMatchMotion(fence, mo) {
+		return nil
 	}
 	var roamkeys, roamids []string
 	var roammeters []float64
 	var detect string = "outside"
 	if fence != nil {
 		if fence.roam.on {
+			if fence.cmd != "nearby" {
+				return fenceMatchRoamArea(hookName, sw, fence, metas, details, mo)
+			}
 			if details.command == "set" {
 				roamkeys, roamids, roammeters = fenceMatchRoam(sw.c, fence, details.key, details.id, details.obj)
 			}
@@ -134,6 +157,7 @@ func fenceMatch(hookName string, sw *scanWriter, fence *liveFenceSwitches, metas
 					}
 				}
 			}
+			fenceUpdateDwell(fence, details, detect == "enter" || detect == "inside")
 		}
 	}
 
@@ -154,38 +178,11 @@ func fenceMatch(hookName string, sw *scanWriter, fence *liveFenceSwitches, metas
 		}
 		break
 	}
-	sw.mu.Lock()
-	var distance float64
-	if fence.distance {
-		distance = details.obj.CalculatedPoint().DistanceTo(geojson.Position{X: fence.lon, Y: fence.lat, Z: 0})
-	}
-	sw.fmap = details.fmap
-	sw.fullFields = true
-	sw.msg.OutputType = server.JSON
-	sw.writeObject(ScanWriterParams{
-		id:       details.id,
-		o:        details.obj,
-		fields:   details.fields,
-		noLock:   true,
-		distance: distance,
-	})
-
-	if sw.wr.Len() == 0 {
-		sw.mu.Unlock()
+	res := fenceWriteObject(sw, fence, details)
+	if res == nil {
 		return nil
 	}
 
-	res := make([]byte, sw.wr.Len())
-	copy(res, sw.wr.Bytes())
-	sw.wr.Reset()
-	if len(res) > 0 && res[0] == ',' {
-		res = res[1:]
-	}
-	if sw.output == outputIDs {
-		res = []byte(`{"id":` + string(res) + `}`)
-	}
-	sw.mu.Unlock()
-
 	if fence.groups == nil {
 		fence.groups = make(map[string]string)
 	}
@@ -209,7 +206,7 @@ func fenceMatch(hookName string, sw *scanWriter, fence *liveFenceSwitches, metas
 	var msgs [][]byte
 	if fence.detect == nil || fence.detect[detect] {
 		if len(res) > 0 && res[0] == '{' {
-			msgs = append(msgs, makemsg(details.command, group, detect, hookName, metas, details.key, details.timestamp, res[1:]))
+			msgs = append(msgs, makemsg(details.command, group, detect, hookName, metas, details.key, details.timestamp, mo, res[1:]))
 		} else {
 			msgs = append(msgs, res)
 		}
@@ -217,11 +214,11 @@ func fenceMatch(hookName string, sw *scanWriter, fence *liveFenceSwitches, metas
 	switch detect {
 	case "enter":
 		if fence.detect == nil || fence.detect["inside"] {
-			msgs = append(msgs, makemsg(details.command, group, "inside", hookName, metas, details.key, details.timestamp, res[1:]))
+			msgs = append(msgs, makemsg(details.command, group, "inside", hookName, metas, details.key, details.timestamp, mo, res[1:]))
 		}
 	case "exit", "cross":
 		if fence.detect == nil || fence.detect["outside"] {
-			msgs = append(msgs, makemsg(details.command, group, "outside", hookName, metas, details.key, details.timestamp, res[1:]))
+			msgs = append(msgs, makemsg(details.command, group, "outside", hookName, metas, details.key, details.timestamp, mo, res[1:]))
 		}
 	case "roam":
 		if len(msgs) > 0 {
@@ -250,7 +247,7 @@ func fenceMatch(hookName string, sw *scanWriter, fence *liveFenceSwitches, metas
 								nmsg = append(nmsg, `{"id":`+jsonString(id)+`,"self":true,"object":`+obj.JSON()+`}`...)
 							}
 							pattern := id + fence.roam.scan
-							iterator := func(oid string, o geojson.Object, fields []float64) bool {
+							iterator := func(oid string, o geojson.Object, fields []collection.FieldValue) bool {
 								if oid == id {
 									return true
 								}
@@ -280,7 +277,162 @@ func fenceMatch(hookName string, sw *scanWriter, fence *liveFenceSwitches, metas
 	return msgs
 }
 
-func makemsg(command, group, detect, hookName string, metas []FenceMeta, key string, t time.Time, tail []byte) []byte {
+// fenceWriteObject returns the json of the object in a fence notification, or
+// nil when the scan writer filters the object out.
+func fenceWriteObject(sw *scanWriter, fence *liveFenceSwitches, details *commandDetailsT) []byte {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	var distance float64
+	if fence.distance {
+		distance = details.obj.CalculatedPoint().DistanceTo(geojson.Position{X: fence.lon, Y: fence.lat, Z: 0})
+	}
+	sw.fmap = details.fmap
+	sw.fullFields = true
+	sw.msg.OutputType = server.JSON
+	sw.writeObject(ScanWriterParams{
+		id:       details.id,
+		o:        details.obj,
+		fields:   details.fields,
+		noLock:   true,
+		distance: distance,
+	})
+
+	if sw.wr.Len() == 0 {
+		return nil
+	}
+
+	res := make([]byte, sw.wr.Len())
+	copy(res, sw.wr.Bytes())
+	sw.wr.Reset()
+	if len(res) > 0 && res[0] == ',' {
+		res = res[1:]
+	}
+	if sw.output == outputIDs {
+		res = []byte(`{"id":` + string(res) + `}`)
+	}
+	return res
+}
+
+// fenceMatchRoamArea detects when an object starts or stops intersecting, or
+// being within, the areas of another collection. Each (id, area) pair has its
+// own enter, inside, and exit notifications, which include the area. An FSET
+// doesn't move the object, so it's inside of its areas, and a DEL exits all
+// of them.
+func fenceMatchRoamArea(hookName string, sw *scanWriter, fence *liveFenceSwitches, metas []FenceMeta, details *commandDetailsT, mo *motionT) [][]byte {
+	if details.fmap == nil {
+		return nil
+	}
+	oldObj, newObj := details.oldObj, details.obj
+	switch details.command {
+	case "fset":
+		oldObj = details.obj
+	case "del":
+		oldObj, newObj = details.obj, nil
+	}
+	var oldAreas map[string]bool
+	if oldObj != nil {
+		oldAreas = make(map[string]bool)
+		for _, id := range fenceRoamAreas(sw.c, fence, details.key, details.id, oldObj) {
+			oldAreas[id] = true
+		}
+	}
+	var newAreas []string
+	if newObj != nil {
+		newAreas = fenceRoamAreas(sw.c, fence, details.key, details.id, newObj)
+	}
+	var detects, ids []string
+	for _, id := range newAreas {
+		if oldAreas[id] {
+			detects, ids = append(detects, "inside"), append(ids, id)
+		} else {
+			detects, ids = append(detects, "enter", "inside"), append(ids, id, id)
+		}
+		delete(oldAreas, id)
+	}
+	var exits []string
+	for id := range oldAreas {
+		exits = append(exits, id)
+	}
+	sort.Strings(exits)
+	for _, id := range exits {
+		detects, ids = append(detects, "exit"), append(ids, id)
+	}
+
+	// the groups are kept for every detection, including the ones that are
+	// not notified, and are removed on exit
+	objkey := details.key + ":" + details.id
+	groups := fence.roamGroups[objkey]
+	if groups == nil {
+		groups = make(map[string]string)
+	}
+	var res []byte
+	var msgs [][]byte
+	for i, detect := range detects {
+		group, ok := groups[ids[i]]
+		if !ok || detect == "enter" {
+			group = bsonID()
+			groups[ids[i]] = group
+		}
+		if detect == "exit" {
+			delete(groups, ids[i])
+		}
+		if fence.detect != nil && !fence.detect[detect] {
+			continue
+		}
+		if res == nil {
+			if res = fenceWriteObject(sw, fence, details); res == nil {
+				break
+			}
+		}
+		msg := makemsg(details.command, group, detect, hookName, metas, details.key, details.timestamp, mo, res[1:])
+		msg = append(msg[:len(msg)-1], `,"`+fence.cmd+`":{"key":`...)
+		msg = appendJSONString(msg, fence.roam.key)
+		msg = append(msg, `,"id":`...)
+		msg = appendJSONString(msg, ids[i])
+		msg = append(msg, '}', '}')
+		msgs = append(msgs, msg)
+	}
+	if len(groups) == 0 || details.command == "del" {
+		delete(fence.roamGroups, objkey)
+	} else {
+		if fence.roamGroups == nil {
+			fence.roamGroups = make(map[string]map[string]string)
+		}
+		fence.roamGroups[objkey] = groups
+	}
+	return msgs
+}
+
+// fenceRoamAreas returns the sorted ids of the roam areas that an object
+// intersects or is within.
+func fenceRoamAreas(c *Controller, fence *liveFenceSwitches, tkey, tid string, obj geojson.Object) []string {
+	col := c.getCol(fence.roam.key)
+	if col == nil || !obj.IsGeometry() {
+		return nil
+	}
+	var ids []string
+	col.Intersects(0, obj, 0, 0, 0, 0, math.Inf(-1), math.Inf(+1),
+		func(id string, area geojson.Object, fields []collection.FieldValue) bool {
+			if tkey == fence.roam.key && id == tid {
+				return true // skip self
+			}
+			var match bool
+			if fence.roam.pattern {
+				match, _ = glob.Match(fence.roam.id, id)
+			} else {
+				match = fence.roam.id == id
+			}
+			if match && (fence.cmd != "within" || obj.Within(area)) {
+				ids = append(ids, id)
+			}
+			return true
+		},
+	)
+	sort.Strings(ids)
+	return ids
+}
+
+func makemsg(command, group, detect, hookName string, metas []FenceMeta, key string, t time.Time, mo *motionT, tail []byte) []byte {
 	var buf []byte
 	buf = append(append(buf, `{"command":"`...), command...)
 	buf = append(append(buf, `","group":"`...), group...)
@@ -289,6 +441,9 @@ func makemsg(command, group, detect, hookName string, metas []FenceMeta, key str
 	buf = appendHookDetails(buf, hookName, metas)
 	buf = appendJSONString(append(buf, `,"key":`...), key)
 	buf = appendJSONTimeFormat(append(buf, `,"time":`...), t)
+	if mo != nil {
+		buf = appendMotion(buf, mo)
+	}
 	buf = append(append(buf, ','), tail...)
 	return buf
 }
@@ -333,7 +488,7 @@ func fenceMatchRoam(c *Controller, fence *liveFenceSwitches, tkey, tid string, o
 	}
 	p := obj.CalculatedPoint()
 	col.Nearby(0, p.Y, p.X, fence.roam.meters, math.Inf(-1), math.Inf(+1),
-		func(id string, obj geojson.Object, fields []float64) bool {
+		func(id string, obj geojson.Object, fields []collection.FieldValue) bool {
 			var match bool
 			if id == tid {
 				return true // skip self
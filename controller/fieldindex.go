@@ -0,0 +1,187 @@
+package controller
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/controller/collection"
+	"github.com/tidwall/tile38/controller/server"
+)
+
+// indexSelectivity is the fraction of a collection, as 1/n, that a WHERE
+// range may match for the search commands to prefer the field index over
+// the spatial index.
+const indexSelectivity = 10
+
+// parseIndexArgs parses the "key FIELD field" arguments of the INDEX and
+// DELINDEX commands.
+func parseIndexArgs(vs []resp.Value) (key, field string, err error) {
+	var ok bool
+	var typ string
+	if vs, key, ok = tokenval(vs); !ok || key == "" {
+		return "", "", errInvalidNumberOfArguments
+	}
+	if vs, typ, ok = tokenval(vs); !ok || typ == "" {
+		return "", "", errInvalidNumberOfArguments
+	}
+	if strings.ToLower(typ) != "field" {
+		return "", "", errInvalidArgument(typ)
+	}
+	if vs, field, ok = tokenval(vs); !ok || field == "" {
+		return "", "", errInvalidNumberOfArguments
+	}
+	if len(vs) != 0 {
+		return "", "", errInvalidNumberOfArguments
+	}
+	if isReservedFieldName(field) {
+		return "", "", errInvalidArgument(field)
+	}
+	return key, field, nil
+}
+
+func (c *Controller) cmdIndex(msg *server.Message) (res string, d commandDetailsT, err error) {
+	start := time.Now()
+	key, field, err := parseIndexArgs(msg.Values[1:])
+	if err != nil {
+		return "", d, err
+	}
+	fields, ok := c.fieldIndexes[key]
+	if !ok {
+		fields = make(map[string]bool)
+		c.fieldIndexes[key] = fields
+	}
+	if !fields[field] {
+		fields[field] = true
+		if col := c.getCol(key); col != nil {
+			col.AddIndex(field)
+		}
+		d.updated = true
+	}
+	d.timestamp = time.Now()
+	switch msg.OutputType {
+	case server.JSON:
+		return server.OKMessage(msg, start), d, nil
+	case server.RESP:
+		if d.updated {
+			return ":1\r\n", d, nil
+		}
+		return ":0\r\n", d, nil
+	}
+	return
+}
+
+func (c *Controller) cmdDelIndex(msg *server.Message) (res string, d commandDetailsT, err error) {
+	start := time.Now()
+	key, field, err := parseIndexArgs(msg.Values[1:])
+	if err != nil {
+		return "", d, err
+	}
+	if fields, ok := c.fieldIndexes[key]; ok && fields[field] {
+		delete(fields, field)
+		if len(fields) == 0 {
+			delete(c.fieldIndexes, key)
+		}
+		if col := c.getCol(key); col != nil {
+			col.RemoveIndex(field)
+		}
+		d.updated = true
+	}
+	d.timestamp = time.Now()
+	switch msg.OutputType {
+	case server.JSON:
+		return server.OKMessage(msg, start), d, nil
+	case server.RESP:
+		if d.updated {
+			return ":1\r\n", d, nil
+		}
+		return ":0\r\n", d, nil
+	}
+	return
+}
+
+func (c *Controller) cmdIndexes(msg *server.Message) (res string, err error) {
+	start := time.Now()
+	vs := msg.Values[1:]
+	var key string
+	var ok bool
+	if vs, key, ok = tokenval(vs); !ok || key == "" {
+		return "", errInvalidNumberOfArguments
+	}
+	if len(vs) != 0 {
+		return "", errInvalidNumberOfArguments
+	}
+	fields := c.indexedFields(key)
+	switch msg.OutputType {
+	case server.JSON:
+		buf := &bytes.Buffer{}
+		buf.WriteString(`{"ok":true,"fields":[`)
+		for i, field := range fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(jsonString(field))
+		}
+		buf.WriteString(`],"elapsed":"` + time.Now().Sub(start).String() + "\"}")
+		return buf.String(), nil
+	case server.RESP:
+		vals := make([]resp.Value, len(fields))
+		for i, field := range fields {
+			vals[i] = resp.StringValue(field)
+		}
+		data, err := resp.ArrayValue(vals).MarshalRESP()
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	return "", nil
+}
+
+// indexedFields returns the sorted names of the indexed fields for a key.
+func (c *Controller) indexedFields(key string) []string {
+	fields := make([]string, 0, len(c.fieldIndexes[key]))
+	for field := range c.fieldIndexes[key] {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// applyIndexes creates the field indexes of a key on a new collection.
+func (c *Controller) applyIndexes(key string, col *collection.Collection) {
+	for field := range c.fieldIndexes[key] {
+		col.AddIndex(field)
+	}
+}
+
+// indexedWhere returns a numeric WHERE clause on an indexed field that can
+// be used to walk the field index, or nil if there is none.
+func indexedWhere(col *collection.Collection, wheres []whereT) *whereT {
+	for i := range wheres {
+		if !wheres[i].str && !wheres[i].unbounded() && col.HasIndex(wheres[i].field) {
+			return &wheres[i]
+		}
+	}
+	return nil
+}
+
+// selectiveWhere returns an indexed WHERE clause that matches a small enough
+// part of the collection for a search to walk the field index instead of the
+// spatial index, or nil if there is none.
+func selectiveWhere(col *collection.Collection, wheres []whereT, sparse uint8) *whereT {
+	if sparse > 0 {
+		return nil
+	}
+	where := indexedWhere(col, wheres)
+	if where == nil {
+		return nil
+	}
+	limit := col.Count() / indexSelectivity
+	if col.IndexRangeCount(where.field, where.min, where.max, limit+1) > limit {
+		return nil
+	}
+	return where
+}
@@ -49,9 +49,10 @@ func (c *Controller) cmdFollow(msg *server.Message) (res string, err error) {
 		port := int(n)
 		update = c.config.FollowHost != host || c.config.FollowPort != port
 		auth := c.config.LeaderAuth
+		tlsConfig := c.replTLS
 		if update {
 			c.mu.Unlock()
-			conn, err := DialTimeout(fmt.Sprintf("%s:%d", host, port), time.Second*2)
+			conn, err := DialTLSTimeout(fmt.Sprintf("%s:%d", host, port), time.Second*2, tlsConfig)
 			if err != nil {
 				c.mu.Lock()
 				return "", fmt.Errorf("cannot follow: %v", err)
@@ -95,6 +96,7 @@ func (c *Controller) cmdFollow(msg *server.Message) (res string, err error) {
 			go c.follow(c.config.FollowHost, c.config.FollowPort, c.followc)
 		} else {
 			log.Infof("following no one")
+			c.replPromote()
 		}
 	}
 	return server.OKMessage(msg, start), nil
@@ -120,22 +122,63 @@ func (c *Controller) followHandleCommand(values []resp.Value, followc uint64, w
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.followc != followc {
-		return c.aofsz, errNoLongerFollowing
+		return c.repl.offset, errNoLongerFollowing
+	}
+	command := strings.ToLower(values[0].String())
+	switch {
+	case command == "multi":
+		c.fmulti = [][]resp.Value{values}
+		return c.repl.offset, nil
+	case len(c.fmulti) > 0 && command == "exec":
+		return c.followHandleMulti(append(c.fmulti, values))
+	case len(c.fmulti) > 0:
+		c.fmulti = append(c.fmulti, values)
+		return c.repl.offset, nil
 	}
 	msg := &server.Message{
-		Command: strings.ToLower(values[0].String()),
+		Command: command,
 		Values:  values,
 	}
 	_, d, err := c.command(msg, nil, nil)
 	if err != nil {
 		if commandErrIsFatal(err) {
-			return c.aofsz, err
+			return c.repl.offset, err
 		}
 	}
 	if err := c.writeAOF(resp.ArrayValue(values), &d); err != nil {
-		return c.aofsz, err
+		return c.repl.offset, err
 	}
-	return c.aofsz, nil
+	return c.repl.offset, nil
+}
+
+// followHandleMulti applies a transaction from the leader, including its
+// MULTI and EXEC, as one unit. The caller must hold the write lock.
+func (c *Controller) followHandleMulti(multi [][]resp.Value) (int, error) {
+	c.fmulti = nil
+	values := []resp.Value{resp.ArrayValue(multi[0])}
+	var ds []*commandDetailsT
+	for _, vals := range multi[1 : len(multi)-1] {
+		msg := &server.Message{
+			Command: strings.ToLower(vals[0].String()),
+			Values:  vals,
+		}
+		_, d, err := c.command(msg, nil, nil)
+		if err != nil {
+			if commandErrIsFatal(err) {
+				return c.repl.offset, err
+			}
+		}
+		values = append(values, resp.ArrayValue(vals))
+		if d.updated {
+			d := d
+			ds = append(ds, &d)
+		}
+	}
+	values = append(values, resp.ArrayValue(multi[len(multi)-1]))
+	if err := c.writeAOFUnit(values, coalesceDetails(ds)); err != nil {
+		return c.repl.offset, err
+	}
+	return c.repl.offset, nil
 }
 
 func (c *Controller) followDoLeaderAuth(conn *Conn, auth string) error {
@@ -159,12 +202,14 @@ func (c *Controller) followStep(host string, port int, followc uint64) error {
 		return errNoLongerFollowing
 	}
 	c.fcup = false
+	c.fmulti = nil
 	auth := c.config.LeaderAuth
+	tlsConfig := c.replTLS
 	c.mu.Unlock()
 	addr := fmt.Sprintf("%s:%d", host, port)
 
 	// check if we are following self
-	conn, err := DialTimeout(addr, time.Second*2)
+	conn, err := DialTLSTimeout(addr, time.Second*2, tlsConfig)
 	if err != nil {
 		return fmt.Errorf("cannot follow: %v", err)
 	}
@@ -189,32 +234,65 @@ func (c *Controller) followStep(host string, port int, followc uint64) error {
 		return fmt.Errorf("cannot follow a follower")
 	}
 
-	// verify checksum
-	pos, err := c.followCheckSome(addr, followc)
+	aofSize, err := strconv.ParseInt(m["aof_size"], 10, 64)
 	if err != nil {
 		return err
 	}
+	leaderOffset, _ := strconv.ParseInt(m["repl_offset"], 10, 64)
 
-	v, err := conn.Do("aof", pos)
-	if err != nil {
-		return err
-	}
-	if v.Error() != nil {
-		return v.Error()
-	}
-	if v.String() != "OK" {
-		return errors.New("invalid response to aof live request")
-	}
-	if core.ShowDebugMessages {
-		log.Debug("follow:", addr, ":read aof")
+	// try to continue from the replication backlog of the leader
+	c.mu.Lock()
+	replid, offset := c.repl.id, c.repl.offset
+	c.fleader = int(leaderOffset)
+	c.mu.Unlock()
+	var partial bool
+	if m["repl_id"] != "" {
+		v, err := conn.Do("psync", replid, offset)
+		if err != nil {
+			return err
+		}
+		if v.Error() == nil {
+			if v.String() != "CONTINUE" {
+				return errors.New("invalid response to psync request")
+			}
+			partial = true
+		}
 	}
+	if partial {
+		log.Infof("partial resync at offset %d", offset)
+	} else {
+		// verify checksum
+		pos, err := c.followCheckSome(addr, followc)
+		if err != nil {
+			return err
+		}
 
-	aofSize, err := strconv.ParseInt(m["aof_size"], 10, 64)
-	if err != nil {
-		return err
+		v, err := conn.Do("aof", pos)
+		if err != nil {
+			return err
+		}
+		if v.Error() != nil {
+			return v.Error()
+		}
+		if v.String() != "OK" {
+			return errors.New("invalid response to aof live request")
+		}
+		if core.ShowDebugMessages {
+			log.Debug("follow:", addr, ":read aof")
+		}
+
+		// the aof position maps to an offset in the stream of the leader
+		offset = int(pos + leaderOffset - aofSize)
+		c.mu.Lock()
+		if c.followc != followc {
+			c.mu.Unlock()
+			return errNoLongerFollowing
+		}
+		c.replReset(m["repl_id"], offset)
+		c.mu.Unlock()
 	}
 
-	caughtUp := pos >= aofSize
+	caughtUp := int64(offset) >= leaderOffset
 	if caughtUp {
 		c.mu.Lock()
 		c.fcup = true
@@ -233,12 +311,12 @@ func (c *Controller) followStep(host string, port int, followc uint64) error {
 			return errors.New("invalid multibulk")
 		}
 
-		aofsz, err := c.followHandleCommand(vals, followc, nullw)
+		offset, err := c.followHandleCommand(vals, followc, nullw)
 		if err != nil {
 			return err
 		}
 		if !caughtUp {
-			if aofsz >= int(aofSize) {
+			if int64(offset) >= leaderOffset {
 				caughtUp = true
 				c.mu.Lock()
 				c.fcup = true
@@ -0,0 +1,476 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/controller/collection"
+	"github.com/tidwall/tile38/controller/server"
+	"github.com/tidwall/tile38/geojson"
+)
+
+var errHistoryNotEnabled = errors.New("history is not enabled for key")
+
+// historyT is the position history of the objects of a collection. The
+// leader records the changes of the objects and writes them to the aof as
+// HISTADD commands, so the history keeps the original times when the aof is
+// loaded and on the followers.
+type historyT struct {
+	maxLen  int           // positions kept per object, 0 is unlimited
+	maxAge  time.Duration // positions older than this are dropped, 0 is unlimited
+	objects map[string][]historyEntry
+}
+
+// historyEntry is a position of an object. A nil object is a deleted object.
+type historyEntry struct {
+	time time.Time
+	obj  geojson.Object
+}
+
+// add inserts a position into the history of an object, keeping the
+// positions sorted by time. A position at the same time as an existing one
+// replaces it, so that a replayed HISTADD doesn't add it twice. Without a
+// max age nothing would ever remove a deleted object, so its history is
+// removed at the deletion.
+func (h *historyT) add(id string, t time.Time, obj geojson.Object) {
+	entries := h.objects[id]
+	if obj == nil && h.maxAge == 0 &&
+		(len(entries) == 0 || !entries[len(entries)-1].time.After(t)) {
+		delete(h.objects, id)
+		return
+	}
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].time.After(t)
+	})
+	if i > 0 && entries[i-1].time.Equal(t) {
+		entries[i-1].obj = obj
+		return
+	}
+	entries = append(entries, historyEntry{})
+	copy(entries[i+1:], entries[i:])
+	entries[i] = historyEntry{time: t, obj: obj}
+	if entries = h.prune(entries, time.Now()); len(entries) == 0 {
+		delete(h.objects, id)
+	} else {
+		h.objects[id] = entries
+	}
+}
+
+// sweep removes the positions that are past the limits of the history, and
+// the objects that have no positions left. The readers skip the positions
+// that are too old, so a sweep only frees the memory.
+func (h *historyT) sweep(now time.Time) {
+	for id, entries := range h.objects {
+		if entries = h.prune(entries, now); len(entries) == 0 {
+			delete(h.objects, id)
+		} else {
+			h.objects[id] = entries
+		}
+	}
+}
+
+// prune returns the positions that are within the limits of the history.
+func (h *historyT) prune(entries []historyEntry, now time.Time) []historyEntry {
+	if h.maxLen > 0 && len(entries) > h.maxLen {
+		entries = entries[len(entries)-h.maxLen:]
+	}
+	if h.maxAge > 0 {
+		for len(entries) > 0 && now.Sub(entries[0].time) > h.maxAge {
+			entries = entries[1:]
+		}
+	}
+	return entries
+}
+
+// at returns the object as it was at a time, or nil if it didn't exist or
+// its positions of that time are too old.
+func (h *historyT) at(id string, t, now time.Time) geojson.Object {
+	entries := h.prune(h.objects[id], now)
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].time.After(t)
+	})
+	if i == 0 {
+		return nil
+	}
+	return entries[i-1].obj
+}
+
+// parseHistoryTime parses a time, which is unix time in seconds or RFC3339.
+func parseHistoryTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		sec := int64(secs)
+		return time.Unix(sec, int64((secs-float64(sec))*float64(time.Second))), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, errInvalidArgument(s)
+	}
+	return t, nil
+}
+
+func (c *Controller) cmdSetHistory(msg *server.Message) (res string, d commandDetailsT, err error) {
+	start := time.Now()
+	vs := msg.Values[1:]
+	var ok bool
+	var key string
+	if vs, key, ok = tokenval(vs); !ok || key == "" {
+		return "", d, errInvalidNumberOfArguments
+	}
+	var maxLen int
+	var maxAge time.Duration
+	for len(vs) > 0 {
+		var opt, sval string
+		if vs, opt, ok = tokenval(vs); !ok || opt == "" {
+			return "", d, errInvalidNumberOfArguments
+		}
+		if vs, sval, ok = tokenval(vs); !ok || sval == "" {
+			return "", d, errInvalidNumberOfArguments
+		}
+		switch strings.ToLower(opt) {
+		default:
+			return "", d, errInvalidArgument(opt)
+		case "len":
+			if maxLen != 0 {
+				return "", d, errDuplicateArgument(strings.ToUpper(opt))
+			}
+			n, err := strconv.ParseUint(sval, 10, 32)
+			if err != nil || n == 0 {
+				return "", d, errInvalidArgument(sval)
+			}
+			maxLen = int(n)
+		case "duration":
+			if maxAge != 0 {
+				return "", d, errDuplicateArgument(strings.ToUpper(opt))
+			}
+			seconds, _ := strconv.ParseFloat(sval, 64)
+			if seconds <= 0 {
+				return "", d, errInvalidArgument(sval)
+			}
+			maxAge = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	if maxLen == 0 && maxAge == 0 {
+		// an unlimited history would grow forever
+		return "", d, errors.New("LEN or DURATION is required")
+	}
+	h, ok := c.histories[key]
+	if !ok {
+		h = &historyT{objects: make(map[string][]historyEntry)}
+		c.histories[key] = h
+		d.updated = true
+	}
+	if h.maxLen != maxLen || h.maxAge != maxAge {
+		h.maxLen, h.maxAge = maxLen, maxAge
+		h.sweep(time.Now())
+		d.updated = true
+	}
+	d.timestamp = time.Now()
+	switch msg.OutputType {
+	case server.JSON:
+		return server.OKMessage(msg, start), d, nil
+	case server.RESP:
+		if d.updated {
+			return ":1\r\n", d, nil
+		}
+		return ":0\r\n", d, nil
+	}
+	return
+}
+
+func (c *Controller) cmdDelHistory(msg *server.Message) (res string, d commandDetailsT, err error) {
+	start := time.Now()
+	vs := msg.Values[1:]
+	var ok bool
+	var key string
+	if vs, key, ok = tokenval(vs); !ok || key == "" {
+		return "", d, errInvalidNumberOfArguments
+	}
+	if len(vs) != 0 {
+		return "", d, errInvalidNumberOfArguments
+	}
+	if _, ok := c.histories[key]; ok {
+		delete(c.histories, key)
+		d.updated = true
+	}
+	d.timestamp = time.Now()
+	switch msg.OutputType {
+	case server.JSON:
+		return server.OKMessage(msg, start), d, nil
+	case server.RESP:
+		if d.updated {
+			return ":1\r\n", d, nil
+		}
+		return ":0\r\n", d, nil
+	}
+	return
+}
+
+// cmdHistAdd adds a position to the history of an object.
+// HISTADD key id time (DELETED | OBJECT geojson | POINT lat lon [z] | ...)
+func (c *Controller) cmdHistAdd(msg *server.Message) (res string, d commandDetailsT, err error) {
+	start := time.Now()
+	vs := msg.Values[1:]
+	if len(vs) < 4 {
+		return "", d, errInvalidNumberOfArguments
+	}
+	key, id := vs[0].String(), vs[1].String()
+	t, err := parseHistoryTime(vs[2].String())
+	if err != nil {
+		return "", d, err
+	}
+	var obj geojson.Object
+	if strings.ToLower(vs[3].String()) == "deleted" {
+		if len(vs) != 4 {
+			return "", d, errInvalidNumberOfArguments
+		}
+	} else {
+		// the object has the same arguments as SET
+		svs := append([]resp.Value{vs[0], vs[1]}, vs[3:]...)
+		var sd commandDetailsT
+		if sd, _, _, _, _, _, _, _, err = c.parseSetArgs(svs); err != nil {
+			return "", d, err
+		}
+		obj = sd.obj
+	}
+	h, ok := c.histories[key]
+	if !ok {
+		return "", d, errHistoryNotEnabled
+	}
+	h.add(id, t, obj)
+	d.updated = true
+	d.timestamp = time.Now()
+	switch msg.OutputType {
+	case server.JSON:
+		return server.OKMessage(msg, start), d, nil
+	case server.RESP:
+		return "+OK\r\n", d, nil
+	}
+	return
+}
+
+// cmdHistory returns the positions of an object.
+// HISTORY key id [START time] [END time]
+func (c *Controller) cmdHistory(msg *server.Message) (res string, err error) {
+	start := time.Now()
+	vs := msg.Values[1:]
+	var ok bool
+	var key, id string
+	if vs, key, ok = tokenval(vs); !ok || key == "" {
+		return "", errInvalidNumberOfArguments
+	}
+	if vs, id, ok = tokenval(vs); !ok || id == "" {
+		return "", errInvalidNumberOfArguments
+	}
+	var from, to time.Time
+	for len(vs) > 0 {
+		var opt, sval string
+		if vs, opt, ok = tokenval(vs); !ok || opt == "" {
+			return "", errInvalidNumberOfArguments
+		}
+		if vs, sval, ok = tokenval(vs); !ok || sval == "" {
+			return "", errInvalidNumberOfArguments
+		}
+		var t *time.Time
+		switch strings.ToLower(opt) {
+		default:
+			return "", errInvalidArgument(opt)
+		case "start":
+			t = &from
+		case "end":
+			t = &to
+		}
+		if !t.IsZero() {
+			return "", errDuplicateArgument(strings.ToUpper(opt))
+		}
+		if *t, err = parseHistoryTime(sval); err != nil {
+			return "", err
+		}
+	}
+	h, ok := c.histories[key]
+	if !ok {
+		return "", errHistoryNotEnabled
+	}
+	var entries []historyEntry
+	for _, entry := range h.prune(h.objects[id], time.Now()) {
+		if (from.IsZero() || !entry.time.Before(from)) &&
+			(to.IsZero() || !entry.time.After(to)) {
+			entries = append(entries, entry)
+		}
+	}
+	switch msg.OutputType {
+	case server.JSON:
+		var buf bytes.Buffer
+		buf.WriteString(`{"ok":true,"history":[`)
+		for i, entry := range entries {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(`{"time":` + jsonTimeFormat(entry.time) + `,"object":`)
+			if entry.obj == nil {
+				buf.WriteString("null")
+			} else {
+				buf.WriteString(entry.obj.JSON())
+			}
+			buf.WriteByte('}')
+		}
+		buf.WriteString(`],"elapsed":"` + time.Now().Sub(start).String() + "\"}")
+		return buf.String(), nil
+	case server.RESP:
+		vals := make([]resp.Value, len(entries))
+		for i, entry := range entries {
+			obj := resp.NullValue()
+			if entry.obj != nil {
+				obj = resp.StringValue(entry.obj.String())
+			}
+			vals[i] = resp.ArrayValue([]resp.Value{
+				resp.StringValue(entry.time.UTC().Format(time.RFC3339Nano)),
+				obj,
+			})
+		}
+		data, err := resp.ArrayValue(vals).MarshalRESP()
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	return "", nil
+}
+
+// histAddValues returns the HISTADD command of a position.
+func histAddValues(key, id string, t time.Time, obj geojson.Object) []string {
+	values := []string{"histadd", key, id, t.UTC().Format(time.RFC3339Nano)}
+	switch {
+	case obj == nil:
+		values = append(values, "deleted")
+	case obj.IsGeometry():
+		values = append(values, "object", obj.JSON())
+	default:
+		values = append(values, "string", obj.String())
+	}
+	return values
+}
+
+// recordHistory adds the changed objects of the collections that have a
+// history, and returns the HISTADD commands that go to the aof. It's only
+// called by the leader.
+func (c *Controller) recordHistory(leaves []*commandDetailsT) []resp.Value {
+	if len(c.histories) == 0 {
+		return nil
+	}
+	var values []resp.Value
+	for _, d := range leaves {
+		var obj geojson.Object
+		switch d.command {
+		default:
+			continue
+		case "set":
+			obj = d.obj
+		case "del":
+		}
+		h, ok := c.histories[d.key]
+		if !ok {
+			continue
+		}
+		h.add(d.id, d.timestamp, obj)
+		var vals []resp.Value
+		for _, s := range histAddValues(d.key, d.id, d.timestamp, obj) {
+			vals = append(vals, resp.StringValue(s))
+		}
+		values = append(values, resp.ArrayValue(vals))
+	}
+	return values
+}
+
+// clearHistory forgets the positions of a dropped collection. The history
+// stays enabled.
+func (c *Controller) clearHistory(key string) {
+	if h, ok := c.histories[key]; ok {
+		h.objects = make(map[string][]historyEntry)
+	}
+}
+
+// historyCommands returns the commands that recreate the history of a key,
+// for the aof shrink and the snapshots.
+func (c *Controller) historyCommands(key string) [][]string {
+	h, ok := c.histories[key]
+	if !ok {
+		return nil
+	}
+	values := []string{"sethistory", key}
+	if h.maxLen > 0 {
+		values = append(values, "len", strconv.Itoa(h.maxLen))
+	}
+	if h.maxAge > 0 {
+		values = append(values, "duration", strconv.FormatFloat(h.maxAge.Seconds(), 'f', -1, 64))
+	}
+	cmds := [][]string{values}
+	ids := make([]string, 0, len(h.objects))
+	for id := range h.objects {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	now := time.Now()
+	for _, id := range ids {
+		for _, entry := range h.prune(h.objects[id], now) {
+			cmds = append(cmds, histAddValues(key, id, entry.time, entry.obj))
+		}
+	}
+	return cmds
+}
+
+// sweepHistories frees the positions of the histories that are older than
+// their max age. The caller must hold the write lock.
+func (c *Controller) sweepHistories(now time.Time) {
+	for _, h := range c.histories {
+		if h.maxAge > 0 {
+			h.sweep(now)
+		}
+	}
+}
+
+// historyKeys returns the sorted keys that have a history.
+func (c *Controller) historyKeys() []string {
+	keys := make([]string, 0, len(c.histories))
+	for key := range c.histories {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// historyCol returns a collection with the objects of a key as they were at
+// a time. When the area of the search is not nil, only the objects that may
+// be in the area are added, which keeps the collection as small as the
+// results.
+func (c *Controller) historyCol(key string, t time.Time, area *geojson.BBox) (*collection.Collection, error) {
+	h, ok := c.histories[key]
+	if !ok {
+		return nil, errHistoryNotEnabled
+	}
+	if area != nil && (area.Min.X < -180 || area.Max.X > 180) {
+		// the area crosses the antimeridian
+		area = nil
+	}
+	col := collection.New()
+	now := time.Now()
+	for id := range h.objects {
+		obj := h.at(id, t, now)
+		if obj == nil {
+			continue
+		}
+		if area != nil && obj.IsGeometry() {
+			bbox := obj.CalculatedBBox()
+			if bbox.Min.X > area.Max.X || bbox.Max.X < area.Min.X ||
+				bbox.Min.Y > area.Max.Y || bbox.Max.Y < area.Min.Y {
+				continue
+			}
+		}
+		col.ReplaceOrInsert(id, obj, nil, nil)
+	}
+	return col, nil
+}
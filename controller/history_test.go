@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tidwall/tile38/geojson"
+)
+
+func TestHistory(t *testing.T) {
+	h := &historyT{maxLen: 3, objects: make(map[string][]historyEntry)}
+	now := time.Now()
+	point := func(x float64) geojson.Object {
+		return geojson.SimplePoint{X: x, Y: 33}
+	}
+	// out of order, and a replayed position
+	for _, i := range []int{2, 1, 4, 3, 2} {
+		h.add("truck1", now.Add(time.Duration(i)*time.Minute), point(float64(i)))
+	}
+	if n := len(h.objects["truck1"]); n != 3 {
+		t.Fatalf("expected 3 positions, got %d", n)
+	}
+	if obj := h.at("truck1", now.Add(time.Second*90), now); obj != nil {
+		t.Fatalf("expected a pruned position, got %v", obj)
+	}
+	if obj := h.at("truck1", now.Add(time.Minute*3), now); obj == nil || obj.CalculatedPoint().X != 3 {
+		t.Fatalf("expected the position at 3 minutes, got %v", obj)
+	}
+	// without a max age, a deleted object has no history
+	h.add("truck1", now.Add(time.Minute*5), nil)
+	if _, ok := h.objects["truck1"]; ok {
+		t.Fatal("expected the history of the deleted object to be removed")
+	}
+
+	// with a max age, a deleted object is kept until its positions are too
+	// old, which the readers skip before they are swept
+	h.maxLen, h.maxAge = 3, time.Minute*10
+	h.add("truck2", now.Add(-time.Minute*8), point(1))
+	h.add("truck2", now.Add(-time.Minute*7), nil)
+	if obj := h.at("truck2", now.Add(-time.Minute*8), now); obj == nil {
+		t.Fatal("expected the position before the deletion")
+	}
+	later := now.Add(time.Minute * 5)
+	if obj := h.at("truck2", now.Add(-time.Minute*8), later); obj != nil {
+		t.Fatalf("expected a position that is too old, got %v", obj)
+	}
+	if n := len(h.objects["truck2"]); n != 2 {
+		t.Fatalf("expected 2 positions before the sweep, got %d", n)
+	}
+	h.sweep(later)
+	if _, ok := h.objects["truck2"]; ok {
+		t.Fatal("expected the swept object to be removed")
+	}
+
+	h.maxLen, h.maxAge = 0, time.Minute*10
+	entries := []historyEntry{
+		{time: now.Add(-time.Hour)},
+		{time: now.Add(-time.Minute)},
+	}
+	if pruned := h.prune(entries, now); len(pruned) != 1 || len(entries) != 2 {
+		t.Fatalf("expected 1 of 2 positions, got %d of %d", len(pruned), len(entries))
+	}
+}
+
+func TestParseHistoryTime(t *testing.T) {
+	for _, s := range []string{"1500.5", "1970-01-01T00:25:00.5Z"} {
+		tm, err := parseHistoryTime(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tm.UnixNano() != 1500500000000 {
+			t.Fatalf("%s: expected 1500.5, got %v", s, tm)
+		}
+	}
+	if _, err := parseHistoryTime("yesterday"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
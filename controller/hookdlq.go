@@ -0,0 +1,225 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/controller/glob"
+	"github.com/tidwall/tile38/controller/server"
+)
+
+// hookStats are the delivery stats of a hook. They have their own lock
+// because the hook lock is held while sending messages.
+type hookStats struct {
+	mu            sync.Mutex
+	numDelivered  uint64 // messages sent
+	numFailed     uint64 // failed send attempts
+	numDead       uint64 // messages dead-lettered
+	attempts      int    // failed attempts for the head of the queue
+	queued        int    // messages in the queue, including the ones being sent
+	lastError     string
+	lastErrorTime time.Time
+}
+
+func (s *hookStats) sent() {
+	s.mu.Lock()
+	s.numDelivered++
+	s.attempts = 0
+	s.mu.Unlock()
+}
+
+func (s *hookStats) failed(err error, attempts int) {
+	s.mu.Lock()
+	s.numFailed++
+	s.attempts = attempts
+	s.lastError = err.Error()
+	s.lastErrorTime = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *hookStats) deadLettered() {
+	s.mu.Lock()
+	s.numDead++
+	s.attempts = 0
+	s.mu.Unlock()
+}
+
+// queue adds to the number of queued messages. It's called within the queue
+// transactions, which keeps it in step with the queue.
+func (s *hookStats) queue(n int) {
+	s.mu.Lock()
+	s.queued += n
+	s.mu.Unlock()
+}
+
+// setQueued sets the number of queued messages after the queue is read.
+func (s *hookStats) setQueued(n int) {
+	s.mu.Lock()
+	s.queued = n
+	s.mu.Unlock()
+}
+
+func (s *hookStats) getQueued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queued
+}
+
+func (s *hookStats) get() (delivered, failed, dead uint64, attempts int, lastError string, lastErrorTime time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.numDelivered, s.numFailed, s.numDead, s.attempts, s.lastError, s.lastErrorTime
+}
+
+// sortedHooks returns all hooks sorted by name.
+func (c *Controller) sortedHooks() []*Hook {
+	var hooks []*Hook
+	for _, hook := range c.hooks {
+		hooks = append(hooks, hook)
+	}
+	sort.Sort(hooksByName(hooks))
+	return hooks
+}
+
+func (c *Controller) writeInfoHooks(w *bytes.Buffer) {
+	for _, hook := range c.sortedHooks() {
+		if hook.channel {
+			continue
+		}
+		delivered, failed, dead, attempts, lastError, lastErrorTime := hook.stats.get()
+		fmt.Fprintf(w, "hook_%s:delivered=%d,failed=%d,dead_lettered=%d,attempts=%d",
+			hook.Name, delivered, failed, dead, attempts)
+		if lastError != "" {
+			fmt.Fprintf(w, ",last_error_time=%d", lastErrorTime.Unix())
+		}
+		w.WriteString("\r\n")
+	}
+}
+
+// hookTotals returns the delivery stats for all hooks.
+func (c *Controller) hookTotals() (delivered, failed, dead uint64) {
+	for _, hook := range c.hooks {
+		hdelivered, hfailed, hdead, _, _, _ := hook.stats.get()
+		delivered += hdelivered
+		failed += hfailed
+		dead += hdead
+	}
+	return
+}
+
+// scanHookDLQ iterates over the dead letters of the hooks that match a
+// pattern.
+func (c *Controller) scanHookDLQ(tx *buntdb.Tx, pattern string, iter func(key, val, name string) bool) error {
+	return tx.Ascend("dlq", func(key, val string) bool {
+		if !strings.HasPrefix(key, hookDLQPrefix) {
+			return true
+		}
+		name := gjson.Get(val, "hook").String()
+		if match, _ := glob.Match(pattern, name); !match {
+			return true
+		}
+		return iter(key, val, name)
+	})
+}
+
+// cmdHooksDLQ lists the dead letters of the hooks that match a pattern.
+func (c *Controller) cmdHooksDLQ(msg *server.Message, pattern string) (res string, err error) {
+	start := time.Now()
+	var vals []string
+	err = c.qdb.View(func(tx *buntdb.Tx) error {
+		return c.scanHookDLQ(tx, pattern, func(key, val, name string) bool {
+			vals = append(vals, val)
+			return true
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	switch msg.OutputType {
+	case server.JSON:
+		buf := &bytes.Buffer{}
+		buf.WriteString(`{"ok":true,"dlq":[`)
+		for i, val := range vals {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(val)
+		}
+		buf.WriteString(`],"elapsed":"` + time.Now().Sub(start).String() + "\"}")
+		return buf.String(), nil
+	case server.RESP:
+		var rvals []resp.Value
+		for _, val := range vals {
+			rvals = append(rvals, resp.ArrayValue([]resp.Value{
+				resp.StringValue(gjson.Get(val, "hook").String()),
+				resp.IntegerValue(int(gjson.Get(val, "attempts").Int())),
+				resp.StringValue(gjson.Get(val, "error").String()),
+				resp.StringValue(gjson.Get(val, "time").String()),
+				resp.StringValue(gjson.Get(val, "message").Raw),
+			}))
+		}
+		data, err := resp.ArrayValue(rvals).MarshalRESP()
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	return "", nil
+}
+
+// cmdHooksReplay moves the dead letters of the hooks that match a pattern
+// back into the queue. Dead letters of hooks that no longer exist remain.
+func (c *Controller) cmdHooksReplay(msg *server.Message, pattern string) (res string, err error) {
+	start := time.Now()
+	replayed := make(map[*Hook]bool)
+	var count int
+	err = c.qdb.Update(func(tx *buntdb.Tx) error {
+		var keys, vals []string
+		var hooks []*Hook
+		err := c.scanHookDLQ(tx, pattern, func(key, val, name string) bool {
+			if hook, ok := c.hooks[name]; ok {
+				keys = append(keys, key)
+				vals = append(vals, gjson.Get(val, "message").Raw)
+				hooks = append(hooks, hook)
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		for i, key := range keys {
+			if _, err := tx.Delete(key); err != nil {
+				return err
+			}
+			key = hookLogPrefix + key[len(hookDLQPrefix):]
+			if _, _, err := tx.Set(key, vals[i], hooks[i].logSetOptions()); err != nil {
+				return err
+			}
+			hooks[i].stats.queue(1)
+			replayed[hooks[i]] = true
+		}
+		count = len(keys)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	for hook := range replayed {
+		hook.Signal()
+	}
+	switch msg.OutputType {
+	case server.JSON:
+		return `{"ok":true,"replayed":` + strconv.Itoa(count) + `,"elapsed":"` + time.Now().Sub(start).String() + "\"}", nil
+	case server.RESP:
+		return ":" + strconv.Itoa(count) + "\r\n", nil
+	}
+	return "", nil
+}
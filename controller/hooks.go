@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"reflect"
 	"sort"
 	"strconv"
 	"strings"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/tidwall/buntdb"
+	"github.com/tidwall/gjson"
 	"github.com/tidwall/resp"
 	"github.com/tidwall/tile38/controller/endpoint"
 	"github.com/tidwall/tile38/controller/glob"
@@ -18,17 +20,13 @@ import (
 	"github.com/tidwall/tile38/controller/server"
 )
 
-const hookLogTTL = time.Second * 30
-
-func hookLogSetDefaults() *buntdb.SetOptions {
-	if hookLogTTL > 0 {
-		return &buntdb.SetOptions{
-			Expires: true, // automatically delete after 30 seconds
-			TTL:     hookLogTTL,
-		}
-	}
-	return nil
-}
+const (
+	hookDefaultMaxAge  = time.Second * 30 // messages older than this are dead-lettered
+	hookDefaultBackoff = time.Second / 4  // delay before the first retry
+	hookMaxBackoff     = time.Minute      // longest delay between retries
+	hookLogGrace       = time.Second * 30 // queued messages outlive their max age by this much
+	hookDLQTTL         = time.Hour * 24   // dead letters are deleted after a day
+)
 
 type hooksByName []*Hook
 
@@ -44,7 +42,30 @@ func (a hooksByName) Swap(i, j int) {
 	a[i], a[j] = a[j], a[i]
 }
 
-func (c *Controller) cmdSetHook(msg *server.Message) (res string, d commandDetailsT, err error) {
+// hookOptionLen returns the number of arguments of the hook option at the
+// start of vs, including the option, or zero when vs doesn't start with an
+// option.
+func hookOptionLen(vs []resp.Value) int {
+	if len(vs) == 0 {
+		return 0
+	}
+	switch strings.ToLower(vs[0].String()) {
+	case "meta", "header":
+		return 3
+	case "retries", "backoff", "maxage", "secret", "timeout", "non2xx":
+		return 2
+	case "auth":
+		if len(vs) > 1 && strings.ToLower(vs[1].String()) == "basic" {
+			return 4
+		}
+		return 3
+	}
+	return 0
+}
+
+// cmdSetHook creates a hook, or a channel when chanCmd is true. A channel is
+// a hook without endpoints, which publishes to its subscribers instead.
+func (c *Controller) cmdSetHook(msg *server.Message, chanCmd bool) (res string, d commandDetailsT, err error) {
 	start := time.Now()
 
 	vs := msg.Values[1:]
@@ -53,22 +74,29 @@ func (c *Controller) cmdSetHook(msg *server.Message) (res string, d commandDetai
 	if vs, name, ok = tokenval(vs); !ok || name == "" {
 		return "", d, errInvalidNumberOfArguments
 	}
-	if vs, urls, ok = tokenval(vs); !ok || urls == "" {
-		return "", d, errInvalidNumberOfArguments
-	}
 	var endpoints []string
-	for _, url := range strings.Split(urls, ",") {
-		url = strings.TrimSpace(url)
-		err := c.epc.Validate(url)
-		if err != nil {
-			log.Errorf("sethook: %v", err)
-			return "", d, errInvalidArgument(url)
+	if !chanCmd {
+		if vs, urls, ok = tokenval(vs); !ok || urls == "" {
+			return "", d, errInvalidNumberOfArguments
+		}
+		for _, url := range strings.Split(urls, ",") {
+			url = strings.TrimSpace(url)
+			err := c.epc.Validate(url)
+			if err != nil {
+				log.Errorf("sethook: %v", err)
+				return "", d, errInvalidArgument(url)
+			}
+			endpoints = append(endpoints, url)
 		}
-		endpoints = append(endpoints, url)
 	}
 	var commandvs []resp.Value
 	var cmdlc string
 	var types []string
+	var maxAttempts int
+	var backoff, maxAge time.Duration
+	var httpOpts endpoint.HTTPOptions
+	var non2xx string
+	var httpOpt string // the first option for the http endpoints
 	metaMap := make(map[string]string)
 	for {
 		commandvs = vs
@@ -90,6 +118,147 @@ func (c *Controller) cmdSetHook(msg *server.Message) (res string, d commandDetai
 			}
 			metaMap[metakey] = metaval
 			continue
+		case "retries":
+			if chanCmd {
+				return "", d, errInvalidArgument(cmd)
+			}
+			var sretries string
+			if vs, sretries, ok = tokenval(vs); !ok || sretries == "" {
+				return "", d, errInvalidNumberOfArguments
+			}
+			if maxAttempts != 0 {
+				return "", d, errDuplicateArgument(strings.ToUpper(cmd))
+			}
+			n, err := strconv.ParseUint(sretries, 10, 32)
+			if err != nil || n == 0 {
+				return "", d, errInvalidArgument(sretries)
+			}
+			maxAttempts = int(n)
+			continue
+		case "backoff", "maxage":
+			if chanCmd {
+				return "", d, errInvalidArgument(cmd)
+			}
+			var sseconds string
+			if vs, sseconds, ok = tokenval(vs); !ok || sseconds == "" {
+				return "", d, errInvalidNumberOfArguments
+			}
+			dur := &backoff
+			if cmdlc == "maxage" {
+				dur = &maxAge
+			}
+			if *dur != 0 {
+				return "", d, errDuplicateArgument(strings.ToUpper(cmd))
+			}
+			seconds, _ := strconv.ParseFloat(sseconds, 64)
+			if seconds <= 0 {
+				return "", d, errInvalidArgument(sseconds)
+			}
+			*dur = time.Duration(seconds * float64(time.Second))
+			continue
+		case "header":
+			if chanCmd {
+				return "", d, errInvalidArgument(cmd)
+			}
+			if httpOpt == "" {
+				httpOpt = cmd
+			}
+			var hname, hval string
+			if vs, hname, ok = tokenval(vs); !ok || hname == "" {
+				return "", d, errInvalidNumberOfArguments
+			}
+			if vs, hval, ok = tokenval(vs); !ok {
+				return "", d, errInvalidNumberOfArguments
+			}
+			httpOpts.Headers = append(httpOpts.Headers, [2]string{hname, hval})
+			continue
+		case "auth":
+			if chanCmd {
+				return "", d, errInvalidArgument(cmd)
+			}
+			if httpOpt == "" {
+				httpOpt = cmd
+			}
+			var scheme string
+			if vs, scheme, ok = tokenval(vs); !ok || scheme == "" {
+				return "", d, errInvalidNumberOfArguments
+			}
+			if httpOpts.Bearer != "" || httpOpts.BasicUser != "" {
+				return "", d, errDuplicateArgument(strings.ToUpper(cmd))
+			}
+			switch strings.ToLower(scheme) {
+			default:
+				return "", d, errInvalidArgument(scheme)
+			case "bearer":
+				if vs, httpOpts.Bearer, ok = tokenval(vs); !ok || httpOpts.Bearer == "" {
+					return "", d, errInvalidNumberOfArguments
+				}
+			case "basic":
+				if vs, httpOpts.BasicUser, ok = tokenval(vs); !ok || httpOpts.BasicUser == "" {
+					return "", d, errInvalidNumberOfArguments
+				}
+				if vs, httpOpts.BasicPass, ok = tokenval(vs); !ok {
+					return "", d, errInvalidNumberOfArguments
+				}
+			}
+			continue
+		case "secret":
+			if chanCmd {
+				return "", d, errInvalidArgument(cmd)
+			}
+			if httpOpt == "" {
+				httpOpt = cmd
+			}
+			var secret string
+			if vs, secret, ok = tokenval(vs); !ok || secret == "" {
+				return "", d, errInvalidNumberOfArguments
+			}
+			if httpOpts.Secret != "" {
+				return "", d, errDuplicateArgument(strings.ToUpper(cmd))
+			}
+			httpOpts.Secret = secret
+			continue
+		case "timeout":
+			if chanCmd {
+				return "", d, errInvalidArgument(cmd)
+			}
+			if httpOpt == "" {
+				httpOpt = cmd
+			}
+			var sseconds string
+			if vs, sseconds, ok = tokenval(vs); !ok || sseconds == "" {
+				return "", d, errInvalidNumberOfArguments
+			}
+			if httpOpts.Timeout != 0 {
+				return "", d, errDuplicateArgument(strings.ToUpper(cmd))
+			}
+			seconds, _ := strconv.ParseFloat(sseconds, 64)
+			if seconds <= 0 {
+				return "", d, errInvalidArgument(sseconds)
+			}
+			httpOpts.Timeout = time.Duration(seconds * float64(time.Second))
+			continue
+		case "non2xx":
+			if chanCmd {
+				return "", d, errInvalidArgument(cmd)
+			}
+			if httpOpt == "" {
+				httpOpt = cmd
+			}
+			var policy string
+			if vs, policy, ok = tokenval(vs); !ok || policy == "" {
+				return "", d, errInvalidNumberOfArguments
+			}
+			if non2xx != "" {
+				return "", d, errDuplicateArgument(strings.ToUpper(cmd))
+			}
+			non2xx = strings.ToLower(policy)
+			switch non2xx {
+			case "retry", "ignore", "deadletter":
+			default:
+				return "", d, errInvalidArgument(policy)
+			}
+			continue
 		case "nearby":
 			types = nearbyTypes
 		case "within", "intersects":
@@ -97,6 +266,18 @@ func (c *Controller) cmdSetHook(msg *server.Message) (res string, d commandDetai
 		}
 		break
 	}
+	if httpOpt != "" {
+		// the http options are not allowed without an http endpoint
+		var http bool
+		for _, url := range endpoints {
+			if strings.HasPrefix(url, "http:") || strings.HasPrefix(url, "https:") {
+				http = true
+			}
+		}
+		if !http {
+			return "", d, errInvalidArgument(httpOpt)
+		}
+	}
 	s, err := c.cmdSearchArgs(cmdlc, vs, types)
 	if err != nil {
 		return "", d, err
@@ -121,19 +302,30 @@ func (c *Controller) cmdSetHook(msg *server.Message) (res string, d commandDetai
 	sort.Sort(hookMetaByName(metas))
 
 	hook := &Hook{
-		Key:       s.key,
-		Name:      name,
-		Endpoints: endpoints,
-		Fence:     &s,
-		Message:   cmsg,
-		db:        c.qdb,
-		epm:       c.epc,
-		Metas:     metas,
+		Key:         s.key,
+		Name:        name,
+		Endpoints:   endpoints,
+		Fence:       &s,
+		Message:     cmsg,
+		db:          c.qdb,
+		epm:         c.epc,
+		Metas:       metas,
+		MaxAttempts: maxAttempts,
+		Backoff:     backoff,
+		MaxAge:      maxAge,
+		Non2xx:      non2xx,
+		channel:     chanCmd,
+	}
+	if httpOpts.Headers != nil || httpOpts.Bearer != "" || httpOpts.BasicUser != "" ||
+		httpOpts.Secret != "" || httpOpts.Timeout != 0 {
+		hook.HTTP = &httpOpts
 	}
 	hook.cond = sync.NewCond(&hook.mu)
 
+	// The scan writer keeps the compiled WHEREEXPR expressions for the
+	// lifetime of the hook, so fence events never parse them again.
 	var wr bytes.Buffer
-	hook.ScanWriter, err = c.newScanWriter(&wr, cmsg, s.key, s.output, s.precision, s.glob, false, s.cursor, s.limit, s.wheres, s.whereins, s.nofields)
+	hook.ScanWriter, err = c.newScanWriter(&wr, cmsg, s.key, s.output, s.precision, s.glob, false, s.cursor, s.limit, s.wheres, s.whereins, s.whereexprs, s.nofields)
 	if err != nil {
 		return "", d, err
 	}
@@ -156,6 +348,7 @@ func (c *Controller) cmdSetHook(msg *server.Message) (res string, d commandDetai
 			delete(hm, h.Name)
 		}
 		delete(c.hooks, h.Name)
+		c.delDwellHook(h.Name)
 	}
 	d.updated = true
 	d.timestamp = time.Now()
@@ -166,6 +359,9 @@ func (c *Controller) cmdSetHook(msg *server.Message) (res string, d commandDetai
 		c.hookcols[hook.Key] = hm
 	}
 	hm[name] = hook
+	if hook.Fence.dwellOn() {
+		c.addDwellHook(hook)
+	}
 	hook.Open()
 	switch msg.OutputType {
 	case server.JSON:
@@ -176,7 +372,7 @@ func (c *Controller) cmdSetHook(msg *server.Message) (res string, d commandDetai
 	return "", d, nil
 }
 
-func (c *Controller) cmdDelHook(msg *server.Message) (res string, d commandDetailsT, err error) {
+func (c *Controller) cmdDelHook(msg *server.Message, chanCmd bool) (res string, d commandDetailsT, err error) {
 	start := time.Now()
 	vs := msg.Values[1:]
 
@@ -188,12 +384,13 @@ func (c *Controller) cmdDelHook(msg *server.Message) (res string, d commandDetai
 	if len(vs) != 0 {
 		return "", d, errInvalidNumberOfArguments
 	}
-	if h, ok := c.hooks[name]; ok {
+	if h, ok := c.hooks[name]; ok && h.channel == chanCmd {
 		h.Close()
 		if hm, ok := c.hookcols[h.Key]; ok {
 			delete(hm, h.Name)
 		}
 		delete(c.hooks, h.Name)
+		c.delDwellHook(h.Name)
 		d.updated = true
 	}
 	d.timestamp = time.Now()
@@ -210,7 +407,7 @@ func (c *Controller) cmdDelHook(msg *server.Message) (res string, d commandDetai
 	return
 }
 
-func (c *Controller) cmdPDelHook(msg *server.Message) (res string, d commandDetailsT, err error) {
+func (c *Controller) cmdPDelHook(msg *server.Message, chanCmd bool) (res string, d commandDetailsT, err error) {
 	start := time.Now()
 	vs := msg.Values[1:]
 
@@ -227,12 +424,13 @@ func (c *Controller) cmdPDelHook(msg *server.Message) (res string, d commandDeta
 	for name := range c.hooks {
 		match, _ := glob.Match(pattern, name)
 		if match {
-			if h, ok := c.hooks[name]; ok {
+			if h, ok := c.hooks[name]; ok && h.channel == chanCmd {
 				h.Close()
 				if hm, ok := c.hookcols[h.Key]; ok {
 					delete(hm, h.Name)
 				}
 				delete(c.hooks, h.Name)
+				c.delDwellHook(h.Name)
 				count++
 			}
 		}
@@ -248,7 +446,7 @@ func (c *Controller) cmdPDelHook(msg *server.Message) (res string, d commandDeta
 	return
 }
 
-func (c *Controller) cmdHooks(msg *server.Message) (res string, err error) {
+func (c *Controller) cmdHooks(msg *server.Message, chanCmd bool) (res string, err error) {
 	start := time.Now()
 	vs := msg.Values[1:]
 
@@ -257,12 +455,28 @@ func (c *Controller) cmdHooks(msg *server.Message) (res string, err error) {
 	if vs, pattern, ok = tokenval(vs); !ok || pattern == "" {
 		return "", errInvalidNumberOfArguments
 	}
+	if len(vs) == 1 && !chanCmd {
+		sub := strings.ToLower(pattern)
+		if vs, pattern, ok = tokenval(vs); !ok || pattern == "" {
+			return "", errInvalidNumberOfArguments
+		}
+		switch sub {
+		case "dlq":
+			return c.cmdHooksDLQ(msg, pattern)
+		case "replay":
+			return c.cmdHooksReplay(msg, pattern)
+		}
+		return "", errInvalidArgument(msg.Values[1].String())
+	}
 	if len(vs) != 0 {
 		return "", errInvalidNumberOfArguments
 	}
 
 	var hooks []*Hook
 	for name, hook := range c.hooks {
+		if hook.channel != chanCmd {
+			continue
+		}
 		match, _ := glob.Match(pattern, name)
 		if match {
 			hooks = append(hooks, hook)
@@ -273,7 +487,11 @@ func (c *Controller) cmdHooks(msg *server.Message) (res string, err error) {
 	switch msg.OutputType {
 	case server.JSON:
 		buf := &bytes.Buffer{}
-		buf.WriteString(`{"ok":true,"hooks":[`)
+		if chanCmd {
+			buf.WriteString(`{"ok":true,"chans":[`)
+		} else {
+			buf.WriteString(`{"ok":true,"hooks":[`)
+		}
 		for i, hook := range hooks {
 			if i > 0 {
 				buf.WriteByte(',')
@@ -281,14 +499,17 @@ func (c *Controller) cmdHooks(msg *server.Message) (res string, err error) {
 			buf.WriteString(`{`)
 			buf.WriteString(`"name":` + jsonString(hook.Name))
 			buf.WriteString(`,"key":` + jsonString(hook.Key))
-			buf.WriteString(`,"endpoints":[`)
-			for i, endpoint := range hook.Endpoints {
-				if i > 0 {
-					buf.WriteByte(',')
+			if !chanCmd {
+				buf.WriteString(`,"endpoints":[`)
+				for i, endpoint := range hook.Endpoints {
+					if i > 0 {
+						buf.WriteByte(',')
+					}
+					buf.WriteString(jsonString(endpoint))
 				}
-				buf.WriteString(jsonString(endpoint))
+				buf.WriteString(`]`)
 			}
-			buf.WriteString(`],"command":[`)
+			buf.WriteString(`,"command":[`)
 			for i, v := range hook.Message.Values {
 				if i > 0 {
 					buf.WriteString(`,`)
@@ -306,11 +527,13 @@ func (c *Controller) cmdHooks(msg *server.Message) (res string, err error) {
 			var hvals []resp.Value
 			hvals = append(hvals, resp.StringValue(hook.Name))
 			hvals = append(hvals, resp.StringValue(hook.Key))
-			var evals []resp.Value
-			for _, endpoint := range hook.Endpoints {
-				evals = append(evals, resp.StringValue(endpoint))
+			if !chanCmd {
+				var evals []resp.Value
+				for _, endpoint := range hook.Endpoints {
+					evals = append(evals, resp.StringValue(endpoint))
+				}
+				hvals = append(hvals, resp.ArrayValue(evals))
 			}
-			hvals = append(hvals, resp.ArrayValue(evals))
 			hvals = append(hvals, resp.ArrayValue(hook.Message.Values))
 			vals = append(vals, resp.ArrayValue(hvals))
 		}
@@ -325,27 +548,42 @@ func (c *Controller) cmdHooks(msg *server.Message) (res string, err error) {
 
 // Hook represents a hook.
 type Hook struct {
-	mu         sync.Mutex
-	cond       *sync.Cond
-	Key        string
-	Name       string
-	Endpoints  []string
-	Message    *server.Message
-	Fence      *liveFenceSwitches
-	ScanWriter *scanWriter
-	Metas      []FenceMeta
-	db         *buntdb.DB
-	closed     bool
-	opened     bool
-	query      string
-	epm        *endpoint.EndpointManager
+	mu          sync.Mutex
+	cond        *sync.Cond
+	Key         string
+	Name        string
+	Endpoints   []string
+	Message     *server.Message
+	Fence       *liveFenceSwitches
+	ScanWriter  *scanWriter
+	Metas       []FenceMeta
+	MaxAttempts int                   // send attempts before dead-lettering, 0 is unlimited
+	Backoff     time.Duration         // delay before the first retry, doubles per retry
+	MaxAge      time.Duration         // messages older than this are dead-lettered
+	HTTP        *endpoint.HTTPOptions // request options of the http endpoints
+	Non2xx      string                // retry, ignore, or deadletter non-2xx responses
+	db          *buntdb.DB
+	closed      bool
+	opened      bool
+	query       string
+	epm         *endpoint.EndpointManager
+	channel     bool      // publishes to subscribers instead of endpoints
+	attempts    int       // failed attempts for the message at the head of the queue
+	retryAt     time.Time // the head of the queue is not sent again before this time
+	stats       hookStats
 }
 
 func (h *Hook) Equals(hook *Hook) bool {
 	if h.Key != hook.Key ||
 		h.Name != hook.Name ||
+		h.channel != hook.channel ||
 		len(h.Endpoints) != len(hook.Endpoints) ||
-		len(h.Metas) != len(hook.Metas) {
+		len(h.Metas) != len(hook.Metas) ||
+		h.MaxAttempts != hook.MaxAttempts ||
+		h.Backoff != hook.Backoff ||
+		h.MaxAge != hook.MaxAge ||
+		h.Non2xx != hook.Non2xx ||
+		!reflect.DeepEqual(h.HTTP, hook.HTTP) {
 		return false
 	}
 	for i, endpoint := range h.Endpoints {
@@ -390,6 +628,10 @@ func (h *Hook) Open() {
 		return
 	}
 	h.opened = true
+	if h.channel {
+		// channels are not queued
+		return
+	}
 	b, _ := json.Marshal(h.Name)
 	h.query = `{"hook":` + string(b) + `}`
 	go h.manager()
@@ -436,16 +678,54 @@ func (h *Hook) manager() {
 	}
 }
 
+// maxAge returns the age that a message may reach before it's dead-lettered.
+func (h *Hook) maxAge() time.Duration {
+	if h.MaxAge > 0 {
+		return h.MaxAge
+	}
+	return hookDefaultMaxAge
+}
+
+// backoff returns the delay before the next attempt to send the message at
+// the head of the queue.
+func (h *Hook) backoff() time.Duration {
+	delay := h.Backoff
+	if delay <= 0 {
+		delay = hookDefaultBackoff
+	}
+	for i := 1; i < h.attempts && delay < hookMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > hookMaxBackoff {
+		delay = hookMaxBackoff
+	}
+	return delay
+}
+
+// logSetOptions returns the options for a message that is added to the
+// queue of the hook. The message expires a little after its max age, which
+// allows for proc to dead-letter it first. The age of a message is derived
+// from its remaining ttl.
+func (h *Hook) logSetOptions() *buntdb.SetOptions {
+	return &buntdb.SetOptions{Expires: true, TTL: h.maxAge() + hookLogGrace}
+}
+
 // proc processes queued hook logs.
 // returning true will indicate that all log entries have been
 // successfully handled.
 func (h *Hook) proc() (ok bool) {
+	if time.Now().Before(h.retryAt) {
+		return false
+	}
 	var keys, vals []string
 	var ttls []time.Duration
 	err := h.db.Update(func(tx *buntdb.Tx) error {
 
 		// get keys and vals
 		err := tx.AscendGreaterOrEqual("hooks", h.query, func(key, val string) bool {
+			if gjson.Get(val, "hook").String() != h.Name {
+				return false
+			}
 			if strings.HasPrefix(key, hookLogPrefix) {
 				keys = append(keys, key)
 				vals = append(vals, val)
@@ -455,18 +735,17 @@ func (h *Hook) proc() (ok bool) {
 		if err != nil {
 			return err
 		}
+		h.stats.setQueued(len(keys))
 
 		// delete the keys
 		for _, key := range keys {
-			if hookLogTTL > 0 {
-				ttl, err := tx.TTL(key)
-				if err != nil {
-					if err != buntdb.ErrNotFound {
-						return err
-					}
+			ttl, err := tx.TTL(key)
+			if err != nil {
+				if err != buntdb.ErrNotFound {
+					return err
 				}
-				ttls = append(ttls, ttl)
 			}
+			ttls = append(ttls, ttl)
 			_, err = tx.Delete(key)
 			if err != nil {
 				if err != buntdb.ErrNotFound {
@@ -481,49 +760,171 @@ func (h *Hook) proc() (ok bool) {
 		return false
 	}
 
-	// send each val. on failure reinsert that one and all of the following
+	// send each val. messages that are too old, or have run out of attempts,
+	// are dead-lettered. on failure reinsert that one and all of the following.
+	var deadKeys, deadVals []string
+	var numSent int
+	var retry bool
 	for i, key := range keys {
 		val := vals[i]
 		idx := stringToUint64(key[len(hookLogPrefix):])
-		var sent bool
-		for _, endpoint := range h.Endpoints {
-			err := h.epm.Send(endpoint, val)
+		if ttls[i] >= 0 && ttls[i] < hookLogGrace {
+			deadKeys = append(deadKeys, hookDLQPrefix+uint64ToString(idx))
+			deadVals = append(deadVals, h.deadLetter(val, "max age exceeded"))
+			continue
+		}
+		var sent, dead bool
+		var lerr error
+		for _, ep := range h.Endpoints {
+			err := h.epm.SendOptions(ep, val, h.HTTP)
 			if err != nil {
-				log.Debugf("Endpoint connect/send error: %v: %v: %v", idx, endpoint, err)
+				log.Debugf("Endpoint connect/send error: %v: %v: %v", idx, ep, err)
+				if _, ok := err.(*endpoint.StatusError); ok {
+					switch h.Non2xx {
+					case "ignore":
+						sent = true
+					case "deadletter":
+						dead = true
+					}
+				}
+				if sent {
+					break
+				}
+				lerr = err
 				continue
 			}
-			log.Debugf("Endpoint send ok: %v: %v: %v", idx, endpoint, err)
+			log.Debugf("Endpoint send ok: %v: %v: %v", idx, ep, err)
 			sent = true
 			break
 		}
-		if !sent {
-			// failed to send. try to reinsert the remaining. if this fails we lose log entries.
-			keys = keys[i:]
-			vals = vals[i:]
-			if hookLogTTL > 0 {
-				ttls = ttls[i:]
-			}
-			h.db.Update(func(tx *buntdb.Tx) error {
-				for i, key := range keys {
-					val := vals[i]
-					var opts *buntdb.SetOptions
-					if hookLogTTL > 0 {
-						opts = &buntdb.SetOptions{
-							Expires: true,
-							TTL:     ttls[i],
-						}
-					}
-					_, _, err := tx.Set(key, val, opts)
-					if err != nil {
-						return err
+		if sent {
+			h.attempts = 0
+			h.stats.sent()
+			numSent++
+			continue
+		}
+		h.attempts++
+		h.stats.failed(lerr, h.attempts)
+		if dead || (h.MaxAttempts > 0 && h.attempts >= h.MaxAttempts) {
+			deadKeys = append(deadKeys, hookDLQPrefix+uint64ToString(idx))
+			deadVals = append(deadVals, h.deadLetter(val, lerr.Error()))
+			continue
+		}
+		// failed to send. try to reinsert the remaining. if this fails we lose log entries.
+		// the retry is no later than when the oldest message reaches its
+		// max age, so it's dead-lettered before it expires from the queue.
+		delay := h.backoff()
+		for _, ttl := range ttls[i:] {
+			if ttl >= 0 && ttl-hookLogGrace < delay {
+				delay = ttl - hookLogGrace
+			}
+		}
+		h.retryAt = time.Now().Add(delay)
+		keys = keys[i:]
+		vals = vals[i:]
+		ttls = ttls[i:]
+		retry = true
+		break
+	}
+	if !retry {
+		keys = nil
+	}
+	// the delivered and the dead-lettered messages have left the queue
+	h.stats.queue(-(numSent + len(deadKeys)))
+	if len(keys) > 0 || len(deadKeys) > 0 {
+		err := h.db.Update(func(tx *buntdb.Tx) error {
+			for i, key := range deadKeys {
+				opts := &buntdb.SetOptions{Expires: true, TTL: hookDLQTTL}
+				if _, _, err := tx.Set(key, deadVals[i], opts); err != nil {
+					return err
+				}
+			}
+			for i, key := range keys {
+				val := vals[i]
+				var opts *buntdb.SetOptions
+				if ttls[i] > 0 {
+					opts = &buntdb.SetOptions{
+						Expires: true,
+						TTL:     ttls[i],
 					}
 				}
-				return nil
-			})
-			return false
+				_, _, err := tx.Set(key, val, opts)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Error(err)
 		}
 	}
-	return true
+	return !retry
+}
+
+// deadLetter wraps a message that could not be delivered. The attempts of
+// the message are reset.
+func (h *Hook) deadLetter(val, reason string) string {
+	buf := []byte(`{"hook":`)
+	buf = appendJSONString(buf, h.Name)
+	buf = append(buf, `,"attempts":`...)
+	buf = strconv.AppendInt(buf, int64(h.attempts), 10)
+	buf = append(buf, `,"error":`...)
+	buf = appendJSONString(buf, reason)
+	buf = appendJSONTimeFormat(append(buf, `,"time":`...), time.Now())
+	buf = append(buf, `,"message":`...)
+	buf = append(buf, val...)
+	buf = append(buf, '}')
+	h.attempts = 0
+	h.stats.deadLettered()
+	log.Debugf("Hook dead-lettered: %v: %v", h.Name, reason)
+	return string(buf)
+}
+
+// commandValues returns the SETHOOK or SETCHAN command that creates the hook.
+// It's used for rewriting the aof and for snapshots.
+func (h *Hook) commandValues() []string {
+	var values []string
+	if h.channel {
+		values = append(values, "setchan", h.Name)
+	} else {
+		values = append(values, "sethook", h.Name, strings.Join(h.Endpoints, ","))
+	}
+	for _, meta := range h.Metas {
+		values = append(values, "meta", meta.Name, meta.Value)
+	}
+	if h.MaxAttempts > 0 {
+		values = append(values, "retries", strconv.Itoa(h.MaxAttempts))
+	}
+	if h.Backoff > 0 {
+		values = append(values, "backoff", strconv.FormatFloat(h.Backoff.Seconds(), 'f', -1, 64))
+	}
+	if h.MaxAge > 0 {
+		values = append(values, "maxage", strconv.FormatFloat(h.MaxAge.Seconds(), 'f', -1, 64))
+	}
+	if h.HTTP != nil {
+		for _, header := range h.HTTP.Headers {
+			values = append(values, "header", header[0], header[1])
+		}
+		if h.HTTP.Bearer != "" {
+			values = append(values, "auth", "bearer", h.HTTP.Bearer)
+		} else if h.HTTP.BasicUser != "" {
+			values = append(values, "auth", "basic", h.HTTP.BasicUser, h.HTTP.BasicPass)
+		}
+		if h.HTTP.Secret != "" {
+			values = append(values, "secret", h.HTTP.Secret)
+		}
+		if h.HTTP.Timeout > 0 {
+			values = append(values, "timeout", strconv.FormatFloat(h.HTTP.Timeout.Seconds(), 'f', -1, 64))
+		}
+	}
+	if h.Non2xx != "" {
+		values = append(values, "non2xx", h.Non2xx)
+	}
+	for _, value := range h.Message.Values {
+		values = append(values, value.String())
+	}
+	return values
 }
 
 /*
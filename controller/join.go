@@ -0,0 +1,238 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/controller/collection"
+	"github.com/tidwall/tile38/controller/server"
+	"github.com/tidwall/tile38/geojson"
+)
+
+// joinSide is one of the keys of a join, with the filters of its objects.
+type joinSide struct {
+	key    string
+	col    *collection.Collection
+	wheres []whereT
+	sw     *scanWriter // matches the fields of the objects
+}
+
+// cmdJoin returns the pairs of objects of two keys that match spatially.
+// An object is not paired with itself when both keys are the same.
+// JOIN keyA keyB (INTERSECTS | WITHIN | NEARBY meters) [CURSOR start] [LIMIT count]
+// [WHERE (A | B) field min max]... [IDS | COUNT | OBJECTS]
+func (c *Controller) cmdJoin(msg *server.Message) (res string, err error) {
+	start := time.Now()
+	vs := msg.Values[1:]
+	var ok bool
+	var a, b joinSide
+	if vs, a.key, ok = tokenval(vs); !ok || a.key == "" {
+		return "", errInvalidNumberOfArguments
+	}
+	if vs, b.key, ok = tokenval(vs); !ok || b.key == "" {
+		return "", errInvalidNumberOfArguments
+	}
+	var spred string
+	if vs, spred, ok = tokenval(vs); !ok || spred == "" {
+		return "", errInvalidNumberOfArguments
+	}
+	var meters float64
+	pred := strings.ToLower(spred)
+	switch pred {
+	default:
+		return "", errInvalidArgument(spred)
+	case "intersects", "within":
+	case "nearby":
+		var smeters string
+		if vs, smeters, ok = tokenval(vs); !ok || smeters == "" {
+			return "", errInvalidNumberOfArguments
+		}
+		if meters, err = strconv.ParseFloat(smeters, 64); err != nil || meters < 0 {
+			return "", errInvalidArgument(smeters)
+		}
+	}
+	var scursor, slimit string
+	output := outputObjects
+	for len(vs) > 0 {
+		var opt string
+		if vs, opt, ok = tokenval(vs); !ok || opt == "" {
+			return "", errInvalidNumberOfArguments
+		}
+		switch strings.ToLower(opt) {
+		default:
+			return "", errInvalidArgument(opt)
+		case "cursor", "limit":
+			s := &scursor
+			if strings.ToLower(opt) == "limit" {
+				s = &slimit
+			}
+			if *s != "" {
+				return "", errDuplicateArgument(strings.ToUpper(opt))
+			}
+			if vs, *s, ok = tokenval(vs); !ok || *s == "" {
+				return "", errInvalidNumberOfArguments
+			}
+		case "where":
+			var side, field, smin, smax string
+			if vs, side, ok = tokenval(vs); !ok || side == "" {
+				return "", errInvalidNumberOfArguments
+			}
+			if vs, field, ok = tokenval(vs); !ok || field == "" {
+				return "", errInvalidNumberOfArguments
+			}
+			if vs, smin, ok = tokenval(vs); !ok || smin == "" {
+				return "", errInvalidNumberOfArguments
+			}
+			if vs, smax, ok = tokenval(vs); !ok || smax == "" {
+				return "", errInvalidNumberOfArguments
+			}
+			where, err := parseWhereRange(field, smin, smax)
+			if err != nil {
+				return "", err
+			}
+			switch strings.ToLower(side) {
+			default:
+				return "", errInvalidArgument(side)
+			case "a":
+				a.wheres = append(a.wheres, where)
+			case "b":
+				b.wheres = append(b.wheres, where)
+			}
+		case "ids", "count", "objects":
+			if len(vs) != 0 {
+				return "", errInvalidNumberOfArguments
+			}
+			switch strings.ToLower(opt) {
+			case "ids":
+				output = outputIDs
+			case "count":
+				output = outputCount
+			}
+		}
+	}
+	var cursor, limit uint64
+	if scursor != "" {
+		if cursor, err = strconv.ParseUint(scursor, 10, 64); err != nil {
+			return "", errInvalidArgument(scursor)
+		}
+	}
+	limit = limitItems
+	if slimit != "" {
+		if limit, err = strconv.ParseUint(slimit, 10, 64); err != nil || limit == 0 {
+			return "", errInvalidArgument(slimit)
+		}
+	}
+	if output == outputCount && slimit == "" {
+		limit = 0
+	}
+	for _, side := range []*joinSide{&a, &b} {
+		side.col = c.getCol(side.key)
+		side.sw, err = c.newScanWriter(&bytes.Buffer{}, msg, side.key, outputIDs,
+			0, "", false, 0, 0, side.wheres, nil, nil, true)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var skipped, count uint64
+	var hitLimit bool
+	var pairs []resp.Value
+	var wr bytes.Buffer
+	if msg.OutputType == server.JSON {
+		wr.WriteString(`{"ok":true`)
+		if output != outputCount {
+			wr.WriteString(`,"pairs":[`)
+		}
+	}
+	if a.col != nil && b.col != nil {
+		a.col.Join(b.col, meters, func(idA string, objA geojson.Object, fieldsA []collection.FieldValue,
+			idB string, objB geojson.Object, fieldsB []collection.FieldValue) bool {
+			var match bool
+			switch pred {
+			case "intersects":
+				match = objA.Intersects(objB)
+			case "within":
+				match = objA.Within(objB)
+			case "nearby":
+				match = objB.Nearby(objA.CalculatedPoint(), meters)
+			}
+			if !match || (a.key == b.key && idA == idB) ||
+				c.hasExpired(a.key, idA) || c.hasExpired(b.key, idB) {
+				return true
+			}
+			if _, ok := a.sw.fieldMatch(fieldsA, objA); !ok {
+				return true
+			}
+			if _, ok := b.sw.fieldMatch(fieldsB, objB); !ok {
+				return true
+			}
+			if skipped < cursor {
+				skipped++
+				return true
+			}
+			count++
+			if output == outputCount {
+				return limit == 0 || count < limit
+			}
+			switch msg.OutputType {
+			case server.JSON:
+				if count > 1 {
+					wr.WriteByte(',')
+				}
+				if output == outputIDs {
+					wr.WriteString(`[` + jsonString(idA) + `,` + jsonString(idB) + `]`)
+				} else {
+					wr.WriteString(`[{"id":` + jsonString(idA) + `,"object":` + objA.JSON() + `},` +
+						`{"id":` + jsonString(idB) + `,"object":` + objB.JSON() + `}]`)
+				}
+			case server.RESP:
+				vals := []resp.Value{resp.StringValue(idA), resp.StringValue(idB)}
+				if output == outputObjects {
+					vals = []resp.Value{
+						resp.StringValue(idA), resp.StringValue(objA.String()),
+						resp.StringValue(idB), resp.StringValue(objB.String()),
+					}
+				}
+				pairs = append(pairs, resp.ArrayValue(vals))
+			}
+			if count >= limit {
+				hitLimit = true
+				return false
+			}
+			return true
+		})
+	}
+	var next uint64
+	if hitLimit {
+		next = cursor + limit
+	}
+	switch msg.OutputType {
+	case server.JSON:
+		if output != outputCount {
+			wr.WriteByte(']')
+		}
+		wr.WriteString(`,"count":` + strconv.FormatUint(count, 10))
+		wr.WriteString(`,"cursor":` + strconv.FormatUint(next, 10))
+		wr.WriteString(`,"elapsed":"` + time.Now().Sub(start).String() + "\"}")
+		return wr.String(), nil
+	case server.RESP:
+		var data []byte
+		if output == outputCount {
+			data, err = resp.IntegerValue(int(count)).MarshalRESP()
+		} else {
+			data, err = resp.ArrayValue([]resp.Value{
+				resp.IntegerValue(int(next)),
+				resp.ArrayValue(pairs),
+			}).MarshalRESP()
+		}
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	return "", errors.New("invalid output type")
+}
@@ -42,6 +42,13 @@ func jsonString(s string) string {
 	return string(b)
 }
 
+func fieldValueJSON(v collection.FieldValue) string {
+	if v.Kind == collection.String {
+		return jsonString(v.Str)
+	}
+	return v.String()
+}
+
 func (c *Controller) cmdJget(msg *server.Message) (string, error) {
 	start := time.Now()
 	if len(msg.Values) < 3 {
@@ -197,6 +204,7 @@ func (c *Controller) cmdJset(msg *server.Message) (res string, d commandDetailsT
 	d.timestamp = time.Now()
 	d.updated = true
 
+	c.snapshotTouch(key, id)
 	c.clearIDExpires(key, id)
 	col.ReplaceOrInsert(d.id, d.obj, nil, nil)
 	switch msg.OutputType {
@@ -270,6 +278,7 @@ func (c *Controller) cmdJdel(msg *server.Message) (res string, d commandDetailsT
 	d.timestamp = time.Now()
 	d.updated = true
 
+	c.snapshotTouch(d.key, d.id)
 	c.clearIDExpires(d.key, d.id)
 	col.ReplaceOrInsert(d.id, d.obj, nil, nil)
 	switch msg.OutputType {
@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/tidwall/tile38/controller/collection"
+	"github.com/tidwall/tile38/geojson"
+)
+
+// motionT tracks the movement of an object for a fence that filters on
+// speed, heading, or acceleration.
+type motionT struct {
+	pos      geojson.Position // last position
+	at       time.Time        // time of the last position
+	speed    float64          // meters per second
+	bearing  float64          // degrees, from 0 to 360
+	accel    float64          // meters per second squared
+	hasSpeed bool             // the object has moved at least once
+	hasAccel bool             // the speed has been calculated at least twice
+}
+
+// kinematicsOn returns true when the fence filters on speed, heading, or
+// acceleration.
+func (fence *liveFenceSwitches) kinematicsOn() bool {
+	return fence.speed != nil || fence.heading != nil || fence.accel != nil
+}
+
+// fenceUpdateMotion calculates the speed, bearing, and acceleration of an
+// object from its previous and current positions. It returns nil when the
+// fence doesn't need the motion, or when the object hasn't moved yet.
+func fenceUpdateMotion(fence *liveFenceSwitches, details *commandDetailsT) *motionT {
+	if !fence.kinematicsOn() {
+		return nil
+	}
+	groupkey := details.key + ":" + details.id
+	if details.command == "del" {
+		delete(fence.motions, groupkey)
+		return nil
+	}
+	mo, ok := fence.motions[groupkey]
+	if details.command == "set" {
+		p := details.obj.CalculatedPoint()
+		if !ok {
+			if fence.motions == nil {
+				fence.motions = make(map[string]*motionT)
+			}
+			mo = &motionT{pos: p, at: details.timestamp}
+			fence.motions[groupkey] = mo
+			return nil
+		}
+		if elapsed := details.timestamp.Sub(mo.at).Seconds(); elapsed > 0 {
+			speed := mo.pos.DistanceTo(p) / elapsed
+			if mo.hasSpeed {
+				mo.accel = (speed - mo.speed) / elapsed
+				mo.hasAccel = true
+			}
+			if p != mo.pos {
+				mo.bearing = mo.pos.BearingTo(p)
+			}
+			mo.speed = speed
+			mo.hasSpeed = true
+			mo.pos = p
+			mo.at = details.timestamp
+		}
+	}
+	if !ok || !mo.hasSpeed {
+		return nil
+	}
+	return mo
+}
+
+// fenceMatchMotion returns true when the motion of an object is within the
+// SPEED, HEADING, and ACCEL ranges of the fence.
+func fenceMatchMotion(fence *liveFenceSwitches, mo *motionT) bool {
+	if !fence.kinematicsOn() {
+		return true
+	}
+	if mo == nil {
+		return false
+	}
+	if fence.speed != nil && !fence.speed.match(collection.FieldValue{Num: mo.speed}) {
+		return false
+	}
+	if fence.heading != nil {
+		heading := *fence.heading
+		if heading.min > heading.max {
+			// the range wraps around north, such as 315 to 45
+			lo, hi := heading, heading
+			lo.max, lo.maxx = 360, false
+			hi.min, hi.minx = 0, false
+			value := collection.FieldValue{Num: mo.bearing}
+			if !lo.match(value) && !hi.match(value) {
+				return false
+			}
+		} else if !heading.match(collection.FieldValue{Num: mo.bearing}) {
+			return false
+		}
+	}
+	if fence.accel != nil {
+		if !mo.hasAccel || !fence.accel.match(collection.FieldValue{Num: mo.accel}) {
+			return false
+		}
+	}
+	return true
+}
+
+// appendMotion appends the speed, bearing, and acceleration of an object to
+// a notification.
+func appendMotion(buf []byte, mo *motionT) []byte {
+	buf = append(buf, `,"speed":`...)
+	buf = strconv.AppendFloat(buf, mo.speed, 'f', -1, 64)
+	buf = append(buf, `,"bearing":`...)
+	buf = strconv.AppendFloat(buf, mo.bearing, 'f', -1, 64)
+	if mo.hasAccel {
+		buf = append(buf, `,"accel":`...)
+		buf = strconv.AppendFloat(buf, mo.accel, 'f', -1, 64)
+	}
+	return buf
+}
@@ -60,10 +60,79 @@ func writeMessage(conn net.Conn, message []byte, wrapRESP bool, connType server.
 		}
 	case server.Native:
 		_, err = fmt.Fprintf(conn, "$%d %s\r\n", len(message), string(message))
+	case server.Telnet:
+		_, err = fmt.Fprintf(conn, "%s\r\n", string(message))
 	}
 	return err
 }
 
+// liveMaxMsgs is the number of pending messages that a live connection may
+// have before it's disconnected for being too slow.
+const liveMaxMsgs = 10000
+
+// liveQueue holds the pending messages of a live connection, such as a
+// subscriber or a monitor. The messages are pushed without blocking on the
+// connection, and are written by the goroutine of the connection.
+type liveQueue struct {
+	cond   *sync.Cond
+	conn   net.Conn
+	kind   string   // kind of connection, for the log
+	msgs   [][]byte // pending messages, encoded for the connection
+	closed bool
+}
+
+func newLiveQueue(conn net.Conn, kind string) liveQueue {
+	return liveQueue{cond: sync.NewCond(&sync.Mutex{}), conn: conn, kind: kind}
+}
+
+// push adds a message to the queue. A connection that has too many pending
+// messages is disconnected. The caller must hold the queue lock.
+func (q *liveQueue) push(msg []byte) {
+	if q.closed {
+		return
+	}
+	if len(q.msgs) >= liveMaxMsgs {
+		log.Warnf("disconnecting slow %s %s", q.kind, q.conn.RemoteAddr())
+		q.msgs = nil
+		q.closed = true
+		q.conn.Close()
+	} else {
+		q.msgs = append(q.msgs, msg)
+	}
+	q.cond.Broadcast()
+}
+
+// close stops the writing of the queue.
+func (q *liveQueue) close() {
+	q.cond.L.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.cond.L.Unlock()
+}
+
+// writeAll writes the pending messages to the connection until the queue
+// is closed or a write fails.
+func (q *liveQueue) writeAll(wrapRESP bool, connType server.Type, websocket bool) {
+	for {
+		q.cond.L.Lock()
+		for !q.closed && len(q.msgs) == 0 {
+			q.cond.Wait()
+		}
+		if q.closed {
+			q.cond.L.Unlock()
+			return
+		}
+		msgs := q.msgs
+		q.msgs = nil
+		q.cond.L.Unlock()
+		for _, msg := range msgs {
+			if err := writeMessage(q.conn, msg, wrapRESP, connType, websocket); err != nil {
+				return
+			}
+		}
+	}
+}
+
 func (c *Controller) goLive(inerr error, conn net.Conn, rd *server.AnyReaderWriter, msg *server.Message, websocket bool) error {
 	addr := conn.RemoteAddr().String()
 	log.Info("live " + addr)
@@ -71,7 +140,13 @@ func (c *Controller) goLive(inerr error, conn net.Conn, rd *server.AnyReaderWrit
 		log.Info("not live " + addr)
 	}()
 	if s, ok := inerr.(liveAOFSwitches); ok {
-		return c.liveAOF(s.pos, conn, rd, msg)
+		return c.liveAOF(s, conn, rd, msg)
+	}
+	if s, ok := inerr.(liveSubscriptionSwitches); ok {
+		return c.liveSubscription(s, conn, rd, msg, websocket)
+	}
+	if _, ok := inerr.(liveMonitorSwitches); ok {
+		return c.liveMonitor(conn, rd, msg, websocket)
 	}
 	lb := &liveBuffer{
 		cond: sync.NewCond(&sync.Mutex{}),
@@ -87,7 +162,7 @@ func (c *Controller) goLive(inerr error, conn net.Conn, rd *server.AnyReaderWrit
 		lb.key = s.key
 		lb.fence = &s
 		c.mu.RLock()
-		sw, err = c.newScanWriter(&wr, msg, s.key, s.output, s.precision, s.glob, false, s.cursor, s.limit, s.wheres, s.whereins, s.nofields)
+		sw, err = c.newScanWriter(&wr, msg, s.key, s.output, s.precision, s.glob, false, s.cursor, s.limit, s.wheres, s.whereins, s.whereexprs, s.nofields)
 		c.mu.RUnlock()
 	}
 	// everything below if for live SCAN, NEARBY, WITHIN, INTERSECTS
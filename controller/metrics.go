@@ -0,0 +1,229 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/btree"
+	"github.com/tidwall/tile38/controller/endpoint"
+	"github.com/tidwall/tile38/controller/log"
+	"github.com/tidwall/tile38/core"
+)
+
+// Prometheus metrics
+//
+// When the server is started with --metrics-addr, the metrics are served in
+// the Prometheus text format at /metrics on that address.
+
+// metricsBuckets are the upper bounds, in seconds, of the command latency
+// histogram buckets.
+var metricsBuckets = []float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1,
+}
+
+// metricsT holds the command counts and latencies.
+type metricsT struct {
+	mu       sync.Mutex
+	commands map[string]*commandMetrics
+}
+
+type commandMetrics struct {
+	count   uint64
+	sum     float64  // seconds
+	buckets []uint64 // counts per bucket, not cumulative
+}
+
+// metricsCommands are the command names that are labeled by name. Other
+// commands are labeled as "unknown".
+var metricsCommands = func() map[string]bool {
+	m := map[string]bool{
+		"client": true, "info": true, "massinsert": true, "nearbydistinct": true,
+		"shutdown": true, "type": true, "aof": true, "aofmd5": true, "psync": true,
+	}
+	for name := range core.Commands {
+		m[strings.ToLower(strings.Split(name, " ")[0])] = true
+	}
+	return m
+}()
+
+// observe counts a command and its duration.
+func (m *metricsT) observe(command string, dur time.Duration) {
+	if !metricsCommands[command] {
+		command = "unknown"
+	}
+	secs := dur.Seconds()
+	m.mu.Lock()
+	if m.commands == nil {
+		m.commands = make(map[string]*commandMetrics)
+	}
+	cm := m.commands[command]
+	if cm == nil {
+		cm = &commandMetrics{buckets: make([]uint64, len(metricsBuckets))}
+		m.commands[command] = cm
+	}
+	cm.count++
+	cm.sum += secs
+	for i, le := range metricsBuckets {
+		if secs <= le {
+			cm.buckets[i]++
+			break
+		}
+	}
+	m.mu.Unlock()
+}
+
+// writeTo writes the command metrics.
+func (m *metricsT) writeTo(w *bytes.Buffer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for name := range m.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metricsHeader(w, "tile38_commands_total", "counter", "Number of commands processed.")
+	for _, name := range names {
+		fmt.Fprintf(w, "tile38_commands_total{command=%s} %d\n", metricsLabel(name), m.commands[name].count)
+	}
+	metricsHeader(w, "tile38_command_duration_seconds", "histogram", "Latency of the commands.")
+	for _, name := range names {
+		cm := m.commands[name]
+		label := metricsLabel(name)
+		var cum uint64
+		for i, le := range metricsBuckets {
+			cum += cm.buckets[i]
+			fmt.Fprintf(w, "tile38_command_duration_seconds_bucket{command=%s,le=\"%s\"} %d\n",
+				label, strconv.FormatFloat(le, 'f', -1, 64), cum)
+		}
+		fmt.Fprintf(w, "tile38_command_duration_seconds_bucket{command=%s,le=\"+Inf\"} %d\n", label, cm.count)
+		fmt.Fprintf(w, "tile38_command_duration_seconds_sum{command=%s} %s\n", label, strconv.FormatFloat(cm.sum, 'f', -1, 64))
+		fmt.Fprintf(w, "tile38_command_duration_seconds_count{command=%s} %d\n", label, cm.count)
+	}
+}
+
+func metricsHeader(w *bytes.Buffer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// metricsLabel returns a quoted label value.
+func metricsLabel(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	value = strings.Replace(value, "\n", `\n`, -1)
+	return `"` + value + `"`
+}
+
+// followLag returns the number of bytes that a follower is behind its
+// leader. The leader position is the one of the last sync, or the one that
+// is reported by the peer monitor when it's more recent. The caller must
+// hold the lock.
+func (c *Controller) followLag() int {
+	if c.config.FollowHost == "" {
+		return 0
+	}
+	leader := c.fleader
+	if peer := c.failover.peers[c.failover.leaderAddr]; peer != nil && peer.err == nil && peer.offset > leader {
+		leader = peer.offset
+	}
+	if leader < c.repl.offset {
+		return 0
+	}
+	return leader - c.repl.offset
+}
+
+// writeMetrics writes all metrics in the Prometheus text format.
+func (c *Controller) writeMetrics(w *bytes.Buffer) error {
+	c.metrics.writeTo(w)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	metricsHeader(w, "tile38_connected_clients", "gauge", "Number of client connections.")
+	fmt.Fprintf(w, "tile38_connected_clients %d\n", len(c.conns))
+
+	metricsHeader(w, "tile38_collection_objects", "gauge", "Number of objects in a collection.")
+	c.cols.Ascend(func(item btree.Item) bool {
+		col := item.(*collectionT)
+		fmt.Fprintf(w, "tile38_collection_objects{collection=%s} %d\n", metricsLabel(col.Key), col.Collection.Count())
+		return true
+	})
+	metricsHeader(w, "tile38_collection_points", "gauge", "Number of points in a collection.")
+	c.cols.Ascend(func(item btree.Item) bool {
+		col := item.(*collectionT)
+		fmt.Fprintf(w, "tile38_collection_points{collection=%s} %d\n", metricsLabel(col.Key), col.Collection.PointCount())
+		return true
+	})
+	metricsHeader(w, "tile38_collection_weight_bytes", "gauge", "In-memory weight of a collection.")
+	c.cols.Ascend(func(item btree.Item) bool {
+		col := item.(*collectionT)
+		fmt.Fprintf(w, "tile38_collection_weight_bytes{collection=%s} %d\n", metricsLabel(col.Key), col.Collection.TotalWeight())
+		return true
+	})
+
+	metricsHeader(w, "tile38_aof_size_bytes", "gauge", "Size of the aof.")
+	fmt.Fprintf(w, "tile38_aof_size_bytes %d\n", c.aofsz)
+	metricsHeader(w, "tile38_aof_last_shrink_duration_seconds", "gauge", "Duration of the last aof shrink.")
+	fmt.Fprintf(w, "tile38_aof_last_shrink_duration_seconds %s\n", strconv.FormatFloat(c.lastShrinkDuration.Seconds(), 'f', -1, 64))
+
+	metricsHeader(w, "tile38_follower_lag_bytes", "gauge", "Number of bytes that a follower is behind its leader.")
+	fmt.Fprintf(w, "tile38_follower_lag_bytes %d\n", c.followLag())
+
+	metricsHeader(w, "tile38_hook_queue_depth", "gauge", "Number of messages waiting to be sent by a hook.")
+	for _, hook := range c.sortedHooks() {
+		if hook.channel {
+			continue
+		}
+		fmt.Fprintf(w, "tile38_hook_queue_depth{hook=%s} %d\n", metricsLabel(hook.Name), hook.stats.getQueued())
+	}
+	stats := c.epc.Stats()
+	var protocols []string
+	for protocol := range stats {
+		protocols = append(protocols, string(protocol))
+	}
+	sort.Strings(protocols)
+	metricsHeader(w, "tile38_hook_deliveries_total", "counter", "Number of hook send attempts by endpoint protocol.")
+	for _, protocol := range protocols {
+		s := stats[endpoint.EndpointProtocol(protocol)]
+		fmt.Fprintf(w, "tile38_hook_deliveries_total{protocol=%s,result=\"success\"} %d\n", metricsLabel(protocol), s.Sent)
+		fmt.Fprintf(w, "tile38_hook_deliveries_total{protocol=%s,result=\"failure\"} %d\n", metricsLabel(protocol), s.Failed)
+	}
+	_, _, dead := c.hookTotals()
+	metricsHeader(w, "tile38_hook_dead_lettered_total", "counter", "Number of hook messages that were dead-lettered.")
+	fmt.Fprintf(w, "tile38_hook_dead_lettered_total %d\n", dead)
+
+	metricsHeader(w, "tile38_expired_objects_total", "counter", "Number of objects that expired.")
+	fmt.Fprintf(w, "tile38_expired_objects_total %d\n", c.statsExpired)
+	return nil
+}
+
+// serveMetrics listens on addr and serves the metrics at /metrics.
+func (c *Controller) serveMetrics(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if err := c.writeMetrics(&buf); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(buf.Bytes())
+	})
+	log.Infof("metrics listening at http://%s/metrics", ln.Addr().String())
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			log.Error(err)
+		}
+	}()
+	return nil
+}
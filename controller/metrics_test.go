@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/controller/endpoint"
+	"github.com/tidwall/tile38/controller/server"
+)
+
+func TestMetricsCommands(t *testing.T) {
+	var m metricsT
+	m.observe("set", time.Microsecond*50)
+	m.observe("set", time.Millisecond*2)
+	m.observe("bogus", time.Millisecond)
+	var buf bytes.Buffer
+	m.writeTo(&buf)
+	out := buf.String()
+	for _, line := range []string{
+		`tile38_commands_total{command="set"} 2`,
+		`tile38_commands_total{command="unknown"} 1`,
+		`tile38_command_duration_seconds_bucket{command="set",le="0.0001"} 1`,
+		`tile38_command_duration_seconds_bucket{command="set",le="0.001"} 1`,
+		`tile38_command_duration_seconds_bucket{command="set",le="0.0025"} 2`,
+		`tile38_command_duration_seconds_bucket{command="set",le="+Inf"} 2`,
+		`tile38_command_duration_seconds_count{command="set"} 2`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("expected '%s' in:\n%s", line, out)
+		}
+	}
+}
+
+func TestMetricsLabel(t *testing.T) {
+	if s := metricsLabel("a\"b\\c\nd"); s != `"a\"b\\c\nd"` {
+		t.Fatalf("got %s", s)
+	}
+}
+
+// testHookQueue returns a hook that sends to url, and a func that sets an
+// object inside of its fence and queues the messages.
+func testHookQueue(t *testing.T, url string) (*Hook, func(id string)) {
+	c := testController()
+	c.epc = endpoint.NewEndpointManager()
+	qdb, err := buntdb.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { qdb.Close() })
+	if err := qdb.CreateIndex("hooks", hookLogPrefix+"*", buntdb.IndexJSONCaseSensitive("hook")); err != nil {
+		t.Fatal(err)
+	}
+	c.qdb = qdb
+	testDo(t, c, "SETHOOK", "h1", url, "BACKOFF", 60, "NEARBY", "fleet", "FENCE", "POINT", 33, -115, 5000)
+	hook := c.hooks["h1"]
+	t.Cleanup(hook.Close)
+	set := func(id string) {
+		msg := &server.Message{OutputType: server.RESP, Command: "set"}
+		msg.Values = resp.MultiBulkValue("SET", "fleet", id, "POINT", 33, -115).Array()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		_, d, err := c.command(msg, nil, nil)
+		if err == nil {
+			err = c.queueHooks(&d)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return hook, set
+}
+
+// testHookFailed waits for a failed attempt of the hook.
+func testHookFailed(t *testing.T, hook *Hook) {
+	for start := time.Now(); ; time.Sleep(time.Millisecond * 10) {
+		if _, failed, _, _, _, _ := hook.stats.get(); failed > 0 {
+			return
+		}
+		if time.Since(start) > time.Second*5 {
+			t.Fatal("expected a failed attempt")
+		}
+	}
+}
+
+func TestHookQueueDepth(t *testing.T) {
+	hook, set := testHookQueue(t, "http://127.0.0.1:1/")
+
+	// the messages stay queued after the first attempt fails
+	set("truck1")
+	testHookFailed(t, hook)
+	if n := hook.stats.getQueued(); n != 2 {
+		t.Fatalf("expected 2, got %d", n)
+	}
+	set("truck2")
+	if n := hook.stats.getQueued(); n != 4 {
+		t.Fatalf("expected 4, got %d", n)
+	}
+}
+
+func TestHookQueueDepthAfterDelivery(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) > 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	hook, set := testHookQueue(t, srv.URL)
+
+	// the first message is delivered and leaves the queue, the second one
+	// fails and stays queued
+	set("truck1")
+	testHookFailed(t, hook)
+	if delivered, _, _, _, _, _ := hook.stats.get(); delivered != 1 {
+		t.Fatalf("expected 1 delivered, got %d", delivered)
+	}
+	if n := hook.stats.getQueued(); n != 1 {
+		t.Fatalf("expected 1, got %d", n)
+	}
+}
@@ -0,0 +1,210 @@
+package controller
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/tile38/controller/log"
+	"github.com/tidwall/tile38/controller/server"
+)
+
+// monitors feeds every processed command to the connections that ran
+// MONITOR.
+type monitors struct {
+	mu   sync.RWMutex
+	subs map[*monitor]bool
+}
+
+// monitor is a connection in monitor mode.
+type monitor struct {
+	liveQueue
+	output server.Type
+}
+
+// liveMonitorSwitches is returned by MONITOR to put a connection into
+// monitor mode.
+type liveMonitorSwitches struct{}
+
+func (s liveMonitorSwitches) Error() string {
+	return "going live"
+}
+
+func newMonitors() *monitors {
+	return &monitors{subs: make(map[*monitor]bool)}
+}
+
+// feed sends a command to the monitors. It never blocks on the monitor
+// connections.
+func (ms *monitors) feed(addr string, msg *server.Message) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	if len(ms.subs) == 0 || len(msg.Values) == 0 {
+		return
+	}
+	now := time.Now()
+	var line []byte
+	line = strconv.AppendFloat(line, float64(now.UnixNano()/int64(time.Microsecond))/1e6, 'f', 6, 64)
+	line = append(line, " ["...)
+	line = append(line, addr...)
+	line = append(line, ']')
+	for i := range msg.Values {
+		line = append(line, ' ')
+		line = strconv.AppendQuote(line, redactArg(msg, i))
+	}
+	var resp, json []byte
+	for m := range ms.subs {
+		m.cond.L.Lock()
+		if m.output == server.JSON {
+			if json == nil {
+				json = []byte(`{"ok":true,"monitor":` + jsonString(string(line)) + `}`)
+			}
+			m.push(json)
+		} else {
+			if resp == nil {
+				resp = []byte("+" + string(line) + "\r\n")
+			}
+			m.push(resp)
+		}
+		m.cond.L.Unlock()
+	}
+}
+
+// redactArg returns an argument of a command as it's shown by MONITOR and
+// SLOWLOG. Passwords, tokens, and the credentials of endpoints are replaced
+// by "(redacted)".
+func redactArg(msg *server.Message, i int) string {
+	const redacted = "(redacted)"
+	arg := msg.Values[i].String()
+	switch msg.Command {
+	case "auth", "acl":
+		if i > 0 {
+			return redacted
+		}
+	case "config":
+		if i == 3 && strings.ToLower(msg.Values[1].String()) == "set" {
+			switch strings.ToLower(msg.Values[2].String()) {
+			case RequirePass, LeaderAuth:
+				return redacted
+			}
+		}
+	case "sethook":
+		if i == 2 {
+			return redactEndpoints(arg)
+		}
+		// the secret, the headers, and the credentials of the http endpoints
+		for j := 3; j < i; {
+			n := hookOptionLen(msg.Values[j:])
+			if n == 0 {
+				break
+			}
+			if i < j+n {
+				switch strings.ToLower(msg.Values[j].String()) {
+				case "secret":
+					return redacted
+				case "header", "auth":
+					if i > j+1 {
+						return redacted
+					}
+				}
+				break
+			}
+			j += n
+		}
+	}
+	return arg
+}
+
+// redactEndpoints replaces the user info and the query values of the
+// endpoint urls, which may contain credentials.
+func redactEndpoints(urls string) string {
+	eps := strings.Split(urls, ",")
+	for i, ep := range eps {
+		j := strings.Index(ep, "://")
+		if j == -1 {
+			continue
+		}
+		j += 3
+		host := ep[j:]
+		if k := strings.IndexAny(host, "/?"); k != -1 {
+			host = host[:k]
+		}
+		if k := strings.LastIndexByte(host, '@'); k != -1 {
+			ep = ep[:j] + "(redacted)" + ep[j+k:]
+		}
+		if k := strings.IndexByte(ep, '?'); k != -1 {
+			params := strings.Split(ep[k+1:], "&")
+			for n, param := range params {
+				if m := strings.IndexByte(param, '='); m != -1 {
+					params[n] = param[:m+1] + "(redacted)"
+				}
+			}
+			ep = ep[:k+1] + strings.Join(params, "&")
+		}
+		eps[i] = ep
+	}
+	return strings.Join(eps, ",")
+}
+
+func (c *Controller) cmdMonitor(msg *server.Message) (res string, err error) {
+	if len(msg.Values) != 1 {
+		return "", errInvalidNumberOfArguments
+	}
+	return "", liveMonitorSwitches{}
+}
+
+// liveMonitor puts a connection into monitor mode, until it quits.
+func (c *Controller) liveMonitor(conn net.Conn, rd *server.AnyReaderWriter, msg *server.Message, websocket bool) error {
+	connType := msg.ConnType
+	m := &monitor{
+		liveQueue: newLiveQueue(conn, "monitor"),
+		output:    msg.OutputType,
+	}
+	if websocket {
+		m.output = server.JSON
+	}
+	if m.output == server.JSON {
+		m.push([]byte(`{"ok":true,"monitor":"OK"}`))
+	} else {
+		m.push([]byte("+OK\r\n"))
+	}
+
+	c.monitors.mu.Lock()
+	c.monitors.subs[m] = true
+	c.monitors.mu.Unlock()
+	defer func() {
+		c.monitors.mu.Lock()
+		delete(c.monitors.subs, m)
+		c.monitors.mu.Unlock()
+		conn.Close()
+	}()
+
+	go func() {
+		defer func() {
+			m.close()
+			conn.Close()
+		}()
+		for {
+			v, err := rd.ReadMessage()
+			if err != nil {
+				if err != io.EOF && !(websocket && err == io.ErrUnexpectedEOF) {
+					log.Error(err)
+				}
+				return
+			}
+			if v == nil {
+				continue
+			}
+			switch strings.ToLower(v.Command) {
+			case "quit", "":
+				return
+			}
+		}
+	}()
+
+	m.writeAll(m.output == server.JSON, connType, websocket)
+	return nil
+}
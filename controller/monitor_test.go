@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/controller/server"
+)
+
+func TestRedactArg(t *testing.T) {
+	tests := []struct {
+		args   []interface{}
+		expect string
+	}{
+		{[]interface{}{"CONFIG", "SET", "requirepass", "pw"}, "CONFIG SET requirepass (redacted)"},
+		{[]interface{}{"CONFIG", "SET", "maxmemory", "1gb"}, "CONFIG SET maxmemory 1gb"},
+		{[]interface{}{"SETHOOK", "h", "nats://u:p@host:4222/s?token=t&js=yes,grpc://host/x", "META", "auth", "x",
+			"AUTH", "BASIC", "u", "p", "SECRET", "s", "NEARBY", "fleet", "FENCE", "POINT", 33, -115, 5000},
+			"SETHOOK h nats://(redacted)@host:4222/s?token=(redacted)&js=(redacted),grpc://host/x META auth x " +
+				"AUTH BASIC (redacted) (redacted) SECRET (redacted) NEARBY fleet FENCE POINT 33 -115 5000"},
+	}
+	for _, test := range tests {
+		msg := &server.Message{}
+		msg.Values = resp.MultiBulkValue(test.args[0].(string), test.args[1:]...).Array()
+		msg.Command = strings.ToLower(msg.Values[0].String())
+		var line string
+		for i := range msg.Values {
+			if i > 0 {
+				line += " "
+			}
+			line += redactArg(msg, i)
+		}
+		if line != test.expect {
+			t.Fatalf("expected '%s', got '%s'", test.expect, line)
+		}
+	}
+}
+
+func TestSlowMonitor(t *testing.T) {
+	ms := newMonitors()
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	m := &monitor{liveQueue: newLiveQueue(conn, "monitor"), output: server.JSON}
+	ms.subs[m] = true
+	msg := &server.Message{Command: "ping", Values: []resp.Value{resp.StringValue("PING")}}
+	for i := 0; i < liveMaxMsgs; i++ {
+		ms.feed("addr", msg)
+	}
+	if m.closed || len(m.msgs) != liveMaxMsgs {
+		t.Fatalf("expected %d pending messages, got %d", liveMaxMsgs, len(m.msgs))
+	}
+	ms.feed("addr", msg)
+	if !m.closed || len(m.msgs) != 0 {
+		t.Fatalf("expected the monitor to be closed, got %d pending messages", len(m.msgs))
+	}
+}
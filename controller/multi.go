@@ -0,0 +1,378 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/controller/server"
+	"github.com/tidwall/tile38/geojson"
+)
+
+// Transactions
+//
+// MULTI starts queueing the commands of a connection, which are applied by
+// EXEC under a single lock. The writes of a transaction are written to the
+// aof as one unit, wrapped in MULTI and EXEC, which the aof loader and the
+// followers apply as a whole. The geofence notifications of a transaction are
+// coalesced so that each object is notified once with its final state.
+// WATCH aborts a transaction when one of the watched objects has changed
+// before EXEC.
+
+var errExecAbort = errors.New("EXECABORT Transaction discarded because of previous errors")
+
+// watchKey is an object that is being watched. An empty id watches all
+// objects in the collection.
+type watchKey struct {
+	key, id string
+}
+
+// multiT is the transaction state of a connection.
+type multiT struct {
+	on      bool              // in MULTI
+	msgs    []*server.Message // queued commands
+	errored bool              // a command could not be queued
+	watches map[watchKey]bool // watched objects
+	dirty   bool              // a watched object changed
+}
+
+// multiWrite returns true for the commands that may be queued and write to
+// the aof.
+func multiWrite(command string) bool {
+	switch command {
+	case "set", "del", "drop", "fset", "flushdb", "sethook", "pdelhook", "delhook",
+		"setchan", "pdelchan", "delchan",
+		"expire", "persist", "jset", "jdel", "pdel", "index", "delindex",
+		"sethistory", "delhistory", "histadd":
+		return true
+	}
+	return false
+}
+
+// multiRead returns true for the commands that may be queued and only read.
+func multiRead(command string) bool {
+	switch command {
+	case "get", "keys", "scan", "nearby", "within", "intersects", "hooks", "chans", "search",
+		"ttl", "bounds", "server", "info", "type", "jget", "indexes", "lastsave", "stats",
+		"history", "join":
+		return true
+	}
+	return false
+}
+
+// clientMulti returns the transaction state of a connection. The state is
+// only changed by the connection, except for the dirty flag which is set by
+// writers under the write lock.
+func (c *Controller) clientMulti(conn *server.Conn) *multiT {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if cc, ok := c.conns[conn]; ok {
+		return &cc.multi
+	}
+	return nil
+}
+
+// touchWatches marks the transactions that watch the objects of the details
+// as dirty. The caller must hold the write lock.
+func (c *Controller) touchWatches(ds []*commandDetailsT) {
+	if len(c.watching) == 0 {
+		return
+	}
+	for cc := range c.watching {
+		for _, d := range ds {
+			if d.command == "flushdb" {
+				cc.multi.dirty = true
+				break
+			}
+			if cc.multi.watches[watchKey{d.key, ""}] ||
+				(d.command == "drop" && cc.multi.watchesKey(d.key)) ||
+				cc.multi.watches[watchKey{d.key, d.id}] {
+				cc.multi.dirty = true
+				break
+			}
+		}
+	}
+}
+
+func (m *multiT) watchesKey(key string) bool {
+	for wk := range m.watches {
+		if wk.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// unwatch forgets the watched objects of a connection. The caller must hold
+// the write lock.
+func (c *Controller) unwatch(cc *clientConn) {
+	cc.multi.watches = nil
+	cc.multi.dirty = false
+	delete(c.watching, cc)
+}
+
+// cmdMulti handles MULTI, EXEC, DISCARD, WATCH, and UNWATCH. It manages its
+// own locks.
+func (c *Controller) cmdMulti(conn *server.Conn, msg *server.Message) (res string, err error) {
+	start := time.Now()
+	m := c.clientMulti(conn)
+	if m == nil {
+		return "", errors.New("transactions are not supported for this connection")
+	}
+	switch msg.Command {
+	case "multi":
+		if len(msg.Values) != 1 {
+			return "", errInvalidNumberOfArguments
+		}
+		if m.on {
+			return "", errors.New("MULTI calls can not be nested")
+		}
+		m.on = true
+		m.msgs = nil
+		m.errored = false
+	case "discard":
+		if len(msg.Values) != 1 {
+			return "", errInvalidNumberOfArguments
+		}
+		if !m.on {
+			return "", errors.New("DISCARD without MULTI")
+		}
+		c.mu.Lock()
+		c.unwatch(c.conns[conn])
+		c.mu.Unlock()
+		m.on = false
+		m.msgs = nil
+	case "watch":
+		vs := msg.Values[1:]
+		if len(vs) == 0 {
+			return "", errInvalidNumberOfArguments
+		}
+		if m.on {
+			return "", errors.New("WATCH inside MULTI is not allowed")
+		}
+		var keys []watchKey
+		for len(vs) > 0 {
+			var wk watchKey
+			var ok bool
+			if vs, wk.key, ok = tokenval(vs); !ok || wk.key == "" {
+				return "", errInvalidNumberOfArguments
+			}
+			if vs, wk.id, ok = tokenval(vs); !ok || wk.id == "" {
+				return "", errInvalidNumberOfArguments
+			}
+			if wk.id == "*" {
+				wk.id = ""
+			}
+			keys = append(keys, wk)
+		}
+		c.mu.Lock()
+		if m.watches == nil {
+			m.watches = make(map[watchKey]bool)
+		}
+		for _, wk := range keys {
+			m.watches[wk] = true
+		}
+		c.watching[c.conns[conn]] = true
+		c.mu.Unlock()
+	case "unwatch":
+		if len(msg.Values) != 1 {
+			return "", errInvalidNumberOfArguments
+		}
+		c.mu.Lock()
+		c.unwatch(c.conns[conn])
+		c.mu.Unlock()
+	case "exec":
+		if len(msg.Values) != 1 {
+			return "", errInvalidNumberOfArguments
+		}
+		if !m.on {
+			return "", errors.New("EXEC without MULTI")
+		}
+		msgs, errored := m.msgs, m.errored
+		m.on = false
+		m.msgs = nil
+		m.errored = false
+		if errored {
+			c.mu.Lock()
+			c.unwatch(c.conns[conn])
+			c.mu.Unlock()
+			return "", errExecAbort
+		}
+		return c.execMulti(conn, msg, msgs, start)
+	}
+	switch msg.OutputType {
+	case server.JSON:
+		return server.OKMessage(msg, start), nil
+	case server.RESP:
+		return "+OK\r\n", nil
+	}
+	return "", nil
+}
+
+// queueMulti queues a command of a connection that is in MULTI.
+func (c *Controller) queueMulti(m *multiT, msg *server.Message) (res string, err error) {
+	start := time.Now()
+	if !multiWrite(msg.Command) && !multiRead(msg.Command) {
+		m.errored = true
+		return "", errors.New("'" + msg.Command + "' is not allowed in a transaction")
+	}
+	m.msgs = append(m.msgs, msg)
+	switch msg.OutputType {
+	case server.JSON:
+		return `{"ok":true,"queued":true,"elapsed":"` + time.Now().Sub(start).String() + "\"}", nil
+	case server.RESP:
+		return "+QUEUED\r\n", nil
+	}
+	return "", nil
+}
+
+// execMulti applies the queued commands of a transaction under a single
+// lock. The reply contains the reply of each command.
+func (c *Controller) execMulti(conn *server.Conn, msg *server.Message, msgs []*server.Message, start time.Time) (res string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cc := c.conns[conn]
+	dirty := cc != nil && cc.multi.dirty
+	if cc != nil {
+		c.unwatch(cc)
+	}
+	if dirty {
+		switch msg.OutputType {
+		case server.JSON:
+			return "", errors.New("transaction aborted, a watched object changed")
+		case server.RESP:
+			return "*-1\r\n", nil
+		}
+		return "", nil
+	}
+	for _, qmsg := range msgs {
+		if multiWrite(qmsg.Command) {
+			if c.config.FollowHost != "" {
+				return "", errors.New("not the leader")
+			}
+			if c.config.ReadOnly {
+				return "", errors.New("read only")
+			}
+		}
+	}
+	var ress []string
+	var values []resp.Value
+	var ds []*commandDetailsT
+	for _, qmsg := range msgs {
+		qmsg.OutputType = msg.OutputType
+		res, d, err := c.command(qmsg, nil, conn)
+		if err != nil {
+			if err.Error() == "going live" {
+				err = errors.New("FENCE is not allowed in a transaction")
+			}
+			switch msg.OutputType {
+			case server.JSON:
+				res = `{"ok":false,"err":` + jsonString(err.Error()) + `}`
+			case server.RESP:
+				data, _ := resp.ErrorValue(errors.New("ERR " + err.Error())).MarshalRESP()
+				res = string(data)
+			}
+		} else if multiWrite(qmsg.Command) && d.updated {
+			d := d
+			values = append(values, resp.ArrayValue(qmsg.Values))
+			ds = append(ds, &d)
+		}
+		ress = append(ress, res)
+	}
+	if len(values) > 0 {
+		if err := c.writeAOFUnit(multiValues(values), coalesceDetails(ds)); err != nil {
+			return "", err
+		}
+	}
+	switch msg.OutputType {
+	case server.JSON:
+		return `{"ok":true,"results":[` + strings.Join(ress, ",") + `],"elapsed":"` + time.Now().Sub(start).String() + "\"}", nil
+	case server.RESP:
+		return "*" + strconv.Itoa(len(ress)) + "\r\n" + strings.Join(ress, ""), nil
+	}
+	return "", nil
+}
+
+// multiValues wraps commands in MULTI and EXEC.
+func multiValues(values []resp.Value) []resp.Value {
+	values = append([]resp.Value{resp.ArrayValue([]resp.Value{resp.StringValue("multi")})}, values...)
+	return append(values, resp.ArrayValue([]resp.Value{resp.StringValue("exec")}))
+}
+
+// detailsChain is the changes of an object in a transaction.
+type detailsChain struct {
+	first, last *commandDetailsT
+	set         bool // the chain has a set
+}
+
+// coalesceDetails merges the details of a transaction such that each object
+// has one set, fset, or del, with the state of the object before and after
+// the transaction. The details of other commands, such as drop, are kept in
+// order and are not merged across.
+func coalesceDetails(ds []*commandDetailsT) []*commandDetailsT {
+	var chains []*detailsChain
+	objs := make(map[watchKey]*detailsChain)
+	for _, d := range ds {
+		var leaves []*commandDetailsT
+		if d.parent {
+			leaves = d.children
+		} else {
+			leaves = []*commandDetailsT{d}
+		}
+		for _, d := range leaves {
+			switch d.command {
+			case "set", "fset", "del":
+				wk := watchKey{d.key, d.id}
+				if chain, ok := objs[wk]; ok {
+					chain.last = d
+					chain.set = chain.set || d.command == "set"
+					continue
+				}
+				chain := &detailsChain{first: d, last: d, set: d.command == "set"}
+				objs[wk] = chain
+				chains = append(chains, chain)
+			default:
+				objs = make(map[watchKey]*detailsChain)
+				chains = append(chains, &detailsChain{first: d, last: d})
+			}
+		}
+	}
+	var out []*commandDetailsT
+	for _, chain := range chains {
+		if chain.first == chain.last {
+			out = append(out, chain.first)
+			continue
+		}
+		preObj := detailsPreObject(chain.first)
+		d := *chain.last
+		switch d.command {
+		case "set":
+			d.oldObj = preObj
+		case "fset":
+			if chain.set {
+				d.command = "set"
+				d.oldObj = preObj
+			}
+		case "del":
+			if preObj == nil {
+				// the object was added and removed by the transaction
+				continue
+			}
+		}
+		out = append(out, &d)
+	}
+	return out
+}
+
+// detailsPreObject returns the object before a change.
+func detailsPreObject(d *commandDetailsT) geojson.Object {
+	switch d.command {
+	case "set":
+		return d.oldObj
+	case "fset", "del":
+		return d.obj
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/tidwall/tile38/controller/log"
+	"github.com/tidwall/tile38/controller/server"
+)
+
+const defaultReplBacklogSize = 1024 * 1024
+
+var errFullResync = errors.New("full resync required")
+
+// replT tracks the replication stream. The offset is the number of bytes
+// that have been written to the stream since it started. Unlike the aof
+// size it's never rewound by an aof shrink, which allows for a follower to
+// resume from the backlog after reconnecting.
+type replT struct {
+	id      string // replication id
+	offset  int    // replication offset
+	id2     string // previous replication id
+	offset2 int    // last offset of the previous replication id
+	backlog []byte // most recent bytes of the stream, ending at offset
+}
+
+// replInit continues the replication stream of the config, or starts a new
+// replication stream at the current aof size.
+func (c *Controller) replInit() {
+	if c.config.ReplID != "" {
+		c.repl = replT{id: c.config.ReplID, offset: c.aofsz + c.config.ReplShift}
+		return
+	}
+	c.repl = replT{id: randomKey(20), offset: c.aofsz}
+	c.replSave()
+}
+
+// replSave writes the replication id to the config. The offset is saved as
+// its distance from the aof size, which only changes when the stream is
+// reset or the aof is shrunk, so the config isn't written for every command.
+func (c *Controller) replSave() {
+	c.config.ReplID = c.repl.id
+	c.config.ReplShift = c.repl.offset - c.aofsz
+	if err := c.writeConfig(false); err != nil {
+		log.Errorf("failed to save the replication id: %v", err)
+	}
+}
+
+// replReset points the replication stream at a position in the stream of a
+// leader. It's used by followers after a full sync.
+func (c *Controller) replReset(id string, offset int) {
+	c.repl = replT{id: id, offset: offset}
+	c.replSave()
+}
+
+// replPromote starts a new replication stream for a follower that becomes a
+// leader. The stream of the previous leader remains available up to the
+// current offset, so the other followers of that leader may continue.
+func (c *Controller) replPromote() {
+	c.repl.id2 = c.repl.id
+	c.repl.offset2 = c.repl.offset
+	c.repl.id = randomKey(20)
+	c.replSave()
+}
+
+// replAppend adds bytes to the replication stream.
+func (c *Controller) replAppend(data []byte) {
+	c.repl.backlog = append(c.repl.backlog, data...)
+	c.repl.offset += len(data)
+	size := c.config.ReplBacklogSize
+	if size <= 0 {
+		size = defaultReplBacklogSize
+	}
+	if over := len(c.repl.backlog) - size; over > 0 {
+		c.repl.backlog = c.repl.backlog[over:]
+	}
+}
+
+// cmdPSync resumes a replication stream at an offset. The leader replies with
+// +CONTINUE followed by the missing commands when the offset is in the
+// backlog. Otherwise the follower must do a full sync using AOF.
+func (c *Controller) cmdPSync(msg *server.Message) (res string, err error) {
+	vs := msg.Values[1:]
+	var ok bool
+	var id, soffset string
+	if vs, id, ok = tokenval(vs); !ok || id == "" {
+		return "", errInvalidNumberOfArguments
+	}
+	if vs, soffset, ok = tokenval(vs); !ok || soffset == "" {
+		return "", errInvalidNumberOfArguments
+	}
+	if len(vs) != 0 {
+		return "", errInvalidNumberOfArguments
+	}
+	n, err := strconv.ParseUint(soffset, 10, 64)
+	if err != nil {
+		return "", errInvalidArgument(soffset)
+	}
+	offset := int(n)
+	if c.config.FollowHost != "" {
+		return "", errFullResync
+	}
+	if id != c.repl.id && (id != c.repl.id2 || offset > c.repl.offset2) {
+		return "", errFullResync
+	}
+	start := c.repl.offset - len(c.repl.backlog)
+	if offset < start || offset > c.repl.offset {
+		return "", errFullResync
+	}
+	log.Infof("partial resync at offset %d, %d bytes behind", offset, c.repl.offset-offset)
+	var s liveAOFSwitches
+	s.pos = int64(c.aofsz)
+	s.reply = "+CONTINUE\r\n"
+	s.backlog = append([]byte(nil), c.repl.backlog[offset-start:]...)
+	return "", s
+}
@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/controller/server"
+)
+
+func testPSync(c *Controller, id string, offset int) (liveAOFSwitches, error) {
+	msg := &server.Message{Command: "psync", OutputType: server.RESP}
+	msg.Values = resp.MultiBulkValue("psync", id, offset).Array()
+	_, err := c.cmdPSync(msg)
+	if s, ok := err.(liveAOFSwitches); ok {
+		return s, nil
+	}
+	return liveAOFSwitches{}, err
+}
+
+func TestPSync(t *testing.T) {
+	c := &Controller{dir: t.TempDir()}
+	c.config.ReplBacklogSize = 8
+	c.aofsz = 100
+	c.replInit()
+	c.replAppend([]byte("abcdef"))
+	c.aofsz += 6
+	if c.repl.offset != 106 || string(c.repl.backlog) != "abcdef" {
+		t.Fatalf("invalid stream %d %q", c.repl.offset, c.repl.backlog)
+	}
+	c.replAppend([]byte("ghij"))
+	c.aofsz += 4
+	if c.repl.offset != 110 || string(c.repl.backlog) != "cdefghij" {
+		t.Fatalf("invalid stream %d %q", c.repl.offset, c.repl.backlog)
+	}
+	s, err := testPSync(c, c.repl.id, 106)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.pos != 110 || string(s.backlog) != "ghij" || s.reply != "+CONTINUE\r\n" {
+		t.Fatalf("invalid switches %v %q %q", s.pos, s.backlog, s.reply)
+	}
+	for _, offset := range []int{101, 111} {
+		if _, err := testPSync(c, c.repl.id, offset); err != errFullResync {
+			t.Fatalf("%d: expected full resync, got %v", offset, err)
+		}
+	}
+	if _, err := testPSync(c, "other", 110); err != errFullResync {
+		t.Fatalf("expected full resync, got %v", err)
+	}
+
+	// the previous stream continues up to the offset of the promotion
+	prev := c.repl.id
+	c.replPromote()
+	c.replAppend([]byte("kl"))
+	c.aofsz += 2
+	if _, err := testPSync(c, prev, 108); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testPSync(c, prev, 112); err != errFullResync {
+		t.Fatalf("expected full resync, got %v", err)
+	}
+}
+
+// testAOFController returns a controller that writes to an aof in a
+// temporary directory.
+func testAOFController(t *testing.T) *Controller {
+	c := testController()
+	c.dir = t.TempDir()
+	c.fcond = sync.NewCond(&sync.Mutex{})
+	c.lcond = sync.NewCond(&sync.Mutex{})
+	c.exlist = []exitem{}
+	f, err := os.OpenFile(filepath.Join(c.dir, "appendonly.aof"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	c.f = f
+	return c
+}
+
+// testWrite runs a command and writes it to the aof.
+func testWrite(t *testing.T, c *Controller, args ...interface{}) {
+	msg := &server.Message{OutputType: server.RESP}
+	msg.Values = resp.MultiBulkValue(args[0].(string), args[1:]...).Array()
+	msg.Command = strings.ToLower(msg.Values[0].String())
+	_, d, err := c.command(msg, nil, nil)
+	if err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	if err := c.writeAOF(resp.ArrayValue(msg.Values), &d); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPSyncAfterExpiry(t *testing.T) {
+	leader, follower := testAOFController(t), testAOFController(t)
+	leader.replInit()
+	follower.config.FollowHost = "leader"
+	follower.replReset(leader.repl.id, leader.repl.offset)
+	for _, c := range []*Controller{leader, follower} {
+		testWrite(t, c, "SET", "fleet", "truck1", "EX", 0.01, "POINT", 33, -115)
+	}
+	time.Sleep(time.Millisecond * 20)
+
+	// the follower waits for the delete of the leader
+	if n, err := follower.purgeExpired(time.Now()); err != nil || n != 0 {
+		t.Fatalf("expected nothing purged, got %d %v", n, err)
+	}
+	if n, err := leader.purgeExpired(time.Now()); err != nil || n != 1 {
+		t.Fatalf("expected one purged, got %d %v", n, err)
+	}
+	s, err := testPSync(leader, follower.repl.id, follower.repl.offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(s.backlog), "*3\r\n$3\r\ndel\r\n") {
+		t.Fatalf("expected del, got %q", s.backlog)
+	}
+	testWrite(t, follower, "DEL", "fleet", "truck1")
+	if follower.repl.offset != leader.repl.offset {
+		t.Fatalf("expected offset %d, got %d", leader.repl.offset, follower.repl.offset)
+	}
+	if _, err := follower.purgeExpired(time.Now()); err != nil || len(follower.exlist) != 0 {
+		t.Fatalf("expected empty expires list, got %d %v", len(follower.exlist), err)
+	}
+}
+
+func TestReplRestart(t *testing.T) {
+	c := testAOFController(t)
+	c.replInit()
+	c.replAppend(make([]byte, 30))
+	c.aofsz += 30
+	// a shrink makes the aof smaller than the offset
+	c.aofsz = 10
+	c.replSave()
+	c.replAppend(make([]byte, 5))
+	c.aofsz += 5
+
+	restarted := testController()
+	restarted.dir = c.dir
+	if err := restarted.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	restarted.aofsz = c.aofsz
+	restarted.replInit()
+	if restarted.repl.id != c.repl.id || restarted.repl.offset != c.repl.offset {
+		t.Fatalf("expected %s %d, got %s %d", c.repl.id, c.repl.offset,
+			restarted.repl.id, restarted.repl.offset)
+	}
+	if _, err := testPSync(restarted, c.repl.id, c.repl.offset); err != nil {
+		t.Fatal(err)
+	}
+}
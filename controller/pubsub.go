@@ -0,0 +1,234 @@
+package controller
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/controller/glob"
+	"github.com/tidwall/tile38/controller/log"
+	"github.com/tidwall/tile38/controller/server"
+)
+
+// pubsub delivers the messages of the channels that are created with
+// SETCHAN to the connections that SUBSCRIBE or PSUBSCRIBE to them.
+type pubsub struct {
+	mu   sync.RWMutex
+	subs map[*subscriber]bool
+}
+
+// subscriber is a connection in subscription mode.
+type subscriber struct {
+	liveQueue
+	output   server.Type
+	channels map[string]bool
+	patterns map[string]bool
+}
+
+// liveSubscriptionSwitches is returned by SUBSCRIBE and PSUBSCRIBE to put a
+// connection into subscription mode.
+type liveSubscriptionSwitches struct {
+	pattern bool
+	names   []string
+}
+
+func (s liveSubscriptionSwitches) Error() string {
+	return "going live"
+}
+
+func newPubsub() *pubsub {
+	return &pubsub{subs: make(map[*subscriber]bool)}
+}
+
+// publish sends messages to the subscribers of a channel. It never blocks on
+// the subscriber connections.
+func (ps *pubsub) publish(channel string, msgs [][]byte) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	for sub := range ps.subs {
+		sub.cond.L.Lock()
+		if sub.channels[channel] {
+			for _, msg := range msgs {
+				sub.push(sub.encodeMessage("", channel, msg))
+			}
+		}
+		for pattern := range sub.patterns {
+			if match, _ := glob.Match(pattern, channel); match {
+				for _, msg := range msgs {
+					sub.push(sub.encodeMessage(pattern, channel, msg))
+				}
+			}
+		}
+		sub.cond.L.Unlock()
+	}
+}
+
+// encodeMessage encodes a channel message. The pattern is empty for
+// messages of channels that are subscribed by name.
+func (sub *subscriber) encodeMessage(pattern, channel string, msg []byte) []byte {
+	if sub.output == server.JSON {
+		return msg
+	}
+	var vals []resp.Value
+	if pattern == "" {
+		vals = append(vals, resp.StringValue("message"))
+	} else {
+		vals = append(vals, resp.StringValue("pmessage"), resp.StringValue(pattern))
+	}
+	vals = append(vals, resp.StringValue(channel), resp.BytesValue(msg))
+	data, _ := resp.ArrayValue(vals).MarshalRESP()
+	return data
+}
+
+// encodeReply encodes the reply of a SUBSCRIBE, PSUBSCRIBE, UNSUBSCRIBE, or
+// PUNSUBSCRIBE for a single channel or pattern.
+func (sub *subscriber) encodeReply(command, name string) []byte {
+	count := len(sub.channels) + len(sub.patterns)
+	if sub.output == server.JSON {
+		return []byte(`{"ok":true,"command":` + jsonString(command) +
+			`,"channel":` + jsonString(name) + `,"num":` + strconv.Itoa(count) + `}`)
+	}
+	var vals []resp.Value
+	vals = append(vals, resp.StringValue(command))
+	if name == "" {
+		vals = append(vals, resp.NullValue())
+	} else {
+		vals = append(vals, resp.StringValue(name))
+	}
+	vals = append(vals, resp.IntegerValue(count))
+	data, _ := resp.ArrayValue(vals).MarshalRESP()
+	return data
+}
+
+// subscribe adds channels or patterns to the subscriber. The caller must
+// hold the subscriber lock.
+func (sub *subscriber) subscribe(pattern bool, names []string) {
+	for _, name := range names {
+		if pattern {
+			sub.patterns[name] = true
+			sub.push(sub.encodeReply("psubscribe", name))
+		} else {
+			sub.channels[name] = true
+			sub.push(sub.encodeReply("subscribe", name))
+		}
+	}
+}
+
+// unsubscribe removes channels or patterns from the subscriber, or all of
+// them when no names are provided. The caller must hold the subscriber lock.
+func (sub *subscriber) unsubscribe(pattern bool, names []string) {
+	command, set := "unsubscribe", sub.channels
+	if pattern {
+		command, set = "punsubscribe", sub.patterns
+	}
+	if len(names) == 0 {
+		for name := range set {
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			sub.push(sub.encodeReply(command, ""))
+		}
+	}
+	for _, name := range names {
+		delete(set, name)
+		sub.push(sub.encodeReply(command, name))
+	}
+}
+
+func (c *Controller) cmdSubscribe(msg *server.Message) (res string, err error) {
+	if len(msg.Values) < 2 {
+		return "", errInvalidNumberOfArguments
+	}
+	var s liveSubscriptionSwitches
+	s.pattern = msg.Command == "psubscribe"
+	for _, v := range msg.Values[1:] {
+		s.names = append(s.names, v.String())
+	}
+	return "", s
+}
+
+// liveSubscription puts a connection into subscription mode. The connection
+// may subscribe and unsubscribe to more channels, and ping, until it quits.
+func (c *Controller) liveSubscription(s liveSubscriptionSwitches, conn net.Conn, rd *server.AnyReaderWriter, msg *server.Message, websocket bool) error {
+	connType := msg.ConnType
+	sub := &subscriber{
+		liveQueue: newLiveQueue(conn, "subscriber"),
+		output:    msg.OutputType,
+		channels:  make(map[string]bool),
+		patterns:  make(map[string]bool),
+	}
+	if websocket {
+		sub.output = server.JSON
+	}
+	sub.cond.L.Lock()
+	sub.subscribe(s.pattern, s.names)
+	sub.cond.L.Unlock()
+
+	c.pubsub.mu.Lock()
+	c.pubsub.subs[sub] = true
+	c.pubsub.mu.Unlock()
+	defer func() {
+		c.pubsub.mu.Lock()
+		delete(c.pubsub.subs, sub)
+		c.pubsub.mu.Unlock()
+		conn.Close()
+	}()
+
+	go func() {
+		defer func() {
+			sub.close()
+			conn.Close()
+		}()
+		for {
+			v, err := rd.ReadMessage()
+			if err != nil {
+				if err != io.EOF && !(websocket && err == io.ErrUnexpectedEOF) {
+					log.Error(err)
+				}
+				return
+			}
+			if v == nil {
+				continue
+			}
+			var names []string
+			for i := 1; i < len(v.Values); i++ {
+				names = append(names, v.Values[i].String())
+			}
+			sub.cond.L.Lock()
+			switch v.Command {
+			default:
+				err = errors.New("only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context")
+				if sub.output == server.JSON {
+					sub.push([]byte(`{"ok":false,"err":` + jsonString(err.Error()) + `}`))
+				} else {
+					data, _ := resp.ErrorValue(errors.New("ERR " + err.Error())).MarshalRESP()
+					sub.push(data)
+				}
+			case "subscribe", "psubscribe":
+				if len(names) == 0 {
+					sub.cond.L.Unlock()
+					continue
+				}
+				sub.subscribe(v.Command == "psubscribe", names)
+			case "unsubscribe", "punsubscribe":
+				sub.unsubscribe(v.Command == "punsubscribe", names)
+			case "ping":
+				if sub.output == server.JSON {
+					sub.push([]byte(`{"ok":true,"ping":"pong"}`))
+				} else {
+					sub.push([]byte("*2\r\n$4\r\npong\r\n$0\r\n\r\n"))
+				}
+			case "quit", "":
+				sub.cond.L.Unlock()
+				return
+			}
+			sub.cond.L.Unlock()
+		}
+	}()
+
+	sub.writeAll(sub.output == server.JSON, connType, websocket)
+	return nil
+}
@@ -0,0 +1,34 @@
+package controller
+
+import (
+	"net"
+	"testing"
+
+	"github.com/tidwall/tile38/controller/server"
+)
+
+func TestSlowSubscriber(t *testing.T) {
+	ps := newPubsub()
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	sub := &subscriber{
+		liveQueue: newLiveQueue(conn, "subscriber"),
+		output:    server.JSON,
+		channels:  map[string]bool{"ch1": true},
+		patterns:  make(map[string]bool),
+	}
+	ps.subs[sub] = true
+	for i := 0; i < liveMaxMsgs; i++ {
+		ps.publish("ch1", [][]byte{[]byte(`{}`)})
+	}
+	if sub.closed || len(sub.msgs) != liveMaxMsgs {
+		t.Fatalf("expected %d pending messages, got %d", liveMaxMsgs, len(sub.msgs))
+	}
+	ps.publish("ch1", [][]byte{[]byte(`{}`)})
+	if !sub.closed || len(sub.msgs) != 0 {
+		t.Fatalf("expected the subscriber to be closed, got %d pending messages", len(sub.msgs))
+	}
+	if _, err := conn.Write([]byte("x")); err == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+}
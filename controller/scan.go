@@ -2,9 +2,12 @@ package controller
 
 import (
 	"bytes"
+	"errors"
+	"sort"
 	"time"
 
 	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/controller/collection"
 	"github.com/tidwall/tile38/controller/glob"
 	"github.com/tidwall/tile38/controller/server"
 	"github.com/tidwall/tile38/geojson"
@@ -30,27 +33,104 @@ func (c *Controller) cmdScan(msg *server.Message) (res string, err error) {
 	if err != nil {
 		return "", err
 	}
-	sw, err := c.newScanWriter(wr, msg, s.key, s.output, s.precision, s.glob, false, s.cursor, s.limit, s.wheres, s.whereins, s.nofields)
+	sw, err := c.newScanWriter(wr, msg, s.key, s.output, s.precision, s.glob, false, s.cursor, s.limit, s.wheres, s.whereins, s.whereexprs, s.nofields)
 	if err != nil {
 		return "", err
 	}
+	sw.agg = s.agg
+	if sw.col != nil && s.orderby != "" && !sw.col.HasIndex(s.orderby) {
+		return "", errors.New("field '" + s.orderby + "' is not indexed")
+	}
+	if s.opaque {
+		// the cursors are offsets, unless opaque cursors are requested
+		if s.orderby != "" {
+			err = sw.useCursor(s.after, cursorField, s.desc, false, fieldCursorKey(sw, s.orderby))
+		} else {
+			err = sw.useCursor(s.after, cursorID, s.desc, false, idCursorKey)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
 	if msg.OutputType == server.JSON {
 		wr.WriteString(`{"ok":true`)
 	}
 	sw.writeHead()
 	if sw.col != nil {
 		if sw.output == outputCount && len(sw.wheres) == 0 &&
-			len(sw.whereins) == 0 && sw.globEverything == true {
+			len(sw.whereins) == 0 && len(sw.whereexprs) == 0 &&
+			sw.globEverything == true && s.after == nil {
 			count := sw.col.Count() - int(s.cursor)
 			if count < 0 {
 				count = 0
 			}
 			sw.count = uint64(count)
+		} else if s.orderby != "" {
+			iter := func(id string, o geojson.Object, fields []collection.FieldValue) bool {
+				return sw.writeObject(ScanWriterParams{
+					id:     id,
+					o:      o,
+					fields: fields,
+				})
+			}
+			var where *whereT
+			for i := range s.wheres {
+				if s.wheres[i].field == s.orderby && !s.wheres[i].str && !s.wheres[i].unbounded() {
+					where = &s.wheres[i]
+					break
+				}
+			}
+			if where != nil {
+				// narrow the walk to the range of the WHERE clause
+				sw.col.ScanIndexRange(where.field, where.min, where.max, s.desc, iter)
+			} else {
+				sw.col.ScanIndex(s.orderby, s.desc, iter)
+			}
+		} else if where := selectiveWhere(sw.col, s.wheres, 0); where != nil {
+			// the WHERE clause is selective, so the candidates are found
+			// with the field index, and are written in the order of the ids
+			var items []ScanWriterParams
+			sw.col.ScanIndexRange(where.field, where.min, where.max, false,
+				func(id string, o geojson.Object, fields []collection.FieldValue) bool {
+					items = append(items, ScanWriterParams{
+						id:     id,
+						o:      o,
+						fields: fields,
+					})
+					return true
+				},
+			)
+			sort.Slice(items, func(i, j int) bool {
+				if s.desc {
+					return items[i].id > items[j].id
+				}
+				return items[i].id < items[j].id
+			})
+			for _, item := range items {
+				if !sw.writeObject(item) {
+					break
+				}
+			}
 		} else {
 			g := glob.Parse(sw.globPattern, s.desc)
-			if g.Limits[0] == "" && g.Limits[1] == "" {
+			if s.after != nil {
+				// resume at the last id of the previous page
+				sw.col.ScanGreaterOrEqual(s.after.id, s.desc,
+					func(id string, o geojson.Object, fields []collection.FieldValue) bool {
+						if g.Limits[1] != "" && (!s.desc && id >= g.Limits[1] ||
+							s.desc && id <= g.Limits[1]) {
+							return false
+						}
+						return sw.writeObject(ScanWriterParams{
+							id:     id,
+							o:      o,
+							fields: fields,
+						})
+					},
+				)
+			} else if g.Limits[0] == "" && g.Limits[1] == "" {
 				sw.col.Scan(s.desc,
-					func(id string, o geojson.Object, fields []float64) bool {
+					func(id string, o geojson.Object, fields []collection.FieldValue) bool {
 						return sw.writeObject(ScanWriterParams{
 							id:     id,
 							o:      o,
@@ -60,7 +140,7 @@ func (c *Controller) cmdScan(msg *server.Message) (res string, err error) {
 				)
 			} else {
 				sw.col.ScanRange(g.Limits[0], g.Limits[1], s.desc,
-					func(id string, o geojson.Object, fields []float64) bool {
+					func(id string, o geojson.Object, fields []collection.FieldValue) bool {
 						return sw.writeObject(ScanWriterParams{
 							id:     id,
 							o:      o,
@@ -77,3 +157,20 @@ func (c *Controller) cmdScan(msg *server.Message) (res string, err error) {
 	}
 	return string(wr.Bytes()), nil
 }
+
+func idCursorKey(opts ScanWriterParams) cursorT {
+	return cursorT{kind: cursorID, id: opts.id}
+}
+
+// fieldCursorKey returns the cursor key function of a scan that is ordered
+// by an indexed field.
+func fieldCursorKey(sw *scanWriter, field string) func(opts ScanWriterParams) cursorT {
+	idx, ok := sw.fmap[field]
+	return func(opts ScanWriterParams) cursorT {
+		var value collection.FieldValue
+		if ok && idx < len(opts.fields) {
+			value = opts.fields[idx]
+		}
+		return cursorT{kind: cursorField, value: value, id: opts.id}
+	}
+}
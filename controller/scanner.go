@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"errors"
 	"math"
+	"sort"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/tidwall/resp"
 	"github.com/tidwall/tile38/controller/collection"
+	"github.com/tidwall/tile38/controller/expr"
 	"github.com/tidwall/tile38/controller/glob"
 	"github.com/tidwall/tile38/controller/server"
 	"github.com/tidwall/tile38/geojson"
@@ -26,6 +29,7 @@ const (
 	outputPoints
 	outputHashes
 	outputBounds
+	outputAgg
 )
 
 type scanWriter struct {
@@ -36,10 +40,14 @@ type scanWriter struct {
 	col            *collection.Collection
 	fmap           map[string]int
 	farr           []string
-	fvals          []float64
+	fvals          []collection.FieldValue
 	output         outputT
 	wheres         []whereT
 	whereins       []whereinT
+	whereexprs     []*expr.Expr
+	exprLookup     expr.Lookup
+	exprFields     []collection.FieldValue
+	exprObject     geojson.Object
 	numberItems    uint64
 	nofields       bool
 	cursor         uint64
@@ -54,12 +62,26 @@ type scanWriter struct {
 	fullFields     bool
 	values         []resp.Value
 	matchValues    bool
+	after          *cursorT                            // the cursor of the previous page
+	cursorKey      func(opts ScanWriterParams) cursorT // sort key of an item, nil for offsets
+	cursorDesc     bool                                // the keys are iterated in descending order
+	last           cursorT                             // key of the last item of the page
+	sorted         bool                                // items are sorted by key before writing
+	pending        []pendingItem                       // matching items of a sorted scan
+	agg            *aggregateT                         // the aggregates of the AGG output
+}
+
+// pendingItem is a matching item of a sorted scan, which is written when
+// the iteration is done.
+type pendingItem struct {
+	opts ScanWriterParams
+	key  cursorT
 }
 
 type ScanWriterParams struct {
 	id       string
 	o        geojson.Object
-	fields   []float64
+	fields   []collection.FieldValue
 	distance float64
 	noLock   bool
 }
@@ -67,17 +89,18 @@ type ScanWriterParams struct {
 func (c *Controller) newScanWriter(
 	wr *bytes.Buffer, msg *server.Message, key string, output outputT,
 	precision uint64, globPattern string, matchValues bool,
-	cursor, limit uint64, wheres []whereT, whereins []whereinT, nofields bool,
+	cursor, limit uint64, wheres []whereT, whereins []whereinT,
+	whereexprs []*expr.Expr, nofields bool,
 ) (
 	*scanWriter, error,
 ) {
 	switch output {
 	default:
 		return nil, errors.New("invalid output type")
-	case outputIDs, outputObjects, outputCount, outputBounds, outputPoints, outputHashes:
+	case outputIDs, outputObjects, outputCount, outputBounds, outputPoints, outputHashes, outputAgg:
 	}
 	if limit == 0 {
-		if output == outputCount {
+		if output == outputCount || output == outputAgg {
 			limit = math.MaxUint64
 		} else {
 			limit = limitItems
@@ -91,6 +114,7 @@ func (c *Controller) newScanWriter(
 		limit:       limit,
 		wheres:      wheres,
 		whereins:    whereins,
+		whereexprs:  whereexprs,
 		output:      output,
 		nofields:    nofields,
 		precision:   precision,
@@ -104,13 +128,100 @@ func (c *Controller) newScanWriter(
 			sw.globSingle = true
 		}
 	}
-	sw.col = c.getCol(key)
+	sw.useCol(c.getCol(key))
+	sw.exprLookup = sw.exprFieldValue
+	return sw, nil
+}
+
+// useCol makes the scan writer read from a collection other than the one of
+// the key, such as the collection of a history.
+func (sw *scanWriter) useCol(col *collection.Collection) {
+	sw.col = col
+	sw.fmap, sw.farr = nil, nil
 	if sw.col != nil {
 		sw.fmap = sw.col.FieldMap()
 		sw.farr = sw.col.FieldArr()
 	}
-	sw.fvals = make([]float64, len(sw.farr))
-	return sw, nil
+	sw.fvals = make([]collection.FieldValue, len(sw.farr))
+}
+
+// useCursor makes the scan writer page with opaque cursors of a kind. The
+// key function returns the sort key of an item. When sorted is true, the
+// items are iterated in no particular order and are sorted by key before
+// they are written, otherwise the iteration follows the order of the keys.
+func (sw *scanWriter) useCursor(after *cursorT, kind byte, desc, sorted bool,
+	key func(opts ScanWriterParams) cursorT,
+) error {
+	if after != nil {
+		if after.kind != kind {
+			return errors.New("invalid cursor")
+		}
+		if after.spatial() && time.Since(time.Unix(after.issued, 0)) > cursorExpiry {
+			return errCursorExpired
+		}
+	}
+	sw.after = after
+	sw.cursorKey = key
+	sw.cursorDesc = desc
+	sw.sorted = sorted && sw.output != outputCount && sw.output != outputAgg
+	return nil
+}
+
+// afterCursor returns true when the key of an item comes after the cursor
+// of the previous page.
+func (sw *scanWriter) afterCursor(key *cursorT) bool {
+	if sw.after == nil {
+		return true
+	}
+	if sw.cursorDesc {
+		return key.compare(sw.after) < 0
+	}
+	return key.compare(sw.after) > 0
+}
+
+// pushSorted adds a matching item to a sorted scan. Only the items that may
+// be on the page are kept.
+func (sw *scanWriter) pushSorted(item pendingItem) {
+	sw.pending = append(sw.pending, item)
+	keep := sw.cursor + sw.limit + 1
+	if keep < math.MaxInt32 && uint64(len(sw.pending)) >= keep*2 {
+		sw.sortPending()
+		sw.pending = sw.pending[:keep]
+	}
+}
+
+func (sw *scanWriter) sortPending() {
+	sort.Slice(sw.pending, func(i, j int) bool {
+		return sw.pending[i].key.compare(&sw.pending[j].key) < 0
+	})
+}
+
+// flushSorted writes the page of a sorted scan. The caller must hold the
+// scan writer lock.
+func (sw *scanWriter) flushSorted() {
+	sw.sortPending()
+	for i, item := range sw.pending {
+		if uint64(i) < sw.cursor {
+			continue
+		}
+		nfields, _ := sw.fieldMatch(item.opts.fields, item.opts.o)
+		if !sw.writeItem(item.opts, nfields) {
+			break
+		}
+	}
+	sw.pending = nil
+}
+
+// exprFieldValue returns the value of a field for the object that is
+// currently being matched against the WHEREEXPR expressions.
+func (sw *scanWriter) exprFieldValue(name string) collection.FieldValue {
+	if name == "z" {
+		return collection.NumberValue(sw.exprObject.CalculatedPoint().Z)
+	}
+	if idx, ok := sw.fmap[name]; ok && idx < len(sw.exprFields) {
+		return sw.exprFields[idx]
+	}
+	return collection.FieldValue{}
 }
 
 func (sw *scanWriter) hasFieldsOutput() bool {
@@ -148,7 +259,7 @@ func (sw *scanWriter) writeHead() {
 			sw.wr.WriteString(`,"bounds":[`)
 		case outputHashes:
 			sw.wr.WriteString(`,"hashes":[`)
-		case outputCount:
+		case outputCount, outputAgg:
 
 		}
 	case server.RESP:
@@ -158,10 +269,21 @@ func (sw *scanWriter) writeHead() {
 func (sw *scanWriter) writeFoot() {
 	sw.mu.Lock()
 	defer sw.mu.Unlock()
+	if sw.sorted {
+		sw.flushSorted()
+	}
 	cursor := sw.cursor + sw.numberItems
 	if !sw.hitLimit {
 		cursor = 0
 	}
+	var token string
+	if sw.hitLimit && sw.cursorKey != nil {
+		token = sw.last.encode()
+	}
+	if sw.output == outputAgg {
+		sw.writeAggFoot()
+		return
+	}
 	switch sw.msg.OutputType {
 	case server.JSON:
 		switch sw.output {
@@ -171,7 +293,11 @@ func (sw *scanWriter) writeFoot() {
 
 		}
 		sw.wr.WriteString(`,"count":` + strconv.FormatUint(sw.count, 10))
-		sw.wr.WriteString(`,"cursor":` + strconv.FormatUint(cursor, 10))
+		if token != "" {
+			sw.wr.WriteString(`,"cursor":` + jsonString(token))
+		} else {
+			sw.wr.WriteString(`,"cursor":` + strconv.FormatUint(cursor, 10))
+		}
 	case server.RESP:
 		sw.wr.Reset()
 		var data []byte
@@ -183,6 +309,9 @@ func (sw *scanWriter) writeFoot() {
 				resp.IntegerValue(int(cursor)),
 				resp.ArrayValue(sw.values),
 			}
+			if token != "" {
+				values[0] = resp.StringValue(token)
+			}
 			data, err = resp.ArrayValue(values).MarshalRESP()
 		}
 		if err != nil {
@@ -192,7 +321,22 @@ func (sw *scanWriter) writeFoot() {
 	}
 }
 
-func (sw *scanWriter) fieldMatch(fields []float64, o geojson.Object) ([]float64, bool) {
+// writeAggFoot writes the aggregates of the AGG output.
+func (sw *scanWriter) writeAggFoot() {
+	switch sw.msg.OutputType {
+	case server.JSON:
+		sw.agg.writeJSON(sw.wr)
+	case server.RESP:
+		sw.wr.Reset()
+		data, err := sw.agg.respValue().MarshalRESP()
+		if err != nil {
+			panic("Eek this is bad. Marshal resp should not fail.")
+		}
+		sw.wr.Write(data)
+	}
+}
+
+func (sw *scanWriter) fieldMatch(fields []collection.FieldValue, o geojson.Object) ([]collection.FieldValue, bool) {
 	var z float64
 	var gotz bool
 	if !sw.hasFieldsOutput() || sw.fullFields {
@@ -201,12 +345,12 @@ func (sw *scanWriter) fieldMatch(fields []float64, o geojson.Object) ([]float64,
 				if !gotz {
 					z = o.CalculatedPoint().Z
 				}
-				if !where.match(z) {
+				if !where.match(collection.NumberValue(z)) {
 					return sw.fvals, false
 				}
 				continue
 			}
-			var value float64
+			var value collection.FieldValue
 			idx, ok := sw.fmap[where.field]
 			if ok {
 				if len(fields) > idx {
@@ -218,7 +362,7 @@ func (sw *scanWriter) fieldMatch(fields []float64, o geojson.Object) ([]float64,
 			}
 		}
 		for _, wherein := range sw.whereins {
-			var value float64
+			var value collection.FieldValue
 			idx, ok := sw.fmap[wherein.field]
 			if ok {
 				if len(fields) > idx {
@@ -231,7 +375,7 @@ func (sw *scanWriter) fieldMatch(fields []float64, o geojson.Object) ([]float64,
 		}
 	} else {
 		for idx := range sw.farr {
-			var value float64
+			var value collection.FieldValue
 			if len(fields) > idx {
 				value = fields[idx]
 			}
@@ -242,12 +386,12 @@ func (sw *scanWriter) fieldMatch(fields []float64, o geojson.Object) ([]float64,
 				if !gotz {
 					z = o.CalculatedPoint().Z
 				}
-				if !where.match(z) {
+				if !where.match(collection.NumberValue(z)) {
 					return sw.fvals, false
 				}
 				continue
 			}
-			var value float64
+			var value collection.FieldValue
 			idx, ok := sw.fmap[where.field]
 			if ok {
 				value = sw.fvals[idx]
@@ -257,7 +401,7 @@ func (sw *scanWriter) fieldMatch(fields []float64, o geojson.Object) ([]float64,
 			}
 		}
 		for _, wherein := range sw.whereins {
-			var value float64
+			var value collection.FieldValue
 			idx, ok := sw.fmap[wherein.field]
 			if ok {
 				value = sw.fvals[idx]
@@ -267,10 +411,24 @@ func (sw *scanWriter) fieldMatch(fields []float64, o geojson.Object) ([]float64,
 			}
 		}
 	}
+	if len(sw.whereexprs) > 0 {
+		sw.exprFields, sw.exprObject = fields, o
+		match := true
+		for _, e := range sw.whereexprs {
+			if !e.Match(sw.exprLookup) {
+				match = false
+				break
+			}
+		}
+		sw.exprFields, sw.exprObject = nil, nil
+		if !match {
+			return sw.fvals, false
+		}
+	}
 	return sw.fvals, true
 }
 
-//id string, o geojson.Object, fields []float64, noLock bool
+// id string, o geojson.Object, fields []collection.FieldValue, noLock bool
 func (sw *scanWriter) writeObject(opts ScanWriterParams) bool {
 	if !opts.noLock {
 		sw.mu.Lock()
@@ -300,13 +458,34 @@ func (sw *scanWriter) writeObject(opts ScanWriterParams) bool {
 	if !ok {
 		return true
 	}
+	var key cursorT
+	if sw.after != nil || sw.sorted {
+		key = sw.cursorKey(opts)
+		if !sw.afterCursor(&key) {
+			return true
+		}
+	}
 	sw.count++
+	if sw.sorted {
+		sw.pushSorted(pendingItem{opts: opts, key: key})
+		return keepGoing
+	}
 	if sw.count <= sw.cursor {
 		return true
 	}
 	if sw.output == outputCount {
 		return sw.count < sw.limit
 	}
+	if sw.output == outputAgg {
+		sw.agg.add(opts.o, sw.fmap, opts.fields)
+		return sw.count < sw.limit
+	}
+	return sw.writeItem(opts, nfields) && keepGoing
+}
+
+// writeItem writes an item of the page. It returns false when the page is
+// full.
+func (sw *scanWriter) writeItem(opts ScanWriterParams, nfields []collection.FieldValue) bool {
 	switch sw.msg.OutputType {
 	case server.JSON:
 		var wr bytes.Buffer
@@ -323,11 +502,11 @@ func (sw *scanWriter) writeObject(opts ScanWriterParams) bool {
 					var i int
 					for field, idx := range sw.fmap {
 						if len(opts.fields) > idx {
-							if opts.fields[idx] != 0 {
+							if !opts.fields[idx].IsZero() {
 								if i > 0 {
 									jsfields += `,`
 								}
-								jsfields += jsonString(field) + ":" + strconv.FormatFloat(opts.fields[idx], 'f', -1, 64)
+								jsfields += jsonString(field) + ":" + fieldValueJSON(opts.fields[idx])
 								i++
 							}
 						}
@@ -341,7 +520,7 @@ func (sw *scanWriter) writeObject(opts ScanWriterParams) bool {
 					if i > 0 {
 						jsfields += ","
 					}
-					jsfields += strconv.FormatFloat(field, 'f', -1, 64)
+					jsfields += fieldValueJSON(field)
 				}
 				jsfields += `]`
 			}
@@ -422,7 +601,7 @@ func (sw *scanWriter) writeObject(opts ScanWriterParams) bool {
 				if len(fvs) > 0 {
 					fvals := make([]resp.Value, 0, len(fvs)*2)
 					for i, fv := range fvs {
-						fvals = append(fvals, resp.StringValue(fv.field), resp.StringValue(strconv.FormatFloat(fv.value, 'f', -1, 64)))
+						fvals = append(fvals, resp.StringValue(fv.field), resp.StringValue(fv.value.String()))
 						i++
 					}
 					vals = append(vals, resp.ArrayValue(fvals))
@@ -438,7 +617,10 @@ func (sw *scanWriter) writeObject(opts ScanWriterParams) bool {
 	sw.numberItems++
 	if sw.numberItems == sw.limit {
 		sw.hitLimit = true
+		if sw.cursorKey != nil {
+			sw.last = sw.cursorKey(opts)
+		}
 		return false
 	}
-	return keepGoing
+	return true
 }
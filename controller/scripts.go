@@ -0,0 +1,453 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/controller/server"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Scripts
+//
+// EVAL and EVALSHA run a Lua script under the write lock, such that the
+// script is atomic. The script calls commands with tile38.call, which raises
+// an error when the command fails, or with tile38.pcall, which returns the
+// error as a table. The writes of a script are written to the aof as one
+// transaction, so the followers apply the effects of the script rather than
+// running it again.
+
+// scriptTimeout is the longest time that a script may hold the lock.
+const scriptTimeout = time.Second * 5
+
+// The stacks of a script are bounded, such that a runaway recursion or unpack
+// raises an error rather than taking the memory of the server.
+const (
+	scriptCallStackSize    = 200        // nested function calls
+	scriptRegistrySize     = 1024 * 20  // initial data stack slots
+	scriptRegistryMaxSize  = 1024 * 256 // data stack slots that it may grow to
+	scriptRegistryGrowStep = 1024       // slots added each time that it grows
+)
+
+var errNoScript = errors.New("NOSCRIPT No matching script. Please use EVAL.")
+
+// scriptLibs are the Lua libraries that are available to scripts.
+var scriptLibs = []struct {
+	name string
+	open lua.LGFunction
+}{
+	{lua.BaseLibName, lua.OpenBase},
+	{lua.TabLibName, lua.OpenTable},
+	{lua.StringLibName, lua.OpenString},
+	{lua.MathLibName, lua.OpenMath},
+}
+
+// scriptUnsafe are the functions of the base library that are removed,
+// because they access the file system, load code, or change environments.
+var scriptUnsafe = []string{
+	"collectgarbage", "dofile", "load", "loadfile", "loadstring", "module",
+	"require", "print", "_printregs", "getfenv", "setfenv", "newproxy",
+}
+
+// scriptSHA returns the sha1 of a script, which is used by EVALSHA.
+func scriptSHA(script string) string {
+	sum := sha1.Sum([]byte(script))
+	return hex.EncodeToString(sum[:])
+}
+
+// compileScript compiles a script and caches it by its sha1. The caller must
+// hold the write lock.
+func (c *Controller) compileScript(script string) (sha string, proto *lua.FunctionProto, err error) {
+	sha = scriptSHA(script)
+	if proto, ok := c.scripts[sha]; ok {
+		return sha, proto, nil
+	}
+	chunk, err := parse.Parse(strings.NewReader(script), "@user_script")
+	if err != nil {
+		return "", nil, scriptError("Error compiling script", err)
+	}
+	proto, err = lua.Compile(chunk, "@user_script")
+	if err != nil {
+		return "", nil, scriptError("Error compiling script", err)
+	}
+	c.scripts[sha] = proto
+	return sha, proto, nil
+}
+
+// scriptError returns a single line error for a failed script, without the
+// stack traceback.
+func scriptError(prefix string, err error) error {
+	msg := err.Error()
+	if aerr, ok := err.(*lua.ApiError); ok {
+		msg = aerr.Object.String()
+	}
+	msg = strings.Join(strings.Fields(msg), " ")
+	return errors.New(prefix + ": " + msg)
+}
+
+// scriptCall is a running script.
+type scriptCall struct {
+	c      *Controller
+	conn   *server.Conn
+	values []resp.Value       // written commands
+	ds     []*commandDetailsT // details of the written commands
+}
+
+// newState returns a sandboxed Lua state with the KEYS and ARGV tables and
+// the tile38 module.
+func (sc *scriptCall) newState(keys, args []resp.Value) *lua.LState {
+	L := lua.NewState(lua.Options{
+		CallStackSize:    scriptCallStackSize,
+		RegistrySize:     scriptRegistrySize,
+		RegistryMaxSize:  scriptRegistryMaxSize,
+		RegistryGrowStep: scriptRegistryGrowStep,
+		SkipOpenLibs:     true,
+	})
+	for _, lib := range scriptLibs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range scriptUnsafe {
+		L.SetGlobal(name, lua.LNil)
+	}
+	L.SetGlobal("KEYS", luaStrings(L, keys))
+	L.SetGlobal("ARGV", luaStrings(L, args))
+	L.SetGlobal("tile38", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"call":         func(L *lua.LState) int { return sc.call(L, false) },
+		"pcall":        func(L *lua.LState) int { return sc.call(L, true) },
+		"sha1hex":      luaSHA1Hex,
+		"error_reply":  luaErrorReply,
+		"status_reply": luaStatusReply,
+	}))
+	return L
+}
+
+func luaStrings(L *lua.LState, vs []resp.Value) *lua.LTable {
+	tbl := L.CreateTable(len(vs), 0)
+	for _, v := range vs {
+		tbl.Append(lua.LString(v.String()))
+	}
+	return tbl
+}
+
+func luaSHA1Hex(L *lua.LState) int {
+	L.Push(lua.LString(scriptSHA(L.CheckString(1))))
+	return 1
+}
+
+func luaErrorReply(L *lua.LState) int {
+	tbl := L.NewTable()
+	tbl.RawSetString("err", lua.LString(L.CheckString(1)))
+	L.Push(tbl)
+	return 1
+}
+
+func luaStatusReply(L *lua.LState) int {
+	tbl := L.NewTable()
+	tbl.RawSetString("ok", lua.LString(L.CheckString(1)))
+	L.Push(tbl)
+	return 1
+}
+
+// call runs a command from a script. Errors are raised, or returned as a
+// table with an err field when protected is set.
+func (sc *scriptCall) call(L *lua.LState, protected bool) int {
+	res, err := sc.command(L)
+	if err != nil {
+		if !protected {
+			L.RaiseError("%s", err.Error())
+			return 0
+		}
+		tbl := L.NewTable()
+		tbl.RawSetString("err", lua.LString(err.Error()))
+		L.Push(tbl)
+		return 1
+	}
+	L.Push(res)
+	return 1
+}
+
+func (sc *scriptCall) command(L *lua.LState) (lua.LValue, error) {
+	n := L.GetTop()
+	if n == 0 {
+		return nil, errors.New("Please specify at least one argument for tile38.call()")
+	}
+	msg := &server.Message{OutputType: server.RESP}
+	for i := 1; i <= n; i++ {
+		switch v := L.Get(i).(type) {
+		case lua.LString, lua.LNumber:
+			msg.Values = append(msg.Values, resp.StringValue(v.String()))
+		default:
+			return nil, errors.New("Lua tile38.call() command arguments must be strings or numbers")
+		}
+	}
+	msg.Command = strings.ToLower(msg.Values[0].String())
+	write := multiWrite(msg.Command)
+	if !write && !multiRead(msg.Command) {
+		return nil, errors.New("'" + msg.Command + "' is not allowed from scripts")
+	}
+	if err := sc.c.aclAllowed(sc.c.connUser(sc.conn), msg); err != nil {
+		return nil, err
+	}
+	if write {
+		if sc.c.config.FollowHost != "" {
+			return nil, errors.New("not the leader")
+		}
+		if sc.c.config.ReadOnly {
+			return nil, errors.New("read only")
+		}
+	}
+	res, d, err := sc.c.command(msg, nil, sc.conn)
+	if err != nil {
+		if err.Error() == "going live" {
+			return nil, errors.New("FENCE is not allowed from scripts")
+		}
+		return nil, err
+	}
+	if write && d.updated {
+		sc.values = append(sc.values, resp.ArrayValue(msg.Values))
+		sc.ds = append(sc.ds, &d)
+	}
+	v, _, err := resp.NewReader(strings.NewReader(res)).ReadValue()
+	if err != nil {
+		return nil, err
+	}
+	return respToLua(L, v), nil
+}
+
+// respToLua converts a command reply to a Lua value. Status replies become a
+// table with an ok field, error replies a table with an err field, and null
+// replies false.
+func respToLua(L *lua.LState, v resp.Value) lua.LValue {
+	switch v.Type() {
+	case resp.Integer:
+		return lua.LNumber(v.Integer())
+	case resp.SimpleString:
+		tbl := L.NewTable()
+		tbl.RawSetString("ok", lua.LString(v.String()))
+		return tbl
+	case resp.Error:
+		tbl := L.NewTable()
+		tbl.RawSetString("err", lua.LString(v.String()))
+		return tbl
+	case resp.Array:
+		if v.IsNull() {
+			return lua.LFalse
+		}
+		vals := v.Array()
+		tbl := L.CreateTable(len(vals), 0)
+		for _, v := range vals {
+			tbl.Append(respToLua(L, v))
+		}
+		return tbl
+	}
+	if v.IsNull() {
+		return lua.LFalse
+	}
+	return lua.LString(v.String())
+}
+
+// luaToResp converts the result of a script to a reply. Numbers without a
+// fraction become integers, other numbers become strings, true becomes 1,
+// and false and nil become null. Tables are arrays, up to the first nil,
+// unless they have an err or ok field.
+func luaToResp(v lua.LValue) resp.Value {
+	switch v := v.(type) {
+	case lua.LNumber:
+		f := float64(v)
+		if f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return resp.IntegerValue(int(f))
+		}
+		return resp.FloatValue(f)
+	case lua.LString:
+		return resp.StringValue(string(v))
+	case lua.LBool:
+		if v {
+			return resp.IntegerValue(1)
+		}
+	case *lua.LTable:
+		if err, ok := v.RawGetString("err").(lua.LString); ok {
+			return resp.ErrorValue(errors.New(string(err)))
+		}
+		if ok, isok := v.RawGetString("ok").(lua.LString); isok {
+			return resp.SimpleStringValue(string(ok))
+		}
+		var vals []resp.Value
+		for i := 1; ; i++ {
+			v := v.RawGetInt(i)
+			if v == lua.LNil {
+				break
+			}
+			vals = append(vals, luaToResp(v))
+		}
+		return resp.ArrayValue(vals)
+	}
+	return resp.NullValue()
+}
+
+// respToJSON converts a script reply to json.
+func respToJSON(buf *bytes.Buffer, v resp.Value) {
+	switch v.Type() {
+	case resp.Integer:
+		buf.WriteString(strconv.FormatInt(int64(v.Integer()), 10))
+	case resp.Array:
+		buf.WriteByte('[')
+		for i, v := range v.Array() {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			respToJSON(buf, v)
+		}
+		buf.WriteByte(']')
+	default:
+		if v.IsNull() {
+			buf.WriteString("null")
+		} else {
+			buf.WriteString(jsonString(v.String()))
+		}
+	}
+}
+
+// cmdEval runs EVAL and EVALSHA. It manages its own locks.
+func (c *Controller) cmdEval(msg *server.Message, conn *server.Conn) (res string, err error) {
+	start := time.Now()
+	vs := msg.Values[1:]
+	var ok bool
+	var script, snumkeys string
+	if vs, script, ok = tokenval(vs); !ok || script == "" {
+		return "", errInvalidNumberOfArguments
+	}
+	if vs, snumkeys, ok = tokenval(vs); !ok || snumkeys == "" {
+		return "", errInvalidNumberOfArguments
+	}
+	numkeys, err := strconv.ParseUint(snumkeys, 10, 64)
+	if err != nil || numkeys > uint64(len(vs)) {
+		return "", errInvalidArgument(snumkeys)
+	}
+	keys, args := vs[:numkeys], vs[numkeys:]
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var proto *lua.FunctionProto
+	if msg.Command == "evalsha" {
+		if proto, ok = c.scripts[strings.ToLower(script)]; !ok {
+			return "", errNoScript
+		}
+	} else if _, proto, err = c.compileScript(script); err != nil {
+		return "", err
+	}
+
+	sc := &scriptCall{c: c, conn: conn}
+	L := sc.newState(keys, args)
+	defer L.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+	L.Push(L.NewFunctionFromProto(proto))
+	serr := L.PCall(0, 1, nil)
+
+	// the writes are kept when the script fails later on
+	if len(sc.values) > 0 {
+		if err := c.writeAOFUnit(multiValues(sc.values), coalesceDetails(sc.ds)); err != nil {
+			return "", err
+		}
+	}
+	if serr != nil {
+		if ctx.Err() != nil {
+			return "", errors.New("script timed out")
+		}
+		return "", scriptError("Error running script", serr)
+	}
+	v := luaToResp(L.Get(-1))
+	if v.Type() == resp.Error {
+		return "", errors.New(v.String())
+	}
+	switch msg.OutputType {
+	case server.JSON:
+		buf := &bytes.Buffer{}
+		buf.WriteString(`{"ok":true,"result":`)
+		respToJSON(buf, v)
+		buf.WriteString(`,"elapsed":"` + time.Now().Sub(start).String() + "\"}")
+		return buf.String(), nil
+	case server.RESP:
+		data, err := v.MarshalRESP()
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	return "", nil
+}
+
+// cmdScript runs SCRIPT LOAD, SCRIPT EXISTS, and SCRIPT FLUSH.
+func (c *Controller) cmdScript(msg *server.Message) (res string, err error) {
+	start := time.Now()
+	vs := msg.Values[1:]
+	var ok bool
+	var subcmd string
+	if vs, subcmd, ok = tokenval(vs); !ok || subcmd == "" {
+		return "", errInvalidNumberOfArguments
+	}
+	var result resp.Value
+	switch strings.ToLower(subcmd) {
+	default:
+		return "", errInvalidArgument(subcmd)
+	case "load":
+		var script string
+		if vs, script, ok = tokenval(vs); !ok || len(vs) != 0 {
+			return "", errInvalidNumberOfArguments
+		}
+		sha, _, err := c.compileScript(script)
+		if err != nil {
+			return "", err
+		}
+		result = resp.StringValue(sha)
+	case "exists":
+		if len(vs) == 0 {
+			return "", errInvalidNumberOfArguments
+		}
+		var vals []resp.Value
+		for _, v := range vs {
+			if _, ok := c.scripts[strings.ToLower(v.String())]; ok {
+				vals = append(vals, resp.IntegerValue(1))
+			} else {
+				vals = append(vals, resp.IntegerValue(0))
+			}
+		}
+		result = resp.ArrayValue(vals)
+	case "flush":
+		if len(vs) != 0 {
+			return "", errInvalidNumberOfArguments
+		}
+		c.scripts = make(map[string]*lua.FunctionProto)
+		result = resp.SimpleStringValue("OK")
+	}
+	switch msg.OutputType {
+	case server.JSON:
+		if result.Type() == resp.SimpleString {
+			return server.OKMessage(msg, start), nil
+		}
+		buf := &bytes.Buffer{}
+		buf.WriteString(`{"ok":true,"result":`)
+		respToJSON(buf, result)
+		buf.WriteString(`,"elapsed":"` + time.Now().Sub(start).String() + "\"}")
+		return buf.String(), nil
+	case server.RESP:
+		data, err := result.MarshalRESP()
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	return "", nil
+}
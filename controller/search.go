@@ -9,6 +9,7 @@ import (
 
 	"github.com/tidwall/resp"
 	"github.com/tidwall/tile38/controller/bing"
+	"github.com/tidwall/tile38/controller/collection"
 	"github.com/tidwall/tile38/controller/glob"
 	"github.com/tidwall/tile38/controller/server"
 	"github.com/tidwall/tile38/geojson"
@@ -25,6 +26,9 @@ type liveFenceSwitches struct {
 	roam             roamSwitches
 	knn              bool
 	groups           map[string]string
+	roamGroups       map[string]map[string]string // roam area groups by object and area
+	dwells           map[string]*dwellT
+	motions          map[string]*motionT
 }
 
 type roamSwitches struct {
@@ -70,8 +74,9 @@ func (c *Controller) cmdSearchArgs(cmd string, vs []resp.Value, types []string)
 			break
 		}
 	}
-	if !found && s.searchScanBaseTokens.fence && ltyp == "roam" && cmd == "nearby" {
-		// allow roaming for nearby fence searches.
+	if !found && s.searchScanBaseTokens.fence && ltyp == "roam" {
+		// allow roaming for fence searches. nearby roams by distance, while
+		// within and intersects roam on the areas of another collection.
 		found = true
 	}
 	if !found {
@@ -255,6 +260,9 @@ func (c *Controller) cmdSearchArgs(cmd string, vs []resp.Value, types []string)
 			return
 		}
 		s.roam.pattern = glob.IsGlob(s.roam.id)
+		if cmd != "nearby" {
+			break
+		}
 		var smeters string
 		if vs, smeters, ok = tokenval(vs); !ok || smeters == "" {
 			err = errInvalidNumberOfArguments
@@ -302,16 +310,45 @@ func (c *Controller) cmdNearby(msg *server.Message) (res string, err error) {
 		return "", s
 	}
 	minZ, maxZ := zMinMaxFromWheres(s.wheres)
-	sw, err := c.newScanWriter(wr, msg, s.key, s.output, s.precision, s.glob, false, s.cursor, s.limit, s.wheres, s.whereins, s.nofields)
+	sw, err := c.newScanWriter(wr, msg, s.key, s.output, s.precision, s.glob, false, s.cursor, s.limit, s.wheres, s.whereins, s.whereexprs, s.nofields)
 	if err != nil {
 		return "", err
 	}
+	sw.agg = s.agg
+	if !s.at.IsZero() {
+		// the objects as they were at a time
+		var area *geojson.BBox
+		if !s.knn {
+			bbox := geojson.BBoxesFromCenter(s.lat, s.lon, s.meters)
+			area = &bbox
+		}
+		col, err := c.historyCol(s.key, s.at, area)
+		if err != nil {
+			return "", err
+		}
+		sw.useCol(col)
+	}
+	if s.opaque {
+		// the items are in the order of the index, unless opaque cursors are
+		// requested, which page by distance. knn already is, the others are
+		// sorted.
+		center := geojson.Position{X: s.lon, Y: s.lat, Z: 0}
+		err = sw.useCursor(s.after, cursorDistance, false, !s.knn && s.sparse == 0,
+			func(opts ScanWriterParams) cursorT {
+				dist := opts.o.CalculatedPoint().DistanceTo(center)
+				return cursorT{kind: cursorDistance, nums: [2]float64{dist}, id: opts.id}
+			},
+		)
+		if err != nil {
+			return "", err
+		}
+	}
 	if msg.OutputType == server.JSON {
 		wr.WriteString(`{"ok":true`)
 	}
 	sw.writeHead()
 	if sw.col != nil {
-		iter := func(id string, o geojson.Object, fields []float64, dist *float64) bool {
+		iter := func(id string, o geojson.Object, fields []collection.FieldValue, dist *float64) bool {
 			if c.hasExpired(s.key, id) {
 				return true
 			}
@@ -334,9 +371,18 @@ func (c *Controller) cmdNearby(msg *server.Message) (res string, err error) {
 		}
 		if s.knn {
 			nearestNeighbors(sw, s.lat, s.lon, iter)
+		} else if where := selectiveWhere(sw.col, s.wheres, s.sparse); where != nil {
+			sw.col.ScanIndexRange(where.field, where.min, where.max, false,
+				func(id string, o geojson.Object, fields []collection.FieldValue) bool {
+					if !collection.NearbyMatch(o, s.lat, s.lon, s.meters, minZ, maxZ) {
+						return true
+					}
+					return iter(id, o, fields, nil)
+				},
+			)
 		} else {
 			sw.col.Nearby(s.sparse, s.lat, s.lon, s.meters, minZ, maxZ,
-				func(id string, o geojson.Object, fields []float64) bool {
+				func(id string, o geojson.Object, fields []collection.FieldValue) bool {
 					return iter(id, o, fields, nil)
 				},
 			)
@@ -364,16 +410,25 @@ func (c *Controller) cmdNearbyDistinct(msg *server.Message) (res string, err err
 		return "", s
 	}
 	// minZ, maxZ := zMinMaxFromWheres(s.wheres)
-	sw, err := c.newScanWriter(wr, msg, s.key, s.output, s.precision, s.glob, false, s.cursor, s.limit, s.wheres, s.whereins, s.nofields)
+	sw, err := c.newScanWriter(wr, msg, s.key, s.output, s.precision, s.glob, false, s.cursor, s.limit, s.wheres, s.whereins, s.whereexprs, s.nofields)
 	if err != nil {
 		return "", err
 	}
+	sw.agg = s.agg
+	if !s.at.IsZero() {
+		// the objects as they were at a time
+		col, err := c.historyCol(s.key, s.at, nil)
+		if err != nil {
+			return "", err
+		}
+		sw.useCol(col)
+	}
 	if msg.OutputType == server.JSON {
 		wr.WriteString(`{"ok":true`)
 	}
 	sw.writeHead()
 	if sw.col != nil {
-		iter := func(id string, o geojson.Object, fields []float64, dist *float64) bool {
+		iter := func(id string, o geojson.Object, fields []collection.FieldValue, dist *float64) bool {
 			if c.hasExpired(s.key, id) {
 				return true
 			}
@@ -408,28 +463,38 @@ func (c *Controller) cmdNearbyDistinct(msg *server.Message) (res string, err err
 type iterItem struct {
 	id     string
 	o      geojson.Object
-	fields []float64
+	fields []collection.FieldValue
 	dist   float64
 }
 
-func nearestNeighbors(sw *scanWriter, lat, lon float64, iter func(id string, o geojson.Object, fields []float64, dist *float64) bool) {
+func nearestNeighbors(sw *scanWriter, lat, lon float64, iter func(id string, o geojson.Object, fields []collection.FieldValue, dist *float64) bool) {
 	limit := sw.limit * 10
 	if limit > limitItems*10 {
 		limit = limitItems * 10
 	}
 	k := sw.cursor + limit
 	var items []iterItem
-	sw.col.NearestNeighbors(lat, lon, func(id string, o geojson.Object, fields []float64) bool {
+	sw.col.NearestNeighbors(lat, lon, func(id string, o geojson.Object, fields []collection.FieldValue) bool {
 		if k == 0 {
 			return false
 		}
 		dist := o.CalculatedPoint().DistanceTo(geojson.Position{X: lon, Y: lat, Z: 0})
+		if sw.after != nil {
+			// skip the items of the previous pages
+			key := cursorT{kind: cursorDistance, nums: [2]float64{dist}, id: id}
+			if !sw.afterCursor(&key) {
+				return true
+			}
+		}
 		items = append(items, iterItem{id: id, o: o, fields: fields, dist: dist})
 		k--
 		return true
 	})
 	sort.Slice(items, func(i, j int) bool {
-		return items[i].dist < items[j].dist
+		if items[i].dist != items[j].dist {
+			return items[i].dist < items[j].dist
+		}
+		return items[i].id < items[j].id
 	})
 	for _, item := range items {
 		if !iter(item.id, item.o, item.fields, &item.dist) {
@@ -439,7 +504,7 @@ func nearestNeighbors(sw *scanWriter, lat, lon float64, iter func(id string, o g
 }
 
 // 根据id来
-func nearestNeighborsDistinct(sw *scanWriter, lat, lon float64, distance float64, iter func(id string, o geojson.Object, fields []float64, dist *float64) bool) {
+func nearestNeighborsDistinct(sw *scanWriter, lat, lon float64, distance float64, iter func(id string, o geojson.Object, fields []collection.FieldValue, dist *float64) bool) {
 	limit := sw.limit * 10
 	if limit > limitItems*10 {
 		limit = limitItems * 10
@@ -447,7 +512,7 @@ func nearestNeighborsDistinct(sw *scanWriter, lat, lon float64, distance float64
 	k := sw.cursor + limit
 	var items []iterItem
 	ids := map[string]iterItem{}
-	sw.col.NearestNeighbors(lat, lon, func(id string, o geojson.Object, fields []float64) bool {
+	sw.col.NearestNeighbors(lat, lon, func(id string, o geojson.Object, fields []collection.FieldValue) bool {
 		if k == 0 {
 			return false
 		}
@@ -498,19 +563,110 @@ func (c *Controller) cmdWithinOrIntersects(cmd string, msg *server.Message) (res
 	if s.fence {
 		return "", s
 	}
-	sw, err := c.newScanWriter(wr, msg, s.key, s.output, s.precision, s.glob, false, s.cursor, s.limit, s.wheres, s.whereins, s.nofields)
+	sw, err := c.newScanWriter(wr, msg, s.key, s.output, s.precision, s.glob, false, s.cursor, s.limit, s.wheres, s.whereins, s.whereexprs, s.nofields)
 	if err != nil {
 		return "", err
 	}
+	sw.agg = s.agg
+	bbox := geojson.BBox{
+		Min: geojson.Position{X: s.minLon, Y: s.minLat},
+		Max: geojson.Position{X: s.maxLon, Y: s.maxLat},
+	}
+	if s.o != nil {
+		bbox = s.o.CalculatedBBox()
+	}
+	if sw.agg != nil && sw.agg.cells {
+		// the cells of GRID SIZE divide the area of the search
+		sw.agg.bounds = bbox
+	}
+	if !s.at.IsZero() {
+		// the objects as they were at a time
+		col, err := c.historyCol(s.key, s.at, &bbox)
+		if err != nil {
+			return "", err
+		}
+		sw.useCol(col)
+	}
+	if s.opaque {
+		// the items are in the order of the index, unless opaque cursors are
+		// requested, which page by position
+		err = sw.useCursor(s.after, cursorPoint, false, s.sparse == 0,
+			func(opts ScanWriterParams) cursorT {
+				point := opts.o.CalculatedPoint()
+				return cursorT{kind: cursorPoint, nums: [2]float64{point.X, point.Y}, id: opts.id}
+			},
+		)
+		if err != nil {
+			return "", err
+		}
+	}
 	if msg.OutputType == server.JSON {
 		wr.WriteString(`{"ok":true`)
 	}
 	sw.writeHead()
 	if sw.col != nil {
 		minZ, maxZ := zMinMaxFromWheres(s.wheres)
-		if cmd == "within" {
+		if c.gridCountable(s, sw) {
+			// only the counts of the cells are needed, so the parts of the
+			// index that are inside of the area and of one cell are counted
+			// without visiting their objects
+			bbox.Min.Z, bbox.Max.Z = minZ, maxZ
+			sw.col.SearchCount(bbox,
+				func(nodeBBox geojson.BBox) bool {
+					return nodeBBox.Min.X >= bbox.Min.X && nodeBBox.Max.X <= bbox.Max.X &&
+						nodeBBox.Min.Y >= bbox.Min.Y && nodeBBox.Max.Y <= bbox.Max.Y &&
+						sw.agg.countable(nodeBBox)
+				},
+				func(id string, o geojson.Object, fields []collection.FieldValue) bool {
+					var match bool
+					if cmd == "within" {
+						match = collection.WithinMatch(o, nil, s.minLat, s.minLon, s.maxLat, s.maxLon, minZ, maxZ)
+					} else {
+						match = collection.IntersectsMatch(o, nil, s.minLat, s.minLon, s.maxLat, s.maxLon, minZ, maxZ)
+					}
+					if !match {
+						return true
+					}
+					return sw.writeObject(ScanWriterParams{
+						id:     id,
+						o:      o,
+						fields: fields,
+						noLock: true,
+					})
+				},
+				func(nodeBBox geojson.BBox, n int) bool {
+					sw.agg.addCount(nodeBBox, n)
+					sw.count += uint64(n)
+					return true
+				},
+			)
+		} else if where := selectiveWhere(sw.col, s.wheres, s.sparse); where != nil {
+			// the WHERE clause is selective, walk the field index
+			sw.col.ScanIndexRange(where.field, where.min, where.max, false,
+				func(id string, o geojson.Object, fields []collection.FieldValue) bool {
+					if c.hasExpired(s.key, id) {
+						return true
+					}
+					var match bool
+					if cmd == "within" {
+						match = collection.WithinMatch(o, s.o, s.minLat, s.minLon, s.maxLat, s.maxLon, minZ, maxZ)
+					} else {
+						match = collection.IntersectsMatch(o, s.o, s.minLat, s.minLon, s.maxLat, s.maxLon, minZ, maxZ)
+					}
+					if !match {
+						return true
+					}
+					return sw.writeObject(ScanWriterParams{
+						id:     id,
+						o:      o,
+						fields: fields,
+						noLock: true,
+					})
+				},
+			)
+		} else if cmd == "within" {
 			sw.col.Within(s.sparse, s.o, s.minLat, s.minLon, s.maxLat, s.maxLon, minZ, maxZ,
-				func(id string, o geojson.Object, fields []float64) bool {
+				func(id string, o geojson.Object, fields []collection.FieldValue) bool {
 					if c.hasExpired(s.key, id) {
 						return true
 					}
@@ -524,7 +680,7 @@ func (c *Controller) cmdWithinOrIntersects(cmd string, msg *server.Message) (res
 			)
 		} else if cmd == "intersects" {
 			sw.col.Intersects(s.sparse, s.o, s.minLat, s.minLon, s.maxLat, s.maxLon, minZ, maxZ,
-				func(id string, o geojson.Object, fields []float64) bool {
+				func(id string, o geojson.Object, fields []collection.FieldValue) bool {
 					if c.hasExpired(s.key, id) {
 						return true
 					}
@@ -565,17 +721,30 @@ func (c *Controller) cmdSearch(msg *server.Message) (res string, err error) {
 	if err != nil {
 		return "", err
 	}
-	sw, err := c.newScanWriter(wr, msg, s.key, s.output, s.precision, s.glob, true, s.cursor, s.limit, s.wheres, s.whereins, s.nofields)
+	sw, err := c.newScanWriter(wr, msg, s.key, s.output, s.precision, s.glob, true, s.cursor, s.limit, s.wheres, s.whereins, s.whereexprs, s.nofields)
 	if err != nil {
 		return "", err
 	}
+	sw.agg = s.agg
+	if s.opaque {
+		// the cursors are offsets, unless opaque cursors are requested
+		err = sw.useCursor(s.after, cursorValue, s.desc, false,
+			func(opts ScanWriterParams) cursorT {
+				return cursorT{kind: cursorValue, value: collection.StringValue(opts.o.String()), id: opts.id}
+			},
+		)
+		if err != nil {
+			return "", err
+		}
+	}
 	if msg.OutputType == server.JSON {
 		wr.WriteString(`{"ok":true`)
 	}
 	sw.writeHead()
 	if sw.col != nil {
 		// log.Infof("search %v", msg)
-		if sw.output == outputCount && len(sw.wheres) == 0 && sw.globEverything == true {
+		if sw.output == outputCount && len(sw.wheres) == 0 &&
+			len(sw.whereexprs) == 0 && sw.globEverything == true && s.after == nil {
 			count := sw.col.Count() - int(s.cursor)
 			if count < 0 {
 				count = 0
@@ -583,9 +752,28 @@ func (c *Controller) cmdSearch(msg *server.Message) (res string, err error) {
 			sw.count = uint64(count)
 		} else {
 			g := glob.Parse(sw.globPattern, s.desc)
-			if g.Limits[0] == "" && g.Limits[1] == "" {
+			if s.after != nil {
+				// resume at the last value and id of the previous page
+				sw.globSingle = false
+				sw.col.SearchValuesGreaterOrEqual(s.after.value.Str, s.after.id, s.desc,
+					func(id string, o geojson.Object, fields []collection.FieldValue) bool {
+						if g.Limits[1] != "" {
+							val := o.String()
+							if !s.desc && val >= g.Limits[1] || s.desc && val <= g.Limits[1] {
+								return false
+							}
+						}
+						return sw.writeObject(ScanWriterParams{
+							id:     id,
+							o:      o,
+							fields: fields,
+							noLock: true,
+						})
+					},
+				)
+			} else if g.Limits[0] == "" && g.Limits[1] == "" {
 				sw.col.SearchValues(s.desc,
-					func(id string, o geojson.Object, fields []float64) bool {
+					func(id string, o geojson.Object, fields []collection.FieldValue) bool {
 						return sw.writeObject(ScanWriterParams{
 							id:     id,
 							o:      o,
@@ -599,7 +787,7 @@ func (c *Controller) cmdSearch(msg *server.Message) (res string, err error) {
 				// globSingle is only for ID matches, not values.
 				sw.globSingle = false
 				sw.col.SearchValuesRange(g.Limits[0], g.Limits[1], s.desc,
-					func(id string, o geojson.Object, fields []float64) bool {
+					func(id string, o geojson.Object, fields []collection.FieldValue) bool {
 						return sw.writeObject(ScanWriterParams{
 							id:     id,
 							o:      o,
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -66,15 +67,24 @@ func ListenAndServe(
 	closed func(conn *Conn),
 	lnp *net.Listener,
 	http bool,
+	tlsConfig *tls.Config,
 ) error {
 	ln, err := net.Listen("tcp", fmt.Sprintf("%s:%d", host, port))
 	if err != nil {
 		return err
 	}
+	if tlsConfig != nil {
+		// all protocols, including http and websockets, are served over tls
+		ln = tls.NewListener(ln, tlsConfig)
+	}
 	if lnp != nil {
 		*lnp = ln
 	}
-	log.Infof("The server is now ready to accept connections on port %d", port)
+	if tlsConfig != nil {
+		log.Infof("The server is now ready to accept TLS connections on port %d", port)
+	} else {
+		log.Infof("The server is now ready to accept connections on port %d", port)
+	}
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
@@ -125,7 +135,7 @@ func handleConn(
 
 		// Just closing connection if we have deprecated HTTP or WS connection,
 		// And --http-transport = false
-		if !http && (msg.ConnType == WebSocket || msg.ConnType == HTTP) {
+		if !http && msg != nil && (msg.ConnType == WebSocket || msg.ConnType == HTTP) {
 			conn.Close()
 			return
 		}
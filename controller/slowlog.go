@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/controller/server"
+)
+
+const (
+	slowlogMaxArgs   = 32  // arguments kept per entry
+	slowlogMaxArgLen = 128 // bytes kept per argument
+)
+
+// slowlogEntry is a command that exceeded the slowlog threshold.
+type slowlogEntry struct {
+	id    int
+	start time.Time
+	dur   time.Duration
+	args  []string
+	addr  string // client address
+	name  string // client name
+}
+
+// slowlogT is a ring of the most recent slow commands.
+type slowlogT struct {
+	mu      sync.Mutex
+	entries []slowlogEntry // oldest first
+	nextID  int
+	maxLen  int
+}
+
+// setMaxLen changes the number of entries that are kept.
+func (sl *slowlogT) setMaxLen(maxLen int) {
+	sl.mu.Lock()
+	sl.maxLen = maxLen
+	sl.trim()
+	sl.mu.Unlock()
+}
+
+// trim drops the oldest entries beyond maxLen. The caller must hold the
+// slowlog lock.
+func (sl *slowlogT) trim() {
+	if len(sl.entries) > sl.maxLen {
+		sl.entries = append([]slowlogEntry(nil), sl.entries[len(sl.entries)-sl.maxLen:]...)
+	}
+}
+
+// add logs a command.
+func (sl *slowlogT) add(msg *server.Message, start time.Time, dur time.Duration, addr, name string) {
+	var args []string
+	for i := range msg.Values {
+		if i == slowlogMaxArgs-1 && len(msg.Values) > slowlogMaxArgs {
+			args = append(args, "... ("+strconv.Itoa(len(msg.Values)-i)+" more arguments)")
+			break
+		}
+		arg := redactArg(msg, i)
+		if len(arg) > slowlogMaxArgLen {
+			arg = arg[:slowlogMaxArgLen] + "... (" + strconv.Itoa(len(arg)-slowlogMaxArgLen) + " more bytes)"
+		}
+		args = append(args, arg)
+	}
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.entries = append(sl.entries, slowlogEntry{
+		id: sl.nextID, start: start, dur: dur, args: args, addr: addr, name: name,
+	})
+	sl.nextID++
+	sl.trim()
+}
+
+// cmdSlowlog handles SLOWLOG GET, LEN, and RESET. It manages its own locks.
+func (c *Controller) cmdSlowlog(msg *server.Message) (res string, err error) {
+	start := time.Now()
+	vs := msg.Values[1:]
+	var ok bool
+	var subcmd string
+	if vs, subcmd, ok = tokenval(vs); !ok || subcmd == "" {
+		return "", errInvalidNumberOfArguments
+	}
+	sl := &c.slowlog
+	switch strings.ToLower(subcmd) {
+	default:
+		return "", errInvalidArgument(subcmd)
+	case "len":
+		if len(vs) != 0 {
+			return "", errInvalidNumberOfArguments
+		}
+		sl.mu.Lock()
+		n := len(sl.entries)
+		sl.mu.Unlock()
+		switch msg.OutputType {
+		case server.JSON:
+			return `{"ok":true,"len":` + strconv.Itoa(n) + `,"elapsed":"` + time.Now().Sub(start).String() + "\"}", nil
+		case server.RESP:
+			return ":" + strconv.Itoa(n) + "\r\n", nil
+		}
+	case "reset":
+		if len(vs) != 0 {
+			return "", errInvalidNumberOfArguments
+		}
+		sl.mu.Lock()
+		sl.entries = nil
+		sl.mu.Unlock()
+		switch msg.OutputType {
+		case server.JSON:
+			return server.OKMessage(msg, start), nil
+		case server.RESP:
+			return "+OK\r\n", nil
+		}
+	case "get":
+		count := 10
+		if len(vs) > 0 {
+			var scount string
+			if vs, scount, ok = tokenval(vs); !ok || len(vs) != 0 {
+				return "", errInvalidNumberOfArguments
+			}
+			n, err := strconv.ParseInt(scount, 10, 64)
+			if err != nil || n < -1 {
+				return "", errInvalidArgument(scount)
+			}
+			count = int(n)
+		}
+		sl.mu.Lock()
+		var entries []slowlogEntry
+		for i := len(sl.entries) - 1; i >= 0 && (count == -1 || len(entries) < count); i-- {
+			entries = append(entries, sl.entries[i])
+		}
+		sl.mu.Unlock()
+		switch msg.OutputType {
+		case server.JSON:
+			var ms []map[string]interface{}
+			for _, e := range entries {
+				ms = append(ms, map[string]interface{}{
+					"id":       e.id,
+					"time":     e.start.Unix(),
+					"duration": int64(e.dur / time.Microsecond),
+					"command":  e.args,
+					"addr":     e.addr,
+					"name":     e.name,
+				})
+			}
+			if ms == nil {
+				ms = []map[string]interface{}{}
+			}
+			data, err := json.Marshal(ms)
+			if err != nil {
+				return "", err
+			}
+			return `{"ok":true,"slowlog":` + string(data) + `,"elapsed":"` + time.Now().Sub(start).String() + "\"}", nil
+		case server.RESP:
+			vals := []resp.Value{}
+			for _, e := range entries {
+				var args []resp.Value
+				for _, arg := range e.args {
+					args = append(args, resp.StringValue(arg))
+				}
+				vals = append(vals, resp.ArrayValue([]resp.Value{
+					resp.IntegerValue(e.id),
+					resp.IntegerValue(int(e.start.Unix())),
+					resp.IntegerValue(int(e.dur / time.Microsecond)),
+					resp.ArrayValue(args),
+					resp.StringValue(e.addr),
+					resp.StringValue(e.name),
+				}))
+			}
+			data, err := resp.ArrayValue(vals).MarshalRESP()
+			if err != nil {
+				return "", err
+			}
+			return string(data), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,689 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/btree"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/controller/collection"
+	"github.com/tidwall/tile38/controller/log"
+	"github.com/tidwall/tile38/controller/server"
+	"github.com/tidwall/tile38/geojson"
+)
+
+// The snapshot file is a point-in-time copy of the dataset. It records the
+// size of the aof at the moment the snapshot started, which allows for the
+// startup to load the snapshot and then only replay the tail of the aof.
+//
+// Layout, all integers are little endian:
+//
+//	header  "TILE38SNAP" version:u32 time:i64 aofpos:i64 tailsize:u32 tailcrc:u32
+//	records type:u8 ...
+//	footer  snapEnd count:u64
+//
+// The dataset is read in chunks while the snapshot is written, and writes
+// are not blocked. The snapshot is still a copy of the dataset at aofpos,
+// because the writes keep the objects as they were before their first change
+// until the snapshot is done. See snapshotView.
+const (
+	snapshotMagic   = "TILE38SNAP"
+	snapshotVersion = 1
+	snapshotTail    = 4096 // aof bytes that are checksummed before aofpos
+)
+
+// snapshot record types
+const (
+	snapEnd     = 0 // end of the file
+	snapObject  = 1 // key, id, object, fields, and expiration
+	snapCommand = 2 // a command to replay, such as for hooks and indexes
+)
+
+// snapshot object types
+const (
+	snapString      = 0
+	snapSimplePoint = 1
+	snapGeoJSON     = 2
+)
+
+var errInvalidSnapshot = errors.New("invalid snapshot file")
+
+func (c *Controller) cmdSave(msg *server.Message) (res string, err error) {
+	start := time.Now()
+	if len(msg.Values) != 1 {
+		return "", errInvalidNumberOfArguments
+	}
+	if err := c.saveSnapshot(); err != nil {
+		return "", err
+	}
+	return server.OKMessage(msg, start), nil
+}
+
+func (c *Controller) cmdBgSave(msg *server.Message) (res string, err error) {
+	start := time.Now()
+	if len(msg.Values) != 1 {
+		return "", errInvalidNumberOfArguments
+	}
+	if c.snap != nil {
+		return "", errors.New("background save already in progress")
+	}
+	go func() {
+		if err := c.saveSnapshot(); err != nil {
+			log.Errorf("background save failed: %v", err)
+		}
+	}()
+	switch msg.OutputType {
+	case server.JSON:
+		return server.OKMessage(msg, start), nil
+	case server.RESP:
+		return "+Background saving started\r\n", nil
+	}
+	return "", nil
+}
+
+func (c *Controller) cmdLastSave(msg *server.Message) (res string, err error) {
+	start := time.Now()
+	if len(msg.Values) != 1 {
+		return "", errInvalidNumberOfArguments
+	}
+	var at int64
+	if !c.lastSave.IsZero() {
+		at = c.lastSave.Unix()
+	}
+	switch msg.OutputType {
+	case server.JSON:
+		return `{"ok":true,"lastsave":` + strconv.FormatInt(at, 10) +
+			`,"elapsed":"` + time.Now().Sub(start).String() + "\"}", nil
+	case server.RESP:
+		return ":" + strconv.FormatInt(at, 10) + "\r\n", nil
+	}
+	return "", nil
+}
+
+// aofTailChecksum returns the checksum of the aof bytes that come right
+// before pos. It's used to make sure that a snapshot belongs to the aof.
+func aofTailChecksum(f *os.File, pos int64) (size uint32, crc uint32, err error) {
+	n := int64(snapshotTail)
+	if pos < n {
+		n = pos
+	}
+	buf := make([]byte, n)
+	if _, err := f.ReadAt(buf, pos-n); err != nil {
+		return 0, 0, err
+	}
+	return uint32(n), crc32.ChecksumIEEE(buf), nil
+}
+
+type snapshotWriter struct {
+	wr    *bufio.Writer
+	count uint64
+	buf   [binary.MaxVarintLen64]byte
+}
+
+func (sw *snapshotWriter) writeUint(x uint64) {
+	n := binary.PutUvarint(sw.buf[:], x)
+	sw.wr.Write(sw.buf[:n])
+}
+
+func (sw *snapshotWriter) writeFloat(x float64) {
+	binary.LittleEndian.PutUint64(sw.buf[:8], math.Float64bits(x))
+	sw.wr.Write(sw.buf[:8])
+}
+
+func (sw *snapshotWriter) writeString(s string) {
+	sw.writeUint(uint64(len(s)))
+	sw.wr.WriteString(s)
+}
+
+func (sw *snapshotWriter) writeCommand(values []string) {
+	sw.wr.WriteByte(snapCommand)
+	sw.writeUint(uint64(len(values)))
+	for _, value := range values {
+		sw.writeString(value)
+	}
+	sw.count++
+}
+
+func (sw *snapshotWriter) writeObject(key, id string, obj geojson.Object,
+	fnames []string, fields []collection.FieldValue, ex int64,
+) {
+	sw.wr.WriteByte(snapObject)
+	sw.writeString(key)
+	sw.writeString(id)
+	switch obj := obj.(type) {
+	default:
+		if obj.IsGeometry() {
+			sw.wr.WriteByte(snapGeoJSON)
+			sw.writeString(obj.JSON())
+		} else {
+			sw.wr.WriteByte(snapString)
+			sw.writeString(obj.String())
+		}
+	case geojson.SimplePoint:
+		sw.wr.WriteByte(snapSimplePoint)
+		sw.writeFloat(obj.X)
+		sw.writeFloat(obj.Y)
+	}
+	var nfields int
+	for _, fvalue := range fields {
+		if !fvalue.IsZero() {
+			nfields++
+		}
+	}
+	sw.writeUint(uint64(nfields))
+	for i, fvalue := range fields {
+		if fvalue.IsZero() {
+			continue
+		}
+		sw.writeString(fnames[i])
+		sw.wr.WriteByte(byte(fvalue.Kind))
+		if fvalue.Kind == collection.String {
+			sw.writeString(fvalue.Str)
+		} else {
+			sw.writeFloat(fvalue.Num)
+		}
+	}
+	sw.writeUint(uint64(ex))
+	sw.count++
+}
+
+// snapshotView is the dataset at the moment that a snapshot started. The
+// collections are the ones that were in the database at that moment, which
+// are not changed when keys are dropped. The objects that change in those
+// collections are kept in before, as they were before their first change.
+type snapshotView struct {
+	keys     []string
+	cols     map[string]*collection.Collection
+	expires  map[string]map[string]time.Time
+	commands [][]string // indexes and histories, written before the objects
+	hooks    [][]string // hooks and channels, written after the objects
+	before   map[string]map[string]*snapshotObject
+
+	// the progress of the writing, protected by the controller lock
+	done    map[string]bool // keys that are completely written
+	key     string          // the key that is being written
+	last    string          // the last id of key that is written
+	started bool            // some ids of key are written
+}
+
+// snapshotObject is an object as it was when a snapshot started.
+type snapshotObject struct {
+	obj     geojson.Object // nil for an object that didn't exist
+	fields  []collection.FieldValue
+	ex      int64
+	written bool
+}
+
+// snapshotTouch keeps an object as it was when the snapshot started. It must
+// be called before an object or its expiration is changed, while holding the
+// write lock. Objects that are already written are not kept.
+func (c *Controller) snapshotTouch(key, id string) {
+	v := c.snap
+	if v == nil {
+		return
+	}
+	col := v.cols[key]
+	if col == nil || col != c.getCol(key) || v.done[key] ||
+		(key == v.key && v.started && id <= v.last) {
+		return
+	}
+	objs := v.before[key]
+	if objs == nil {
+		objs = make(map[string]*snapshotObject)
+		v.before[key] = objs
+	}
+	if _, ok := objs[id]; ok {
+		return
+	}
+	so := &snapshotObject{}
+	if obj, fields, ok := col.Get(id); ok {
+		so.obj = obj
+		so.fields = append([]collection.FieldValue(nil), fields...)
+		if at, ok := v.expires[key][id]; ok {
+			so.ex = at.UnixNano()
+		}
+	}
+	objs[id] = so
+}
+
+// beginSnapshot starts a snapshot of the dataset at the current aof position.
+// The caller must hold the write lock.
+func (c *Controller) beginSnapshot() (*snapshotView, error) {
+	if c.snap != nil {
+		return nil, errors.New("background save already in progress")
+	}
+	if c.shrinking {
+		return nil, errors.New("aof shrink in progress")
+	}
+	v := &snapshotView{
+		cols:    make(map[string]*collection.Collection),
+		expires: make(map[string]map[string]time.Time),
+		before:  make(map[string]map[string]*snapshotObject),
+		done:    make(map[string]bool),
+	}
+	c.scanGreaterOrEqual("", func(key string, col *collection.Collection) bool {
+		v.keys = append(v.keys, key)
+		v.cols[key] = col
+		v.expires[key] = c.expires[key]
+		return true
+	})
+	// field indexes go first, so that collections are indexed while loading
+	var keys []string
+	for key := range c.fieldIndexes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, field := range c.indexedFields(key) {
+			v.commands = append(v.commands, []string{"index", key, "field", field})
+		}
+	}
+	for _, key := range c.historyKeys() {
+		v.commands = append(v.commands, c.historyCommands(key)...)
+	}
+	var names []string
+	for name := range c.hooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		hook := c.hooks[name]
+		hook.mu.Lock()
+		v.hooks = append(v.hooks, hook.commandValues())
+		hook.mu.Unlock()
+	}
+	c.snap = v
+	return v, nil
+}
+
+// writeChunk writes the next chunk of objects of a snapshot. It returns false
+// when all objects are written. The database is read locked while writing
+// the chunk.
+func (c *Controller) writeChunk(v *snapshotView, sw *snapshotWriter) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(v.keys) == 0 {
+		return false
+	}
+	key := v.keys[0]
+	col := v.cols[key]
+	fnames := col.FieldArr()
+	exm := v.expires[key]
+	objs := v.before[key]
+	var count int
+	var more bool
+	start := ""
+	if v.key == key && v.started {
+		start = v.last
+	}
+	col.ScanGreaterOrEqual(start, false,
+		func(id string, obj geojson.Object, fields []collection.FieldValue) bool {
+			if v.key == key && v.started && id <= v.last {
+				return true
+			}
+			if count == maxids {
+				more = true
+				return false
+			}
+			v.key, v.last, v.started = key, id, true
+			count++
+			var ex int64
+			if so, ok := objs[id]; ok {
+				so.written = true
+				if so.obj == nil {
+					return true
+				}
+				obj, fields, ex = so.obj, so.fields, so.ex
+			} else if at, ok := exm[id]; ok {
+				ex = at.UnixNano()
+			}
+			sw.writeObject(key, id, obj, fnames, fields, ex)
+			return true
+		},
+	)
+	if more {
+		return true
+	}
+	// the objects that were deleted before they were written
+	var ids []string
+	for id, so := range objs {
+		if so.obj != nil && !so.written {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		so := objs[id]
+		sw.writeObject(key, id, so.obj, fnames, so.fields, so.ex)
+	}
+	v.done[key] = true
+	v.key, v.last, v.started = "", "", false
+	v.keys = v.keys[1:]
+	return len(v.keys) > 0
+}
+
+// saveSnapshot writes a snapshot of the dataset to disk. The database is
+// only locked while reading small chunks of data.
+func (c *Controller) saveSnapshot() error {
+	start := time.Now()
+	var aofpos int64
+	var tailsize, tailcrc uint32
+	var v *snapshotView
+	err := func() error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.snap != nil {
+			return errors.New("background save already in progress")
+		}
+		aofpos = int64(c.aofsz)
+		var err error
+		if tailsize, tailcrc, err = aofTailChecksum(c.f, aofpos); err != nil {
+			return err
+		}
+		v, err = c.beginSnapshot()
+		return err
+	}()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		c.mu.Lock()
+		c.snap = nil
+		c.mu.Unlock()
+	}()
+
+	tmp := path.Join(c.dir, "snapshot.tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(tmp) // no-op once renamed
+	}()
+	sw := &snapshotWriter{wr: bufio.NewWriterSize(f, maxchunk)}
+	var header [38]byte
+	copy(header[:], snapshotMagic)
+	binary.LittleEndian.PutUint32(header[10:], snapshotVersion)
+	binary.LittleEndian.PutUint64(header[14:], uint64(start.UnixNano()))
+	binary.LittleEndian.PutUint64(header[22:], uint64(aofpos))
+	binary.LittleEndian.PutUint32(header[30:], tailsize)
+	binary.LittleEndian.PutUint32(header[34:], tailcrc)
+	sw.wr.Write(header[:])
+	if err := v.write(c, sw); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path.Join(c.dir, "snapshot")); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.lastSave = start
+	c.mu.Unlock()
+	log.Infof("DB saved on disk: %d records, %v", sw.count, time.Now().Sub(start))
+	return nil
+}
+
+// write writes the records and the footer of a snapshot.
+func (v *snapshotView) write(c *Controller, sw *snapshotWriter) error {
+	for _, cmd := range v.commands {
+		sw.writeCommand(cmd)
+	}
+	for c.writeChunk(v, sw) {
+	}
+	for _, cmd := range v.hooks {
+		sw.writeCommand(cmd)
+	}
+	sw.wr.WriteByte(snapEnd)
+	binary.LittleEndian.PutUint64(sw.buf[:8], sw.count)
+	sw.wr.Write(sw.buf[:8])
+	return sw.wr.Flush()
+}
+
+type snapshotReader struct {
+	rd  *bufio.Reader
+	buf [8]byte
+}
+
+func (sr *snapshotReader) readUint() (uint64, error) {
+	return binary.ReadUvarint(sr.rd)
+}
+
+func (sr *snapshotReader) readUint64() (uint64, error) {
+	if _, err := io.ReadFull(sr.rd, sr.buf[:8]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(sr.buf[:8]), nil
+}
+
+func (sr *snapshotReader) readFloat() (float64, error) {
+	x, err := sr.readUint64()
+	return math.Float64frombits(x), err
+}
+
+func (sr *snapshotReader) readString() (string, error) {
+	n, err := sr.readUint()
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, int(n))
+	if _, err := io.ReadFull(sr.rd, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// loadSnapshot loads the snapshot file, if any, and positions the aof at the
+// point where the snapshot was taken. A snapshot that does not belong to the
+// aof is ignored and the whole aof is loaded instead.
+func (c *Controller) loadSnapshot() error {
+	f, err := os.Open(path.Join(c.dir, "snapshot"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	start := time.Now()
+	sr := &snapshotReader{rd: bufio.NewReaderSize(f, maxchunk)}
+	var header [38]byte
+	if _, err := io.ReadFull(sr.rd, header[:]); err != nil ||
+		string(header[:10]) != snapshotMagic ||
+		binary.LittleEndian.Uint32(header[10:]) != snapshotVersion {
+		log.Warnf("ignoring snapshot: %v", errInvalidSnapshot)
+		return nil
+	}
+	at := time.Unix(0, int64(binary.LittleEndian.Uint64(header[14:])))
+	aofpos := int64(binary.LittleEndian.Uint64(header[22:]))
+	tailsize := binary.LittleEndian.Uint32(header[30:])
+	tailcrc := binary.LittleEndian.Uint32(header[34:])
+	fi, err := c.f.Stat()
+	if err != nil {
+		return err
+	}
+	if aofpos > fi.Size() {
+		log.Warnf("ignoring snapshot: aof is smaller than the snapshot position")
+		return nil
+	}
+	if size, crc, err := aofTailChecksum(c.f, aofpos); err != nil {
+		return err
+	} else if size != tailsize || crc != tailcrc {
+		log.Warnf("ignoring snapshot: aof does not match the snapshot")
+		return nil
+	}
+	count, err := c.readSnapshot(sr)
+	if err != nil {
+		// start over with a full aof load
+		log.Warnf("ignoring snapshot: %v", err)
+		c.resetDataset()
+		return nil
+	}
+	if _, err := c.f.Seek(aofpos, 0); err != nil {
+		return err
+	}
+	c.aofsz = int(aofpos)
+	c.lastSave = at
+	log.Infof("snapshot loaded %d records: %.2fs", count,
+		float64(time.Now().Sub(start))/float64(time.Second))
+	return nil
+}
+
+func (c *Controller) readSnapshot(sr *snapshotReader) (count uint64, err error) {
+	var msg server.Message
+	var fnames []string
+	var fvalues []collection.FieldValue
+	for {
+		typ, err := sr.rd.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch typ {
+		default:
+			return 0, errInvalidSnapshot
+		case snapEnd:
+			n, err := sr.readUint64()
+			if err != nil {
+				return 0, err
+			}
+			if n != count {
+				return 0, errInvalidSnapshot
+			}
+			return count, nil
+		case snapCommand:
+			n, err := sr.readUint()
+			if err != nil {
+				return 0, err
+			}
+			msg.Values = msg.Values[:0]
+			for i := uint64(0); i < n; i++ {
+				value, err := sr.readString()
+				if err != nil {
+					return 0, err
+				}
+				msg.Values = append(msg.Values, resp.StringValue(value))
+			}
+			if len(msg.Values) == 0 {
+				return 0, errInvalidSnapshot
+			}
+			msg.Command = strings.ToLower(msg.Values[0].String())
+			if _, _, err := c.command(&msg, nil, nil); err != nil {
+				return 0, err
+			}
+		case snapObject:
+			key, err := sr.readString()
+			if err != nil {
+				return 0, err
+			}
+			id, err := sr.readString()
+			if err != nil {
+				return 0, err
+			}
+			otyp, err := sr.rd.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			var obj geojson.Object
+			switch otyp {
+			default:
+				return 0, errInvalidSnapshot
+			case snapString:
+				s, err := sr.readString()
+				if err != nil {
+					return 0, err
+				}
+				obj = geojson.String(s)
+			case snapSimplePoint:
+				var p geojson.SimplePoint
+				if p.X, err = sr.readFloat(); err != nil {
+					return 0, err
+				}
+				if p.Y, err = sr.readFloat(); err != nil {
+					return 0, err
+				}
+				obj = p
+			case snapGeoJSON:
+				s, err := sr.readString()
+				if err != nil {
+					return 0, err
+				}
+				if obj, err = geojson.ObjectJSON(s); err != nil {
+					return 0, err
+				}
+			}
+			nfields, err := sr.readUint()
+			if err != nil {
+				return 0, err
+			}
+			fnames, fvalues = fnames[:0], fvalues[:0]
+			for i := uint64(0); i < nfields; i++ {
+				name, err := sr.readString()
+				if err != nil {
+					return 0, err
+				}
+				kind, err := sr.rd.ReadByte()
+				if err != nil {
+					return 0, err
+				}
+				value := collection.FieldValue{Kind: collection.FieldKind(kind)}
+				if value.Kind == collection.String {
+					value.Str, err = sr.readString()
+				} else {
+					value.Num, err = sr.readFloat()
+				}
+				if err != nil {
+					return 0, err
+				}
+				fnames = append(fnames, name)
+				fvalues = append(fvalues, value)
+			}
+			ex, err := sr.readUint()
+			if err != nil {
+				return 0, err
+			}
+			col := c.getCol(key)
+			if col == nil {
+				col = collection.New()
+				c.setCol(key, col)
+			}
+			if len(fnames) > 0 {
+				col.ReplaceOrInsert(id, obj, fnames, fvalues)
+			} else {
+				col.ReplaceOrInsert(id, obj, nil, nil)
+			}
+			if ex != 0 {
+				c.expireAt(key, id, time.Unix(0, int64(ex)))
+			}
+		}
+		count++
+	}
+}
+
+// resetDataset clears everything that may have been loaded from a snapshot.
+func (c *Controller) resetDataset() {
+	c.cols = btree.New(16, 0)
+	c.clearAllExpires()
+	for _, hook := range c.hooks {
+		hook.Close()
+	}
+	c.hooks = make(map[string]*Hook)
+	c.hookcols = make(map[string]map[string]*Hook)
+	c.resetDwellHooks()
+	c.fieldIndexes = make(map[string]map[string]bool)
+	c.histories = make(map[string]*historyT)
+}
@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/btree"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/controller/collection"
+	"github.com/tidwall/tile38/controller/server"
+	"github.com/tidwall/tile38/geojson"
+)
+
+func testController() *Controller {
+	return &Controller{
+		cols:         btree.New(16, 0),
+		expires:      make(map[string]map[string]time.Time),
+		hooks:        make(map[string]*Hook),
+		hookcols:     make(map[string]map[string]*Hook),
+		fieldIndexes: make(map[string]map[string]bool),
+	}
+}
+
+func testDo(t *testing.T, c *Controller, args ...interface{}) {
+	msg := &server.Message{OutputType: server.RESP}
+	msg.Values = resp.MultiBulkValue(args[0].(string), args[1:]...).Array()
+	msg.Command = strings.ToLower(msg.Values[0].String())
+	if _, _, err := c.command(msg, nil, nil); err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+}
+
+// testDump returns the objects of the database, one line per object.
+func testDump(c *Controller) string {
+	var lines []string
+	c.scanGreaterOrEqual("", func(key string, col *collection.Collection) bool {
+		fnames := col.FieldArr()
+		col.Scan(false, func(id string, obj geojson.Object, fields []collection.FieldValue) bool {
+			line := key + " " + id + " " + obj.String()
+			for i, value := range fields {
+				if !value.IsZero() {
+					line += " " + fnames[i] + "=" + value.String()
+				}
+			}
+			if at, ok := c.getExpires(key, id); ok {
+				line += " ex=" + at.Format(time.RFC3339Nano)
+			}
+			lines = append(lines, line)
+			return true
+		})
+		return true
+	})
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func TestSnapshotWhileWriting(t *testing.T) {
+	c := testController()
+	for i := 0; i < maxids*4; i++ {
+		testDo(t, c, "SET", "fleet", fmt.Sprintf("truck%03d", i), "FIELD", "speed", i, "POINT", 33, -115)
+	}
+	testDo(t, c, "SET", "fleet", "truck100", "EX", 3600, "POINT", 33, -115)
+	testDo(t, c, "SET", "zones", "zone1", "STRING", "one")
+	c.mu.Lock()
+	v, err := c.beginSnapshot()
+	c.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := testDump(c)
+
+	// every kind of write, before and after the objects are written
+	writes := [][]interface{}{
+		{"DEL", "fleet", "truck001"},
+		{"DEL", "fleet", "truck120"},
+		{"SET", "fleet", "truck002", "POINT", 34, -116},
+		{"SET", "fleet", "truck121", "POINT", 34, -116},
+		{"SET", "fleet", "truck000a", "POINT", 34, -116},
+		{"SET", "fleet", "truck999", "POINT", 34, -116},
+		{"FSET", "fleet", "truck003", "speed", 99},
+		{"FSET", "fleet", "truck122", "speed", 99},
+		{"EXPIRE", "fleet", "truck004", 60},
+		{"PERSIST", "fleet", "truck100"},
+		{"PDEL", "fleet", "truck05*"},
+		{"JSET", "zones", "zone2", "name", "two"},
+		{"DROP", "zones"},
+		{"SET", "zones", "zone1", "STRING", "new"},
+	}
+	var buf bytes.Buffer
+	sw := &snapshotWriter{wr: bufio.NewWriter(&buf)}
+	for c.writeChunk(v, sw) {
+		c.mu.Lock()
+		for j := 0; j < 4 && len(writes) > 0; j++ {
+			testDo(t, c, writes[0]...)
+			writes = writes[1:]
+		}
+		c.mu.Unlock()
+	}
+	if len(writes) != 0 {
+		t.Fatalf("expected all writes during the snapshot, %d left", len(writes))
+	}
+	if err := v.write(c, sw); err != nil {
+		t.Fatal(err)
+	}
+	c.snap = nil
+
+	restored := testController()
+	if _, err := restored.readSnapshot(&snapshotReader{rd: bufio.NewReader(&buf)}); err != nil {
+		t.Fatal(err)
+	}
+	if dump := testDump(restored); dump != expect {
+		t.Fatalf("expected\n%s\ngot\n%s", expect, dump)
+	}
+}
+
+func TestSnapshotAfterAOFShrink(t *testing.T) {
+	c := testAOFController(t)
+	for i := 0; i < 10; i++ {
+		testWrite(t, c, "SET", "fleet", "truck1", "POINT", 33, -115+float64(i)/100)
+	}
+	if err := c.saveSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	testWrite(t, c, "SET", "fleet", "truck2", "POINT", 34, -115)
+	c.aofshrink()
+	expect := testDump(c)
+
+	// the new snapshot is at the end of the shrunken aof
+	restored := testAOFController(t)
+	restored.dir = c.dir
+	restored.f = c.f
+	if err := restored.loadSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if restored.aofsz != c.aofsz {
+		t.Fatalf("expected aof position %d, got %d", c.aofsz, restored.aofsz)
+	}
+	if dump := testDump(restored); dump != expect {
+		t.Fatalf("expected\n%s\ngot\n%s", expect, dump)
+	}
+}
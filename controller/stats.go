@@ -85,8 +85,11 @@ func (c *Controller) cmdServer(msg *server.Message) (res string, err error) {
 	m["http_transport"] = c.http
 	m["pid"] = os.Getpid()
 	m["aof_size"] = c.aofsz
+	m["repl_id"] = c.repl.id
+	m["repl_offset"] = c.repl.offset
 	m["num_collections"] = c.cols.Len()
 	m["num_hooks"] = len(c.hooks)
+	m["hooks_delivered"], m["hooks_failed"], m["hooks_dead_lettered"] = c.hookTotals()
 	sz := 0
 	c.cols.Ascend(func(item btree.Item) bool {
 		col := item.(*collectionT).Collection
@@ -183,7 +186,7 @@ func (c *Controller) writeInfoCluster(w *bytes.Buffer) {
 
 func (c *Controller) cmdInfo(msg *server.Message) (res string, err error) {
 	start := time.Now()
-	sections := []string{"server", "clients", "memory", "persistence", "stats", "replication", "cpu", "cluster", "keyspace"}
+	sections := []string{"server", "clients", "memory", "persistence", "stats", "replication", "cpu", "cluster", "keyspace", "hooks"}
 	switch len(msg.Values) {
 	default:
 		return "", errInvalidNumberOfArguments
@@ -194,7 +197,7 @@ func (c *Controller) cmdInfo(msg *server.Message) (res string, err error) {
 		default:
 			sections = []string{section}
 		case "all":
-			sections = []string{"server", "clients", "memory", "persistence", "stats", "replication", "cpu", "commandstats", "cluster", "keyspace"}
+			sections = []string{"server", "clients", "memory", "persistence", "stats", "replication", "cpu", "commandstats", "cluster", "keyspace", "hooks"}
 		case "default":
 		}
 	}
@@ -231,6 +234,9 @@ func (c *Controller) cmdInfo(msg *server.Message) (res string, err error) {
 		case "cluster":
 			w.WriteString("# Cluster\r\n")
 			c.writeInfoCluster(w)
+		case "hooks":
+			w.WriteString("# Hooks\r\n")
+			c.writeInfoHooks(w)
 		}
 	}
 
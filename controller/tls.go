@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+// tlsConfigs returns the tls configs of the listener and of the connections
+// to the leader and the peers. The listener config is nil when no
+// certificate is configured, and the replication config is nil when
+// tls-replication is off. The replication connections present the
+// certificate of the server, which allows for leaders that authenticate
+// clients. The caller must hold the lock.
+func (c *Controller) tlsConfigs() (listener, repl *tls.Config, err error) {
+	certFile, keyFile := c.config.TLSCertFile, c.config.TLSKeyFile
+	if (certFile == "") != (keyFile == "") {
+		return nil, nil, errors.New("tls-cert-file and tls-key-file must both be set")
+	}
+	var certs []tls.Certificate
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		certs = append(certs, cert)
+	}
+	var pool *x509.CertPool
+	if c.config.TLSCACertFile != "" {
+		data, err := ioutil.ReadFile(c.config.TLSCACertFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, nil, errors.New("tls-ca-cert-file has no valid certificates")
+		}
+	}
+	if len(certs) > 0 {
+		listener = &tls.Config{Certificates: certs, ClientCAs: pool, MinVersion: tls.VersionTLS12}
+		switch c.config.TLSAuthClients {
+		case "yes":
+			listener.ClientAuth = tls.RequireAndVerifyClientCert
+		case "optional":
+			listener.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	if c.config.TLSReplication == "yes" {
+		repl = &tls.Config{Certificates: certs, RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return listener, repl, nil
+}
@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tidwall/tile38/controller/server"
+)
+
+// testWriteCert writes a self-signed certificate for localhost.
+func testWriteCert(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// testServeTLS starts a server that replies PONG to every command.
+func testServeTLS(t *testing.T, c *Controller) (addr string, ln *net.Listener) {
+	listener, _, err := c.tlsConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	ln = new(net.Listener)
+	handler := func(conn *server.Conn, msg *server.Message, rd *server.AnyReaderWriter, w io.Writer, websocket bool) error {
+		_, err := io.WriteString(w, "+PONG\r\n")
+		return err
+	}
+	go server.ListenAndServe("127.0.0.1", port, func() bool { return false }, handler,
+		func(conn *server.Conn) {}, func(conn *server.Conn) {}, ln, false, listener)
+	time.Sleep(time.Millisecond * 100)
+	return l.Addr().String(), ln
+}
+
+func TestTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tile38-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := testWriteCert(t, dir)
+
+	c := &Controller{}
+	c.config.TLSCertFile = certFile
+	if _, _, err := c.tlsConfigs(); err == nil {
+		t.Fatal("expected an error for a missing key")
+	}
+	c.config.TLSKeyFile = keyFile
+	c.config.TLSCACertFile = certFile
+	c.config.TLSReplication = "yes"
+	listener, repl, err := c.tlsConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listener.MinVersion != tls.VersionTLS12 || repl.MinVersion != tls.VersionTLS12 {
+		t.Fatalf("expected TLS 1.2 at least, got %x and %x", listener.MinVersion, repl.MinVersion)
+	}
+	addr, ln := testServeTLS(t, c)
+	defer (*ln).Close()
+	conn, err := DialTLSTimeout(addr, time.Second, repl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := conn.Do("ping")
+	if err != nil || v.String() != "PONG" {
+		t.Fatalf("expected 'PONG', got '%v' (%v)", v, err)
+	}
+	conn.Close()
+
+	// a client without a certificate is rejected
+	c.config.TLSAuthClients = "yes"
+	addr, ln2 := testServeTLS(t, c)
+	defer (*ln2).Close()
+	noCert := repl.Clone()
+	noCert.Certificates = nil
+	if conn, err := DialTLSTimeout(addr, time.Second, noCert); err == nil {
+		if _, err := conn.Do("ping"); err == nil {
+			t.Fatal("expected an error for a client without a certificate")
+		}
+		conn.Close()
+	}
+	conn, err = DialTLSTimeout(addr, time.Second, repl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := conn.Do("ping"); err != nil || v.String() != "PONG" {
+		t.Fatalf("expected 'PONG', got '%v' (%v)", v, err)
+	}
+	conn.Close()
+}
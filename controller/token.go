@@ -6,8 +6,11 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/controller/collection"
+	"github.com/tidwall/tile38/controller/expr"
 )
 
 const defaultSearchOutput = outputObjects
@@ -119,33 +122,80 @@ type whereT struct {
 	min   float64
 	maxx  bool
 	max   float64
+	str   bool // compare string values using smin and smax
+	smin  string
+	smax  string
 }
 
-func (where whereT) match(value float64) bool {
+func (where whereT) match(value collection.FieldValue) bool {
+	if where.str {
+		return where.matchString(value)
+	}
+	if value.Kind == collection.String {
+		// only an unbounded range matches every kind of value
+		return where.unbounded()
+	}
 	if !where.minx {
-		if value < where.min {
+		if value.Num < where.min {
 			return false
 		}
 	} else {
-		if value <= where.min {
+		if value.Num <= where.min {
 			return false
 		}
 	}
 	if !where.maxx {
-		if value > where.max {
+		if value.Num > where.max {
 			return false
 		}
 	} else {
-		if value >= where.max {
+		if value.Num >= where.max {
 			return false
 		}
 	}
 	return true
 }
 
+// unbounded returns true for a range that matches every value, which can't
+// be walked in the numeric order of a field index.
+func (where whereT) unbounded() bool {
+	return math.IsInf(where.min, -1) && math.IsInf(where.max, +1)
+}
+
+// matchString matches a string value against a lexicographical range.
+// Infinite bounds are represented by the min and max numbers.
+func (where whereT) matchString(value collection.FieldValue) bool {
+	if value.Kind != collection.String {
+		return false
+	}
+	if !math.IsInf(where.min, -1) {
+		if !where.minx {
+			if value.Str < where.smin {
+				return false
+			}
+		} else {
+			if value.Str <= where.smin {
+				return false
+			}
+		}
+	}
+	if !math.IsInf(where.max, +1) {
+		if !where.maxx {
+			if value.Str > where.smax {
+				return false
+			}
+		} else {
+			if value.Str >= where.smax {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func zMinMaxFromWheres(wheres []whereT) (minZ, maxZ float64) {
 	for _, w := range wheres {
-		if w.field == "z" {
+		if w.field == "z" && !w.str {
 			minZ = w.min
 			maxZ = w.max
 			return
@@ -157,34 +207,102 @@ func zMinMaxFromWheres(wheres []whereT) (minZ, maxZ float64) {
 }
 
 type whereinT struct {
-	field string
-	val_map map[float64]struct{}
+	field   string
+	val_map map[collection.FieldValue]struct{}
+}
+
+// whereinKey normalizes a value so that booleans and numbers with the same
+// numeric value use the same map key.
+func whereinKey(value collection.FieldValue) collection.FieldValue {
+	if value.Kind == collection.Bool {
+		value.Kind = collection.Number
+	}
+	return value
 }
 
-func (wherein whereinT) match(value float64) bool {
-	_, ok := wherein.val_map[value]
+func (wherein whereinT) match(value collection.FieldValue) bool {
+	_, ok := wherein.val_map[whereinKey(value)]
 	return ok
 }
 
+// parseWhereRange parses the min and max of a WHERE clause. When either
+// bound is not a number the range is a lexicographical string range.
+func parseWhereRange(field, smin, smax string) (where whereT, err error) {
+	where.field = field
+	if strings.ToLower(smin) == "-inf" {
+		where.min = math.Inf(-1)
+	} else {
+		if strings.HasPrefix(smin, "(") {
+			where.minx = true
+			smin = smin[1:]
+		}
+		if where.min, err = strconv.ParseFloat(smin, 64); err != nil || isQuoted(smin) {
+			where.str = true
+			smin = unquote(smin)
+		}
+		where.smin = smin
+	}
+	if strings.ToLower(smax) == "+inf" {
+		where.max = math.Inf(+1)
+	} else {
+		if strings.HasPrefix(smax, "(") {
+			where.maxx = true
+			smax = smax[1:]
+		}
+		if where.max, err = strconv.ParseFloat(smax, 64); err != nil || isQuoted(smax) {
+			where.str = true
+			smax = unquote(smax)
+		}
+		where.smax = smax
+	}
+	err = nil
+	return
+}
+
+// isQuoted returns true for a value in double quotes, which is always a
+// string, like "007".
+func isQuoted(s string) bool {
+	return len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"'
+}
+
+// unquote returns a value without its double quotes.
+func unquote(s string) string {
+	if isQuoted(s) {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
 type searchScanBaseTokens struct {
-	key       string
-	cursor    uint64
-	output    outputT
-	precision uint64
-	lineout   string
-	fence     bool
-	distance  bool
-	detect    map[string]bool
-	accept    map[string]bool
-	glob      string
-	wheres    []whereT
-	whereins  []whereinT
-	nofields  bool
-	ulimit    bool
-	limit     uint64
-	usparse   bool
-	sparse    uint8
-	desc      bool
+	key        string
+	cursor     uint64
+	after      *cursorT // opaque cursor, nil for offsets
+	opaque     bool     // opaque cursors are requested, by CURSOR * or a token
+	output     outputT
+	precision  uint64
+	lineout    string
+	fence      bool
+	distance   bool
+	detect     map[string]bool
+	dwell      time.Duration
+	loiter     float64
+	speed      *whereT
+	heading    *whereT
+	accel      *whereT
+	accept     map[string]bool
+	glob       string
+	wheres     []whereT
+	whereins   []whereinT
+	whereexprs []*expr.Expr
+	orderby    string
+	nofields   bool
+	ulimit     bool
+	limit      uint64
+	usparse    bool
+	sparse     uint8
+	desc       bool
+	at         time.Time   // query the history at this time
+	agg        *aggregateT // the aggregates of the AGG output
 }
 
 func parseSearchScanBaseTokens(cmd string, vs []resp.Value) (vsout []resp.Value, t searchScanBaseTokens, err error) {
@@ -226,35 +344,11 @@ func parseSearchScanBaseTokens(cmd string, vs []resp.Value) (vsout []resp.Value,
 					err = errInvalidNumberOfArguments
 					return
 				}
-				var minx, maxx bool
-				var min, max float64
-				if strings.ToLower(smin) == "-inf" {
-					min = math.Inf(-1)
-				} else {
-					if strings.HasPrefix(smin, "(") {
-						minx = true
-						smin = smin[1:]
-					}
-					min, err = strconv.ParseFloat(smin, 64)
-					if err != nil {
-						err = errInvalidArgument(smin)
-						return
-					}
-				}
-				if strings.ToLower(smax) == "+inf" {
-					max = math.Inf(+1)
-				} else {
-					if strings.HasPrefix(smax, "(") {
-						maxx = true
-						smax = smax[1:]
-					}
-					max, err = strconv.ParseFloat(smax, 64)
-					if err != nil {
-						err = errInvalidArgument(smax)
-						return
-					}
+				var where whereT
+				if where, err = parseWhereRange(field, smin, smax); err != nil {
+					return
 				}
-				t.wheres = append(t.wheres, whereT{field, minx, min, maxx, max})
+				t.wheres = append(t.wheres, where)
 				continue
 			} else if (wtok[0] == 'W' || wtok[0] == 'w') && strings.ToLower(wtok) == "wherein" {
 				vs = nvs
@@ -272,22 +366,41 @@ func parseSearchScanBaseTokens(cmd string, vs []resp.Value) (vsout []resp.Value,
 					err = errInvalidArgument(nvals_str)
 					return
 				}
-				val_map := make(map[float64]struct{})
-				var val float64
+				val_map := make(map[collection.FieldValue]struct{})
 				var empty struct{}
 				for i = 0; i < nvals; i++ {
 					if vs, val_str, ok = tokenval(vs); !ok || val_str == "" {
 						err = errInvalidNumberOfArguments
 						return
 					}
-					if val, err = strconv.ParseFloat(val_str, 64); err != nil  {
-						err = errInvalidArgument(val_str)
-						return
-					}
-					val_map[val] = empty
+					val_map[whereinKey(collection.ParseFieldValue(val_str))] = empty
 				}
 				t.whereins = append(t.whereins, whereinT{field, val_map})
 				continue
+			} else if (wtok[0] == 'W' || wtok[0] == 'w') && strings.ToLower(wtok) == "whereexpr" {
+				vs = nvs
+				var src string
+				if vs, src, ok = tokenval(vs); !ok || src == "" {
+					err = errInvalidNumberOfArguments
+					return
+				}
+				var e *expr.Expr
+				if e, err = expr.Compile(src); err != nil {
+					return
+				}
+				t.whereexprs = append(t.whereexprs, e)
+				continue
+			} else if (wtok[0] == 'O' || wtok[0] == 'o') && strings.ToLower(wtok) == "orderby" {
+				vs = nvs
+				if t.orderby != "" {
+					err = errDuplicateArgument(strings.ToUpper(wtok))
+					return
+				}
+				if vs, t.orderby, ok = tokenval(vs); !ok || t.orderby == "" {
+					err = errInvalidNumberOfArguments
+					return
+				}
+				continue
 			} else if (wtok[0] == 'N' || wtok[0] == 'n') && strings.ToLower(wtok) == "nofields" {
 				vs = nvs
 				if t.nofields {
@@ -326,6 +439,21 @@ func parseSearchScanBaseTokens(cmd string, vs []resp.Value) (vsout []resp.Value,
 				}
 				t.fence = true
 				continue
+			} else if (wtok[0] == 'A' || wtok[0] == 'a') && strings.ToLower(wtok) == "at" {
+				vs = nvs
+				if !t.at.IsZero() {
+					err = errDuplicateArgument(strings.ToUpper(wtok))
+					return
+				}
+				var sat string
+				if vs, sat, ok = tokenval(vs); !ok || sat == "" {
+					err = errInvalidNumberOfArguments
+					return
+				}
+				if t.at, err = parseHistoryTime(sat); err != nil {
+					return
+				}
+				continue
 			} else if (wtok[0] == 'C' || wtok[0] == 'c') && strings.ToLower(wtok) == "commands" {
 				vs = nvs
 				if t.accept != nil {
@@ -376,7 +504,7 @@ func parseSearchScanBaseTokens(cmd string, vs []resp.Value) (vsout []resp.Value,
 					default:
 						err = errInvalidArgument(peek)
 						return
-					case "inside", "outside", "enter", "exit", "cross":
+					case "inside", "outside", "enter", "exit", "cross", "dwell", "loiter":
 					}
 					if t.detect[part] {
 						err = errDuplicateArgument(s)
@@ -394,6 +522,91 @@ func parseSearchScanBaseTokens(cmd string, vs []resp.Value) (vsout []resp.Value,
 					}
 				}
 				continue
+			} else if (wtok[0] == 'D' || wtok[0] == 'd') && strings.ToLower(wtok) == "dwell" {
+				vs = nvs
+				if t.dwell != 0 {
+					err = errDuplicateArgument(strings.ToUpper(wtok))
+					return
+				}
+				var sdwell string
+				if vs, sdwell, ok = tokenval(vs); !ok || sdwell == "" {
+					err = errInvalidNumberOfArguments
+					return
+				}
+				seconds, _ := strconv.ParseFloat(sdwell, 64)
+				if seconds <= 0 {
+					err = errInvalidArgument(sdwell)
+					return
+				}
+				t.dwell = time.Duration(seconds * float64(time.Second))
+				continue
+			} else if (wtok[0] == 'L' || wtok[0] == 'l') && strings.ToLower(wtok) == "loiter" {
+				vs = nvs
+				if t.loiter != 0 {
+					err = errDuplicateArgument(strings.ToUpper(wtok))
+					return
+				}
+				var sloiter string
+				if vs, sloiter, ok = tokenval(vs); !ok || sloiter == "" {
+					err = errInvalidNumberOfArguments
+					return
+				}
+				meters, _ := strconv.ParseFloat(sloiter, 64)
+				if meters <= 0 {
+					err = errInvalidArgument(sloiter)
+					return
+				}
+				t.loiter = meters
+				continue
+			} else if ((wtok[0] == 'S' || wtok[0] == 's') && strings.ToLower(wtok) == "speed") ||
+				((wtok[0] == 'H' || wtok[0] == 'h') && strings.ToLower(wtok) == "heading") ||
+				((wtok[0] == 'A' || wtok[0] == 'a') && strings.ToLower(wtok) == "accel") {
+				vs = nvs
+				field := strings.ToLower(wtok)
+				var where **whereT
+				switch field {
+				case "speed":
+					where = &t.speed
+				case "heading":
+					where = &t.heading
+				case "accel":
+					where = &t.accel
+				}
+				if *where != nil {
+					err = errDuplicateArgument(strings.ToUpper(wtok))
+					return
+				}
+				var smin, smax string
+				if vs, smin, ok = tokenval(vs); !ok || smin == "" {
+					err = errInvalidNumberOfArguments
+					return
+				}
+				if vs, smax, ok = tokenval(vs); !ok || smax == "" {
+					err = errInvalidNumberOfArguments
+					return
+				}
+				var r whereT
+				if r, err = parseWhereRange(field, smin, smax); err != nil {
+					return
+				}
+				if r.str {
+					if _, err = strconv.ParseFloat(strings.TrimPrefix(smin, "("), 64); err != nil {
+						err = errInvalidArgument(smin)
+					} else {
+						err = errInvalidArgument(smax)
+					}
+					return
+				}
+				if field == "heading" && (r.min < 0 || r.min > 360 || r.max < 0 || r.max > 360) {
+					if r.min < 0 || r.min > 360 {
+						err = errInvalidArgument(smin)
+					} else {
+						err = errInvalidArgument(smax)
+					}
+					return
+				}
+				*where = &r
+				continue
 			} else if (wtok[0] == 'D' || wtok[0] == 'd') && strings.ToLower(wtok) == "desc" {
 				vs = nvs
 				if t.desc || asc {
@@ -436,6 +649,10 @@ func parseSearchScanBaseTokens(cmd string, vs []resp.Value) (vsout []resp.Value,
 			err = errors.New("FENCE is not allowed for " + strings.ToUpper(cmd))
 			return
 		}
+		if !t.at.IsZero() {
+			err = errors.New("AT is not allowed for " + strings.ToUpper(cmd))
+			return
+		}
 	} else {
 		if t.desc {
 			err = errors.New("DESC is not allowed for " + strings.ToUpper(cmd))
@@ -454,14 +671,46 @@ func parseSearchScanBaseTokens(cmd string, vs []resp.Value) (vsout []resp.Value,
 		err = errors.New("CURSOR is not allowed when SPARSE is specified")
 		return
 	}
+	if !t.at.IsZero() && t.fence {
+		err = errors.New("AT is not allowed when FENCE is specified")
+		return
+	}
+	if !t.at.IsZero() && (len(t.wheres) > 0 || len(t.whereins) > 0 || len(t.whereexprs) > 0) {
+		// the history has the positions of the objects, not their fields
+		err = errors.New("WHERE, WHEREIN, and WHEREEXPR are not allowed when AT is specified")
+		return
+	}
 	if scursor != "" && t.fence {
 		err = errors.New("CURSOR is not allowed when FENCE is specified")
 		return
 	}
+	if t.orderby != "" && cmd != "scan" {
+		err = errors.New("ORDERBY is not allowed for " + strings.ToUpper(cmd))
+		return
+	}
 	if t.detect != nil && !t.fence {
 		err = errors.New("DETECT is not allowed when FENCE is not specified")
 		return
 	}
+	if t.dwell != 0 && !t.fence {
+		err = errors.New("DWELL is not allowed when FENCE is not specified")
+		return
+	}
+	if (t.speed != nil || t.heading != nil || t.accel != nil) && !t.fence {
+		err = errors.New("SPEED, HEADING, and ACCEL are not allowed when FENCE is not specified")
+		return
+	}
+	if t.dwell == 0 && (t.detect["dwell"] || t.detect["loiter"]) {
+		err = errors.New("DWELL is required to detect dwell or loiter")
+		return
+	}
+	if t.loiter != 0 && t.dwell == 0 {
+		err = errors.New("LOITER is not allowed when DWELL is not specified")
+		return
+	}
+	if t.loiter == 0 {
+		t.loiter = defaultLoiterMeters
+	}
 
 	t.output = defaultSearchOutput
 	var nvs []resp.Value
@@ -492,15 +741,44 @@ func parseSearchScanBaseTokens(cmd string, vs []resp.Value) (vsout []resp.Value,
 			t.output = outputBounds
 		case "ids":
 			t.output = outputIDs
+		case "agg":
+			if t.fence {
+				err = errors.New("AGG is not allowed when FENCE is specified")
+				return
+			}
+			t.output = outputAgg
+			if nvs, t.agg, err = parseAggregate(nvs); err != nil {
+				return
+			}
+		case "grid":
+			if cmd != "within" && cmd != "intersects" {
+				err = errors.New("GRID is not allowed for " + strings.ToUpper(cmd))
+				return
+			}
+			if t.fence {
+				err = errors.New("GRID is not allowed when FENCE is specified")
+				return
+			}
+			t.output = outputAgg
+			if nvs, t.agg, err = parseGrid(nvs); err != nil {
+				return
+			}
 		}
 		if updline {
 			vs = nvs
 		}
 	}
-	if scursor != "" {
+	if scursor == "*" {
+		// the first page of opaque cursors
+		t.opaque = true
+	} else if scursor != "" {
 		if t.cursor, err = strconv.ParseUint(scursor, 10, 64); err != nil {
-			err = errInvalidArgument(scursor)
-			return
+			// not an offset, so it's an opaque cursor
+			if t.after, err = parseCursor(scursor); err != nil {
+				err = errInvalidArgument(scursor)
+				return
+			}
+			t.opaque = true
 		}
 	}
 	if sprecision != "" {
@@ -180,7 +180,7 @@ var commandsJSON = `{
       {
         "command": "FIELD",
         "name": ["name", "value"],
-        "type": ["string", "double"],
+        "type": ["string", "string"],
         "optional": true,
         "multiple": true
       },
@@ -348,12 +348,181 @@ var commandsJSON = `{
       },
       {
         "name": "value",
-        "type": "double"
+        "type": "string"
       }
     ],
     "since": "1.0.0",
     "group": "keys"
   },
+  "INDEX": {
+    "summary": "Create an ordered index on a field of a key",
+    "complexity": "O(N) where N is the number of ids in the key",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "command": "FIELD",
+        "name": ["name"],
+        "type": ["string"]
+      }
+    ],
+    "since": "1.10.0",
+    "group": "keys"
+  },
+  "DELINDEX": {
+    "summary": "Remove the index on a field of a key",
+    "complexity": "O(1)",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "command": "FIELD",
+        "name": ["name"],
+        "type": ["string"]
+      }
+    ],
+    "since": "1.10.0",
+    "group": "keys"
+  },
+  "INDEXES": {
+    "summary": "Returns the indexed fields of a key",
+    "complexity": "O(N) where N is the number of indexed fields",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      }
+    ],
+    "since": "1.10.0",
+    "group": "keys"
+  },
+  "SETHISTORY": {
+    "summary": "Keep the past positions of the objects of a key",
+    "complexity": "O(1)",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "command": "LEN",
+        "name": ["count"],
+        "type": ["integer"],
+        "optional": true
+      },
+      {
+        "command": "DURATION",
+        "name": ["seconds"],
+        "type": ["double"],
+        "optional": true
+      }
+    ],
+    "since": "1.10.0",
+    "group": "keys"
+  },
+  "DELHISTORY": {
+    "summary": "Remove the history of a key",
+    "complexity": "O(1)",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      }
+    ],
+    "since": "1.10.0",
+    "group": "keys"
+  },
+  "HISTORY": {
+    "summary": "Returns the past positions of an id",
+    "complexity": "O(N) where N is the number of positions of the id",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "name": "id",
+        "type": "string"
+      },
+      {
+        "command": "START",
+        "name": ["time"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "command": "END",
+        "name": ["time"],
+        "type": ["string"],
+        "optional": true
+      }
+    ],
+    "since": "1.10.0",
+    "group": "keys"
+  },
+  "HISTADD": {
+    "summary": "Adds a past position of an id to the history of a key",
+    "complexity": "O(log(N)) where N is the number of positions of the id",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "name": "id",
+        "type": "string"
+      },
+      {
+        "name": "time",
+        "type": "string"
+      },
+      {
+        "name": "value",
+        "enumargs": [
+          {
+            "name": "DELETED"
+          },
+          {
+            "name": "OBJECT",
+            "arguments":[
+              {
+                "name": "geojson",
+                "type": "geojson"
+              }
+            ]
+          },
+          {
+            "name": "POINT",
+            "arguments":[
+              {
+                "name": "lat",
+                "type": "double"
+              },
+              {
+                "name": "lon",
+                "type": "double"
+              }
+            ]
+          },
+          {
+            "name": "STRING",
+            "arguments":[
+              {
+                "name": "value",
+                "type": "string"
+              }
+            ]
+          }
+        ]
+      }
+    ],
+    "since": "1.10.0",
+    "group": "keys"
+  },
   "BOUNDS": {
     "summary": "Get the combined bounds of all the objects in a key",
     "complexity": "O(1)",
@@ -384,6 +553,12 @@ var commandsJSON = `{
         "type": [],
         "optional": true
       },
+      {
+        "command": "AT",
+        "name": "time",
+        "type": "string",
+        "optional": true
+      },
       {
         "name": "type",
         "optional": true,
@@ -476,7 +651,7 @@ var commandsJSON = `{
       {
         "command": "CURSOR",
         "name": "start",
-        "type": "integer",
+        "type": "string",
         "optional": true
       },
       {
@@ -506,18 +681,25 @@ var commandsJSON = `{
       {
         "command": "WHERE",
         "name": ["field","min","max"],
-        "type": ["string","double","double"],
+        "type": ["string","string","string"],
         "optional": true,
         "multiple": true
       },
       {
         "command": "WHEREIN",
         "name": ["field","count","value"],
-        "type": ["string","integer","double"],
+        "type": ["string","integer","string"],
         "optional": true,
         "multiple": true,
         "variadic": true
       },
+      {
+        "command": "WHEREEXPR",
+        "name": ["expression"],
+        "type": ["string"],
+        "optional": true,
+        "multiple": true
+      },
       {
         "command": "NOFIELDS",
         "name": [],
@@ -551,7 +733,7 @@ var commandsJSON = `{
       {
         "command": "CURSOR",
         "name": "start",
-        "type": "integer",
+        "type": "string",
         "optional": true
       },
       {
@@ -566,6 +748,12 @@ var commandsJSON = `{
         "type": "pattern",
         "optional": true
       },
+      {
+        "command": "ORDERBY",
+        "name": "field",
+        "type": "string",
+        "optional": true
+      },
       {
         "name": "order",
         "optional": true,
@@ -581,18 +769,25 @@ var commandsJSON = `{
       {
         "command": "WHERE",
         "name": ["field","min","max"],
-        "type": ["string","double","double"],
+        "type": ["string","string","string"],
         "optional": true,
         "multiple": true
       },
       {
         "command": "WHEREIN",
         "name": ["field","count","value"],
-        "type": ["string","integer","double"],
+        "type": ["string","integer","string"],
         "optional": true,
         "multiple": true,
         "variadic": true
       },
+      {
+        "command": "WHEREEXPR",
+        "name": ["expression"],
+        "type": ["string"],
+        "optional": true,
+        "multiple": true
+      },
       {
         "command": "NOFIELDS",
         "name": [],
@@ -626,6 +821,39 @@ var commandsJSON = `{
                 "type": "integer"
               }
             ]
+          },
+          {
+            "name": "AGG",
+            "arguments": [
+              {
+                "command": "GROUPBY",
+                "name": ["by"],
+                "type": ["string"],
+                "optional": true
+              },
+              {
+                "name": "aggregate",
+                "multiple": true,
+                "enumargs": [
+                  {
+                    "name": "SUM",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  },
+                  {
+                    "name": "AVG",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  },
+                  {
+                    "name": "MIN",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  },
+                  {
+                    "name": "MAX",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  }
+                ]
+              }
+            ]
           }
         ]
       }
@@ -644,7 +872,7 @@ var commandsJSON = `{
       {
         "command": "CURSOR",
         "name": "start",
-        "type": "integer",
+        "type": "string",
         "optional": true
       },
       {
@@ -659,6 +887,12 @@ var commandsJSON = `{
         "type": "integer",
         "optional": true
       },
+      {
+        "command": "AT",
+        "name": "time",
+        "type": "string",
+        "optional": true
+      },
       {
         "command": "MATCH",
         "name": "pattern",
@@ -674,18 +908,25 @@ var commandsJSON = `{
       {
         "command": "WHERE",
         "name": ["field","min","max"],
-        "type": ["string","double","double"],
+        "type": ["string","string","string"],
         "optional": true,
         "multiple": true
       },
       {
         "command": "WHEREIN",
         "name": ["field","count","value"],
-        "type": ["string","integer","double"],
+        "type": ["string","integer","string"],
         "optional": true,
         "multiple": true,
         "variadic": true
       },
+      {
+        "command": "WHEREEXPR",
+        "name": ["expression"],
+        "type": ["string"],
+        "optional": true,
+        "multiple": true
+      },
       {
         "command": "NOFIELDS",
         "name": [],
@@ -704,6 +945,36 @@ var commandsJSON = `{
         "type": ["string"],
         "optional": true
       },
+      {
+        "command": "DWELL",
+        "name": ["seconds"],
+        "type": ["double"],
+        "optional": true
+      },
+      {
+        "command": "LOITER",
+        "name": ["meters"],
+        "type": ["double"],
+        "optional": true
+      },
+      {
+        "command": "SPEED",
+        "name": ["min","max"],
+        "type": ["string","string"],
+        "optional": true
+      },
+      {
+        "command": "HEADING",
+        "name": ["min","max"],
+        "type": ["string","string"],
+        "optional": true
+      },
+      {
+        "command": "ACCEL",
+        "name": ["min","max"],
+        "type": ["string","string"],
+        "optional": true
+      },
       {
         "command": "COMMANDS",
         "name": ["which"],
@@ -737,6 +1008,39 @@ var commandsJSON = `{
                 "type": "integer"
               }
             ]
+          },
+          {
+            "name": "AGG",
+            "arguments": [
+              {
+                "command": "GROUPBY",
+                "name": ["by"],
+                "type": ["string"],
+                "optional": true
+              },
+              {
+                "name": "aggregate",
+                "multiple": true,
+                "enumargs": [
+                  {
+                    "name": "SUM",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  },
+                  {
+                    "name": "AVG",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  },
+                  {
+                    "name": "MIN",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  },
+                  {
+                    "name": "MAX",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  }
+                ]
+              }
+            ]
           }
         ]
       },
@@ -794,7 +1098,7 @@ var commandsJSON = `{
       {
         "command": "CURSOR",
         "name": "start",
-        "type": "integer",
+        "type": "string",
         "optional": true
       },
       {
@@ -809,6 +1113,12 @@ var commandsJSON = `{
         "type": "integer",
         "optional": true
       },
+      {
+        "command": "AT",
+        "name": "time",
+        "type": "string",
+        "optional": true
+      },
       {
         "command": "MATCH",
         "name": "pattern",
@@ -818,18 +1128,25 @@ var commandsJSON = `{
       {
         "command": "WHERE",
         "name": ["field","min","max"],
-        "type": ["string","double","double"],
+        "type": ["string","string","string"],
         "optional": true,
         "multiple": true
       },
       {
         "command": "WHEREIN",
         "name": ["field","count","value"],
-        "type": ["string","integer","double"],
+        "type": ["string","integer","string"],
         "optional": true,
         "multiple": true,
         "variadic": true
       },
+      {
+        "command": "WHEREEXPR",
+        "name": ["expression"],
+        "type": ["string"],
+        "optional": true,
+        "multiple": true
+      },
       {
         "command": "NOFIELDS",
         "name": [],
@@ -848,6 +1165,36 @@ var commandsJSON = `{
         "type": ["string"],
         "optional": true
       },
+      {
+        "command": "DWELL",
+        "name": ["seconds"],
+        "type": ["double"],
+        "optional": true
+      },
+      {
+        "command": "LOITER",
+        "name": ["meters"],
+        "type": ["double"],
+        "optional": true
+      },
+      {
+        "command": "SPEED",
+        "name": ["min","max"],
+        "type": ["string","string"],
+        "optional": true
+      },
+      {
+        "command": "HEADING",
+        "name": ["min","max"],
+        "type": ["string","string"],
+        "optional": true
+      },
+      {
+        "command": "ACCEL",
+        "name": ["min","max"],
+        "type": ["string","string"],
+        "optional": true
+      },
       {
         "command": "COMMANDS",
         "name": ["which"],
@@ -881,6 +1228,87 @@ var commandsJSON = `{
                 "type": "integer"
               }
             ]
+          },
+          {
+            "name": "AGG",
+            "arguments": [
+              {
+                "command": "GROUPBY",
+                "name": ["by"],
+                "type": ["string"],
+                "optional": true
+              },
+              {
+                "name": "aggregate",
+                "multiple": true,
+                "enumargs": [
+                  {
+                    "name": "SUM",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  },
+                  {
+                    "name": "AVG",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  },
+                  {
+                    "name": "MIN",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  },
+                  {
+                    "name": "MAX",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  }
+                ]
+              }
+            ]
+          },
+          {
+            "name": "GRID",
+            "arguments": [
+              {
+                "name": "cells",
+                "enumargs": [
+                  {
+                    "name": "HASH",
+                    "arguments": [{"name": "precision", "type": "integer"}]
+                  },
+                  {
+                    "name": "QUAD",
+                    "arguments": [{"name": "level", "type": "integer"}]
+                  },
+                  {
+                    "name": "SIZE",
+                    "arguments": [
+                      {"name": "cols", "type": "integer"},
+                      {"name": "rows", "type": "integer"}
+                    ]
+                  }
+                ]
+              },
+              {
+                "name": "aggregate",
+                "optional": true,
+                "multiple": true,
+                "enumargs": [
+                  {
+                    "name": "SUM",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  },
+                  {
+                    "name": "AVG",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  },
+                  {
+                    "name": "MIN",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  },
+                  {
+                    "name": "MAX",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  }
+                ]
+              }
+            ]
           }
         ]
       },
@@ -964,12 +1392,25 @@ var commandsJSON = `{
                 "type": "geohash"
               }
             ]
-          }
-        ]
-      }
-    ],
-    "since": "1.0.0",
-    "group": "search"
+          },
+          {
+            "name": "ROAM",
+            "arguments":[
+              {
+                "name": "key",
+                "type": "string"
+              },
+              {
+                "name": "pattern",
+                "type": "pattern"
+              }
+            ]
+          }
+        ]
+      }
+    ],
+    "since": "1.0.0",
+    "group": "search"
   },
   "INTERSECTS": {
     "summary": "Searches for ids that are nearby a point",
@@ -982,7 +1423,7 @@ var commandsJSON = `{
       {
         "command": "CURSOR",
         "name": "start",
-        "type": "integer",
+        "type": "string",
         "optional": true
       },
       {
@@ -997,6 +1438,12 @@ var commandsJSON = `{
         "type": "integer",
         "optional": true
       },
+      {
+        "command": "AT",
+        "name": "time",
+        "type": "string",
+        "optional": true
+      },
       {
         "command": "MATCH",
         "name": "pattern",
@@ -1006,18 +1453,25 @@ var commandsJSON = `{
       {
         "command": "WHERE",
         "name": ["field","min","max"],
-        "type": ["string","double","double"],
+        "type": ["string","string","string"],
         "optional": true,
         "multiple": true
       },
       {
         "command": "WHEREIN",
         "name": ["field","count","value"],
-        "type": ["string","integer","double"],
+        "type": ["string","integer","string"],
         "optional": true,
         "multiple": true,
         "variadic": true
       },
+      {
+        "command": "WHEREEXPR",
+        "name": ["expression"],
+        "type": ["string"],
+        "optional": true,
+        "multiple": true
+      },
       {
         "command": "NOFIELDS",
         "name": [],
@@ -1036,6 +1490,36 @@ var commandsJSON = `{
         "type": ["string"],
         "optional": true
       },
+      {
+        "command": "DWELL",
+        "name": ["seconds"],
+        "type": ["double"],
+        "optional": true
+      },
+      {
+        "command": "LOITER",
+        "name": ["meters"],
+        "type": ["double"],
+        "optional": true
+      },
+      {
+        "command": "SPEED",
+        "name": ["min","max"],
+        "type": ["string","string"],
+        "optional": true
+      },
+      {
+        "command": "HEADING",
+        "name": ["min","max"],
+        "type": ["string","string"],
+        "optional": true
+      },
+      {
+        "command": "ACCEL",
+        "name": ["min","max"],
+        "type": ["string","string"],
+        "optional": true
+      },
       {
         "command": "COMMANDS",
         "name": ["which"],
@@ -1069,6 +1553,87 @@ var commandsJSON = `{
                 "type": "integer"
               }
             ]
+          },
+          {
+            "name": "AGG",
+            "arguments": [
+              {
+                "command": "GROUPBY",
+                "name": ["by"],
+                "type": ["string"],
+                "optional": true
+              },
+              {
+                "name": "aggregate",
+                "multiple": true,
+                "enumargs": [
+                  {
+                    "name": "SUM",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  },
+                  {
+                    "name": "AVG",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  },
+                  {
+                    "name": "MIN",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  },
+                  {
+                    "name": "MAX",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  }
+                ]
+              }
+            ]
+          },
+          {
+            "name": "GRID",
+            "arguments": [
+              {
+                "name": "cells",
+                "enumargs": [
+                  {
+                    "name": "HASH",
+                    "arguments": [{"name": "precision", "type": "integer"}]
+                  },
+                  {
+                    "name": "QUAD",
+                    "arguments": [{"name": "level", "type": "integer"}]
+                  },
+                  {
+                    "name": "SIZE",
+                    "arguments": [
+                      {"name": "cols", "type": "integer"},
+                      {"name": "rows", "type": "integer"}
+                    ]
+                  }
+                ]
+              },
+              {
+                "name": "aggregate",
+                "optional": true,
+                "multiple": true,
+                "enumargs": [
+                  {
+                    "name": "SUM",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  },
+                  {
+                    "name": "AVG",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  },
+                  {
+                    "name": "MIN",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  },
+                  {
+                    "name": "MAX",
+                    "arguments": [{"name": "field", "type": "string"}]
+                  }
+                ]
+              }
+            ]
           }
         ]
       },
@@ -1152,6 +1717,19 @@ var commandsJSON = `{
                 "type": "geohash"
               }
             ]
+          },
+          {
+            "name": "ROAM",
+            "arguments":[
+              {
+                "name": "key",
+                "type": "string"
+              },
+              {
+                "name": "pattern",
+                "type": "pattern"
+              }
+            ]
           }
         ]
       }
@@ -1159,6 +1737,66 @@ var commandsJSON = `{
     "since": "1.0.0",
     "group": "search"
   },
+  "JOIN": {
+    "summary": "Searches for the pairs of ids of two keys that match spatially",
+    "complexity": "O(N*log(M)) where N and M are the number of ids in the keys",
+    "arguments":[
+      {
+        "name": "keyA",
+        "type": "string"
+      },
+      {
+        "name": "keyB",
+        "type": "string"
+      },
+      {
+        "name": "predicate",
+        "enumargs": [
+          {
+            "name": "INTERSECTS"
+          },
+          {
+            "name": "WITHIN"
+          },
+          {
+            "name": "NEARBY",
+            "arguments":[
+              {
+                "name": "meters",
+                "type": "double"
+              }
+            ]
+          }
+        ]
+      },
+      {
+        "command": "CURSOR",
+        "name": "start",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "LIMIT",
+        "name": "count",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "WHERE",
+        "name": ["side","field","min","max"],
+        "type": ["string","string","string","string"],
+        "optional": true,
+        "multiple": true
+      },
+      {
+        "name": "type",
+        "optional": true,
+        "enum": ["IDS", "COUNT", "OBJECTS"]
+      }
+    ],
+    "since": "1.10.0",
+    "group": "search"
+  },
   "CONFIG GET": {
     "summary": "Get the value of a configuration parameter",
     "arguments":[
@@ -1189,6 +1827,40 @@ var commandsJSON = `{
     "arguments":[],
     "group": "server"
   },
+  "SLOWLOG GET": {
+    "summary": "Gets the most recent commands that exceeded the slowlog threshold",
+    "complexity": "O(N) where N is the number of entries returned",
+    "arguments":[
+      {
+        "name": "count",
+        "type": "integer",
+        "optional": true
+      }
+    ],
+    "since": "1.10.0",
+    "group": "server"
+  },
+  "SLOWLOG LEN": {
+    "summary": "Returns the number of entries in the slowlog",
+    "complexity": "O(1)",
+    "arguments":[],
+    "since": "1.10.0",
+    "group": "server"
+  },
+  "SLOWLOG RESET": {
+    "summary": "Removes all entries from the slowlog",
+    "complexity": "O(N) where N is the number of entries in the slowlog",
+    "arguments":[],
+    "since": "1.10.0",
+    "group": "server"
+  },
+  "MONITOR": {
+    "summary": "Streams every command processed by the server",
+    "complexity": "O(1)",
+    "arguments":[],
+    "since": "1.10.0",
+    "group": "server"
+  },
   "SERVER": {
     "summary":"Show server stats and details",
     "complexity": "O(1)",
@@ -1203,6 +1875,27 @@ var commandsJSON = `{
     "since": "1.0.0",
     "group": "server"
   },
+  "SAVE": {
+    "summary": "Synchronously saves a snapshot of the dataset to disk",
+    "complexity": "O(N) where N is the number of objects in the database",
+    "arguments": [],
+    "since": "1.10.0",
+    "group": "server"
+  },
+  "BGSAVE": {
+    "summary": "Saves a snapshot of the dataset to disk in the background",
+    "complexity": "O(N) where N is the number of objects in the database",
+    "arguments": [],
+    "since": "1.10.0",
+    "group": "server"
+  },
+  "LASTSAVE": {
+    "summary": "Returns the unix time of the last successful snapshot",
+    "complexity": "O(1)",
+    "arguments": [],
+    "since": "1.10.0",
+    "group": "server"
+  },
   "READONLY": {
     "summary": "Turns on or off readonly mode",
     "complexity": "O(1)",
@@ -1237,6 +1930,20 @@ var commandsJSON = `{
     "since": "1.0.0",
     "group": "replication"
   },
+  "ROLE": {
+    "summary": "Returns the replication role of the server",
+    "complexity": "O(1)",
+    "arguments": [],
+    "since": "1.10.0",
+    "group": "replication"
+  },
+  "REPLICAS": {
+    "summary": "Returns the last known state of the failover peers",
+    "complexity": "O(N) where N is the number of peers",
+    "arguments": [],
+    "since": "1.10.0",
+    "group": "replication"
+  },
   "AOF": {
     "summary": "Downloads the AOF starting from pos and keeps the connection alive",
     "complexity": "O(1)",
@@ -1265,6 +1972,22 @@ var commandsJSON = `{
     "since": "1.0.0",
     "group": "replication"
   },
+  "PSYNC": {
+    "summary": "Continues a replication stream from the leader's backlog and keeps the connection alive",
+    "complexity": "O(1)",
+    "arguments": [
+      {
+        "name": "replid",
+        "type": "string"
+      },
+      {
+        "name": "offset",
+        "type": "integer"
+      }
+    ],
+    "since": "1.10.0",
+    "group": "replication"
+  },
   "AOFSHRINK": {
     "summary": "Shrinks the aof in the background",
     "group": "replication"
@@ -1280,6 +2003,11 @@ var commandsJSON = `{
   "AUTH": {
     "summary": "Authenticate to the server",
     "arguments": [
+      {
+        "name": "username",
+        "type": "string",
+        "optional": true
+      },
       {
         "name": "password",
         "type": "string"
@@ -1323,6 +2051,56 @@ var commandsJSON = `{
         "optional": true,
 		"multiple": true
       },
+      {
+        "command": "RETRIES",
+        "name": ["attempts"],
+        "type": ["integer"],
+        "optional": true
+      },
+      {
+        "command": "BACKOFF",
+        "name": ["seconds"],
+        "type": ["double"],
+        "optional": true
+      },
+      {
+        "command": "MAXAGE",
+        "name": ["seconds"],
+        "type": ["double"],
+        "optional": true
+      },
+      {
+        "command": "HEADER",
+        "name": ["name","value"],
+        "type": ["string","string"],
+        "optional": true,
+        "multiple": true
+      },
+      {
+        "command": "AUTH",
+        "name": ["scheme","credentials"],
+        "type": ["string","string"],
+        "optional": true,
+        "variadic": true
+      },
+      {
+        "command": "SECRET",
+        "name": ["secret"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "command": "TIMEOUT",
+        "name": ["seconds"],
+        "type": ["double"],
+        "optional": true
+      },
+      {
+        "command": "NON2XX",
+        "name": ["policy"],
+        "type": ["string"],
+        "optional": true
+      },
       {
         "enum": ["NEARBY", "WITHIN", "INTERSECTS"]
       },
@@ -1341,6 +2119,36 @@ var commandsJSON = `{
         "type": ["string"],
         "optional": true
       },
+      {
+        "command": "DWELL",
+        "name": ["seconds"],
+        "type": ["double"],
+        "optional": true
+      },
+      {
+        "command": "LOITER",
+        "name": ["meters"],
+        "type": ["double"],
+        "optional": true
+      },
+      {
+        "command": "SPEED",
+        "name": ["min","max"],
+        "type": ["string","string"],
+        "optional": true
+      },
+      {
+        "command": "HEADING",
+        "name": ["min","max"],
+        "type": ["string","string"],
+        "optional": true
+      },
+      {
+        "command": "ACCEL",
+        "name": ["min","max"],
+        "type": ["string","string"],
+        "optional": true
+      },
       {
         "command": "COMMANDS",
         "name": ["which"],
@@ -1369,6 +2177,10 @@ var commandsJSON = `{
   "HOOKS": {
     "summary": "Finds all hooks matching a pattern",
     "arguments":[
+      {
+        "enum": ["DLQ", "REPLAY"],
+        "optional": true
+      },
       {
         "name": "pattern",
         "type": "pattern"
@@ -1386,6 +2198,329 @@ var commandsJSON = `{
     ],
     "group": "webhook"
   },
+  "SETCHAN": {
+    "summary": "Creates a pubsub channel which points to geofenced search",
+    "arguments": [
+      {
+        "name": "name",
+        "type": "string"
+      },
+      {
+        "command": "META",
+        "name": ["name", "value"],
+        "type": ["string", "string"],
+        "optional": true,
+		"multiple": true
+      },
+      {
+        "enum": ["NEARBY", "WITHIN", "INTERSECTS"]
+      },
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "command": "FENCE",
+        "name": [],
+        "type": []
+      },
+      {
+        "command": "DETECT",
+        "name": ["what"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "command": "DWELL",
+        "name": ["seconds"],
+        "type": ["double"],
+        "optional": true
+      },
+      {
+        "command": "LOITER",
+        "name": ["meters"],
+        "type": ["double"],
+        "optional": true
+      },
+      {
+        "command": "SPEED",
+        "name": ["min","max"],
+        "type": ["string","string"],
+        "optional": true
+      },
+      {
+        "command": "HEADING",
+        "name": ["min","max"],
+        "type": ["string","string"],
+        "optional": true
+      },
+      {
+        "command": "ACCEL",
+        "name": ["min","max"],
+        "type": ["string","string"],
+        "optional": true
+      },
+      {
+        "command": "COMMANDS",
+        "name": ["which"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "name": "param",
+        "type": "string",
+        "variadic": true
+      }
+
+    ],
+    "since": "1.10.0",
+    "group": "pubsub"
+  },
+  "DELCHAN": {
+    "summary": "Removes a channel",
+    "arguments": [
+      {
+        "name": "name",
+        "type": "string"
+      }
+    ],
+    "since": "1.10.0",
+    "group": "pubsub"
+  },
+  "CHANS": {
+    "summary": "Finds all channels matching a pattern",
+    "arguments":[
+      {
+        "name": "pattern",
+        "type": "pattern"
+      }
+    ],
+    "since": "1.10.0",
+    "group": "pubsub"
+  },
+  "PDELCHAN": {
+    "summary": "Removes all channels matching a pattern",
+    "arguments":[
+      {
+        "name": "pattern",
+        "type": "pattern"
+      }
+    ],
+    "since": "1.10.0",
+    "group": "pubsub"
+  },
+  "SUBSCRIBE": {
+    "summary": "Subscribes the client to the specified channels",
+    "complexity": "O(N) where N is the number of channels to subscribe to",
+    "arguments":[
+      {
+        "name": "channel",
+        "type": "string",
+        "multiple": true
+      }
+    ],
+    "since": "1.10.0",
+    "group": "pubsub"
+  },
+  "PSUBSCRIBE": {
+    "summary": "Subscribes the client to the given patterns",
+    "complexity": "O(N) where N is the number of patterns the client is already subscribed to",
+    "arguments":[
+      {
+        "name": "pattern",
+        "type": "pattern",
+        "multiple": true
+      }
+    ],
+    "since": "1.10.0",
+    "group": "pubsub"
+  },
+  "MULTI": {
+    "summary": "Marks the start of a transaction block",
+    "complexity": "O(1)",
+    "arguments": [],
+    "since": "1.10.0",
+    "group": "transactions"
+  },
+  "EXEC": {
+    "summary": "Executes all commands issued after MULTI",
+    "complexity": "Depends on the commands in the transaction",
+    "arguments": [],
+    "since": "1.10.0",
+    "group": "transactions"
+  },
+  "DISCARD": {
+    "summary": "Discards all commands issued after MULTI",
+    "complexity": "O(N) where N is the number of queued commands",
+    "arguments": [],
+    "since": "1.10.0",
+    "group": "transactions"
+  },
+  "WATCH": {
+    "summary": "Watches objects to determine execution of the MULTI/EXEC block",
+    "complexity": "O(1) for every object",
+    "arguments":[
+      {
+        "name": ["key", "id"],
+        "type": ["string", "string"],
+        "multiple": true
+      }
+    ],
+    "since": "1.10.0",
+    "group": "transactions"
+  },
+  "UNWATCH": {
+    "summary": "Forgets about all watched objects",
+    "complexity": "O(1)",
+    "arguments": [],
+    "since": "1.10.0",
+    "group": "transactions"
+  },
+  "EVAL": {
+    "summary": "Runs a Lua script on the server",
+    "complexity": "Depends on the script",
+    "arguments":[
+      {
+        "name": "script",
+        "type": "string"
+      },
+      {
+        "name": "numkeys",
+        "type": "integer"
+      },
+      {
+        "name": "key",
+        "type": "string",
+        "optional": true,
+        "multiple": true
+      },
+      {
+        "name": "arg",
+        "type": "string",
+        "optional": true,
+        "multiple": true
+      }
+    ],
+    "since": "1.10.0",
+    "group": "scripting"
+  },
+  "EVALSHA": {
+    "summary": "Runs a Lua script that was loaded with SCRIPT LOAD",
+    "complexity": "Depends on the script",
+    "arguments":[
+      {
+        "name": "sha1",
+        "type": "string"
+      },
+      {
+        "name": "numkeys",
+        "type": "integer"
+      },
+      {
+        "name": "key",
+        "type": "string",
+        "optional": true,
+        "multiple": true
+      },
+      {
+        "name": "arg",
+        "type": "string",
+        "optional": true,
+        "multiple": true
+      }
+    ],
+    "since": "1.10.0",
+    "group": "scripting"
+  },
+  "SCRIPT LOAD": {
+    "summary": "Loads a Lua script into the script cache",
+    "complexity": "O(N) with N being the length in bytes of the script body",
+    "arguments":[
+      {
+        "name": "script",
+        "type": "string"
+      }
+    ],
+    "since": "1.10.0",
+    "group": "scripting"
+  },
+  "SCRIPT EXISTS": {
+    "summary": "Checks the existence of scripts in the script cache",
+    "complexity": "O(N) with N being the number of scripts to check",
+    "arguments":[
+      {
+        "name": "sha1",
+        "type": "string",
+        "multiple": true
+      }
+    ],
+    "since": "1.10.0",
+    "group": "scripting"
+  },
+  "SCRIPT FLUSH": {
+    "summary": "Removes all scripts from the script cache",
+    "complexity": "O(N) with N being the number of scripts in cache",
+    "arguments":[],
+    "since": "1.10.0",
+    "group": "scripting"
+  },
+  "ACL SETUSER": {
+    "summary": "Creates or modifies a user with the given rules",
+    "complexity": "O(N) where N is the number of rules provided",
+    "arguments":[
+      {
+        "name": "username",
+        "type": "string"
+      },
+      {
+        "name": "rule",
+        "type": "string",
+        "optional": true,
+        "multiple": true
+      }
+    ],
+    "since": "1.10.0",
+    "group": "acl"
+  },
+  "ACL GETUSER": {
+    "summary": "Gets the rules of a user",
+    "complexity": "O(N) where N is the number of passwords, categories and key patterns of the user",
+    "arguments":[
+      {
+        "name": "username",
+        "type": "string"
+      }
+    ],
+    "since": "1.10.0",
+    "group": "acl"
+  },
+  "ACL DELUSER": {
+    "summary": "Removes users",
+    "complexity": "O(N) where N is the number of users",
+    "arguments":[
+      {
+        "name": "username",
+        "type": "string",
+        "multiple": true
+      }
+    ],
+    "since": "1.10.0",
+    "group": "acl"
+  },
+  "ACL LIST": {
+    "summary": "Lists the users and their rules",
+    "complexity": "O(N) where N is the number of users",
+    "arguments":[],
+    "since": "1.10.0",
+    "group": "acl"
+  },
+  "ACL WHOAMI": {
+    "summary": "Returns the user of the current connection",
+    "complexity": "O(1)",
+    "arguments":[],
+    "since": "1.10.0",
+    "group": "acl"
+  },
   "PDEL": {
     "summary": "Removes all objects matching a pattern",
     "arguments":[
@@ -8,3 +8,18 @@ var ShowDebugMessages = false
 
 // ProtectedMode forces Tile38 to default in protected mode.
 var ProtectedMode = "yes"
+
+// TLSCertFile, TLSKeyFile, TLSCACertFile, TLSAuthClients, and TLSReplication
+// are provided on the command line, and take precedence over the tls
+// properties of the config file.
+var (
+	TLSCertFile    = ""
+	TLSKeyFile     = ""
+	TLSCACertFile  = ""
+	TLSAuthClients = ""
+	TLSReplication = ""
+)
+
+// MetricsAddr is the address of the Prometheus metrics listener, which is
+// disabled when empty.
+var MetricsAddr = ""
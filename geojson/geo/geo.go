@@ -20,6 +20,17 @@ func DistanceTo(latA, lonA, latB, lonB float64) (meters float64) {
 	return earthRadius * c
 }
 
+// BearingTo return the initial bearing in degrees, from 0 to 360, from one
+// point to another.
+func BearingTo(latA, lonA, latB, lonB float64) (bearingDegrees float64) {
+	φ1 := toRadians(latA)
+	φ2 := toRadians(latB)
+	Δλ := toRadians(lonB - lonA)
+	y := math.Sin(Δλ) * math.Cos(φ2)
+	x := math.Cos(φ1)*math.Sin(φ2) - math.Sin(φ1)*math.Cos(φ2)*math.Cos(Δλ)
+	return math.Mod(toDegrees(math.Atan2(y, x))+360, 360)
+}
+
 // DestinationPoint return the destination from a point based on a distance and bearing.
 func DestinationPoint(lat, lon, meters, bearingDegrees float64) (destLat, destLon float64) {
 	// see http://williams.best.vwh.net/avform.htm#LL
@@ -70,6 +70,11 @@ func (p Position) DistanceTo(position Position) float64 {
 	return geo.DistanceTo(p.Y, p.X, position.Y, position.X)
 }
 
+// BearingTo calculates the initial bearing to a position
+func (p Position) BearingTo(position Position) float64 {
+	return geo.BearingTo(p.Y, p.X, position.Y, position.X)
+}
+
 // Destination calculates a new position based on the distance and bearing.
 func (p Position) Destination(meters, bearingDegrees float64) Position {
 	lat, lon := geo.DestinationPoint(p.Y, p.X, meters, bearingDegrees)
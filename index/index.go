@@ -203,3 +203,48 @@ func (ix *Index) Search(swLat, swLon, neLat, neLon, minZ, maxZ float64,
 	}
 	return keepon
 }
+
+// Join iterates over the pairs of items of two indexes whose rectangles
+// intersect. The rectangles of the items of ix are grown by padX and padY.
+func (ix *Index) Join(other *Index, padX, padY float64,
+	iterator func(a, b interface{}) bool,
+) bool {
+	var idm map[[2]interface{}]bool
+	return ix.r.Join(other.r, padX, padY, func(a, b interface{}) bool {
+		a, b = ix.getRTreeItem(a), other.getRTreeItem(b)
+		if a == nil || b == nil {
+			return true
+		}
+		if (len(ix.mulm) > 0 && ix.mulm[a]) || (len(other.mulm) > 0 && other.mulm[b]) {
+			// items that span multiple rects may pair more than once
+			if idm == nil {
+				idm = make(map[[2]interface{}]bool)
+			}
+			if idm[[2]interface{}{a, b}] {
+				return true
+			}
+			idm[[2]interface{}{a, b}] = true
+		}
+		return iterator(a, b)
+	})
+}
+
+// SearchCount is like Search, but the items of the nodes of the rtree that
+// the whole func accepts are passed to count as their number instead. It
+// falls back to Search when the items may be counted more than once.
+func (ix *Index) SearchCount(swLat, swLon, neLat, neLon, minZ, maxZ float64,
+	whole func(minX, minY, maxX, maxY float64) bool,
+	iterator func(item interface{}) bool,
+	count func(minX, minY, maxX, maxY float64, n int) bool,
+) bool {
+	mins, maxs, _ := normRect(swLat, swLon, neLat, neLon)
+	if len(mins) != 1 || len(ix.mulm) > 0 {
+		return ix.Search(swLat, swLon, neLat, neLon, minZ, maxZ, iterator)
+	}
+	return ix.r.SearchCount(mins[0][0], mins[0][1], maxs[0][0], maxs[0][1], whole,
+		func(item interface{}) bool {
+			return iterator(ix.getRTreeItem(item))
+		},
+		count,
+	)
+}
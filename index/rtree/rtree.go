@@ -75,3 +75,28 @@ func (tr *RTree) NearestNeighbors(x, y float64, iter func(item interface{}, dist
 		return iter(item, dist)
 	})
 }
+
+// Join iterates over the pairs of items of two rtrees that intersect. The
+// rectangles of the items of tr are grown by padX and padY.
+func (tr *RTree) Join(other *RTree, padX, padY float64, iter func(a, b interface{}) bool) bool {
+	return tr.tr.Join(other.tr, [2]float64{padX, padY}, iter)
+}
+
+// SearchCount finds all items in bounding box, except for the items of the
+// nodes that the whole func accepts, which are passed to count as the number
+// of items of the node.
+func (tr *RTree) SearchCount(minX, minY, maxX, maxY float64,
+	whole func(minX, minY, maxX, maxY float64) bool,
+	iterator func(data interface{}) bool,
+	count func(minX, minY, maxX, maxY float64, n int) bool,
+) bool {
+	return tr.tr.SearchCount([2]float64{minX, minY}, [2]float64{maxX, maxY},
+		func(min, max [2]float64) bool {
+			return whole(min[0], min[1], max[0], max[1])
+		},
+		iterator,
+		func(min, max [2]float64, n int) bool {
+			return count(min[0], min[1], max[0], max[1], n)
+		},
+	)
+}
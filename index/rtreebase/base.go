@@ -17,6 +17,7 @@ type treeNode struct {
 	min, max [D]float64
 	children [M + 1]*treeNode
 	count    int
+	items    int // the number of items of the subtree
 	height   int
 	leaf     bool
 }
@@ -169,15 +170,16 @@ func (node *treeNode) contains(b *treeNode) bool {
 	return true
 }
 
-func (node *treeNode) childCount() int {
+// calcItems sets the number of items of the subtree from the children.
+func (node *treeNode) calcItems() {
 	if node.leaf {
-		return node.count
+		node.items = node.count
+		return
 	}
-	var n int
+	node.items = 0
 	for i := 0; i < node.count; i++ {
-		n += node.children[i].childCount()
+		node.items += node.children[i].items
 	}
-	return n
 }
 
 type treeItem struct {
@@ -236,6 +238,13 @@ func (tr *RTree) insert(bbox *treeNode, item interface{}, level int, isNode bool
 		node.count++
 	}
 	node.extend(bbox)
+	items := 1
+	if item == nil {
+		items = bbox.items
+	}
+	for _, n := range insertPath {
+		n.items += items
+	}
 	for level >= 0 {
 		if insertPath[level].count > tr.maxEntries {
 			insertPath = tr.split(insertPath, level)
@@ -355,6 +364,7 @@ func (tr *RTree) chooseSplitIndex(node *treeNode, m, M int) int {
 }
 func (tr *RTree) calcBBox(node *treeNode) {
 	tr.distBBox(node, 0, node.count, node)
+	node.calcItems()
 }
 func (tr *RTree) chooseSplitAxis(node *treeNode, m, M int) {
 	minMargin := tr.allDistMargin(node, m, M, 0)
@@ -578,7 +588,7 @@ func (tr *RTree) condense(path []*treeNode) {
 
 // Count returns the number of items in the R-tree.
 func (tr *RTree) Count() int {
-	return tr.data.childCount()
+	return tr.data.items
 }
 
 // Traverse iterates over the entire R-tree and includes all nodes and items.
@@ -655,3 +665,46 @@ func (tr *RTree) Complexity() float64 {
 	})
 	return float64(tr.maxEntries*nodeCount) / float64(itemCount)
 }
+
+// SearchCount is like Search, but the items of a node that the whole func
+// accepts are not visited. They are passed to count as the number of the
+// items of the node instead.
+func (tr *RTree) SearchCount(min, max [D]float64,
+	whole func(min, max [D]float64) bool,
+	iter func(item interface{}) bool,
+	count func(min, max [D]float64, n int) bool,
+) bool {
+	bbox := &treeNode{min: min, max: max}
+	if !tr.data.intersects(bbox) {
+		return true
+	}
+	return tr.searchCount(tr.data, bbox, whole, iter, count)
+}
+
+func (tr *RTree) searchCount(node, bbox *treeNode,
+	whole func(min, max [D]float64) bool,
+	iter func(item interface{}) bool,
+	count func(min, max [D]float64, n int) bool,
+) bool {
+	if whole(node.min, node.max) {
+		return count(node.min, node.max, node.items)
+	}
+	if node.leaf {
+		for i := 0; i < node.count; i++ {
+			if bbox.intersects(node.children[i]) {
+				if !iter(node.children[i].unsafeItem().item) {
+					return false
+				}
+			}
+		}
+	} else {
+		for i := 0; i < node.count; i++ {
+			if bbox.intersects(node.children[i]) {
+				if !tr.searchCount(node.children[i], bbox, whole, iter, count) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
@@ -552,3 +552,81 @@ func TestBenchmarks(t *testing.T) {
 	dur = time.Since(start)
 	log.Printf("bulk-insert 1M items: %.3fs", dur.Seconds())
 }
+
+func TestSearchCount(t *testing.T) {
+	rand.Seed(2)
+	tr := New()
+	for i := 0; i < 10000; i++ {
+		p := ptrMakePoint(rand.Float64()*360-180, rand.Float64()*180-90)
+		tr.Insert(p.min, p.max, p.item)
+	}
+	min, max := [D]float64{-100, -50}, [D]float64{100, 50}
+	var expect int
+	tr.Search(min, max, func(item interface{}) bool {
+		expect++
+		return true
+	})
+	var n, nodes int
+	tr.SearchCount(min, max,
+		func(nmin, nmax [D]float64) bool {
+			// the nodes that are inside of the box
+			return nmin[0] >= min[0] && nmin[1] >= min[1] && nmax[0] <= max[0] && nmax[1] <= max[1]
+		},
+		func(item interface{}) bool {
+			n++
+			return true
+		},
+		func(nmin, nmax [D]float64, count int) bool {
+			n += count
+			nodes++
+			return true
+		},
+	)
+	if n != expect {
+		t.Fatalf("expected %d, got %d", expect, n)
+	}
+	if nodes == 0 {
+		t.Fatal("expected counted nodes")
+	}
+}
+
+func TestNodeItems(t *testing.T) {
+	rand.Seed(3)
+	tr := New()
+	var check func(node *treeNode) int
+	check = func(node *treeNode) int {
+		n := node.count
+		if !node.leaf {
+			n = 0
+			for i := 0; i < node.count; i++ {
+				n += check(node.children[i])
+			}
+		}
+		if node.items != n {
+			t.Fatalf("expected %d items, got %d", n, node.items)
+		}
+		return n
+	}
+	var rects []*Rect
+	for i := 0; i < 5000; i++ {
+		r := ptrMakePoint(rand.Float64()*360-180, rand.Float64()*180-90)
+		tr.Insert(r.min, r.max, r.item)
+		rects = append(rects, r)
+	}
+	var mins, maxs [][D]float64
+	var items []interface{}
+	for i := 0; i < 2000; i++ {
+		r := ptrMakePoint(rand.Float64()*360-180, rand.Float64()*180-90)
+		mins, maxs, items = append(mins, r.min), append(maxs, r.max), append(items, r.item)
+		rects = append(rects, r)
+	}
+	tr.Load(mins, maxs, items)
+	assert.Equal(t, len(rects), check(tr.data))
+	for i, r := range rects {
+		if i%3 != 0 {
+			tr.Remove(r.min, r.max, r.item)
+		}
+	}
+	assert.Equal(t, (len(rects)+2)/3, check(tr.data))
+	assert.Equal(t, (len(rects)+2)/3, tr.Count())
+}
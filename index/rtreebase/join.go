@@ -0,0 +1,57 @@
+package rtreebase
+
+// Join iterates over the pairs of items of two R-trees that intersect. The
+// rectangles of the items of tr are grown by pad on each side. Both trees
+// are walked together, so the subtrees that are apart are never visited.
+func (tr *RTree) Join(other *RTree, pad [D]float64, iter func(a, b interface{}) bool) bool {
+	if tr.data.count == 0 || other.data.count == 0 {
+		return true
+	}
+	if !padIntersects(tr.data, other.data, pad) {
+		return true
+	}
+	return join(tr.data, other.data, pad, iter)
+}
+
+// padIntersects returns true when a, grown by pad, intersects b.
+func padIntersects(a, b *treeNode, pad [D]float64) bool {
+	for i := 0; i < D; i++ {
+		if b.min[i] > a.max[i]+pad[i] || b.max[i] < a.min[i]-pad[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func join(a, b *treeNode, pad [D]float64, iter func(a, b interface{}) bool) bool {
+	switch {
+	case a.leaf && b.leaf:
+		for i := 0; i < a.count; i++ {
+			for j := 0; j < b.count; j++ {
+				if padIntersects(a.children[i], b.children[j], pad) {
+					if !iter(a.children[i].unsafeItem().item, b.children[j].unsafeItem().item) {
+						return false
+					}
+				}
+			}
+		}
+	case b.leaf || (!a.leaf && a.height >= b.height):
+		// descend the taller tree
+		for i := 0; i < a.count; i++ {
+			if padIntersects(a.children[i], b, pad) {
+				if !join(a.children[i], b, pad, iter) {
+					return false
+				}
+			}
+		}
+	default:
+		for j := 0; j < b.count; j++ {
+			if padIntersects(a, b.children[j], pad) {
+				if !join(a, b.children[j], pad, iter) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package rtreebase
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestJoin(t *testing.T) {
+	rand.Seed(1)
+	randRects := func(n int, size float64) []*Rect {
+		rects := make([]*Rect, n)
+		for i := range rects {
+			x, y := rand.Float64()*360-180, rand.Float64()*180-90
+			rects[i] = ptrMakeRect(x, y, x+rand.Float64()*size, y+rand.Float64()*size)
+		}
+		return rects
+	}
+	// trees of different heights
+	as, bs := randRects(5000, 2), randRects(300, 10)
+	tra, trb := New(), New()
+	for _, r := range as {
+		tra.Insert(r.min, r.max, r.item)
+	}
+	for _, r := range bs {
+		trb.Insert(r.min, r.max, r.item)
+	}
+	for _, pad := range [][D]float64{{0, 0}, {0.5, 1}} {
+		expect := make(map[[2]*Rect]bool)
+		for _, a := range as {
+			for _, b := range bs {
+				if padIntersects(a.node(), b.node(), pad) {
+					expect[[2]*Rect{a, b}] = true
+				}
+			}
+		}
+		pairs := make(map[[2]*Rect]bool)
+		tra.Join(trb, pad, func(a, b interface{}) bool {
+			pair := [2]*Rect{a.(*Rect), b.(*Rect)}
+			if pairs[pair] {
+				t.Fatalf("duplicate pair %v", pair)
+			}
+			pairs[pair] = true
+			return true
+		})
+		if len(pairs) != len(expect) {
+			t.Fatalf("expected %d pairs, got %d", len(expect), len(pairs))
+		}
+		for pair := range expect {
+			if !pairs[pair] {
+				t.Fatalf("missing pair %v", pair)
+			}
+		}
+	}
+	var n int
+	tra.Join(trb, [D]float64{}, func(a, b interface{}) bool {
+		n++
+		return n < 10
+	})
+	if n != 10 {
+		t.Fatalf("expected the join to stop at 10, got %d", n)
+	}
+	if !New().Join(trb, [D]float64{}, func(a, b interface{}) bool {
+		t.Fatal("expected no pairs")
+		return true
+	}) {
+		t.Fatal("expected true")
+	}
+}
+
+func (r *Rect) node() *treeNode {
+	return &treeNode{min: r.min, max: r.max}
+}
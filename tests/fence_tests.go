@@ -18,6 +18,12 @@ import (
 func subTestFence(t *testing.T, mc *mockServer) {
 	runStep(t, mc, "basic", fence_basic_test)
 	runStep(t, mc, "detect inside,outside", fence_detect_inside_test)
+	runStep(t, mc, "roam areas", fence_roam_areas_test)
+	runStep(t, mc, "dwell and loiter", fence_dwell_test)
+	runStep(t, mc, "loiter radius", fence_loiter_test)
+	runStep(t, mc, "speed and heading", fence_motion_test)
+	runStep(t, mc, "channels", fence_channel_test)
+	runStep(t, mc, "transactions", fence_multi_test)
 }
 
 type fenceReader struct {
@@ -216,3 +222,380 @@ func fence_detect_inside_test(mc *mockServer) error {
 	}
 	return nil
 }
+
+func fence_roam_areas_test(mc *mockServer) error {
+	c, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if _, err := c.Do("SET", "zones", "z1", "BOUNDS", 32, -116, 34, -114); err != nil {
+		return err
+	}
+	if _, err := c.Do("SET", "zones", "z2", "BOUNDS", 33.5, -116, 35, -114); err != nil {
+		return err
+	}
+	if _, err := c.Do("SET", "zones", "x1", "BOUNDS", 32, -116, 35, -114); err != nil {
+		return err
+	}
+
+	conn, err := net.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "INTERSECTS trucks FENCE ROAM zones z*\r\n")
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+	res := string(buf[:n])
+	if res != "+OK\r\n" {
+		return fmt.Errorf("expected OK, got '%v'", res)
+	}
+	rd := &fenceReader{conn, bufio.NewReader(conn)}
+
+	steps := []struct {
+		lat     float64
+		expects [][2]string
+	}{
+		{33, [][2]string{{"enter", "z1"}, {"inside", "z1"}}},
+		{33.7, [][2]string{{"inside", "z1"}, {"enter", "z2"}, {"inside", "z2"}}},
+		{34.5, [][2]string{{"inside", "z2"}, {"exit", "z1"}}},
+	}
+	for _, step := range steps {
+		if _, err := c.Do("SET", "trucks", "t1", "POINT", step.lat, -115); err != nil {
+			return err
+		}
+		for _, expect := range step.expects {
+			if err := rd.receiveExpect("command", "set",
+				"detect", expect[0],
+				"key", "trucks",
+				"id", "t1",
+				"intersects.key", "zones",
+				"intersects.id", expect[1]); err != nil {
+				return err
+			}
+		}
+	}
+
+	// FSET stays inside of the areas, with the same group
+	if _, err := c.Do("SET", "trucks", "t2", "POINT", 34.5, -115); err != nil {
+		return err
+	}
+	s, err := rd.receive()
+	if err != nil {
+		return err
+	}
+	if gjson.Get(s, "detect").String() != "enter" || gjson.Get(s, "intersects.id").String() != "z2" {
+		return fmt.Errorf("expected enter z2, got '%s'", s)
+	}
+	group := gjson.Get(s, "group").String()
+	if err := rd.receiveExpect("detect", "inside", "id", "t2", "group", group); err != nil {
+		return err
+	}
+	if _, err := c.Do("FSET", "trucks", "t2", "speed", 10); err != nil {
+		return err
+	}
+	if err := rd.receiveExpect("command", "fset",
+		"detect", "inside",
+		"id", "t2",
+		"intersects.id", "z2",
+		"group", group); err != nil {
+		return err
+	}
+
+	// DEL exits all of the areas
+	if _, err := c.Do("DEL", "trucks", "t2"); err != nil {
+		return err
+	}
+	if err := rd.receiveExpect("command", "del",
+		"detect", "exit",
+		"id", "t2",
+		"intersects.id", "z2",
+		"group", group); err != nil {
+		return err
+	}
+	if s, err = rd.receive(); err != nil {
+		return err
+	}
+	if gjson.Get(s, "command").String() != "del" || gjson.Get(s, "detect").Exists() {
+		return fmt.Errorf("expected del, got '%s'", s)
+	}
+
+	// an object that expires exits all of the areas
+	if _, err := c.Do("SET", "trucks", "t3", "EX", 1, "POINT", 34.5, -115); err != nil {
+		return err
+	}
+	if err := rd.receiveExpect("detect", "enter", "id", "t3", "intersects.id", "z2"); err != nil {
+		return err
+	}
+	if s, err = rd.receive(); err != nil {
+		return err
+	}
+	group = gjson.Get(s, "group").String()
+	time.Sleep(time.Second * 3 / 2)
+	return rd.receiveExpect("command", "del",
+		"detect", "exit",
+		"id", "t3",
+		"intersects.id", "z2",
+		"group", group)
+}
+
+func fence_dwell_test(mc *mockServer) error {
+	if err := mc.DoBatch([][]interface{}{
+		{"NEARBY", "mykey", "FENCE", "DETECT", "dwell", "POINT", 33, -115, 5000}, {"ERR DWELL is required to detect dwell or loiter"},
+		{"NEARBY", "mykey", "DWELL", 10, "POINT", 33, -115, 5000}, {"ERR DWELL is not allowed when FENCE is not specified"},
+		{"NEARBY", "mykey", "FENCE", "LOITER", 10, "POINT", 33, -115, 5000}, {"ERR LOITER is not allowed when DWELL is not specified"},
+		{"NEARBY", "mykey", "FENCE", "DWELL", 10, "LOITER", 0, "POINT", 33, -115, 5000}, {"ERR invalid argument '0'"},
+	}); err != nil {
+		return err
+	}
+	conn, err := net.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "NEARBY mykey FENCE DETECT dwell,loiter DWELL 0.3 POINT 33 -115 5000\r\n")
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+	res := string(buf[:n])
+	if res != "+OK\r\n" {
+		return fmt.Errorf("expected OK, got '%v'", res)
+	}
+	rd := &fenceReader{conn, bufio.NewReader(conn)}
+
+	c, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if _, err := c.Do("SET", "mykey", "myid1", "POINT", 33, -115); err != nil {
+		return err
+	}
+	if err := rd.receiveExpect("command", "set", "detect", "dwell", "id", "myid1"); err != nil {
+		return err
+	}
+	if err := rd.receiveExpect("command", "set", "detect", "loiter", "id", "myid1"); err != nil {
+		return err
+	}
+	// moving away from the loiter point starts a new loiter, but not a new dwell
+	if _, err := c.Do("SET", "mykey", "myid1", "POINT", 33.01, -115); err != nil {
+		return err
+	}
+	if err := rd.receiveExpect("command", "set", "detect", "loiter", "id", "myid1",
+		"object.coordinates", "[-115,33.01]"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func fence_loiter_test(mc *mockServer) error {
+	conn, err := net.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "NEARBY loiterkey FENCE DETECT loiter DWELL 0.3 LOITER 5 POINT 33 -115 5000\r\n")
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+	res := string(buf[:n])
+	if res != "+OK\r\n" {
+		return fmt.Errorf("expected OK, got '%v'", res)
+	}
+	rd := &fenceReader{conn, bufio.NewReader(conn)}
+
+	c, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if _, err := c.Do("SET", "loiterkey", "myid1", "POINT", 33, -115); err != nil {
+		return err
+	}
+	if err := rd.receiveExpect("command", "set", "detect", "loiter", "id", "myid1"); err != nil {
+		return err
+	}
+	// about 11 meters is within the default radius, but not within 5 meters
+	if _, err := c.Do("SET", "loiterkey", "myid1", "POINT", 33.0001, -115); err != nil {
+		return err
+	}
+	return rd.receiveExpect("command", "set", "detect", "loiter", "id", "myid1",
+		"object.coordinates", "[-115,33.0001]")
+}
+
+func fence_motion_test(mc *mockServer) error {
+	if err := mc.DoBatch([][]interface{}{
+		{"NEARBY", "mykey", "SPEED", 10, "+inf", "POINT", 33, -115, 5000}, {"ERR SPEED, HEADING, and ACCEL are not allowed when FENCE is not specified"},
+		{"NEARBY", "mykey", "FENCE", "HEADING", 0, 400, "POINT", 33, -115, 5000}, {"ERR invalid argument '400'"},
+		{"NEARBY", "mykey", "FENCE", "SPEED", "slow", "+inf", "POINT", 33, -115, 5000}, {"ERR invalid argument 'slow'"},
+	}); err != nil {
+		return err
+	}
+	conn, err := net.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "NEARBY mykey FENCE SPEED 10 +inf HEADING 315 45 POINT 33 -115 5000\r\n")
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+	res := string(buf[:n])
+	if res != "+OK\r\n" {
+		return fmt.Errorf("expected OK, got '%v'", res)
+	}
+	rd := &fenceReader{conn, bufio.NewReader(conn)}
+
+	c, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	// the first position has no speed, staying still is too slow, and going
+	// east is outside of the heading range
+	for _, pos := range [][]float64{{33, -115}, {33.01, -115}, {33.01, -115}, {33.01, -114.99}, {33.02, -114.99}} {
+		if _, err := c.Do("SET", "mykey", "myid3", "POINT", pos[0], pos[1]); err != nil {
+			return err
+		}
+		time.Sleep(time.Second / 10)
+	}
+	if err := rd.receiveExpect("command", "set", "detect", "inside", "id", "myid3",
+		"object.coordinates", "[-115,33.01]", "bearing", "0"); err != nil {
+		return err
+	}
+	if err := rd.receiveExpect("command", "set", "detect", "inside", "id", "myid3",
+		"object.coordinates", "[-114.99,33.02]", "bearing", "0"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func fence_channel_test(mc *mockServer) error {
+	if err := mc.DoBatch([][]interface{}{
+		{"SETCHAN", "mychan", "NEARBY", "mykey", "FENCE", "DETECT", "enter", "POINT", 33, -115, 5000}, {1},
+		{"SETCHAN", "mychan", "RETRIES", 1, "NEARBY", "mykey", "FENCE", "POINT", 33, -115, 5000}, {"ERR invalid argument 'RETRIES'"},
+		{"CHANS", "*"}, {"[[mychan mykey [NEARBY mykey FENCE DETECT enter POINT 33 -115 5000]]]"},
+		{"HOOKS", "mychan"}, {"[]"},
+		{"DELHOOK", "mychan"}, {0},
+	}); err != nil {
+		return err
+	}
+	var psc [2]redis.PubSubConn
+	for i := range psc {
+		c, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		psc[i] = redis.PubSubConn{Conn: c}
+	}
+	if err := psc[0].Subscribe("mychan"); err != nil {
+		return err
+	}
+	if err := psc[1].PSubscribe("my*"); err != nil {
+		return err
+	}
+	for i := range psc {
+		if _, ok := psc[i].Receive().(redis.Subscription); !ok {
+			return errors.New("expected subscription")
+		}
+	}
+	if err := mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "mychanid", "POINT", 33, -115}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	for i := range psc {
+		switch v := psc[i].Receive().(type) {
+		case redis.Message:
+			if v.Channel != "mychan" || gjson.GetBytes(v.Data, "detect").String() != "enter" ||
+				gjson.GetBytes(v.Data, "id").String() != "mychanid" {
+				return fmt.Errorf("unexpected message %s", v.Data)
+			}
+		case redis.PMessage:
+			if v.Pattern != "my*" || v.Channel != "mychan" ||
+				gjson.GetBytes(v.Data, "id").String() != "mychanid" {
+				return fmt.Errorf("unexpected message %s", v.Data)
+			}
+		default:
+			return fmt.Errorf("unexpected %v", v)
+		}
+	}
+	return mc.DoBatch([][]interface{}{
+		{"DELCHAN", "mychan"}, {1},
+		{"CHANS", "*"}, {"[]"},
+	})
+}
+
+func fence_multi_test(mc *mockServer) error {
+	if err := mc.DoBatch([][]interface{}{
+		{"SETCHAN", "multichan", "NEARBY", "multikey", "FENCE", "DETECT", "enter", "POINT", 33, -115, 5000}, {1},
+	}); err != nil {
+		return err
+	}
+	c, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	psc := redis.PubSubConn{Conn: c}
+	if err := psc.Subscribe("multichan"); err != nil {
+		return err
+	}
+	if _, ok := psc.Receive().(redis.Subscription); !ok {
+		return errors.New("expected subscription")
+	}
+	// the object is notified once, with the state after the transaction
+	if err := mc.DoBatch([][]interface{}{
+		{"MULTI"}, {"OK"},
+		{"SET", "multikey", "id1", "POINT", 10, 10}, {"QUEUED"},
+		{"SET", "multikey", "id1", "POINT", 33, -115}, {"QUEUED"},
+		{"FSET", "multikey", "id1", "speed", 10}, {"QUEUED"},
+		{"SET", "multikey", "id2", "POINT", 33, -115}, {"QUEUED"},
+		{"DEL", "multikey", "id2"}, {"QUEUED"},
+		{"EXEC"}, {"[OK OK 1 OK 1]"},
+		{"SET", "multikey", "id3", "POINT", 33, -115}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	var ids []string
+	for len(ids) < 2 {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			ids = append(ids, gjson.GetBytes(v.Data, "id").String())
+			if len(ids) == 1 && (ids[0] != "id1" || gjson.GetBytes(v.Data, "fields.speed").Float() != 10) {
+				return fmt.Errorf("unexpected message %s", v.Data)
+			}
+		default:
+			return fmt.Errorf("unexpected %v", v)
+		}
+	}
+	if ids[1] != "id3" {
+		return fmt.Errorf("expected 'id3', got '%s'", ids[1])
+	}
+	return mc.DoBatch([][]interface{}{
+		{"DELCHAN", "multichan"}, {1},
+	})
+}
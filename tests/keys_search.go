@@ -1,9 +1,20 @@
 package tests
 
-import "testing"
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
 
 func subTestSearch(t *testing.T, mc *mockServer) {
 	runStep(t, mc, "KNN", keys_KNN_test)
+	runStep(t, mc, "WHEREEXPR", keys_WHEREEXPR_test)
+	runStep(t, mc, "INDEX", keys_INDEX_test)
+	runStep(t, mc, "CURSOR", keys_CURSOR_test)
+	runStep(t, mc, "JOIN", keys_JOIN_test)
+	runStep(t, mc, "AGG", keys_AGG_test)
+	runStep(t, mc, "GRID", keys_GRID_test)
 }
 
 func keys_KNN_test(mc *mockServer) error {
@@ -23,3 +34,238 @@ func keys_KNN_test(mc *mockServer) error {
 				"]]"},
 	})
 }
+
+func keys_WHEREEXPR_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "fleet", "truck1", "FIELD", "speed", 45, "FIELD", "fuel", 8, "FIELD", "status", 1, "POINT", 33, -115}, {"OK"},
+		{"SET", "fleet", "truck2", "FIELD", "speed", 50, "FIELD", "fuel", 60, "FIELD", "status", 3, "POINT", 33.01, -115}, {"OK"},
+		{"SET", "fleet", "truck3", "FIELD", "speed", 10, "FIELD", "fuel", 5, "FIELD", "status", 3, "POINT", 33.02, -115}, {"OK"},
+		{"SET", "fleet", "truck4", "FIELD", "speed", 60, "FIELD", "fuel", 50, "FIELD", "status", 1, "FIELD", "name", "bob", "POINT", 33.03, -115}, {"OK"},
+		{"SCAN", "fleet", "WHEREEXPR", "speed > 30 AND (fuel < 10 OR status == 3)", "IDS"}, {"[0 [truck1 truck2]]"},
+		{"SCAN", "fleet", "WHEREEXPR", "speed > 30", "WHEREEXPR", "fuel > 10", "IDS"}, {"[0 [truck2 truck4]]"},
+		{"SCAN", "fleet", "WHEREEXPR", "name == 'bob'", "COUNT"}, {"1"},
+		{"SCAN", "fleet", "WHERE", "status", 3, 3, "WHEREEXPR", "speed < 20", "IDS"}, {"[0 [truck3]]"},
+		{"WITHIN", "fleet", "WHEREEXPR", "not (speed >= 45)", "IDS", "BOUNDS", 32.9, -115.1, 33.1, -114.9}, {"[0 [truck3]]"},
+		{"NEARBY", "fleet", "WHEREEXPR", "fuel * 2 > 100", "IDS", "POINT", 33, -115, 10000}, {"[0 [truck2]]"},
+		{"SCAN", "fleet", "WHEREEXPR", "speed >", "IDS"}, {"ERR invalid expression: unexpected end of expression"},
+	})
+}
+
+func keys_INDEX_test(mc *mockServer) error {
+	// enough slow trucks for a WHERE on fast trucks to be selective
+	var batch [][]interface{}
+	for i := 0; i < 50; i++ {
+		batch = append(batch,
+			[]interface{}{"SET", "fleet", fmt.Sprintf("slow%d", i), "FIELD", "speed", 1, "POINT", 33, -115}, []interface{}{"OK"},
+		)
+	}
+	if err := mc.DoBatch(batch); err != nil {
+		return err
+	}
+	return mc.DoBatch([][]interface{}{
+		{"SET", "fleet", "truck1", "FIELD", "speed", 45, "POINT", 33, -115}, {"OK"},
+		{"SET", "fleet", "truck2", "FIELD", "speed", 10, "POINT", 33.01, -115}, {"OK"},
+		{"SET", "fleet", "truck3", "FIELD", "speed", 70, "POINT", 33.02, -115}, {"OK"},
+		{"SCAN", "fleet", "ORDERBY", "speed", "IDS"}, {"ERR field 'speed' is not indexed"},
+		{"INDEX", "fleet", "FIELD", "speed"}, {1},
+		{"INDEX", "fleet", "FIELD", "speed"}, {0},
+		{"INDEX", "fleet", "FIELD", "z"}, {"ERR invalid argument 'z'"},
+		{"INDEXES", "fleet"}, {"[speed]"},
+		{"SET", "fleet", "truck4", "FIELD", "speed", 50, "POINT", 33.03, -115}, {"OK"},
+		{"SET", "fleet", "truck5", "POINT", 33.04, -115}, {"OK"},
+		{"FSET", "fleet", "truck2", "speed", 20}, {1},
+		{"SCAN", "fleet", "ORDERBY", "speed", "MATCH", "truck*", "IDS"}, {"[0 [truck5 truck2 truck1 truck4 truck3]]"},
+		{"SCAN", "fleet", "ORDERBY", "speed", "DESC", "LIMIT", 5, "IDS"}, {"[5 [truck3 truck4 truck1 truck2 slow9]]"},
+		{"SCAN", "fleet", "ORDERBY", "speed", "WHERE", "speed", 40, "(70", "IDS"}, {"[0 [truck1 truck4]]"},
+		{"SCAN", "fleet", "ORDERBY", "speed", "LIMIT", 2, "IDS"}, {"[2 [truck5 slow0]]"},
+		{"SCAN", "fleet", "WHERE", "speed", 50, "+inf", "IDS"}, {"[0 [truck3 truck4]]"},
+		{"SCAN", "fleet", "WHERE", "speed", 40, "+inf", "DESC", "IDS"}, {"[0 [truck4 truck3 truck1]]"},
+		{"SCAN", "fleet", "WHERE", "speed", 40, "+inf", "LIMIT", 2, "IDS"}, {"[2 [truck1 truck3]]"},
+		{"WITHIN", "fleet", "WHERE", "speed", 60, "+inf", "IDS", "BOUNDS", 32.9, -115.1, 33.1, -114.9}, {"[0 [truck3]]"},
+		{"NEARBY", "fleet", "WHERE", "speed", 60, "+inf", "IDS", "POINT", 33, -115, 10000}, {"[0 [truck3]]"},
+		{"DEL", "fleet", "truck3"}, {1},
+		{"SCAN", "fleet", "WHERE", "speed", 50, "+inf", "IDS"}, {"[0 [truck4]]"},
+		{"NEARBY", "fleet", "ORDERBY", "speed", "IDS", "POINT", 33, -115, 10000}, {"ERR ORDERBY is not allowed for NEARBY"},
+		{"DROP", "fleet"}, {1},
+		{"SET", "fleet", "truck1", "FIELD", "speed", 45, "POINT", 33, -115}, {"OK"},
+		{"SCAN", "fleet", "ORDERBY", "speed", "IDS"}, {"[0 [truck1]]"},
+		{"DELINDEX", "fleet", "FIELD", "speed"}, {1},
+		{"DELINDEX", "fleet", "FIELD", "speed"}, {0},
+		{"INDEXES", "fleet"}, {"[]"},
+	})
+}
+
+// page checks the items of a page that has an opaque cursor, which is stored
+// in cursor.
+func page(cursor *string, items string) func(v interface{}) (resp, expect interface{}) {
+	return func(v interface{}) (resp, expect interface{}) {
+		vals, ok := v.([]string)
+		if !ok || len(vals) != 2 || vals[0] == "0" {
+			return v, items
+		}
+		if cursor != nil {
+			*cursor = vals[0]
+		}
+		return vals[1], items
+	}
+}
+
+func keys_CURSOR_test(mc *mockServer) error {
+	var cursor string
+	if err := mc.DoBatch([][]interface{}{
+		{"SET", "cur", "a", "POINT", 33.001, -115}, {"OK"},
+		{"SET", "cur", "b", "POINT", 33.002, -115}, {"OK"},
+		{"SET", "cur", "c", "POINT", 33.003, -115}, {"OK"},
+		{"SET", "cur", "d", "POINT", 33.004, -115}, {"OK"},
+		{"SCAN", "cur", "LIMIT", 2, "IDS"}, {"[2 [a b]]"},
+		{"SCAN", "cur", "CURSOR", "*", "LIMIT", 2, "IDS"}, {page(&cursor, "[a b]")},
+		{"DEL", "cur", "a"}, {1},
+	}); err != nil {
+		return err
+	}
+	// the deleted item doesn't shift the next page
+	if err := mc.DoBatch([][]interface{}{
+		{"SCAN", "cur", "CURSOR", cursor, "LIMIT", 3, "IDS"}, {"[0 [c d]]"},
+		{"SCAN", "cur", "CURSOR", "not-a-cursor", "IDS"}, {"ERR invalid argument 'not-a-cursor'"},
+		{"NEARBY", "cur", "CURSOR", cursor, "IDS", "POINT", 33, -115, 1000}, {"ERR invalid cursor"},
+		{"NEARBY", "cur", "LIMIT", 2, "IDS", "POINT", 33, -115, 1000}, {func(v interface{}) (resp, expect interface{}) {
+			// the order of the index, with an offset
+			return v.([]string)[0], "2"
+		}},
+		{"NEARBY", "cur", "CURSOR", "*", "LIMIT", 2, "IDS", "POINT", 33, -115, 1000}, {page(&cursor, "[b c]")},
+		{"SET", "cur", "a", "POINT", 33.001, -115}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	// the inserted item, which is closer than the cursor, isn't repeated
+	if err := mc.DoBatch([][]interface{}{
+		{"NEARBY", "cur", "CURSOR", cursor, "LIMIT", 2, "IDS", "POINT", 33, -115, 1000}, {"[0 [d]]"},
+		{"NEARBY", "cur", "CURSOR", "*", "LIMIT", 2, "IDS", "POINT", 33, -115}, {page(&cursor, "[a b]")},
+		{"DEL", "cur", "b"}, {1},
+	}); err != nil {
+		return err
+	}
+	if err := mc.DoBatch([][]interface{}{
+		{"NEARBY", "cur", "CURSOR", cursor, "LIMIT", 3, "IDS", "POINT", 33, -115}, {"[0 [c d]]"},
+		{"WITHIN", "cur", "LIMIT", 2, "IDS", "BOUNDS", 32.9, -115.1, 33.1, -114.9}, {func(v interface{}) (resp, expect interface{}) {
+			// the order of the index, with an offset
+			return v.([]string)[0], "2"
+		}},
+		{"SCAN", "cur", "CURSOR", "*", "LIMIT", 1, "IDS"}, {page(nil, "[a]")},
+		{"WITHIN", "cur", "CURSOR", "*", "LIMIT", 2, "IDS", "BOUNDS", 32.9, -115.1, 33.1, -114.9}, {page(&cursor, "[a c]")},
+		{"SET", "cur", "b", "POINT", 33.002, -115}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	return mc.DoBatch([][]interface{}{
+		{"WITHIN", "cur", "CURSOR", cursor, "IDS", "BOUNDS", 32.9, -115.1, 33.1, -114.9}, {"[0 [d]]"},
+	})
+}
+
+func keys_JOIN_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "zones", "zone1", "BOUNDS", 32.9, -115.1, 33.1, -114.9}, {"OK"},
+		{"SET", "zones", "zone2", "BOUNDS", 33.9, -115.1, 34.1, -114.9}, {"OK"},
+		{"SET", "trucks", "truck1", "FIELD", "speed", 0, "POINT", 33, -115}, {"OK"},
+		{"SET", "trucks", "truck2", "FIELD", "speed", 40, "POINT", 33.001, -115}, {"OK"},
+		{"SET", "trucks", "truck3", "FIELD", "speed", 0, "POINT", 35, -115}, {"OK"},
+		{"JOIN", "trucks", "zones", "WITHIN", "WHERE", "A", "speed", 0, 0, "IDS"}, {"[0 [[truck1 zone1]]]"},
+		{"JOIN", "trucks", "zones", "WITHIN", "OBJECTS"}, {func(v interface{}) (resp, expect interface{}) {
+			return len(v.([]string)), 2
+		}},
+		{"JOIN", "trucks", "zones", "WITHIN", "COUNT"}, {2},
+		{"JOIN", "zones", "trucks", "INTERSECTS", "COUNT"}, {2},
+		{"JOIN", "zones", "trucks", "INTERSECTS", "WHERE", "B", "speed", 10, "+inf", "IDS"}, {"[0 [[zone1 truck2]]]"},
+		{"JOIN", "trucks", "trucks", "NEARBY", 200, "COUNT"}, {2},
+		{"JOIN", "trucks", "trucks", "NEARBY", 0, "IDS"}, {"[0 []]"},
+		{"JOIN", "trucks", "trucks", "NEARBY", 200, "WHERE", "A", "speed", 0, 0, "WHERE", "B", "speed", 1, "+inf", "IDS"}, {"[0 [[truck1 truck2]]]"},
+		{"JOIN", "trucks", "zones", "WITHIN", "LIMIT", 1, "IDS"}, {func(v interface{}) (resp, expect interface{}) {
+			return v.([]string)[0], "1"
+		}},
+		{"JOIN", "trucks", "zones", "WITHIN", "CURSOR", 1, "LIMIT", 1, "IDS"}, {func(v interface{}) (resp, expect interface{}) {
+			return v.([]string)[0], "2"
+		}},
+		{"JOIN", "trucks", "zones", "WITHIN", "CURSOR", 2, "LIMIT", 1, "IDS"}, {"[0 []]"},
+		{"JOIN", "trucks", "zones", "WITHIN", "CURSOR", 1, "COUNT"}, {1},
+		{"JOIN", "trucks", "zones", "WITHIN", "CURSOR", 1, "LIMIT", 1, "COUNT"}, {1},
+		{"JOIN", "trucks", "zones", "WITHIN", "LIMIT", 1, "COUNT"}, {1},
+		{"OUTPUT", "json"}, {func(v interface{}) (resp, expect interface{}) {
+			return gjson.Get(v.(string), "ok").Bool(), true
+		}},
+		{"JOIN", "trucks", "zones", "WITHIN", "CURSOR", 1, "IDS"}, {func(v interface{}) (resp, expect interface{}) {
+			return gjson.Get(v.(string), "count").Int(), int64(1)
+		}},
+		{"OUTPUT", "resp"}, {"OK"},
+		{"JOIN", "trucks", "nokey", "WITHIN", "COUNT"}, {0},
+		{"JOIN", "trucks", "zones", "CONTAINS"}, {"ERR invalid argument 'CONTAINS'"},
+		{"JOIN", "trucks", "zones", "WITHIN", "WHERE", "C", "speed", 0, 0}, {"ERR invalid argument 'C'"},
+	})
+}
+
+func keys_AGG_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "fleet", "truck1", "FIELD", "speed", 10, "POINT", 33, -115}, {"OK"},
+		{"SET", "fleet", "truck2", "FIELD", "speed", 30, "FIELD", "kind", 2, "POINT", 33.01, -115}, {"OK"},
+		{"SET", "fleet", "truck3", "FIELD", "speed", 50, "FIELD", "kind", 2, "POINT", 40, -100}, {"OK"},
+		{"SCAN", "fleet", "AGG", "SUM", "speed", "AVG", "speed", "MIN", "speed", "MAX", "speed"}, {
+			"[count 3 sum(speed) 90 avg(speed) 30 min(speed) 10 max(speed) 50]",
+		},
+		{"SCAN", "fleet", "WHERE", "speed", 20, "+inf", "AGG", "AVG", "speed"}, {"[count 2 avg(speed) 40]"},
+		{"SCAN", "fleet", "AGG", "GROUPBY", "kind", "SUM", "speed"}, {
+			"[[0 [count 1 sum(speed) 10]] [2 [count 2 sum(speed) 80]]]",
+		},
+		{"SCAN", "fleet", "AGG", "MIN", "missing"}, {"[count 3 min(missing) 0]"},
+		{"NEARBY", "fleet", "AGG", "GROUPBY", "HASH", 3, "MAX", "speed", "POINT", 33, -115, 5000}, {
+			"[[9my [count 2 max(speed) 30]]]",
+		},
+		{"NEARBY", "fleet", "LIMIT", 1, "AGG", "SUM", "speed", "POINT", 33, -115}, {"[count 1 sum(speed) 10]"},
+		{"WITHIN", "fleet", "AGG", "GROUPBY", "TILE", 4, "AVG", "speed", "BOUNDS", 30, -120, 45, -90}, {
+			"[[4/2/6 [count 2 avg(speed) 20]] [4/3/6 [count 1 avg(speed) 50]]]",
+		},
+		{"INTERSECTS", "fleet", "AGG", "SUM", "speed", "BOUNDS", 30, -120, 35, -110}, {"[count 2 sum(speed) 40]"},
+		{"WITHIN", "nokey", "AGG", "AVG", "speed", "BOUNDS", 30, -120, 45, -90}, {"[count 0 avg(speed) nil]"},
+		{"SCAN", "fleet", "AGG"}, {"ERR AGG requires an aggregate or GROUPBY"},
+		{"SCAN", "fleet", "AGG", "GROUPBY", "HASH", 0}, {"ERR invalid argument '0'"},
+	})
+}
+
+func keys_GRID_test(mc *mockServer) error {
+	// a lattice of 20x20 points, which is counted by the parts of the index
+	var lattice [][]interface{}
+	for i := 0; i < 400; i++ {
+		lattice = append(lattice, []interface{}{"SET", "lattice", fmt.Sprintf("p%d", i),
+			"POINT", 30.25 + float64(i/20)*0.5, -119.75 + float64(i%20)*0.5}, []interface{}{"OK"})
+	}
+	quarters := "[" +
+		"[0,0 [[30 -120] [35 -115]] [count 100]] [0,1 [[35 -120] [40 -115]] [count 100]] " +
+		"[1,0 [[30 -115] [35 -110]] [count 100]] [1,1 [[35 -115] [40 -110]] [count 100]]]"
+	return mc.DoBatch(lattice, [][]interface{}{
+		{"WITHIN", "lattice", "GRID", "SIZE", 2, 2, "BOUNDS", 30, -120, 40, -110}, {quarters},
+		{"INTERSECTS", "lattice", "GRID", "SIZE", 2, 2, "BOUNDS", 30, -120, 40, -110}, {quarters},
+		{"WITHIN", "lattice", "WHERE", "z", "-inf", "+inf", "GRID", "SIZE", 2, 2, "BOUNDS", 30, -120, 40, -110}, {quarters},
+		{"WITHIN", "lattice", "GRID", "SIZE", 1, 1, "BOUNDS", 30, -120, 32, -118}, {
+			"[[0,0 [[30 -120] [32 -118]] [count 16]]]",
+		},
+
+		{"SET", "fleet", "truck1", "FIELD", "speed", 10, "POINT", 33.5, -112.1}, {"OK"},
+		{"SET", "fleet", "truck2", "FIELD", "speed", 20, "POINT", 33.6, -112.2}, {"OK"},
+		{"SET", "fleet", "truck3", "FIELD", "speed", 30, "POINT", 34.5, -111.1}, {"OK"},
+		{"WITHIN", "fleet", "GRID", "HASH", 3, "BOUNDS", 33, -113, 35, -111}, {
+			"[[9tb [[32.34375 -112.5] [33.75 -111.09375]] [count 2]] " +
+				"[9w0 [[33.75 -112.5] [35.15625 -111.09375]] [count 1]]]",
+		},
+		{"WITHIN", "fleet", "GRID", "QUAD", 6, "SUM", "speed", "BOUNDS", 33, -113, 35, -111}, {
+			"[[023102 [[31.952162238024954 -112.5] [36.597889133070204 -106.875]] [count 3 sum(speed) 60]]]",
+		},
+		{"INTERSECTS", "fleet", "GRID", "SIZE", 2, 2, "AVG", "speed", "BOUNDS", 33, -113, 35, -111}, {
+			"[[0,0 [[33 -113] [34 -112]] [count 2 avg(speed) 15]] [1,1 [[34 -112] [35 -111]] [count 1 avg(speed) 30]]]",
+		},
+		{"WITHIN", "nokey", "GRID", "HASH", 3, "BOUNDS", 33, -113, 35, -111}, {"[]"},
+		{"NEARBY", "fleet", "GRID", "HASH", 3, "POINT", 33, -112, 1000}, {"ERR GRID is not allowed for NEARBY"},
+		{"WITHIN", "fleet", "FENCE", "GRID", "HASH", 3, "BOUNDS", 33, -113, 35, -111}, {
+			"ERR GRID is not allowed when FENCE is specified",
+		},
+		{"WITHIN", "fleet", "GRID", "SIZE", 0, 2, "BOUNDS", 33, -113, 35, -111}, {"ERR invalid argument '0'"},
+		{"WITHIN", "fleet", "GRID", "BOX", 2, "BOUNDS", 33, -113, 35, -111}, {"ERR invalid argument 'BOX'"},
+	})
+}
@@ -1,9 +1,13 @@
 package tests
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -30,6 +34,16 @@ func subTestKeys(t *testing.T, mc *mockServer) {
 	runStep(t, mc, "PDEL", keys_PDEL_test)
 	runStep(t, mc, "FIELDS", keys_FIELDS_test)
 	runStep(t, mc, "WHEREIN", keys_WHEREIN_test)
+	runStep(t, mc, "TYPED FIELDS", keys_TYPED_FIELDS_test)
+	runStep(t, mc, "SAVE", keys_SAVE_test)
+	runStep(t, mc, "ROLE", keys_ROLE_test)
+	runStep(t, mc, "HOOKS DLQ", keys_HOOKS_DLQ_test)
+	runStep(t, mc, "HOOKS HTTP", keys_HOOKS_HTTP_test)
+	runStep(t, mc, "MULTI", keys_MULTI_test)
+	runStep(t, mc, "ACL", keys_ACL_test)
+	runStep(t, mc, "SLOWLOG", keys_SLOWLOG_test)
+	runStep(t, mc, "MONITOR", keys_MONITOR_test)
+	runStep(t, mc, "HISTORY", keys_HISTORY_test)
 }
 
 func keys_BOUNDS_test(mc *mockServer) error {
@@ -302,8 +316,8 @@ func keys_FIELDS_test(mc *mockServer) error {
 	return mc.DoBatch([][]interface{}{
 		{"SET", "mykey", "myid1a", "FIELD", "a", 1, "POINT", 33, -115}, {"OK"},
 		{"GET", "mykey", "myid1a", "WITHFIELDS"}, {`[{"type":"Point","coordinates":[-115,33]} [a 1]]`},
-		{"SET", "mykey", "myid1a", "FIELD", "a", "a", "POINT", 33, -115}, {"ERR invalid argument 'a'"},
-		{"GET", "mykey", "myid1a", "WITHFIELDS"}, {`[{"type":"Point","coordinates":[-115,33]} [a 1]]`},
+		{"SET", "mykey", "myid1a", "FIELD", "a", "a", "POINT", 33, -115}, {"OK"},
+		{"GET", "mykey", "myid1a", "WITHFIELDS"}, {`[{"type":"Point","coordinates":[-115,33]} [a a]]`},
 		{"SET", "mykey", "myid1a", "FIELD", "a", 1, "FIELD", "b", 2, "POINT", 33, -115}, {"OK"},
 		{"GET", "mykey", "myid1a", "WITHFIELDS"}, {`[{"type":"Point","coordinates":[-115,33]} [a 1 b 2]]`},
 		{"SET", "mykey", "myid1a", "FIELD", "b", 2, "POINT", 33, -115}, {"OK"},
@@ -340,9 +354,402 @@ func keys_WHEREIN_test(mc *mockServer) error {
 		{"WITHIN", "mykey", "WHEREIN", "a", 3, 0, 1, 2, "BOUNDS", 32.8, -115.2, 33.2, -114.8}, {`[0 [[myid_a1 {"type":"Point","coordinates":[-115,33]} [a 1]]]]`},
 		{"WITHIN", "mykey", "WHEREIN", "a", "a", 0, 1, 2, "BOUNDS", 32.8, -115.2, 33.2, -114.8}, {"ERR invalid argument 'a'"},
 		{"WITHIN", "mykey", "WHEREIN", "a", 1, 0, 1, 2, "BOUNDS", 32.8, -115.2, 33.2, -114.8}, {"ERR invalid argument '1'"},
-		{"WITHIN", "mykey", "WHEREIN", "a", 3, 0, "a", 2, "BOUNDS", 32.8, -115.2, 33.2, -114.8}, {"ERR invalid argument 'a'"},
+		{"WITHIN", "mykey", "WHEREIN", "a", 3, 0, "a", 2, "BOUNDS", 32.8, -115.2, 33.2, -114.8}, {`[0 []]`},
 		{"SET", "mykey", "myid_a2", "FIELD", "a", 2, "POINT", 32.99, -115}, {"OK"},
 		{"SET", "mykey", "myid_a3", "FIELD", "a", 3, "POINT", 33, -115.02}, {"OK"},
 		{"WITHIN", "mykey", "WHEREIN", "a", 3, 0, 1, 2, "BOUNDS", 32.8, -115.2, 33.2, -114.8}, {`[0 [[myid_a1 {"type":"Point","coordinates":[-115,33]} [a 1]] [myid_a2 {"type":"Point","coordinates":[-115,32.99]} [a 2]]]]`},
 	})
 }
+
+func keys_TYPED_FIELDS_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "myid1", "FIELD", "name", "bob", "FIELD", "active", "true", "POINT", 33, -115}, {"OK"},
+		{"GET", "mykey", "myid1", "WITHFIELDS"}, {`[{"type":"Point","coordinates":[-115,33]} [active true name bob]]`},
+		{"SET", "mykey", "myid2", "FIELD", "name", "alice", "FIELD", "active", "FALSE", "POINT", 33, -115}, {"OK"},
+		{"GET", "mykey", "myid2", "WITHFIELDS"}, {`[{"type":"Point","coordinates":[-115,33]} [active false name alice]]`},
+		{"FSET", "mykey", "myid2", "name", "carol"}, {1},
+		{"FSET", "mykey", "myid2", "name", "carol"}, {0},
+		{"GET", "mykey", "myid2", "WITHFIELDS"}, {`[{"type":"Point","coordinates":[-115,33]} [active false name carol]]`},
+		{"SCAN", "mykey", "WHERE", "name", "b", "c", "IDS"}, {`[0 [myid1]]`},
+		{"SCAN", "mykey", "WHERE", "name", "(bob", "+inf", "IDS"}, {`[0 [myid2]]`},
+		{"SCAN", "mykey", "WHERE", "name", "-inf", "c", "IDS"}, {`[0 [myid1]]`},
+		{"SCAN", "mykey", "WHERE", "name", "-inf", "+inf", "IDS"}, {`[0 [myid1 myid2]]`},
+		{"SCAN", "mykey", "WHEREIN", "name", 2, "bob", "dave", "IDS"}, {`[0 [myid1]]`},
+		{"SCAN", "mykey", "WHEREIN", "active", 1, "false", "IDS"}, {`[0 [myid2]]`},
+		{"SCAN", "mykey", "WHEREIN", "active", 1, 1, "IDS"}, {`[0 [myid1]]`},
+		{"SET", "mykey", "myid3", "FIELD", "driver", `"007"`, "FIELD", "level", "nan", "POINT", 33, -115}, {"OK"},
+		{"GET", "mykey", "myid3", "WITHFIELDS"}, {`[{"type":"Point","coordinates":[-115,33]} [driver 007 level nan]]`},
+		{"SCAN", "mykey", "WHERE", "driver", `"007"`, `"007"`, "IDS"}, {`[0 [myid3]]`},
+		{"SCAN", "mykey", "WHERE", "driver", 7, 7, "IDS"}, {`[0 []]`},
+		{"SCAN", "mykey", "WHEREIN", "driver", 1, `"007"`, "IDS"}, {`[0 [myid3]]`},
+		{"SCAN", "mykey", "WHERE", "level", "m", "o", "IDS"}, {`[0 [myid3]]`},
+		{"SCAN", "mykey", "WHERE", "active", 1, 1, "IDS"}, {`[0 [myid1]]`},
+	})
+}
+
+func keys_SAVE_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "myid1", "FIELD", "name", "bob", "POINT", 33, -115}, {"OK"},
+		{"SET", "mykey", "myid2", "EX", 60, "STRING", "value"}, {"OK"},
+		{"SAVE"}, {"OK"},
+		{"LASTSAVE"}, {func(v interface{}) (resp, expect interface{}) {
+			return v.(int64) > 0, true
+		}},
+		{"SAVE", "now"}, {"ERR wrong number of arguments for 'save' command"},
+		{"BGSAVE"}, {"Background saving started"},
+	})
+}
+
+func keys_ROLE_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"ROLE"}, {func(v interface{}) (resp, expect interface{}) {
+			m := make(map[string]string)
+			role := v.([]string)
+			for i := 0; i+1 < len(role); i += 2 {
+				m[role[i]] = role[i+1]
+			}
+			return m["role"] == "leader" && m["repl_offset"] != "", true
+		}},
+		{"REPLICAS"}, {"[]"},
+		{"CONFIG", "SET", "peers", "localhost"}, {"ERR Invalid argument 'localhost' for CONFIG SET 'peers'"},
+	})
+}
+
+func keys_HOOKS_DLQ_test(mc *mockServer) error {
+	dlqlen := func(v interface{}) (resp, expect interface{}) {
+		return len(v.([]string)), 2
+	}
+	return mc.DoBatch([][]interface{}{
+		{"SETHOOK", "dlqhook", "http://127.0.0.1:1/endpoint", "RETRIES", 0, "NEARBY", "dlqkey", "FENCE", "POINT", 33, -115, 5000}, {"ERR invalid argument '0'"},
+		{"SETHOOK", "dlqhook", "http://127.0.0.1:1/endpoint", "RETRIES", 1, "BACKOFF", 0.1, "NEARBY", "dlqkey", "FENCE", "POINT", 33, -115, 5000}, {1},
+		{"SET", "dlqkey", "myid1", "POINT", 33, -115}, {"OK"},
+		{time.Second / 2}, {},
+		{"HOOKS", "DLQ", "dlqhook"}, {dlqlen},
+		{"HOOKS", "DLQ", "other"}, {"[]"},
+		{"HOOKS", "REPLAY", "dlqhook"}, {2},
+		{time.Second / 2}, {},
+		{"HOOKS", "DLQ", "dlqhook"}, {dlqlen},
+		{"HOOKS", "UNKNOWN", "dlqhook"}, {"ERR invalid argument 'UNKNOWN'"},
+		{"DELHOOK", "dlqhook"}, {1},
+
+		// the messages reach their max age while the hook backs off
+		{"SETHOOK", "agehook", "http://127.0.0.1:1/endpoint", "BACKOFF", 60, "MAXAGE", 1, "NEARBY", "agekey", "FENCE", "POINT", 33, -115, 5000}, {1},
+		{"SET", "agekey", "myid1", "POINT", 33, -115}, {"OK"},
+		{time.Second * 2}, {},
+		{"HOOKS", "DLQ", "agehook"}, {func(v interface{}) (resp, expect interface{}) {
+			return len(v.([]string)) == 2 && strings.Contains(fmt.Sprint(v), "max age exceeded"), true
+		}},
+		{"DELHOOK", "agehook"}, {1},
+	})
+}
+
+func keys_HOOKS_HTTP_test(mc *mockServer) error {
+	auths := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auths <- r.Header.Get("Authorization")
+		if r.URL.Path == "/reject" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	dlqlen := func(n int) func(v interface{}) (resp, expect interface{}) {
+		return func(v interface{}) (resp, expect interface{}) {
+			return len(v.([]string)), n
+		}
+	}
+	if err := mc.DoBatch([][]interface{}{
+		{"SETHOOK", "httphook", srv.URL + "/reject", "NON2XX", "maybe", "NEARBY", "httpkey", "FENCE", "POINT", 33, -115, 5000}, {"ERR invalid argument 'maybe'"},
+		{"SETHOOK", "httphook", srv.URL + "/reject", "AUTH", "DIGEST", "u", "NEARBY", "httpkey", "FENCE", "POINT", 33, -115, 5000}, {"ERR invalid argument 'DIGEST'"},
+		{"SETHOOK", "httphook", srv.URL + "/reject", "TIMEOUT", 0, "NEARBY", "httpkey", "FENCE", "POINT", 33, -115, 5000}, {"ERR invalid argument '0'"},
+		{"SETCHAN", "httpchan", "SECRET", "s3cret", "NEARBY", "httpkey", "FENCE", "POINT", 33, -115, 5000}, {"ERR invalid argument 'SECRET'"},
+		{"SETHOOK", "httphook", "nats://127.0.0.1:4222/subj", "SECRET", "s3cret", "NEARBY", "httpkey", "FENCE", "POINT", 33, -115, 5000}, {"ERR invalid argument 'SECRET'"},
+		{"SETHOOK", "httphook", "redis://127.0.0.1:6379/chan", "META", "a", "b", "TIMEOUT", 2, "HEADER", "X-A", "b", "NEARBY", "httpkey", "FENCE", "POINT", 33, -115, 5000}, {"ERR invalid argument 'TIMEOUT'"},
+		{"SETHOOK", "httphook", "grpc://127.0.0.1:1/x", "AUTH", "BEARER", "t0ken", "NEARBY", "httpkey", "FENCE", "POINT", 33, -115, 5000}, {"ERR invalid argument 'AUTH'"},
+		{"SETHOOK", "httphook", "nats://127.0.0.1:4222/subj", "NON2XX", "RETRY", "NEARBY", "httpkey", "FENCE", "POINT", 33, -115, 5000}, {"ERR invalid argument 'NON2XX'"},
+		{"SETHOOK", "httphook", srv.URL + "/reject", "AUTH", "BEARER", "t0ken", "SECRET", "s3cret", "TIMEOUT", 2, "NON2XX", "DEADLETTER",
+			"NEARBY", "httpkey", "FENCE", "POINT", 33, -115, 5000}, {1},
+		{"SETHOOK", "httphook", srv.URL + "/reject", "AUTH", "BEARER", "t0ken", "SECRET", "s3cret", "TIMEOUT", 2, "NON2XX", "DEADLETTER",
+			"NEARBY", "httpkey", "FENCE", "POINT", 33, -115, 5000}, {0},
+		{"SET", "httpkey", "myid1", "POINT", 33, -115}, {"OK"},
+		{time.Second / 2}, {},
+		{"HOOKS", "DLQ", "httphook"}, {dlqlen(2)},
+	}); err != nil {
+		return err
+	}
+	// each message was dead-lettered after the first non-2xx response
+	for i := 0; i < 2; i++ {
+		if auth := <-auths; auth != "Bearer t0ken" {
+			return fmt.Errorf("expected '%v', got '%v'", "Bearer t0ken", auth)
+		}
+	}
+	return mc.DoBatch([][]interface{}{
+		{"SETHOOK", "httphook", srv.URL + "/reject", "NON2XX", "IGNORE", "NEARBY", "httpkey", "FENCE", "POINT", 33, -115, 5000}, {1},
+		{"SET", "httpkey", "myid2", "POINT", 33, -115}, {"OK"},
+		{time.Second / 2}, {},
+		{"HOOKS", "DLQ", "httphook"}, {dlqlen(2)},
+		{"DELHOOK", "httphook"}, {1},
+	})
+}
+
+func keys_MULTI_test(mc *mockServer) error {
+	if err := mc.DoBatch([][]interface{}{
+		{"MULTI"}, {"OK"},
+		{"SET", "mykey", "myid1", "POINT", 33, -115}, {"QUEUED"},
+		{"FSET", "mykey", "myid1", "speed", 10}, {"QUEUED"},
+		{"GET", "mykey", "myid1", "POINT"}, {"QUEUED"},
+		{"EXEC"}, {"[OK 1 [33 -115]]"},
+		{"MULTI"}, {"OK"},
+		{"SET", "mykey", "myid2", "POINT", 34, -115}, {"QUEUED"},
+		{"DISCARD"}, {"OK"},
+		{"GET", "mykey", "myid2"}, {nil},
+		{"MULTI"}, {"OK"},
+		{"SET", "mykey", "myid2", "POINT", 34, -115}, {"QUEUED"},
+		{"CONFIG", "GET", "requirepass"}, {"ERR 'config' is not allowed in a transaction"},
+		{"EXEC"}, {"ERR EXECABORT Transaction discarded because of previous errors"},
+		{"GET", "mykey", "myid2"}, {nil},
+		{"EXEC"}, {"ERR EXEC without MULTI"},
+		{"WATCH", "mykey", "myid1"}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	// change the watched object from another connection
+	conn, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Do("SET", "mykey", "myid1", "POINT", 35, -115); err != nil {
+		return err
+	}
+	return mc.DoBatch([][]interface{}{
+		{"MULTI"}, {"OK"},
+		{"SET", "mykey", "myid2", "POINT", 34, -115}, {"QUEUED"},
+		{"EXEC"}, {nil},
+		{"GET", "mykey", "myid2"}, {nil},
+		{"WATCH", "mykey", "*"}, {"OK"},
+		{"MULTI"}, {"OK"},
+		{"SET", "mykey", "myid2", "POINT", 34, -115}, {"QUEUED"},
+		{"EXEC"}, {"[OK]"},
+		{"GET", "mykey", "myid2", "POINT"}, {"[34 -115]"},
+	})
+}
+
+func keys_ACL_test(mc *mockServer) error {
+	if err := mc.DoBatch([][]interface{}{
+		{"ACL", "SETUSER", "alice", "on", ">secret", "~fleet*", "+@read", "+@write", "+@hooks"}, {"OK"},
+		{"ACL", "SETUSER", "bob", "+@bogus"}, {"ERR Unknown command category 'bogus'"},
+		{"ACL", "GETUSER", "alice"}, {"[flags [on] passwords [" + aclHash("secret") + "] categories [read write hooks] keys [fleet*]]"},
+		{"ACL", "WHOAMI"}, {"default"},
+	}); err != nil {
+		return err
+	}
+	conn, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	cmds := [][]interface{}{
+		{"AUTH", "alice", "wrong"},
+		{"AUTH", "alice", "secret"},
+		{"ACL", "WHOAMI"},
+		{"SET", "fleet1", "truck1", "POINT", 33, -115},
+		{"SET", "other", "truck1", "POINT", 33, -115},
+		{"WITHIN", "fleet1", "IDS", "GET", "other", "zone1"},
+		{"NEARBY", "fleet1", "FENCE", "ROAM", "other", "*", 100},
+		{"INTERSECTS", "fleet1", "WHERE", "speed", 0, 10, "FENCE", "ROAM", "other", "z*"},
+		{"SETCHAN", "ch1", "NEARBY", "other", "FENCE", "POINT", 33, -115, 100},
+		{"SETCHAN", "ch1", "META", "a", "b", "INTERSECTS", "fleet1", "FENCE", "GET", "other", "zone1"},
+		{"SETHOOK", "h1", "http://127.0.0.1:1/", "AUTH", "BASIC", "u", "p", "NEARBY", "other", "FENCE", "POINT", 33, -115, 100},
+		{"FLUSHDB"},
+		{"KEYS", "*"},
+		{"HOOKS", "*"},
+		{"CHANS", "*"},
+		{"CONFIG", "GET", "requirepass"},
+		{"EVAL", "return 1", 0},
+	}
+	expects := []string{
+		"ERR WRONGPASS invalid username-password pair",
+		"OK",
+		"alice",
+		"OK",
+		"ERR NOPERM this user has no permissions to access the 'other' key",
+		"ERR NOPERM this user has no permissions to access the 'other' key",
+		"ERR NOPERM this user has no permissions to access the 'other' key",
+		"ERR NOPERM this user has no permissions to access the 'other' key",
+		"ERR NOPERM this user has no permissions to access the 'other' key",
+		"ERR NOPERM this user has no permissions to access the 'other' key",
+		"ERR NOPERM this user has no permissions to access the 'other' key",
+		"ERR NOPERM this user has no permissions to access all keys",
+		"ERR NOPERM this user has no permissions to access all keys",
+		"ERR NOPERM this user has no permissions to access all keys",
+		"ERR NOPERM this user has no permissions to access all keys",
+		"ERR NOPERM this user has no permissions to run the 'config' command",
+		"ERR NOPERM this user has no permissions to run the 'eval' command",
+	}
+	for i, cmd := range cmds {
+		res, err := conn.Do(cmd[0].(string), cmd[1:]...)
+		if err != nil {
+			res = err.Error()
+		}
+		if s := fmt.Sprintf("%s", res); s != expects[i] {
+			return fmt.Errorf("expected '%v', got '%v' for %v", expects[i], s, cmd)
+		}
+	}
+	if err := mc.DoBatch([][]interface{}{
+		{"ACL", "LIST"}, {"[user alice on #" + aclHash("secret") + " ~fleet* +@read +@write +@hooks]"},
+		{"ACL", "DELUSER", "alice", "nobody"}, {1},
+		{"ACL", "LIST"}, {"[]"},
+		{"DROP", "fleet1"}, {1},
+		{"ACL", "SETUSER", "default", "on", ">pw", "allkeys", "allcommands"}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	// the default user is checked when it's defined
+	if _, err := conn.Do("AUTH", "default", "wrong"); err == nil ||
+		err.Error() != "ERR WRONGPASS invalid username-password pair" {
+		return fmt.Errorf("expected WRONGPASS, got '%v'", err)
+	}
+	if res, err := redis.String(conn.Do("AUTH", "default", "pw")); err != nil || res != "OK" {
+		return fmt.Errorf("expected OK, got '%v' %v", res, err)
+	}
+	return mc.DoBatch([][]interface{}{
+		{"ACL", "DELUSER", "default"}, {1},
+	})
+}
+
+func aclHash(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func keys_SLOWLOG_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "slowlog-log-slower-than", 0}, {"OK"},
+		{"SLOWLOG", "RESET"}, {"OK"},
+		{"SET", "mykey", "myid", "POINT", 33, -115}, {"OK"},
+		{"SLOWLOG", "LEN"}, {2},
+		{"SLOWLOG", "GET", 1}, {func(v, org interface{}) (resp, expect interface{}) {
+			entries := org.([]interface{})
+			if len(entries) != 1 {
+				return len(entries), 1
+			}
+			args := normalize(entries[0].([]interface{})[3])
+			return args, "[SLOWLOG LEN]"
+		}},
+		{"SLOWLOG", "GET", -1}, {func(v, org interface{}) (resp, expect interface{}) {
+			entries := org.([]interface{})
+			if len(entries) != 4 {
+				return len(entries), 4
+			}
+			args := normalize(entries[2].([]interface{})[3])
+			return args, "[SET mykey myid POINT 33 -115]"
+		}},
+		{"CONFIG", "SET", "slowlog-max-len", 2}, {"OK"},
+		{"SLOWLOG", "LEN"}, {2},
+		{"CONFIG", "SET", "slowlog-log-slower-than", ""}, {"OK"},
+		{"CONFIG", "SET", "slowlog-max-len", ""}, {"OK"},
+		{"SLOWLOG", "RESET"}, {"OK"},
+		{"DROP", "mykey"}, {1},
+	})
+}
+
+func keys_MONITOR_test(mc *mockServer) error {
+	conn, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	res, err := redis.String(conn.Do("MONITOR"))
+	if err != nil {
+		return err
+	}
+	if res != "OK" {
+		return fmt.Errorf("expected '%v', got '%v'", "OK", res)
+	}
+	if err := mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "myid", "POINT", 33, -115}, {"OK"},
+		{"AUTH", "secret"}, {"ERR invalid password"},
+		{"CONFIG", "SET", "leaderauth", "s3cret"}, {"OK"},
+		{"CONFIG", "SET", "leaderauth", ""}, {"OK"},
+		{"SETHOOK", "mhook", "http://u:p@127.0.0.1:1/x?token=t0k", "HEADER", "Authorization", "t0k",
+			"NEARBY", "mykey", "FENCE", "POINT", 33, -115, 5000}, {1},
+		{"DELHOOK", "mhook"}, {1},
+		{"DROP", "mykey"}, {1},
+	}); err != nil {
+		return err
+	}
+	for _, expect := range []string{
+		`"SET" "mykey" "myid" "POINT" "33" "-115"`,
+		`"AUTH" "(redacted)"`,
+		`"CONFIG" "SET" "leaderauth" "(redacted)"`,
+		`"CONFIG" "SET" "leaderauth" "(redacted)"`,
+		`"SETHOOK" "mhook" "http://(redacted)@127.0.0.1:1/x?token=(redacted)" "HEADER" "Authorization" "(redacted)" ` +
+			`"NEARBY" "mykey" "FENCE" "POINT" "33" "-115" "5000"`,
+		`"DELHOOK" "mhook"`,
+		`"DROP" "mykey"`,
+	} {
+		line, err := redis.String(conn.Receive())
+		if err != nil {
+			return err
+		}
+		if !strings.HasSuffix(line, "] "+expect) {
+			return fmt.Errorf("expected '%v', got '%v'", expect, line)
+		}
+	}
+	return nil
+}
+
+func keys_HISTORY_test(mc *mockServer) error {
+	count := func(n int) func(v interface{}) (resp, expect interface{}) {
+		return func(v interface{}) (resp, expect interface{}) {
+			vals, _ := v.([]string)
+			return len(vals), n
+		}
+	}
+	return mc.DoBatch([][]interface{}{
+		{"SETHISTORY", "fleet"}, {"ERR LEN or DURATION is required"},
+		{"SETHISTORY", "fleet", "LEN", 0}, {"ERR invalid argument '0'"},
+		{"SETHISTORY", "fleet", "LEN", 10}, {1},
+		{"SETHISTORY", "fleet", "LEN", 10}, {0},
+		{"HISTADD", "nohist", "truck1", 1000, "POINT", 33, -115}, {"ERR history is not enabled for key"},
+		{"HISTADD", "fleet", "truck1", 2000, "POINT", 34, -115}, {"OK"},
+		{"HISTADD", "fleet", "truck1", 1000, "POINT", 33, -115}, {"OK"},
+		{"HISTORY", "fleet", "truck1", "START", 1500, "END", 2500}, {
+			`[[1970-01-01T00:33:20Z {"type":"Point","coordinates":[-115,34]}]]`,
+		},
+		{"HISTORY", "fleet", "truck1"}, {count(2)},
+		{"GET", "fleet", "truck1", "AT", 500}, {nil},
+		{"GET", "fleet", "truck1", "AT", 1500, "POINT"}, {"[33 -115]"},
+		{"GET", "fleet", "truck1", "AT", "1970-01-01T00:40:00Z", "POINT"}, {"[34 -115]"},
+		{"GET", "fleet", "truck1", "AT", 3500, "POINT"}, {"[34 -115]"},
+		{"GET", "nohist", "truck1", "AT", 1500}, {"ERR history is not enabled for key"},
+		{"WITHIN", "fleet", "AT", 1500, "IDS", "BOUNDS", 32.5, -116, 33.5, -114}, {"[0 [truck1]]"},
+		{"WITHIN", "fleet", "AT", 2500, "IDS", "BOUNDS", 32.5, -116, 33.5, -114}, {"[0 []]"},
+		{"NEARBY", "fleet", "AT", 2500, "IDS", "POINT", 34, -115, 1000}, {"[0 [truck1]]"},
+		{"SCAN", "fleet", "AT", 1500, "IDS"}, {"ERR AT is not allowed for SCAN"},
+		{"WITHIN", "fleet", "AT", 1500, "WHERE", "speed", 0, 10, "IDS", "BOUNDS", 32.5, -116, 33.5, -114}, {
+			"ERR WHERE, WHEREIN, and WHEREEXPR are not allowed when AT is specified",
+		},
+		{"NEARBY", "fleet", "WHEREEXPR", "speed > 0", "AT", 2500, "IDS", "POINT", 34, -115, 1000}, {
+			"ERR WHERE, WHEREIN, and WHEREEXPR are not allowed when AT is specified",
+		},
+		{"HISTADD", "fleet", "truck3", 1000, "POINT", 40, -100}, {"OK"},
+		{"NEARBY", "fleet", "AT", 1500, "IDS", "POINT", 40.1, -100, 100000}, {"[0 [truck3]]"},
+		{"INTERSECTS", "fleet", "AT", 1500, "IDS", "BOUNDS", 32, 170, 34, 190}, {"[0 []]"},
+
+		// without a DURATION, the history of a deleted object is removed
+		{"HISTADD", "fleet", "truck1", 3000, "DELETED"}, {"OK"},
+		{"HISTORY", "fleet", "truck1"}, {count(0)},
+		{"GET", "fleet", "truck1", "AT", 1500}, {nil},
+
+		// the changes of the objects are recorded
+		{"SETHISTORY", "fleet", "LEN", 10, "DURATION", 3600}, {1},
+		{"SET", "fleet", "truck2", "POINT", 33, -115}, {"OK"},
+		{"SET", "fleet", "truck2", "POINT", 34, -115}, {"OK"},
+		{"DEL", "fleet", "truck2"}, {1},
+		{"HISTORY", "fleet", "truck2"}, {count(3)},
+		{"GET", "fleet", "truck2"}, {nil},
+		{"DELHISTORY", "fleet"}, {1},
+		{"HISTORY", "fleet", "truck2"}, {"ERR history is not enabled for key"},
+	})
+}
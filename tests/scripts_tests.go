@@ -0,0 +1,57 @@
+package tests
+
+import "testing"
+
+func subTestScripts(t *testing.T, mc *mockServer) {
+	runStep(t, mc, "EVAL", scripts_EVAL_test)
+	runStep(t, mc, "EVALSHA", scripts_EVALSHA_test)
+	runStep(t, mc, "sandbox", scripts_sandbox_test)
+}
+
+func scripts_EVAL_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"EVAL", "return tile38.call('set', KEYS[1], ARGV[1], 'point', 33, -115)", 1, "mykey", "myid1"}, {"OK"},
+		{"EVAL", "return tile38.call('get', KEYS[1], ARGV[1], 'point')", 1, "mykey", "myid1"}, {"[33 -115]"},
+		{"EVAL", "return {1, 2.5, 'three', true, false}", 0}, {"[1 2.5 three 1 nil]"},
+		{"EVAL", "return tile38.pcall('fset', 'mykey', 'myid2', 'speed', 10)", 0}, {"ERR id not found"},
+		{"EVAL", "return tile38.call('fset', 'mykey', 'myid2', 'speed', 10)", 0}, {"ERR Error running script: @user_script:1: id not found"},
+		{"EVAL", "return tile38.error_reply('my error')", 0}, {"ERR my error"},
+		{"EVAL", "return tile38.status_reply('DONE')", 0}, {"DONE"},
+		{"EVAL", "return 1", 2, "mykey"}, {"ERR invalid argument '2'"},
+		{"EVAL", "return (", 0}, {"ERR Error compiling script: @user_script at EOF: syntax error"},
+		// the writes are applied atomically, in a single transaction
+		{"EVAL", `
+			local n = 0
+			for i = 1, #ARGV do
+				tile38.call('set', KEYS[1], ARGV[i], 'point', 33, -115)
+				n = n + 1
+			end
+			tile38.call('del', KEYS[1], ARGV[1])
+			return n`, 1, "mykey", "myid2", "myid3", "myid4"}, {3},
+		{"SCAN", "mykey", "IDS"}, {"[0 [myid1 myid3 myid4]]"},
+	})
+}
+
+func scripts_EVALSHA_test(mc *mockServer) error {
+	sha := "098e0f0d1448c0a81dafe820f66d460eb09263da" // return ARGV[1]
+	return mc.DoBatch([][]interface{}{
+		{"EVALSHA", sha, 0, "hello"}, {"ERR NOSCRIPT No matching script. Please use EVAL."},
+		{"SCRIPT", "LOAD", "return ARGV[1]"}, {sha},
+		{"SCRIPT", "EXISTS", sha, "unknown"}, {"[1 0]"},
+		{"EVALSHA", sha, 0, "hello"}, {"hello"},
+		{"SCRIPT", "FLUSH"}, {"OK"},
+		{"SCRIPT", "EXISTS", sha}, {"[0]"},
+		{"EVALSHA", sha, 0, "hello"}, {"ERR NOSCRIPT No matching script. Please use EVAL."},
+	})
+}
+
+func scripts_sandbox_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"EVAL", "return os.time()", 0}, {"ERR Error running script: @user_script:1: attempt to index a non-table object(nil)"},
+		{"EVAL", "return dofile('/etc/passwd')", 0}, {"ERR Error running script: @user_script:1: attempt to call a non-function object"},
+		{"EVAL", "return tile38.call('eval', 'return 1', 0)", 0}, {"ERR Error running script: @user_script:1: 'eval' is not allowed from scripts"},
+		{"EVAL", "return tile38.call('nearby', 'mykey', 'fence', 'point', 33, -115, 100)", 0}, {"ERR Error running script: @user_script:1: FENCE is not allowed from scripts"},
+		{"EVAL", "local function f(n) return 1 + f(n + 1) end return f(1)", 0}, {"ERR Error running script: @user_script:1: stack overflow"},
+		{"EVAL", "local t = {} for i = 1, 300000 do t[i] = i end return select('#', unpack(t))", 0}, {"ERR Error running script: @user_script:1: registry overflow"},
+	})
+}